@@ -0,0 +1,58 @@
+package ldclient
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Computes the delays between attempts to reconnect. Each delay is twice the previous one, up to
+// maxDelay; a random jitter of up to half the delay is subtracted, so that many clients that lost
+// their connections at the same time do not all reconnect at the same time. If the last connection
+// stayed up for at least resetInterval, the delay starts over from initialDelay.
+//
+// This is not thread-safe; it is used only by the goroutine that manages a connection.
+type backoffWithJitter struct {
+	initialDelay  time.Duration
+	maxDelay      time.Duration
+	resetInterval time.Duration
+	attempts      uint
+	goodSince     time.Time
+	random        func(n int64) int64
+}
+
+func newBackoffWithJitter(initialDelay, maxDelay, resetInterval time.Duration) *backoffWithJitter {
+	if maxDelay < initialDelay {
+		maxDelay = initialDelay
+	}
+	return &backoffWithJitter{
+		initialDelay:  initialDelay,
+		maxDelay:      maxDelay,
+		resetInterval: resetInterval,
+		random:        rand.Int63n,
+	}
+}
+
+// Records that a connection was made successfully.
+func (b *backoffWithJitter) setGoodSince(t time.Time) {
+	b.goodSince = t
+}
+
+// Returns the delay before the next attempt, after a connection failed or was lost at time now.
+func (b *backoffWithJitter) nextDelay(now time.Time) time.Duration {
+	if !b.goodSince.IsZero() && now.Sub(b.goodSince) >= b.resetInterval {
+		b.attempts = 0
+	}
+	b.goodSince = time.Time{}
+	delay := b.initialDelay
+	for i := uint(0); i < b.attempts && delay < b.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > b.maxDelay {
+		delay = b.maxDelay
+	}
+	b.attempts++
+	if half := int64(delay / 2); half > 0 {
+		delay -= time.Duration(b.random(half))
+	}
+	return delay
+}
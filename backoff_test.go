@@ -0,0 +1,60 @@
+package ldclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestBackoff(random func(int64) int64) *backoffWithJitter {
+	b := newBackoffWithJitter(time.Second, 10*time.Second, time.Minute)
+	b.random = random
+	return b
+}
+
+func TestBackoffDoublesUpToMaximum(t *testing.T) {
+	b := makeTestBackoff(func(n int64) int64 { return 0 })
+	now := time.Now()
+
+	var delays []time.Duration
+	for i := 0; i < 6; i++ {
+		delays = append(delays, b.nextDelay(now))
+	}
+
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		10 * time.Second, 10 * time.Second}, delays)
+}
+
+func TestBackoffSubtractsJitterOfUpToHalfTheDelay(t *testing.T) {
+	var limits []int64
+	b := makeTestBackoff(func(n int64) int64 {
+		limits = append(limits, n)
+		return n - 1
+	})
+	now := time.Now()
+
+	assert.Equal(t, 500*time.Millisecond+1, b.nextDelay(now))
+	assert.Equal(t, time.Second+1, b.nextDelay(now))
+	assert.Equal(t, []int64{int64(500 * time.Millisecond), int64(time.Second)}, limits)
+}
+
+func TestBackoffResetsAfterConnectionStaysUp(t *testing.T) {
+	b := makeTestBackoff(func(n int64) int64 { return 0 })
+	now := time.Now()
+	b.nextDelay(now)
+	b.nextDelay(now)
+
+	b.setGoodSince(now)
+	assert.Equal(t, 4*time.Second, b.nextDelay(now.Add(time.Second)))
+
+	b.setGoodSince(now)
+	assert.Equal(t, time.Second, b.nextDelay(now.Add(time.Minute)))
+	assert.Equal(t, 2*time.Second, b.nextDelay(now.Add(time.Minute)))
+}
+
+func TestBackoffMaximumIsAtLeastInitialDelay(t *testing.T) {
+	b := newBackoffWithJitter(time.Second, time.Millisecond, time.Minute)
+	b.random = func(n int64) int64 { return 0 }
+	assert.Equal(t, time.Second, b.nextDelay(time.Now()))
+}
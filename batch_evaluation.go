@@ -0,0 +1,350 @@
+package ldclient
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// BatchFlag identifies a flag to be evaluated by EvaluateBatch, and the value to use for it if it cannot
+// be evaluated.
+type BatchFlag struct {
+	Key     string
+	Default interface{}
+}
+
+// UserIterator supplies the users for EvaluateBatch. Its Next method is only called from one goroutine
+// at a time, so it does not need to be thread-safe.
+type UserIterator interface {
+	// Next returns the next user, or false if there are no more users.
+	Next() (User, bool)
+}
+
+// UserSlice returns a UserIterator that supplies the users in a slice.
+func UserSlice(users []User) UserIterator {
+	return &userSliceIterator{users: users}
+}
+
+type userSliceIterator struct {
+	users []User
+	next  int
+}
+
+func (it *userSliceIterator) Next() (User, bool) {
+	if it.next >= len(it.users) {
+		return User{}, false
+	}
+	it.next++
+	return it.users[it.next-1], true
+}
+
+// BatchEvaluationOptions contains optional parameters for EvaluateBatch.
+type BatchEvaluationOptions struct {
+	// Workers is the number of goroutines that evaluate flags in parallel. If it is zero, the value of
+	// runtime.GOMAXPROCS is used.
+	Workers int
+	// WithReasons specifies whether evaluation reasons should be included in analytics events, as they
+	// are for the *Detail variation methods. The results passed to the handler always include reasons.
+	WithReasons bool
+}
+
+// BatchEvaluationResult is the result of evaluating the flags for one user in EvaluateBatch.
+type BatchEvaluationResult struct {
+	// Index is the position of the user in the sequence supplied by the UserIterator, starting at zero.
+	Index int
+	// User is the user that the flags were evaluated for.
+	User User
+	// Details contains the result for each flag, in the same order as the flags passed to EvaluateBatch.
+	Details []EvaluationDetail
+}
+
+// EvaluateBatch evaluates a set of feature flags for each of a sequence of users. It is meant for jobs
+// that evaluate the same few flags for a large number of users, and is much faster than calling one of
+// the variation methods for each user and flag.
+//
+// The flags, their prerequisites, and the segments they refer to are read from the feature store once,
+// at the start, and all of the users are evaluated against that version of the data. The users are
+// evaluated in parallel by several goroutines (see BatchEvaluationOptions). The handler is called from
+// those goroutines as each user is evaluated, so it must be thread-safe, and the results are not
+// necessarily passed to it in the same order as the users.
+//
+// Rather than an analytics event for each evaluation, a single FeatureRequestSummaryEvent is sent at
+// the end, containing the number of times that each flag produced each value. However, evaluations of
+// flags that have event tracking or debugging turned on still produce individual events, since those
+// need to refer to a specific user.
+//
+// If the context is cancelled, no more users are evaluated, and EvaluateBatch returns the context's
+// error once the evaluations that have already started are finished. If the flag data cannot be read,
+// it returns an error without calling the handler. Otherwise, it returns nil; if a flag cannot be
+// evaluated for a user, the result for that flag contains the default value and an error reason.
+func (client *LDClient) EvaluateBatch(ctx context.Context, flags []BatchFlag, users UserIterator,
+	options BatchEvaluationOptions, handler func(BatchEvaluationResult)) error {
+	b := &batchEvaluator{client: client, flags: flags, withReasons: options.WithReasons}
+	if client.IsOffline() {
+		b.offline = true
+	} else {
+		if !client.Initialized() {
+			if !client.store.Initialized() {
+				return ErrClientNotInitialized
+			}
+			client.logger.Warn("Batch evaluation called before LaunchDarkly client initialization completed; using last known values from feature store")
+		}
+		store, err := loadBatchFeatureStore(featureStoreWithContext(ctx, client.storeForEvaluation()), flags)
+		if err != nil {
+			return err
+		}
+		b.store = store
+	}
+
+	workers := options.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	startDate := now()
+	chunksCh := make(chan []BatchEvaluationResult, workers)
+	counters := make([]map[counterKey]*FeatureRequestCounter, workers)
+	var wg sync.WaitGroup
+	for i := range counters {
+		counters[i] = make(map[counterKey]*FeatureRequestCounter)
+		wg.Add(1)
+		go func(workerCounters map[counterKey]*FeatureRequestCounter) {
+			defer wg.Done()
+			for chunk := range chunksCh {
+				for _, result := range chunk {
+					result.Details = b.evaluateUser(result.User, workerCounters)
+					handler(result)
+				}
+			}
+		}(counters[i])
+	}
+
+	// Users are passed to the workers in chunks, since the cost of a channel operation for each one
+	// would be significant compared to the cost of evaluating a few simple flags.
+	var err error
+	chunk := make([]BatchEvaluationResult, 0, batchChunkSize)
+	for index := 0; ; index++ {
+		user, ok := users.Next()
+		if ok {
+			chunk = append(chunk, BatchEvaluationResult{Index: index, User: user})
+		}
+		if len(chunk) == batchChunkSize || (!ok && len(chunk) > 0) {
+			select {
+			case chunksCh <- chunk:
+				chunk = make([]BatchEvaluationResult, 0, batchChunkSize)
+			case <-ctx.Done():
+			}
+		}
+		if err = ctx.Err(); err != nil || !ok {
+			break
+		}
+	}
+	close(chunksCh)
+	wg.Wait()
+
+	if summary, ok := mergeBatchCounters(counters); ok {
+		client.eventProcessor.SendEvent(FeatureRequestSummaryEvent{
+			BaseEvent: BaseEvent{CreationDate: now()},
+			StartDate: startDate,
+			Counters:  summary,
+		})
+	}
+	return err
+}
+
+const batchChunkSize = 100
+
+type batchEvaluator struct {
+	client      *LDClient
+	flags       []BatchFlag
+	store       *batchFeatureStore
+	withReasons bool
+	offline     bool
+}
+
+func (b *batchEvaluator) evaluateUser(user User, counters map[counterKey]*FeatureRequestCounter) []EvaluationDetail {
+	details := make([]EvaluationDetail, len(b.flags))
+	for i, bf := range b.flags {
+		details[i] = b.evaluateFlag(user, bf, counters)
+	}
+	return details
+}
+
+// Evaluates a flag in the same way as LDClient.variation, except for how the events are delivered.
+func (b *batchEvaluator) evaluateFlag(user User, bf BatchFlag, counters map[counterKey]*FeatureRequestCounter) EvaluationDetail {
+	if b.offline {
+		return EvaluationDetail{Value: bf.Default, Reason: newEvalReasonError(EvalErrorClientNotReady)}
+	}
+	flag := b.store.flags[bf.Key]
+	var detail EvaluationDetail
+	if flag == nil {
+		detail = EvaluationDetail{Value: bf.Default, Reason: newEvalReasonError(EvalErrorFlagNotFound)}
+	} else if user.Key == nil {
+		detail = EvaluationDetail{Value: bf.Default, Reason: newEvalReasonError(EvalErrorUserNotSpecified)}
+	} else {
+		var prereqEvents []FeatureRequestEvent
+		detail, prereqEvents = flag.evaluateDetail(user, b.store, b.withReasons,
+			&evaluationState{logger: b.client.logger})
+		if detail.IsDefaultValue() {
+			detail.Value = bf.Default
+		}
+		for _, event := range prereqEvents {
+			b.recordEvent(event, counters)
+		}
+	}
+
+	evt := NewFeatureRequestEvent(bf.Key, flag, user, detail.VariationIndex, detail.Value, bf.Default, nil)
+	if b.withReasons {
+		evt.Reason.Reason = detail.Reason
+	}
+	b.recordEvent(evt, counters)
+	detail.Value = deepCopyValue(detail.Value) // it will be passed to the handler
+	return detail
+}
+
+// Adds an event to the counters, unless it has to be sent individually because the flag has event
+// tracking or debugging turned on.
+func (b *batchEvaluator) recordEvent(evt FeatureRequestEvent, counters map[counterKey]*FeatureRequestCounter) {
+	if evt.TrackEvents || (evt.DebugEventsUntilDate != nil && *evt.DebugEventsUntilDate > now()) {
+		b.client.eventProcessor.SendEvent(evt)
+		return
+	}
+	key := counterKey{key: evt.Key, variation: nilVariation}
+	if evt.Variation != nil {
+		key.variation = *evt.Variation
+	}
+	if evt.Version != nil {
+		key.version = *evt.Version
+	}
+	if c, ok := counters[key]; ok {
+		c.Count++
+	} else {
+		counters[key] = &FeatureRequestCounter{Key: evt.Key, Version: evt.Version, Variation: evt.Variation,
+			Value: evt.Value, Default: evt.Default, Count: 1}
+	}
+}
+
+// Combines the counters from each worker, returning false if there are none.
+func mergeBatchCounters(workerCounters []map[counterKey]*FeatureRequestCounter) ([]FeatureRequestCounter, bool) {
+	merged := make(map[counterKey]*FeatureRequestCounter)
+	for _, counters := range workerCounters {
+		for key, c := range counters {
+			if m, ok := merged[key]; ok {
+				m.Count += c.Count
+			} else {
+				merged[key] = c
+			}
+		}
+	}
+	if len(merged) == 0 {
+		return nil, false
+	}
+	ret := make([]FeatureRequestCounter, 0, len(merged))
+	for _, c := range merged {
+		ret = append(ret, *c)
+	}
+	return ret, true
+}
+
+// batchFeatureStore is a read-only FeatureStore containing only the items needed for a batch
+// evaluation. Items that were not found are stored as nil, so that they are not queried again; unlike
+// InMemoryFeatureStore, it does not log a warning for them, since that would happen for every user.
+type batchFeatureStore struct {
+	flags    map[string]*FeatureFlag
+	segments map[string]*Segment
+}
+
+// Reads the specified flags, their prerequisites, and the segments that they refer to.
+func loadBatchFeatureStore(store FeatureStore, flags []BatchFlag) (*batchFeatureStore, error) {
+	s := &batchFeatureStore{flags: make(map[string]*FeatureFlag), segments: make(map[string]*Segment)}
+	keys := make([]string, 0, len(flags))
+	for _, bf := range flags {
+		keys = append(keys, bf.Key)
+	}
+	for len(keys) > 0 {
+		key := keys[0]
+		keys = keys[1:]
+		if _, loaded := s.flags[key]; loaded {
+			continue
+		}
+		item, err := store.Get(Features, key)
+		if err != nil {
+			return nil, err
+		}
+		flag, _ := item.(*FeatureFlag)
+		s.flags[key] = flag
+		if flag == nil {
+			continue
+		}
+		for _, p := range flag.Prerequisites {
+			keys = append(keys, p.Key)
+		}
+		for _, r := range flag.Rules {
+			for _, c := range r.Clauses {
+				if c.Op != OperatorSegmentMatch {
+					continue
+				}
+				for _, value := range c.Values {
+					segmentKey, ok := value.(string)
+					if _, loaded := s.segments[segmentKey]; !ok || loaded {
+						continue
+					}
+					item, err := store.Get(Segments, segmentKey)
+					if err != nil {
+						return nil, err
+					}
+					s.segments[segmentKey], _ = item.(*Segment)
+				}
+			}
+		}
+	}
+	return s, nil
+}
+
+func (s *batchFeatureStore) Get(kind VersionedDataKind, key string) (VersionedData, error) {
+	switch kind.(type) {
+	case FeatureFlagVersionedDataKind:
+		if flag := s.flags[key]; flag != nil {
+			return flag, nil
+		}
+	case SegmentVersionedDataKind:
+		if segment := s.segments[key]; segment != nil {
+			return segment, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *batchFeatureStore) All(kind VersionedDataKind) (map[string]VersionedData, error) {
+	ret := make(map[string]VersionedData)
+	switch kind.(type) {
+	case FeatureFlagVersionedDataKind:
+		for key, flag := range s.flags {
+			if flag != nil {
+				ret[key] = flag
+			}
+		}
+	case SegmentVersionedDataKind:
+		for key, segment := range s.segments {
+			if segment != nil {
+				ret[key] = segment
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (s *batchFeatureStore) Init(allData map[VersionedDataKind]map[string]VersionedData) error {
+	return ErrFeatureStoreSnapshotReadOnly
+}
+
+func (s *batchFeatureStore) Upsert(kind VersionedDataKind, item VersionedData) error {
+	return ErrFeatureStoreSnapshotReadOnly
+}
+
+func (s *batchFeatureStore) Delete(kind VersionedDataKind, key string, version int) error {
+	return ErrFeatureStoreSnapshotReadOnly
+}
+
+func (s *batchFeatureStore) Initialized() bool {
+	return true
+}
@@ -0,0 +1,252 @@
+package ldclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Collects the results of EvaluateBatch, which are delivered from several goroutines.
+type batchResultCollector struct {
+	results map[int]BatchEvaluationResult
+	lock    sync.Mutex
+}
+
+func newBatchResultCollector() *batchResultCollector {
+	return &batchResultCollector{results: make(map[int]BatchEvaluationResult)}
+}
+
+func (c *batchResultCollector) handle(result BatchEvaluationResult) {
+	c.lock.Lock()
+	c.results[result.Index] = result
+	c.lock.Unlock()
+}
+
+// Records the Get queries made to a feature store, or makes them fail if err is set. It embeds the
+// FeatureStore interface rather than the InMemoryFeatureStore, so that the client does not use the
+// in-memory store's snapshot instead.
+type queryRecordingFeatureStore struct {
+	FeatureStore
+	queries []string
+	err     error
+}
+
+func (s *queryRecordingFeatureStore) Get(kind VersionedDataKind, key string) (VersionedData, error) {
+	s.queries = append(s.queries, kind.GetNamespace()+":"+key)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.FeatureStore.Get(kind, key)
+}
+
+func makeBatchTestUsers(n int) []User {
+	users := make([]User, n)
+	for i := range users {
+		users[i] = NewUser(fmt.Sprintf("user%d", i))
+	}
+	return users
+}
+
+// A flag that returns variation 1 for "user0" and variation 0 for everyone else.
+func makeBatchTestFlag(key string) *FeatureFlag {
+	flag := makeTestFlag(key, 0, "other", "first")
+	flag.Targets = []Target{{Values: []string{"user0"}, Variation: 1}}
+	return flag
+}
+
+func getSummaryEvent(t *testing.T, client *LDClient) FeatureRequestSummaryEvent {
+	var summaries []FeatureRequestSummaryEvent
+	for _, e := range client.eventProcessor.(*testEventProcessor).events {
+		if se, ok := e.(FeatureRequestSummaryEvent); ok {
+			summaries = append(summaries, se)
+		}
+	}
+	require.Len(t, summaries, 1)
+	return summaries[0]
+}
+
+func TestEvaluateBatchEvaluatesEachFlagForEachUser(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	flag1 := makeBatchTestFlag("flag1")
+	flag2 := makeTestFlag("flag2", 1, false, true)
+	client.store.Upsert(Features, flag1)
+	client.store.Upsert(Features, flag2)
+	users := makeBatchTestUsers(100)
+
+	collector := newBatchResultCollector()
+	flags := []BatchFlag{{Key: "flag1", Default: "default"}, {Key: "flag2", Default: false}}
+	err := client.EvaluateBatch(context.Background(), flags, UserSlice(users), BatchEvaluationOptions{Workers: 4}, collector.handle)
+
+	require.NoError(t, err)
+	require.Len(t, collector.results, len(users))
+	for i, user := range users {
+		result := collector.results[i]
+		assert.Equal(t, user, result.User)
+		require.Len(t, result.Details, 2)
+		if i == 0 {
+			assert.Equal(t, EvaluationDetail{Value: "first", VariationIndex: intPtr(1), Reason: evalReasonTargetMatchInstance}, result.Details[0])
+		} else {
+			assert.Equal(t, EvaluationDetail{Value: "other", VariationIndex: intPtr(0), Reason: evalReasonFallthroughInstance}, result.Details[0])
+		}
+		assert.Equal(t, EvaluationDetail{Value: true, VariationIndex: intPtr(1), Reason: evalReasonFallthroughInstance}, result.Details[1])
+	}
+}
+
+func TestEvaluateBatchSendsSummaryEventInsteadOfFeatureEvents(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	flag := makeBatchTestFlag("flag1")
+	client.store.Upsert(Features, flag)
+
+	err := client.EvaluateBatch(context.Background(), []BatchFlag{{Key: "flag1", Default: "default"}, {Key: "unknown", Default: "x"}},
+		UserSlice(makeBatchTestUsers(10)), BatchEvaluationOptions{}, func(BatchEvaluationResult) {})
+
+	require.NoError(t, err)
+	events := client.eventProcessor.(*testEventProcessor).events
+	require.Len(t, events, 1)
+	se := getSummaryEvent(t, client)
+	assert.ElementsMatch(t, []FeatureRequestCounter{
+		{Key: "flag1", Version: &flag.Version, Variation: intPtr(1), Value: "first", Default: "default", Count: 1},
+		{Key: "flag1", Version: &flag.Version, Variation: intPtr(0), Value: "other", Default: "default", Count: 9},
+		{Key: "unknown", Value: "x", Default: "x", Count: 10},
+	}, se.Counters)
+	assert.True(t, se.StartDate <= se.CreationDate)
+}
+
+func TestEvaluateBatchSendsIndividualEventsForTrackedFlag(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	flag := makeBatchTestFlag("flag1")
+	flag.TrackEvents = true
+	client.store.Upsert(Features, flag)
+
+	err := client.EvaluateBatch(context.Background(), []BatchFlag{{Key: "flag1", Default: "default"}},
+		UserSlice(makeBatchTestUsers(3)), BatchEvaluationOptions{WithReasons: true}, func(BatchEvaluationResult) {})
+
+	require.NoError(t, err)
+	events := client.eventProcessor.(*testEventProcessor).events
+	require.Len(t, events, 3)
+	for _, e := range events {
+		fe := e.(FeatureRequestEvent)
+		assert.Equal(t, "flag1", fe.Key)
+		assert.True(t, fe.TrackEvents)
+		assert.NotNil(t, fe.Reason.Reason)
+	}
+}
+
+func TestEvaluateBatchReadsPrerequisitesAndSegmentsOnce(t *testing.T) {
+	store := &queryRecordingFeatureStore{FeatureStore: NewInMemoryFeatureStore(nil)}
+	client := makeTestClientWithFeatureStore(store)
+	defer client.Close()
+	segment := Segment{Key: "segment", Included: []string{"user1"}}
+	prereq := makeTestFlag("prereq", 1, false, true)
+	flag := makeTestFlag("flag1", 0, "no", "yes")
+	flag.Prerequisites = []Prerequisite{{Key: "prereq", Variation: 1}}
+	flag.Rules = []Rule{{
+		VariationOrRollout: VariationOrRollout{Variation: intPtr(1)},
+		Clauses:            []Clause{{Op: OperatorSegmentMatch, Values: []interface{}{"segment", "missing"}}},
+	}}
+	require.NoError(t, store.Init(map[VersionedDataKind]map[string]VersionedData{
+		Features: {"flag1": flag, "prereq": prereq},
+		Segments: {"segment": &segment},
+	}))
+
+	collector := newBatchResultCollector()
+	err := client.EvaluateBatch(context.Background(), []BatchFlag{{Key: "flag1", Default: "default"}},
+		UserSlice(makeBatchTestUsers(5)), BatchEvaluationOptions{}, collector.handle)
+
+	require.NoError(t, err)
+	assert.Equal(t, "no", collector.results[0].Details[0].Value)
+	assert.Equal(t, "yes", collector.results[1].Details[0].Value)
+	assert.ElementsMatch(t, []string{"features:flag1", "features:prereq", "segments:segment", "segments:missing"}, store.queries)
+
+	se := getSummaryEvent(t, client)
+	assert.Contains(t, se.Counters, FeatureRequestCounter{Key: "prereq", Version: &prereq.Version, Variation: intPtr(1),
+		Value: true, Count: 5})
+}
+
+func TestEvaluateBatchStopsWhenContextIsCancelled(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	client.store.Upsert(Features, makeBatchTestFlag("flag1"))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var count int
+	var lock sync.Mutex
+	err := client.EvaluateBatch(ctx, []BatchFlag{{Key: "flag1"}}, UserSlice(makeBatchTestUsers(1000)),
+		BatchEvaluationOptions{Workers: 1}, func(BatchEvaluationResult) {
+			lock.Lock()
+			defer lock.Unlock()
+			count++
+			if count == 10 {
+				cancel()
+			}
+		})
+
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, count < 1000)
+	total := 0
+	for _, c := range getSummaryEvent(t, client).Counters {
+		total += c.Count
+	}
+	assert.Equal(t, count, total)
+}
+
+func TestEvaluateBatchReturnsDefaultsInOfflineMode(t *testing.T) {
+	client := makeTestClientWithConfig(func(c *Config) { c.Offline = true })
+	defer client.Close()
+
+	collector := newBatchResultCollector()
+	err := client.EvaluateBatch(context.Background(), []BatchFlag{{Key: "flag1", Default: "default"}},
+		UserSlice(makeBatchTestUsers(2)), BatchEvaluationOptions{}, collector.handle)
+
+	require.NoError(t, err)
+	require.Len(t, collector.results, 2)
+	assert.Equal(t, EvaluationDetail{Value: "default", Reason: newEvalReasonError(EvalErrorClientNotReady)},
+		collector.results[1].Details[0])
+}
+
+func TestEvaluateBatchReturnsErrorIfStoreFails(t *testing.T) {
+	store := &queryRecordingFeatureStore{FeatureStore: NewInMemoryFeatureStore(nil), err: errors.New("sorry")}
+	client := makeTestClientWithFeatureStore(store)
+	defer client.Close()
+	require.NoError(t, store.Init(nil))
+
+	called := false
+	err := client.EvaluateBatch(context.Background(), []BatchFlag{{Key: "flag1"}}, UserSlice(makeBatchTestUsers(2)),
+		BatchEvaluationOptions{}, func(BatchEvaluationResult) { called = true })
+
+	assert.Error(t, err)
+	assert.False(t, called)
+}
+
+func BenchmarkBoolVariationForManyUsers(b *testing.B) {
+	client := makeTestClientWithConfig(func(c *Config) { c.EventProcessor = newNullEventProcessor() })
+	defer client.Close()
+	client.store.Upsert(Features, makeTestFlag("flag", 1, false, true))
+	users := makeBatchTestUsers(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, user := range users {
+			_, _ = client.BoolVariation("flag", user, false)
+		}
+	}
+}
+
+func BenchmarkEvaluateBatchForManyUsers(b *testing.B) {
+	client := makeTestClientWithConfig(func(c *Config) { c.EventProcessor = newNullEventProcessor() })
+	defer client.Close()
+	client.store.Upsert(Features, makeTestFlag("flag", 1, false, true))
+	users := makeBatchTestUsers(1000)
+	flags := []BatchFlag{{Key: "flag", Default: false}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = client.EvaluateBatch(context.Background(), flags, UserSlice(users), BatchEvaluationOptions{}, func(BatchEvaluationResult) {})
+	}
+}
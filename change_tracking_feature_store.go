@@ -0,0 +1,205 @@
+package ldclient
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// changeTrackingFeatureStore wraps the configured FeatureStore so that every write made to it, by
+// any UpdateProcessor (including custom ones such as the file data source), can be examined to
+// determine which feature flags have changed, either directly or because of a change to one of their
+// prerequisites or segments. LDClient always interposes this between the UpdateProcessor and the
+// application's FeatureStore. It is also where flags and segments are preprocessed for evaluation.
+type changeTrackingFeatureStore struct {
+	generation   uint64 // accessed atomically; must be first for alignment on 32-bit platforms
+	store        FeatureStore
+	dependencies dependencyTracker
+	versions     map[kindAndKey]int // the last version of each item, including deleted ones
+	broadcaster  *flagChangeBroadcaster
+	lock         sync.Mutex
+}
+
+func newChangeTrackingFeatureStore(store FeatureStore, broadcaster *flagChangeBroadcaster) *changeTrackingFeatureStore {
+	return &changeTrackingFeatureStore{
+		store:        store,
+		dependencies: newDependencyTracker(),
+		versions:     make(map[kindAndKey]int),
+		broadcaster:  broadcaster,
+	}
+}
+
+// Get returns an individual object of a given type from the underlying store.
+func (s *changeTrackingFeatureStore) Get(kind VersionedDataKind, key string) (VersionedData, error) {
+	return s.store.Get(kind, key)
+}
+
+// All returns all the objects of a given kind from the underlying store.
+func (s *changeTrackingFeatureStore) All(kind VersionedDataKind) (map[string]VersionedData, error) {
+	return s.store.All(kind)
+}
+
+// Snapshot returns a snapshot of the underlying store if it is a FeatureStoreSnapshotProvider, or
+// otherwise the underlying store itself.
+func (s *changeTrackingFeatureStore) Snapshot() FeatureStore {
+	if p, ok := s.store.(FeatureStoreSnapshotProvider); ok {
+		return p.Snapshot()
+	}
+	return s.store
+}
+
+// Close closes the underlying store, if it implements io.Closer.
+func (s *changeTrackingFeatureStore) Close() error {
+	if closer, ok := s.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Returns a number that is incremented every time the contents of the store are changed, so that
+// results computed from an earlier version of the data can be recognized as stale.
+func (s *changeTrackingFeatureStore) currentGeneration() uint64 {
+	return atomic.LoadUint64(&s.generation)
+}
+
+// Initialized returns whether the underlying store has been initialized with data.
+func (s *changeTrackingFeatureStore) Initialized() bool {
+	return s.store.Initialized()
+}
+
+// Init replaces the contents of the underlying store, and reports any flags that were affected.
+func (s *changeTrackingFeatureStore) Init(allData map[VersionedDataKind]map[string]VersionedData) error {
+	changedFlagKeys, err := s.initAndFindChanges(allData)
+	s.sendChangeEvents(changedFlagKeys)
+	return err
+}
+
+func (s *changeTrackingFeatureStore) initAndFindChanges(allData map[VersionedDataKind]map[string]VersionedData) ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// We only need to compare against the old data if someone is listening for changes; this avoids
+	// an extra query in the usual case where there are no listeners and the store is a database.
+	var oldData map[VersionedDataKind]map[string]VersionedData
+	if s.broadcaster.hasListeners() {
+		oldData = make(map[VersionedDataKind]map[string]VersionedData)
+		for _, kind := range VersionedDataKinds {
+			if items, err := s.store.All(kind); err == nil {
+				oldData[kind] = items
+			}
+		}
+	}
+
+	for _, items := range allData {
+		for _, item := range items {
+			PreprocessItem(item)
+		}
+	}
+	if err := s.store.Init(allData); err != nil {
+		return nil, err
+	}
+	atomic.AddUint64(&s.generation, 1)
+
+	s.dependencies.reset()
+	s.versions = make(map[kindAndKey]int)
+	for kind, items := range allData {
+		for key, item := range items {
+			s.dependencies.updateDependenciesFrom(kindAndKey{kind, key}, item)
+			s.versions[kindAndKey{kind, key}] = item.GetVersion()
+		}
+	}
+
+	if oldData == nil {
+		return nil, nil
+	}
+	affectedItems := make(kindAndKeySet)
+	for _, kind := range VersionedDataKinds {
+		oldItems := oldData[kind]
+		newItems := allData[kind]
+		for key, oldItem := range oldItems {
+			if hasVersionChanged(oldItem, newItems[key]) {
+				s.dependencies.addAffectedItems(affectedItems, kindAndKey{kind, key})
+			}
+		}
+		for key, newItem := range newItems {
+			if _, existed := oldItems[key]; !existed && !newItem.IsDeleted() {
+				s.dependencies.addAffectedItems(affectedItems, kindAndKey{kind, key})
+			}
+		}
+	}
+	return affectedFlagKeys(affectedItems), nil
+}
+
+// Upsert updates or adds an item in the underlying store, and reports any flags that were affected.
+func (s *changeTrackingFeatureStore) Upsert(kind VersionedDataKind, item VersionedData) error {
+	PreprocessItem(item)
+	changedFlagKeys, err := s.updateAndFindChanges(kind, item.GetKey(), item.GetVersion(), item,
+		func() error { return s.store.Upsert(kind, item) })
+	s.sendChangeEvents(changedFlagKeys)
+	return err
+}
+
+// Delete deletes an item from the underlying store, and reports any flags that were affected.
+func (s *changeTrackingFeatureStore) Delete(kind VersionedDataKind, key string, version int) error {
+	changedFlagKeys, err := s.updateAndFindChanges(kind, key, version, nil,
+		func() error { return s.store.Delete(kind, key, version) })
+	s.sendChangeEvents(changedFlagKeys)
+	return err
+}
+
+func (s *changeTrackingFeatureStore) updateAndFindChanges(kind VersionedDataKind, key string, version int,
+	newItem VersionedData, action func() error) ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	itemKey := kindAndKey{kind, key}
+	// Get never returns deleted items, so we remember the versions that we have seen, including those
+	// of deleted items; we only need to ask the store about an item that we have not seen yet.
+	oldVersion, known := s.versions[itemKey]
+	if !known {
+		if oldItem, _ := s.store.Get(kind, key); oldItem != nil {
+			oldVersion, known = oldItem.GetVersion(), true
+		}
+	}
+	if err := action(); err != nil {
+		return nil, err
+	}
+	if known && oldVersion >= version {
+		return nil, nil // the store will have ignored this update since it was out of date
+	}
+	s.versions[itemKey] = version
+	atomic.AddUint64(&s.generation, 1)
+	s.dependencies.updateDependenciesFrom(itemKey, newItem)
+	if !s.broadcaster.hasListeners() {
+		return nil, nil
+	}
+	affectedItems := make(kindAndKeySet)
+	s.dependencies.addAffectedItems(affectedItems, itemKey)
+	return affectedFlagKeys(affectedItems), nil
+}
+
+func hasVersionChanged(oldItem, newItem VersionedData) bool {
+	if newItem == nil || newItem.IsDeleted() {
+		return !oldItem.IsDeleted()
+	}
+	return oldItem.IsDeleted() || oldItem.GetVersion() != newItem.GetVersion()
+}
+
+func affectedFlagKeys(affectedItems kindAndKeySet) []string {
+	flagKeys := make([]string, 0, len(affectedItems))
+	for item := range affectedItems {
+		if item.kind == Features {
+			flagKeys = append(flagKeys, item.key)
+		}
+	}
+	sort.Strings(flagKeys)
+	return flagKeys
+}
+
+// Sends change events for the specified flags. This must not be called while holding the lock, since
+// a listener that is slow to read its channel would then hold up every write to the store.
+func (s *changeTrackingFeatureStore) sendChangeEvents(flagKeys []string) {
+	for _, key := range flagKeys {
+		s.broadcaster.broadcast(FlagChangeEvent{Key: key})
+	}
+}
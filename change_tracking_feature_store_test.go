@@ -0,0 +1,297 @@
+package ldclient
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/launchdarkly/go-client.v4/internal/broadcast"
+)
+
+func makeChangeTrackingStore() (*changeTrackingFeatureStore, *flagChangeBroadcaster) {
+	broadcaster := newFlagChangeBroadcaster()
+	store := newChangeTrackingFeatureStore(NewInMemoryFeatureStore(log.New(ioutil.Discard, "", 0)), broadcaster)
+	return store, broadcaster
+}
+
+func expectFlagChangeEvents(t *testing.T, ch <-chan FlagChangeEvent, keys ...string) {
+	actual := make(map[string]bool)
+	for range keys {
+		select {
+		case e := <-ch:
+			actual[e.Key] = true
+		case <-time.After(time.Second):
+			require.Fail(t, "timed out waiting for flag change event")
+		}
+	}
+	expected := make(map[string]bool)
+	for _, key := range keys {
+		expected[key] = true
+	}
+	assert.Equal(t, expected, actual)
+	expectNoFlagChangeEvents(t, ch)
+}
+
+func expectNoFlagChangeEvents(t *testing.T, ch <-chan FlagChangeEvent) {
+	select {
+	case e := <-ch:
+		assert.Fail(t, "received unexpected flag change event", "key: %s", e.Key)
+	default:
+	}
+}
+
+func flagWithPrereq(key string, version int, prereqKey string) *FeatureFlag {
+	return &FeatureFlag{Key: key, Version: version, Prerequisites: []Prerequisite{{Key: prereqKey}}}
+}
+
+func flagWithSegment(key string, version int, segmentKey string) *FeatureFlag {
+	clause := Clause{Attribute: "", Op: OperatorSegmentMatch, Values: []interface{}{segmentKey}}
+	return &FeatureFlag{Key: key, Version: version, Rules: []Rule{{Clauses: []Clause{clause}}}}
+}
+
+func TestChangeTrackingStoreSendsEventForUpsertedFlag(t *testing.T) {
+	store, broadcaster := makeChangeTrackingStore()
+	require.NoError(t, store.Init(MakeAllVersionedDataMap(map[string]*FeatureFlag{
+		"flag1": {Key: "flag1", Version: 1},
+		"flag2": {Key: "flag2", Version: 1},
+	}, nil)))
+	ch := broadcaster.addListener("", true)
+
+	require.NoError(t, store.Upsert(Features, &FeatureFlag{Key: "flag1", Version: 2}))
+	expectFlagChangeEvents(t, ch, "flag1")
+}
+
+func TestChangeTrackingStoreSendsNoEventForOutdatedUpsert(t *testing.T) {
+	store, broadcaster := makeChangeTrackingStore()
+	require.NoError(t, store.Init(MakeAllVersionedDataMap(map[string]*FeatureFlag{
+		"flag1": {Key: "flag1", Version: 2},
+	}, nil)))
+	ch := broadcaster.addListener("", true)
+
+	require.NoError(t, store.Upsert(Features, &FeatureFlag{Key: "flag1", Version: 2}))
+	require.NoError(t, store.Upsert(Features, &FeatureFlag{Key: "flag1", Version: 1}))
+	expectNoFlagChangeEvents(t, ch)
+}
+
+func TestChangeTrackingStoreSendsEventForDeletedFlag(t *testing.T) {
+	store, broadcaster := makeChangeTrackingStore()
+	require.NoError(t, store.Init(MakeAllVersionedDataMap(map[string]*FeatureFlag{
+		"flag1": {Key: "flag1", Version: 1},
+	}, nil)))
+	ch := broadcaster.addListener("", true)
+
+	require.NoError(t, store.Delete(Features, "flag1", 2))
+	expectFlagChangeEvents(t, ch, "flag1")
+}
+
+func TestChangeTrackingStoreSendsNoEventForUpsertOlderThanDeletion(t *testing.T) {
+	store, broadcaster := makeChangeTrackingStore()
+	require.NoError(t, store.Init(MakeAllVersionedDataMap(map[string]*FeatureFlag{
+		"flag1": {Key: "flag1", Version: 1},
+		"flag2": {Key: "flag2", Version: 1},
+	}, nil)))
+	require.NoError(t, store.Delete(Features, "flag1", 3))
+	ch := broadcaster.addListener("", true)
+
+	require.NoError(t, store.Upsert(Features, flagWithPrereq("flag1", 2, "flag2")))
+	expectNoFlagChangeEvents(t, ch)
+
+	// The ignored upsert must not have made flag1 depend on flag2
+	require.NoError(t, store.Upsert(Features, &FeatureFlag{Key: "flag2", Version: 2}))
+	expectFlagChangeEvents(t, ch, "flag2")
+}
+
+func TestChangeTrackingStoreSendsEventsForFlagsAffectedByPrerequisiteChange(t *testing.T) {
+	store, broadcaster := makeChangeTrackingStore()
+	require.NoError(t, store.Init(MakeAllVersionedDataMap(map[string]*FeatureFlag{
+		"flag1": {Key: "flag1", Version: 1},
+		"flag2": flagWithPrereq("flag2", 1, "flag1"),
+		"flag3": flagWithPrereq("flag3", 1, "flag2"),
+		"flag4": {Key: "flag4", Version: 1},
+	}, nil)))
+	ch := broadcaster.addListener("", true)
+
+	require.NoError(t, store.Upsert(Features, &FeatureFlag{Key: "flag1", Version: 2}))
+	expectFlagChangeEvents(t, ch, "flag1", "flag2", "flag3")
+}
+
+func TestChangeTrackingStoreSendsEventsForFlagsAffectedBySegmentChange(t *testing.T) {
+	store, broadcaster := makeChangeTrackingStore()
+	require.NoError(t, store.Init(MakeAllVersionedDataMap(map[string]*FeatureFlag{
+		"flag1": flagWithSegment("flag1", 1, "segment1"),
+		"flag2": flagWithPrereq("flag2", 1, "flag1"),
+		"flag3": {Key: "flag3", Version: 1},
+	}, map[string]*Segment{
+		"segment1": {Key: "segment1", Version: 1},
+	})))
+	ch := broadcaster.addListener("", true)
+
+	require.NoError(t, store.Upsert(Segments, &Segment{Key: "segment1", Version: 2}))
+	expectFlagChangeEvents(t, ch, "flag1", "flag2")
+}
+
+func TestChangeTrackingStoreSendsEventsForChangesInInit(t *testing.T) {
+	store, broadcaster := makeChangeTrackingStore()
+	require.NoError(t, store.Init(MakeAllVersionedDataMap(map[string]*FeatureFlag{
+		"unchanged": {Key: "unchanged", Version: 1},
+		"changed":   {Key: "changed", Version: 1},
+		"removed":   {Key: "removed", Version: 1},
+		"dependent": flagWithSegment("dependent", 1, "segment1"),
+	}, map[string]*Segment{
+		"segment1": {Key: "segment1", Version: 1},
+	})))
+	ch := broadcaster.addListener("", true)
+
+	require.NoError(t, store.Init(MakeAllVersionedDataMap(map[string]*FeatureFlag{
+		"unchanged": {Key: "unchanged", Version: 1},
+		"changed":   {Key: "changed", Version: 2},
+		"added":     {Key: "added", Version: 1},
+		"dependent": flagWithSegment("dependent", 1, "segment1"),
+	}, map[string]*Segment{
+		"segment1": {Key: "segment1", Version: 2},
+	})))
+	expectFlagChangeEvents(t, ch, "changed", "removed", "added", "dependent")
+}
+
+func TestChangeTrackingStoreToleratesPrerequisiteCycle(t *testing.T) {
+	store, broadcaster := makeChangeTrackingStore()
+	require.NoError(t, store.Init(MakeAllVersionedDataMap(map[string]*FeatureFlag{
+		"flag1": flagWithPrereq("flag1", 1, "flag2"),
+		"flag2": flagWithPrereq("flag2", 1, "flag1"),
+	}, nil)))
+	ch := broadcaster.addListener("", true)
+
+	require.NoError(t, store.Upsert(Features, flagWithPrereq("flag1", 2, "flag2")))
+	expectFlagChangeEvents(t, ch, "flag1", "flag2")
+}
+
+func TestFlagChangeListenerForKeyOnlyReceivesThatKey(t *testing.T) {
+	store, broadcaster := makeChangeTrackingStore()
+	require.NoError(t, store.Init(MakeAllVersionedDataMap(map[string]*FeatureFlag{
+		"flag1": {Key: "flag1", Version: 1},
+		"flag2": {Key: "flag2", Version: 1},
+	}, nil)))
+	ch := broadcaster.addListener("flag2", false)
+
+	require.NoError(t, store.Upsert(Features, &FeatureFlag{Key: "flag1", Version: 2}))
+	require.NoError(t, store.Upsert(Features, &FeatureFlag{Key: "flag2", Version: 2}))
+	expectFlagChangeEvents(t, ch, "flag2")
+}
+
+func TestRemovedFlagChangeListenerIsClosed(t *testing.T) {
+	_, broadcaster := makeChangeTrackingStore()
+	ch := broadcaster.addListener("", true)
+	broadcaster.removeListener(ch)
+	_, ok := <-ch
+	assert.False(t, ok)
+	assert.False(t, broadcaster.hasListeners())
+}
+
+func TestUnreadFlagChangeListenerDoesNotBlockStoreUpdates(t *testing.T) {
+	store, broadcaster := makeChangeTrackingStore()
+	ch := broadcaster.addListener("", true)
+	count := broadcast.ListenerBufferSize * 3
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < count; i++ {
+			_ = store.Upsert(Features, &FeatureFlag{Key: fmt.Sprintf("flag%d", i), Version: 1})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.Fail(t, "store update was blocked by a listener that was not being read")
+	}
+
+	var expected []string
+	for i := 0; i < count; i++ {
+		expected = append(expected, fmt.Sprintf("flag%d", i))
+	}
+	expectFlagChangeEvents(t, ch, expected...)
+
+	broadcaster.removeListener(ch)
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestFlagChangeListenerIsNotSentDuplicateEventsWhileBehind(t *testing.T) {
+	store, broadcaster := makeChangeTrackingStore()
+	ch := broadcaster.addListener("", true)
+	defer broadcaster.removeListener(ch)
+	for i := 0; i < broadcast.ListenerBufferSize; i++ {
+		require.NoError(t, store.Upsert(Features, &FeatureFlag{Key: fmt.Sprintf("flag%d", i), Version: 1}))
+	}
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, store.Upsert(Features, &FeatureFlag{Key: "flagA", Version: i}))
+		require.NoError(t, store.Upsert(Features, &FeatureFlag{Key: "flagB", Version: i}))
+	}
+
+	for i := 0; i < broadcast.ListenerBufferSize; i++ {
+		<-ch
+	}
+	expectFlagChangeEvents(t, ch, "flagA", "flagB")
+	select {
+	case e := <-ch:
+		assert.Fail(t, "received unexpected event", "%+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSlowFlagChangeListenerDoesNotBlockOtherStoreUpdates(t *testing.T) {
+	store, broadcaster := makeChangeTrackingStore()
+	ch := broadcaster.addListener("flag1", false)
+	defer broadcaster.removeListener(ch)
+	go func() {
+		for i := 1; i <= broadcast.ListenerBufferSize+1; i++ {
+			_ = store.Upsert(Features, &FeatureFlag{Key: "flag1", Version: i})
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		_ = store.Upsert(Features, &FeatureFlag{Key: "flag2", Version: 1})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail(t, "store update was blocked by a listener for a different flag")
+	}
+}
+
+func TestClientFlagChangeListenerReceivesUpdatesFromUpdateProcessor(t *testing.T) {
+	client := makeTestClient()
+	ch := client.AddFlagChangeListener()
+
+	require.NoError(t, client.config.FeatureStore.Upsert(Features, &FeatureFlag{Key: "flag", Version: 1}))
+	expectFlagChangeEvents(t, ch, "flag")
+
+	client.Close()
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestChangeTrackingStoreProvidesSnapshotOfUnderlyingStore(t *testing.T) {
+	store, _ := makeChangeTrackingStore()
+	require.NoError(t, store.Upsert(Features, &FeatureFlag{Key: "flag", Version: 1}))
+	snapshot := store.Snapshot()
+	require.NoError(t, store.Upsert(Features, &FeatureFlag{Key: "flag", Version: 2}))
+
+	item, err := snapshot.Get(Features, "flag")
+	require.NoError(t, err)
+	assert.Equal(t, 1, item.GetVersion())
+	assert.Equal(t, ErrFeatureStoreSnapshotReadOnly, snapshot.Upsert(Features, &FeatureFlag{Key: "flag", Version: 3}))
+}
+
+func TestChangeTrackingStoreUsesUnderlyingStoreIfItCannotProvideSnapshot(t *testing.T) {
+	underlyingStore := struct{ FeatureStore }{NewInMemoryFeatureStore(log.New(ioutil.Discard, "", 0))} // hides Snapshot
+	store := newChangeTrackingFeatureStore(underlyingStore, newFlagChangeBroadcaster())
+	assert.Equal(t, underlyingStore, store.Snapshot())
+}
@@ -0,0 +1,250 @@
+package ldclient
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	es "github.com/launchdarkly/eventsource"
+
+	"gopkg.in/launchdarkly/go-client.v4/internal/broadcast"
+)
+
+// DataSourceState describes the overall state of the component that receives feature flag data
+// from LaunchDarkly (the UpdateProcessor).
+type DataSourceState string
+
+const (
+	// DataSourceStateInitializing means that the data source has not yet received feature flag data.
+	// It stays in this state if it fails to connect, until it either succeeds or gives up permanently;
+	// so this state means "never connected", as opposed to DataSourceStateInterrupted.
+	DataSourceStateInitializing DataSourceState = "INITIALIZING"
+	// DataSourceStateValid means that the data source has received feature flag data and, as far as we
+	// know, is still receiving updates.
+	DataSourceStateValid DataSourceState = "VALID"
+	// DataSourceStateInterrupted means that the data source had received feature flag data, but then
+	// encountered a problem (such as a dropped stream connection) and is trying to recover. Flag
+	// evaluations will use the last known data, which may be stale.
+	DataSourceStateInterrupted DataSourceState = "INTERRUPTED"
+	// DataSourceStateOff means that the data source has been shut down, either because the client was
+	// closed or because it encountered an error that it cannot recover from (such as an invalid SDK key).
+	DataSourceStateOff DataSourceState = "OFF"
+)
+
+// DataSourceErrorKind describes the general category of an error reported in DataSourceErrorInfo.
+type DataSourceErrorKind string
+
+const (
+	// DataSourceErrorKindUnknown is used for any error that does not fit one of the other categories.
+	DataSourceErrorKindUnknown DataSourceErrorKind = "UNKNOWN"
+	// DataSourceErrorKindNetworkError means that an I/O error occurred, such as a failure to connect or
+	// a dropped connection.
+	DataSourceErrorKindNetworkError DataSourceErrorKind = "NETWORK_ERROR"
+	// DataSourceErrorKindErrorResponse means that LaunchDarkly returned an HTTP error status. The status
+	// is in DataSourceErrorInfo.StatusCode.
+	DataSourceErrorKindErrorResponse DataSourceErrorKind = "ERROR_RESPONSE"
+	// DataSourceErrorKindInvalidData means that data was received from LaunchDarkly but could not be parsed.
+	DataSourceErrorKindInvalidData DataSourceErrorKind = "INVALID_DATA"
+	// DataSourceErrorKindStoreError means that the data source received data but could not write it to
+	// the FeatureStore.
+	DataSourceErrorKindStoreError DataSourceErrorKind = "STORE_ERROR"
+)
+
+// DataSourceErrorInfo describes an error that was encountered by the data source.
+type DataSourceErrorInfo struct {
+	// Kind is the general category of the error.
+	Kind DataSourceErrorKind
+	// StatusCode is the HTTP status code, if Kind is DataSourceErrorKindErrorResponse; otherwise zero.
+	StatusCode int
+	// Message is a description of the error, if any.
+	Message string
+	// Time is the time at which the error occurred.
+	Time time.Time
+}
+
+// DataSourceStatus describes the state of the data source at a point in time. It can be obtained from
+// LDClient.GetDataSourceStatus, or received from a channel returned by LDClient.AddDataSourceStatusListener.
+type DataSourceStatus struct {
+	// State is the overall state of the data source.
+	State DataSourceState
+	// StateSince is the time at which the data source entered its current state. This does not change
+	// if the data source reports a new error without changing state.
+	StateSince time.Time
+	// LastError describes the most recent error, regardless of whether it caused a state change. If no
+	// error has occurred, its Kind is an empty string.
+	LastError DataSourceErrorInfo
+}
+
+func newDataSourceErrorInfo(kind DataSourceErrorKind, err error) *DataSourceErrorInfo {
+	info := &DataSourceErrorInfo{Kind: kind, Time: time.Now()}
+	if err != nil {
+		info.Message = err.Error()
+	}
+	return info
+}
+
+func newDataSourceHTTPErrorInfo(statusCode int, err error) *DataSourceErrorInfo {
+	info := newDataSourceErrorInfo(DataSourceErrorKindErrorResponse, err)
+	info.StatusCode = statusCode
+	return info
+}
+
+// Returned by UpdateProcessors when an error came from the FeatureStore rather than from LaunchDarkly.
+type dataSourceStoreError struct {
+	err error
+}
+
+func (e dataSourceStoreError) Error() string {
+	return e.err.Error()
+}
+
+// Chooses the error kind for an error that was returned by a request to LaunchDarkly or by the
+// FeatureStore.
+func makeDataSourceErrorInfo(err error) *DataSourceErrorInfo {
+	switch e := err.(type) {
+	case HttpStatusError:
+		return newDataSourceHTTPErrorInfo(e.Code, err)
+	case es.SubscriptionError:
+		return newDataSourceHTTPErrorInfo(e.Code, err)
+	case dataSourceStoreError:
+		return newDataSourceErrorInfo(DataSourceErrorKindStoreError, e.err)
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return newDataSourceErrorInfo(DataSourceErrorKindInvalidData, err)
+	default:
+		return newDataSourceErrorInfo(DataSourceErrorKindNetworkError, err)
+	}
+}
+
+// Implemented by the SDK's own UpdateProcessors, which can report status changes in detail. For any
+// other UpdateProcessor, the client infers the status from Initialized().
+type dataSourceStatusReportingUpdateProcessor interface {
+	setDataSourceStatusManager(m *dataSourceStatusManager)
+}
+
+// Maintains the current DataSourceStatus and notifies listeners of changes.
+type dataSourceStatusManager struct {
+	status      DataSourceStatus
+	lastUpdate  time.Time // the last time the data source received valid data
+	broadcaster *broadcast.Broadcaster
+	lock        sync.Mutex
+	updateLock  sync.Mutex // ensures that listeners receive updates in the same order they were made
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+func newDataSourceStatusManager() *dataSourceStatusManager {
+	return &dataSourceStatusManager{
+		status:      DataSourceStatus{State: DataSourceStateInitializing, StateSince: time.Now()},
+		broadcaster: broadcast.New(),
+		done:        make(chan struct{}),
+	}
+}
+
+func (m *dataSourceStatusManager) getStatus() DataSourceStatus {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.status
+}
+
+// Updates the state (unless newState is empty), and also the last error if newError is non-nil.
+// Listeners are notified only if something has changed. A nil manager ignores all updates, so that
+// processors that were created outside of the client do not have to check for one.
+func (m *dataSourceStatusManager) updateStatus(newState DataSourceState, newError *DataSourceErrorInfo) {
+	if m == nil {
+		return
+	}
+	m.updateLock.Lock()
+	defer m.updateLock.Unlock()
+	select {
+	case <-m.done:
+		return // once we've been closed, the state stays at Off
+	default:
+	}
+
+	m.lock.Lock()
+	if newState == DataSourceStateValid {
+		m.lastUpdate = time.Now()
+	}
+	oldStatus := m.status
+	if newState == "" {
+		newState = oldStatus.State
+	}
+	if newState == DataSourceStateInterrupted && oldStatus.State == DataSourceStateInitializing {
+		newState = DataSourceStateInitializing // we can't be interrupted if we never got any data
+	}
+	if newState == oldStatus.State && newError == nil {
+		m.lock.Unlock()
+		return
+	}
+	if newState != oldStatus.State {
+		m.status.State = newState
+		m.status.StateSince = time.Now()
+	}
+	if newError != nil {
+		m.status.LastError = *newError
+	}
+	newStatus := m.status
+	m.lock.Unlock()
+
+	m.broadcaster.Broadcast(newStatus)
+}
+
+// Records that the data source has received new data, without changing the state. This is not
+// necessary if it also calls updateStatus with DataSourceStateValid.
+func (m *dataSourceStatusManager) recordUpdate() {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	m.lastUpdate = time.Now()
+	m.lock.Unlock()
+}
+
+// Returns the last time the data source received valid data, or a zero time if it never has.
+func (m *dataSourceStatusManager) getLastUpdate() time.Time {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.lastUpdate
+}
+
+// Updates the last error without changing the state.
+func (m *dataSourceStatusManager) updateLastError(newError *DataSourceErrorInfo) {
+	m.updateStatus("", newError)
+}
+
+func (m *dataSourceStatusManager) addListener() <-chan DataSourceStatus {
+	ch := make(chan DataSourceStatus, broadcast.ListenerBufferSize)
+	var receiveCh <-chan DataSourceStatus = ch
+	m.broadcaster.Add(broadcast.NewSubscription(ch, nil, broadcast.KeepLatest))
+	return receiveCh
+}
+
+func (m *dataSourceStatusManager) removeListener(ch <-chan DataSourceStatus) {
+	m.broadcaster.Remove(ch)
+}
+
+// Sets the status for an UpdateProcessor that does not report its own status, once it has either
+// initialized or given up.
+func (m *dataSourceStatusManager) inferStatus(processor UpdateProcessor, closeWhenReady <-chan struct{}) {
+	select {
+	case <-closeWhenReady:
+		if processor.Initialized() {
+			m.updateStatus(DataSourceStateValid, nil)
+		} else {
+			m.updateStatus(DataSourceStateOff, nil)
+		}
+	case <-m.done:
+	}
+}
+
+// Sets the state to Off, notifies listeners, and then closes all listener channels. None of this waits
+// for listeners; one that has fallen behind in reading will not receive the final status.
+func (m *dataSourceStatusManager) close() {
+	m.closeOnce.Do(func() {
+		m.updateStatus(DataSourceStateOff, nil)
+		m.updateLock.Lock()
+		close(m.done)
+		m.updateLock.Unlock()
+		m.broadcaster.Close()
+	})
+}
@@ -0,0 +1,191 @@
+package ldclient
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/launchdarkly/go-client.v4/internal/broadcast"
+)
+
+func expectDataSourceStatus(t *testing.T, ch <-chan DataSourceStatus) DataSourceStatus {
+	select {
+	case s, ok := <-ch:
+		require.True(t, ok, "status channel was closed unexpectedly")
+		return s
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out waiting for data source status")
+	}
+	return DataSourceStatus{}
+}
+
+func waitForDataSourceState(t *testing.T, ch <-chan DataSourceStatus, state DataSourceState) DataSourceStatus {
+	deadline := time.After(time.Second * 3)
+	for {
+		select {
+		case s, ok := <-ch:
+			require.True(t, ok, "status channel was closed unexpectedly")
+			if s.State == state {
+				return s
+			}
+		case <-deadline:
+			require.Fail(t, "timed out waiting for data source state", "state: %s", state)
+		}
+	}
+}
+
+func TestDataSourceStatusIsInitiallyInitializing(t *testing.T) {
+	m := newDataSourceStatusManager()
+	status := m.getStatus()
+	assert.Equal(t, DataSourceStateInitializing, status.State)
+	assert.False(t, status.StateSince.IsZero())
+	assert.Equal(t, DataSourceErrorInfo{}, status.LastError)
+}
+
+func TestDataSourceStatusStaysInitializingAfterErrorBeforeFirstSuccess(t *testing.T) {
+	m := newDataSourceStatusManager()
+	ch := m.addListener()
+	since := m.getStatus().StateSince
+
+	m.updateStatus(DataSourceStateInterrupted, newDataSourceHTTPErrorInfo(503, errors.New("sorry")))
+
+	status := expectDataSourceStatus(t, ch)
+	assert.Equal(t, DataSourceStateInitializing, status.State)
+	assert.Equal(t, since, status.StateSince)
+	assert.Equal(t, DataSourceErrorKindErrorResponse, status.LastError.Kind)
+	assert.Equal(t, 503, status.LastError.StatusCode)
+	assert.Equal(t, "sorry", status.LastError.Message)
+}
+
+func TestDataSourceStatusBecomesInterruptedAfterSuccess(t *testing.T) {
+	m := newDataSourceStatusManager()
+	ch := m.addListener()
+
+	m.updateStatus(DataSourceStateValid, nil)
+	valid := expectDataSourceStatus(t, ch)
+	assert.Equal(t, DataSourceStateValid, valid.State)
+
+	m.updateStatus(DataSourceStateInterrupted, newDataSourceErrorInfo(DataSourceErrorKindNetworkError, errors.New("EOF")))
+	interrupted := expectDataSourceStatus(t, ch)
+	assert.Equal(t, DataSourceStateInterrupted, interrupted.State)
+	assert.False(t, interrupted.StateSince.Before(valid.StateSince))
+	assert.Equal(t, DataSourceErrorKindNetworkError, interrupted.LastError.Kind)
+}
+
+func TestDataSourceStatusDoesNotNotifyListenersIfNothingChanged(t *testing.T) {
+	m := newDataSourceStatusManager()
+	m.updateStatus(DataSourceStateValid, nil)
+	ch := m.addListener()
+
+	m.updateStatus(DataSourceStateValid, nil)
+
+	select {
+	case s := <-ch:
+		assert.Fail(t, "received unexpected status", "%+v", s)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDataSourceStatusErrorWithoutStateChangeKeepsStateSince(t *testing.T) {
+	m := newDataSourceStatusManager()
+	m.updateStatus(DataSourceStateValid, nil)
+	since := m.getStatus().StateSince
+	ch := m.addListener()
+
+	m.updateLastError(newDataSourceErrorInfo(DataSourceErrorKindInvalidData, errors.New("bad JSON")))
+
+	status := expectDataSourceStatus(t, ch)
+	assert.Equal(t, DataSourceStateValid, status.State)
+	assert.Equal(t, since, status.StateSince)
+	assert.Equal(t, DataSourceErrorKindInvalidData, status.LastError.Kind)
+}
+
+func TestDataSourceStatusIsOffAfterCloseAndIgnoresLaterUpdates(t *testing.T) {
+	m := newDataSourceStatusManager()
+	ch := m.addListener()
+
+	m.close()
+	assert.Equal(t, DataSourceStateOff, expectDataSourceStatus(t, ch).State)
+	_, ok := <-ch
+	assert.False(t, ok)
+
+	m.updateStatus(DataSourceStateValid, nil)
+	assert.Equal(t, DataSourceStateOff, m.getStatus().State)
+}
+
+func TestStalledDataSourceStatusListenerDoesNotBlockUpdatesOrClose(t *testing.T) {
+	client := makeTestClient()
+	ch := client.AddDataSourceStatusListener()
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < broadcast.ListenerBufferSize*3; i++ {
+			client.dataSourceStatus.updateLastError(&DataSourceErrorInfo{Kind: DataSourceErrorKindNetworkError})
+		}
+		client.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.Fail(t, "status updates or Close were blocked by a listener that was not being read")
+	}
+
+	received := 0
+	for range ch {
+		received++
+	}
+	assert.True(t, received <= broadcast.ListenerBufferSize+2, "received %d statuses", received)
+}
+
+func TestMakeDataSourceErrorInfoChoosesErrorKind(t *testing.T) {
+	assert.Equal(t, DataSourceErrorKindErrorResponse, makeDataSourceErrorInfo(HttpStatusError{Code: 500}).Kind)
+	assert.Equal(t, 500, makeDataSourceErrorInfo(HttpStatusError{Code: 500}).StatusCode)
+	assert.Equal(t, DataSourceErrorKindStoreError, makeDataSourceErrorInfo(dataSourceStoreError{errors.New("x")}).Kind)
+	assert.Equal(t, DataSourceErrorKindNetworkError, makeDataSourceErrorInfo(errors.New("x")).Kind)
+}
+
+func TestClientInfersDataSourceStatusForCustomUpdateProcessor(t *testing.T) {
+	updateProcessor := mockUpdateProcessor{
+		IsInitialized: true,
+		StartFn: func(closeWhenReady chan<- struct{}) {
+			close(closeWhenReady)
+		},
+	}
+	client, err := MakeCustomClient("sdkKey", Config{
+		Logger:                 log.New(ioutil.Discard, "", 0),
+		UpdateProcessorFactory: updateProcessorFactory(updateProcessor),
+		EventProcessor:         &testEventProcessor{},
+	}, time.Second)
+	require.NoError(t, err)
+	ch := client.AddDataSourceStatusListener()
+	if client.GetDataSourceStatus().State != DataSourceStateValid {
+		waitForDataSourceState(t, ch, DataSourceStateValid)
+	}
+
+	client.Close()
+	assert.Equal(t, DataSourceStateOff, client.GetDataSourceStatus().State)
+}
+
+func TestClientDataSourceStatusIsOffForCustomUpdateProcessorThatFailed(t *testing.T) {
+	updateProcessor := mockUpdateProcessor{
+		IsInitialized: false,
+		StartFn: func(closeWhenReady chan<- struct{}) {
+			close(closeWhenReady)
+		},
+	}
+	client, _ := MakeCustomClient("sdkKey", Config{
+		Logger:                 log.New(ioutil.Discard, "", 0),
+		UpdateProcessorFactory: updateProcessorFactory(updateProcessor),
+		EventProcessor:         &testEventProcessor{},
+	}, time.Second)
+	defer client.Close()
+	ch := client.AddDataSourceStatusListener()
+	if client.GetDataSourceStatus().State != DataSourceStateOff {
+		waitForDataSourceState(t, ch, DataSourceStateOff)
+	}
+}
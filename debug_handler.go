@@ -0,0 +1,206 @@
+package ldclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Maximum size of the user JSON accepted by the evaluation endpoint of DebugHandler.
+const debugMaxUserSize = 1 << 20
+
+const debugHandlerIndex = `LaunchDarkly client debug endpoints:
+
+GET  flags                    keys and versions of all flags in the feature store
+GET  flags/{key}              JSON representation of a flag
+POST flags/{key}/evaluate     evaluate a flag for the user in the request body, with a trace
+GET  segments                 keys and versions of all user segments in the feature store
+GET  segments/{key}           JSON representation of a user segment
+GET  status                   state of the data source, feature store, and event delivery
+`
+
+type debugItemSummary struct {
+	Version int   `json:"version"`
+	On      *bool `json:"on,omitempty"`
+}
+
+type debugEvaluationResult struct {
+	Value          interface{}               `json:"value"`
+	VariationIndex *int                      `json:"variationIndex"`
+	Reason         EvaluationReasonContainer `json:"reason"`
+	Trace          *EvaluationTrace          `json:"trace,omitempty"`
+}
+
+type debugStatus struct {
+	Mode                    string       `json:"mode"`
+	FeatureStoreInitialized bool         `json:"featureStoreInitialized"`
+	Health                  HealthStatus `json:"health"`
+}
+
+// DebugHandler returns an http.Handler for inspecting what the client currently knows, such as the
+// flags and segments in its FeatureStore, and for evaluating a flag for any user. Like the handlers
+// in net/http/pprof, it is meant to be served only on an administrative port, since it shows the
+// complete configuration of every flag.
+//
+// The handler interprets request paths relative to where it is mounted, so it should be used with
+// http.StripPrefix:
+//
+//	mux.Handle("/debug/launchdarkly/", http.StripPrefix("/debug/launchdarkly", client.DebugHandler()))
+//
+// A GET request for the root path returns a list of the endpoints. The handler never modifies the
+// client's state: evaluations made through it do not send analytics events or call evaluation hooks.
+func (client *LDClient) DebugHandler() http.Handler {
+	return http.HandlerFunc(client.serveDebug)
+}
+
+func (client *LDClient) serveDebug(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	var parts []string
+	if path != "" {
+		parts = strings.Split(path, "/")
+	}
+	if len(parts) == 3 && parts[0] == "flags" && parts[2] == "evaluate" {
+		if r.Method != "POST" {
+			debugMethodNotAllowed(w, "POST")
+			return
+		}
+		client.serveDebugEvaluation(w, r, parts[1])
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		debugMethodNotAllowed(w, "GET")
+		return
+	}
+	switch {
+	case len(parts) == 0:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(w, debugHandlerIndex)
+	case len(parts) == 1 && parts[0] == "flags":
+		client.serveDebugItems(w, Features)
+	case len(parts) == 2 && parts[0] == "flags":
+		client.serveDebugItem(w, Features, parts[1])
+	case len(parts) == 1 && parts[0] == "segments":
+		client.serveDebugItems(w, Segments)
+	case len(parts) == 2 && parts[0] == "segments":
+		client.serveDebugItem(w, Segments, parts[1])
+	case len(parts) == 1 && parts[0] == "status":
+		writeDebugJSON(w, debugStatus{
+			Mode:                    client.dataSourceMode(),
+			FeatureStoreInitialized: client.store.Initialized(),
+			Health:                  client.GetHealthStatus(HealthOptions{}),
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (client *LDClient) serveDebugItems(w http.ResponseWriter, kind VersionedDataKind) {
+	items, err := client.store.All(kind)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	summaries := make(map[string]debugItemSummary, len(items))
+	for key, item := range items {
+		summary := debugItemSummary{Version: item.GetVersion()}
+		if flag, ok := item.(*FeatureFlag); ok {
+			on := flag.On
+			summary.On = &on
+		}
+		summaries[key] = summary
+	}
+	writeDebugJSON(w, summaries)
+}
+
+func (client *LDClient) serveDebugItem(w http.ResponseWriter, kind VersionedDataKind, key string) {
+	item, err := client.store.Get(kind, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if item == nil {
+		http.Error(w, fmt.Sprintf("%s %q not found", kind.GetNamespace(), key), http.StatusNotFound)
+		return
+	}
+	writeDebugJSON(w, item)
+}
+
+func (client *LDClient) serveDebugEvaluation(w http.ResponseWriter, r *http.Request, key string) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, debugMaxUserSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var user User
+	if err := json.Unmarshal(body, &user); err != nil {
+		http.Error(w, "request body must be a user in JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if user.Key == nil {
+		http.Error(w, "user must have a key", http.StatusBadRequest)
+		return
+	}
+
+	store := client.storeForEvaluation()
+	data, err := store.Get(Features, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	flag, ok := data.(*FeatureFlag)
+	if !ok {
+		http.Error(w, fmt.Sprintf("flag %q not found", key), http.StatusNotFound)
+		return
+	}
+	// Unlike the variation methods, this discards the events for prerequisites.
+	trace := &EvaluationTrace{}
+	detail, _ := flag.evaluateDetail(user, store, false, &evaluationState{logger: client.logger, trace: trace})
+	writeDebugJSON(w, debugEvaluationResult{
+		Value:          detail.Value,
+		VariationIndex: detail.VariationIndex,
+		Reason:         EvaluationReasonContainer{Reason: detail.Reason},
+		Trace:          trace,
+	})
+}
+
+// Describes how the client is getting feature flag data.
+func (client *LDClient) dataSourceMode() string {
+	switch {
+	case client.IsOffline():
+		return "offline"
+	case client.config.UseLdd:
+		return "ldd"
+	}
+	switch p := client.updateProcessor.(type) {
+	case *streamProcessor:
+		return "streaming"
+	case *pollingProcessor:
+		return "polling"
+	case *streamingWithPollingFallback:
+		if p.isPolling() {
+			return "polling (fallback from streaming)"
+		}
+		return "streaming"
+	default:
+		return "custom"
+	}
+}
+
+func writeDebugJSON(w http.ResponseWriter, value interface{}) {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(data)
+}
+
+func debugMethodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,138 @@
+package ldclient
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeDebugTestClient() *LDClient {
+	client := makeTestClient()
+	_ = client.store.Init(nil)
+	client.store.Upsert(Features, makeTestFlag("flag1", 1, "a", "b"))
+	flag2 := makeTestFlag("flag2", 1, "a", "b")
+	flag2.On = false
+	flag2.Version = 3
+	client.store.Upsert(Features, flag2)
+	client.store.Upsert(Segments, &Segment{Key: "segment1", Version: 2})
+	return client
+}
+
+func debugRequest(t *testing.T, client *LDClient, method, path, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	handler := http.StripPrefix("/debug/ld", client.DebugHandler())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, "/debug/ld"+path, strings.NewReader(body)))
+	var result map[string]interface{}
+	if rec.Header().Get("Content-Type") == "application/json" {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	}
+	return rec, result
+}
+
+func TestDebugHandlerIndex(t *testing.T) {
+	client := makeDebugTestClient()
+	defer client.Close()
+
+	rec, _ := debugRequest(t, client, "GET", "/", "")
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "flags/{key}/evaluate")
+}
+
+func TestDebugHandlerListsFlagsAndSegments(t *testing.T) {
+	client := makeDebugTestClient()
+	defer client.Close()
+
+	rec, flags := debugRequest(t, client, "GET", "/flags", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, map[string]interface{}{
+		"flag1": map[string]interface{}{"version": 1.0, "on": true},
+		"flag2": map[string]interface{}{"version": 3.0, "on": false},
+	}, flags)
+
+	_, segments := debugRequest(t, client, "GET", "/segments", "")
+	assert.Equal(t, map[string]interface{}{"segment1": map[string]interface{}{"version": 2.0}}, segments)
+}
+
+func TestDebugHandlerShowsSingleItem(t *testing.T) {
+	client := makeDebugTestClient()
+	defer client.Close()
+
+	rec, flag := debugRequest(t, client, "GET", "/flags/flag2", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "flag2", flag["key"])
+	assert.Equal(t, 3.0, flag["version"])
+	assert.Equal(t, []interface{}{"a", "b"}, flag["variations"])
+
+	_, segment := debugRequest(t, client, "GET", "/segments/segment1", "")
+	assert.Equal(t, "segment1", segment["key"])
+
+	rec, _ = debugRequest(t, client, "GET", "/flags/unknown", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestDebugHandlerEvaluatesFlagWithoutSendingEvents(t *testing.T) {
+	client := makeDebugTestClient()
+	defer client.Close()
+
+	rec, result := debugRequest(t, client, "POST", "/flags/flag1/evaluate", `{"key":"userkey"}`)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "b", result["value"])
+	assert.Equal(t, 1.0, result["variationIndex"])
+	assert.Equal(t, map[string]interface{}{"kind": "FALLTHROUGH"}, result["reason"])
+	trace := result["trace"].(map[string]interface{})
+	assert.Equal(t, "flag1", trace["FlagKey"])
+	assert.Len(t, client.eventProcessor.(*testEventProcessor).events, 0)
+}
+
+func TestDebugHandlerRejectsInvalidEvaluationRequests(t *testing.T) {
+	client := makeDebugTestClient()
+	defer client.Close()
+
+	rec, _ := debugRequest(t, client, "GET", "/flags/flag1/evaluate", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "POST", rec.Header().Get("Allow"))
+
+	rec, _ = debugRequest(t, client, "POST", "/flags/flag1/evaluate", `not json`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec, _ = debugRequest(t, client, "POST", "/flags/flag1/evaluate", `{"name":"no key"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec, _ = debugRequest(t, client, "POST", "/flags/unknown/evaluate", `{"key":"userkey"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestDebugHandlerIsReadOnly(t *testing.T) {
+	client := makeDebugTestClient()
+	defer client.Close()
+
+	rec, _ := debugRequest(t, client, "PUT", "/flags/flag1", `{}`)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	rec, _ = debugRequest(t, client, "DELETE", "/flags/flag1", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	_, flag := debugRequest(t, client, "GET", "/flags/flag1", "")
+	assert.Equal(t, "flag1", flag["key"])
+}
+
+func TestDebugHandlerShowsStatus(t *testing.T) {
+	client := makeDebugTestClient()
+	defer client.Close()
+
+	rec, status := debugRequest(t, client, "GET", "/status", "")
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "custom", status["mode"])
+	assert.Equal(t, true, status["featureStoreInitialized"])
+	health := status["health"].(map[string]interface{})
+	assert.Equal(t, true, health["ready"])
+	assert.NotNil(t, health["dataSource"])
+	assert.NotNil(t, health["events"])
+}
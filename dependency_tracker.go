@@ -0,0 +1,96 @@
+package ldclient
+
+// kindAndKey identifies an item of any VersionedDataKind.
+type kindAndKey struct {
+	kind VersionedDataKind
+	key  string
+}
+
+type kindAndKeySet map[kindAndKey]struct{}
+
+func (s kindAndKeySet) add(value kindAndKey) {
+	s[value] = struct{}{}
+}
+
+func (s kindAndKeySet) contains(value kindAndKey) bool {
+	_, ok := s[value]
+	return ok
+}
+
+// Keeps track of which items depend on which other items (flags on their prerequisite flags, and
+// flags on the segments referenced in their rules), so that when an item changes we can determine
+// every flag whose evaluation might be affected. This is not thread-safe; the caller is responsible
+// for synchronization.
+type dependencyTracker struct {
+	dependenciesFrom map[kindAndKey]kindAndKeySet
+	dependenciesTo   map[kindAndKey]kindAndKeySet
+}
+
+func newDependencyTracker() dependencyTracker {
+	return dependencyTracker{
+		dependenciesFrom: make(map[kindAndKey]kindAndKeySet),
+		dependenciesTo:   make(map[kindAndKey]kindAndKeySet),
+	}
+}
+
+func (d *dependencyTracker) reset() {
+	d.dependenciesFrom = make(map[kindAndKey]kindAndKeySet)
+	d.dependenciesTo = make(map[kindAndKey]kindAndKeySet)
+}
+
+// Updates the dependency graph when an item has changed. The item may be nil or deleted, in which
+// case it no longer has any dependencies.
+func (d *dependencyTracker) updateDependenciesFrom(from kindAndKey, item VersionedData) {
+	newDeps := computeDependenciesFrom(from.kind, item)
+	if oldDeps, ok := d.dependenciesFrom[from]; ok {
+		for oldDep := range oldDeps {
+			if depsToThisOldDep, ok := d.dependenciesTo[oldDep]; ok {
+				delete(depsToThisOldDep, from)
+			}
+		}
+	}
+	d.dependenciesFrom[from] = newDeps
+	for newDep := range newDeps {
+		depsToThisNewDep, ok := d.dependenciesTo[newDep]
+		if !ok {
+			depsToThisNewDep = make(kindAndKeySet)
+			d.dependenciesTo[newDep] = depsToThisNewDep
+		}
+		depsToThisNewDep.add(from)
+	}
+}
+
+// Adds the specified item to the set, along with every item that directly or indirectly depends on it.
+func (d *dependencyTracker) addAffectedItems(itemsOut kindAndKeySet, initialModifiedItem kindAndKey) {
+	if itemsOut.contains(initialModifiedItem) {
+		return // we have already visited this item, which also protects us against dependency cycles
+	}
+	itemsOut.add(initialModifiedItem)
+	for affectedItem := range d.dependenciesTo[initialModifiedItem] {
+		d.addAffectedItems(itemsOut, affectedItem)
+	}
+}
+
+func computeDependenciesFrom(kind VersionedDataKind, item VersionedData) kindAndKeySet {
+	ret := make(kindAndKeySet)
+	if item == nil || item.IsDeleted() {
+		return ret
+	}
+	if flag, ok := item.(*FeatureFlag); ok && kind == Features {
+		for _, prereq := range flag.Prerequisites {
+			ret.add(kindAndKey{Features, prereq.Key})
+		}
+		for _, rule := range flag.Rules {
+			for _, clause := range rule.Clauses {
+				if clause.Op == OperatorSegmentMatch {
+					for _, value := range clause.Values {
+						if segmentKey, ok := value.(string); ok {
+							ret.add(kindAndKey{Segments, segmentKey})
+						}
+					}
+				}
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,80 @@
+package ldclient
+
+import "context"
+
+// EvaluationHook is an interface for code that runs before and after each feature flag evaluation.
+// Hooks are registered with Config.EvaluationHooks. They can be used for tracing, metrics, auditing,
+// or changing the result of an evaluation.
+//
+// Hooks are called for evaluations made by the variation methods (including those of a UserEvaluator)
+// and for each flag in AllFlagsState, but not for EvaluateBatch, Evaluate, or TraceVariation. They are
+// called from whatever goroutine is doing the evaluation, so they must be thread-safe, and they should
+// be fast, since they delay the caller.
+type EvaluationHook interface {
+	// BeforeEvaluation is called before a flag is evaluated. The value that it returns is passed to the
+	// AfterEvaluation method of the same hook for the same evaluation, so that it can carry data such as
+	// a start time or a tracing span from one to the other.
+	//
+	// If it returns an error, the flag is not evaluated, and the BeforeEvaluation methods of any hooks
+	// registered after this one are not called. The variation method returns the default value with an
+	// EvalErrorException reason, and the error. This can be used to prevent some flags from being used.
+	//
+	// The context is the one that was passed to the ...Ctx variation method or ForUserCtx, or
+	// context.Background() otherwise.
+	BeforeEvaluation(ctx context.Context, params EvaluationHookParams) (interface{}, error)
+
+	// AfterEvaluation is called with the result of an evaluation, and returns the result that should be
+	// used instead; normally, that is the same EvaluationDetail. It is called even if the evaluation
+	// failed, unless the BeforeEvaluation method of the same hook returned an error.
+	//
+	// If the result is changed, it should normally be one of the flag's variations, with both the
+	// VariationIndex and the Value of that variation; the variation methods treat a result without a
+	// VariationIndex as the default value, and some of them, such as ValueVariation, use the index
+	// rather than the Value. The variation method converts the value in the same way as a value from
+	// the flag, and the analytics event contains the changed result.
+	AfterEvaluation(ctx context.Context, params EvaluationHookParams, data interface{}, detail EvaluationDetail) EvaluationDetail
+}
+
+// EvaluationHookParams describes the evaluation that an EvaluationHook is being called for.
+type EvaluationHookParams struct {
+	// FlagKey is the key of the feature flag.
+	FlagKey string
+	// User is the user that the flag is being evaluated for.
+	User User
+	// Default is the default value passed to the variation method, in the form in which it is sent in
+	// analytics events; for instance, for IntVariation it is a float64, and for DurationVariation it is
+	// a string. It is always nil for AllFlagsState.
+	Default interface{}
+}
+
+// Calls the BeforeEvaluation method of each hook in order, then evaluate, and then the AfterEvaluation
+// method of each hook in reverse order, so that the first hook sees the final result.
+func (client *LDClient) evaluateWithHooks(ctx context.Context, params EvaluationHookParams,
+	evaluate func() (EvaluationDetail, *FeatureFlag, error)) (EvaluationDetail, *FeatureFlag, error) {
+	hooks := client.config.EvaluationHooks
+	if len(hooks) == 0 {
+		return evaluate()
+	}
+
+	var detail EvaluationDetail
+	var flag *FeatureFlag
+	var err error
+	hookData := make([]interface{}, 0, len(hooks))
+	for _, hook := range hooks {
+		data, hookErr := hook.BeforeEvaluation(ctx, params)
+		if hookErr != nil {
+			detail = EvaluationDetail{Value: params.Default, Reason: newEvalReasonError(EvalErrorException)}
+			err = hookErr
+			break
+		}
+		hookData = append(hookData, data)
+	}
+	if err == nil {
+		detail, flag, err = evaluate()
+		detail.Value = deepCopyValue(detail.Value) // the hooks may modify it, but the flag is shared
+	}
+	for i := len(hookData) - 1; i >= 0; i-- {
+		detail = hooks[i].AfterEvaluation(ctx, params, hookData[i], detail)
+	}
+	return detail, flag, err
+}
@@ -0,0 +1,168 @@
+package ldclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Records the calls made to it in a log that can be shared between several hooks.
+type recordingHook struct {
+	name      string
+	calls     *[]string
+	beforeErr error
+	override  func(EvaluationDetail) EvaluationDetail
+	lock      *sync.Mutex
+	lastCtx   context.Context
+	lastAfter EvaluationHookParams
+}
+
+func newRecordingHooks(names ...string) []*recordingHook {
+	calls := &[]string{}
+	lock := &sync.Mutex{}
+	hooks := make([]*recordingHook, len(names))
+	for i, name := range names {
+		hooks[i] = &recordingHook{name: name, calls: calls, lock: lock}
+	}
+	return hooks
+}
+
+func (h *recordingHook) BeforeEvaluation(ctx context.Context, params EvaluationHookParams) (interface{}, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	*h.calls = append(*h.calls, fmt.Sprintf("%s before %s", h.name, params.FlagKey))
+	h.lastCtx = ctx
+	return h.name + " data", h.beforeErr
+}
+
+func (h *recordingHook) AfterEvaluation(ctx context.Context, params EvaluationHookParams, data interface{},
+	detail EvaluationDetail) EvaluationDetail {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	*h.calls = append(*h.calls, fmt.Sprintf("%s after %s: %v %v", h.name, params.FlagKey, data, detail.Value))
+	h.lastAfter = params
+	if h.override != nil {
+		return h.override(detail)
+	}
+	return detail
+}
+
+func makeHookTestClient(modify func(*Config), hooks ...*recordingHook) *LDClient {
+	return makeTestClientWithConfig(func(c *Config) {
+		for _, h := range hooks {
+			c.EvaluationHooks = append(c.EvaluationHooks, h)
+		}
+		modify(c)
+	})
+}
+
+func TestHooksAreCalledAroundEvaluationInOrder(t *testing.T) {
+	hooks := newRecordingHooks("a", "b")
+	client := makeHookTestClient(func(c *Config) {}, hooks...)
+	defer client.Close()
+	client.store.Upsert(Features, makeTestFlag("flag", 1, "x", "y"))
+
+	value, err := client.StringVariation("flag", evalTestUser, "default")
+
+	require.NoError(t, err)
+	assert.Equal(t, "y", value)
+	assert.Equal(t, []string{"a before flag", "b before flag", "b after flag: b data y", "a after flag: a data y"}, *hooks[0].calls)
+	assert.Equal(t, EvaluationHookParams{FlagKey: "flag", User: evalTestUser, Default: "default"}, hooks[0].lastAfter)
+}
+
+func TestHooksReceiveContextOfVariationMethod(t *testing.T) {
+	hooks := newRecordingHooks("a")
+	client := makeHookTestClient(func(c *Config) {}, hooks...)
+	defer client.Close()
+	ctx := context.WithValue(context.Background(), "key", "value")
+
+	_, _ = client.BoolVariationCtx(ctx, "flag", evalTestUser, false)
+
+	assert.Equal(t, "value", hooks[0].lastCtx.Value("key"))
+}
+
+func TestHookReceivesDefaultInEventForm(t *testing.T) {
+	hooks := newRecordingHooks("a")
+	client := makeHookTestClient(func(c *Config) {}, hooks...)
+	defer client.Close()
+
+	_, _ = client.IntVariation("flag", evalTestUser, 3)
+
+	assert.Equal(t, float64(3), hooks[0].lastAfter.Default)
+	assert.Equal(t, []string{"a before flag", "a after flag: a data 3"}, *hooks[0].calls)
+}
+
+func TestHookCanOverrideResult(t *testing.T) {
+	hooks := newRecordingHooks("a")
+	hooks[0].override = func(detail EvaluationDetail) EvaluationDetail {
+		return EvaluationDetail{Value: float64(1000), VariationIndex: intPtr(0), Reason: detail.Reason}
+	}
+	client := makeHookTestClient(func(c *Config) {}, hooks...)
+	defer client.Close()
+	flag := makeTestFlag("flag", 1, float64(1000), float64(2000))
+	client.store.Upsert(Features, flag)
+
+	value, detail, err := client.DurationVariationDetail("flag", evalTestUser, 0)
+
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, value)
+	assert.Equal(t, intPtr(0), detail.VariationIndex)
+	e := client.eventProcessor.(*testEventProcessor).events[0].(FeatureRequestEvent)
+	assert.Equal(t, float64(1000), e.Value)
+	assert.Equal(t, intPtr(0), e.Variation)
+}
+
+func TestHookErrorPreventsEvaluation(t *testing.T) {
+	hooks := newRecordingHooks("a", "b", "c")
+	hooks[1].beforeErr = errors.New("not allowed")
+	client := makeHookTestClient(func(c *Config) {}, hooks...)
+	defer client.Close()
+	client.store.Upsert(Features, makeTestFlag("flag", 1, "x", "y"))
+
+	value, detail, err := client.StringVariationDetail("flag", evalTestUser, "default")
+
+	assert.Equal(t, hooks[1].beforeErr, err)
+	assert.Equal(t, "default", value)
+	assert.Equal(t, newEvalReasonError(EvalErrorException), detail.Reason)
+	assert.Equal(t, []string{"a before flag", "b before flag", "a after flag: a data default"}, *hooks[0].calls)
+	e := client.eventProcessor.(*testEventProcessor).events[0].(FeatureRequestEvent)
+	assert.Equal(t, "default", e.Value)
+}
+
+func TestHooksAreCalledInOfflineMode(t *testing.T) {
+	hooks := newRecordingHooks("a")
+	client := makeHookTestClient(func(c *Config) { c.Offline = true }, hooks...)
+	defer client.Close()
+
+	value, _ := client.StringVariation("flag", evalTestUser, "default")
+
+	assert.Equal(t, "default", value)
+	assert.Equal(t, []string{"a before flag", "a after flag: a data default"}, *hooks[0].calls)
+}
+
+func TestHooksAreCalledForEachFlagInAllFlagsState(t *testing.T) {
+	hooks := newRecordingHooks("a")
+	hooks[0].override = func(detail EvaluationDetail) EvaluationDetail {
+		if detail.Value == "y" {
+			return EvaluationDetail{Value: "z", VariationIndex: intPtr(2), Reason: detail.Reason}
+		}
+		return detail
+	}
+	client := makeHookTestClient(func(c *Config) {}, hooks...)
+	defer client.Close()
+	client.store.Upsert(Features, makeTestFlag("flag1", 1, "x", "y", "z"))
+	client.store.Upsert(Features, makeTestFlag("flag2", 0, "x", "y"))
+
+	state := client.AllFlagsState(evalTestUser)
+
+	assert.Equal(t, map[string]interface{}{"flag1": "z", "flag2": "x"}, state.ToValuesMap())
+	assert.ElementsMatch(t, []string{"a before flag1", "a after flag1: a data y", "a before flag2", "a after flag2: a data x"},
+		*hooks[0].calls)
+	assert.Nil(t, hooks[0].lastAfter.Default)
+}
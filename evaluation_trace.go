@@ -0,0 +1,135 @@
+package ldclient
+
+import "gopkg.in/launchdarkly/go-client.v4/ldvalue"
+
+// EvaluationTrace is a detailed record of the steps taken to evaluate a feature flag for a user. It
+// is only produced on request (see FeatureFlag.EvaluateTrace and LDClient.TraceVariation), since
+// building it requires allocations that normal evaluation avoids.
+//
+// Steps that were not reached are not recorded: for instance, if the flag is off, Targets and Rules
+// are empty, and if the second rule matched, Rules has two entries.
+type EvaluationTrace struct {
+	// FlagKey is the key of the flag that was evaluated.
+	FlagKey string
+	// FlagVersion is the version of the flag that was evaluated.
+	FlagVersion int
+	// On is true if the flag's targeting was turned on.
+	On bool
+	// Prerequisites describes each prerequisite that was checked, in order.
+	Prerequisites []PrerequisiteTrace
+	// Targets describes each list of individually targeted users that was checked, in order.
+	Targets []TargetTrace
+	// Rules describes each rule that was checked, in order.
+	Rules []RuleTrace
+	// Rollout is non-nil if the result was chosen by a percentage rollout, either in the matching rule
+	// or in the fallthrough.
+	Rollout *RolloutTrace
+	// Detail is the result of the evaluation.
+	Detail EvaluationDetail
+}
+
+// PrerequisiteTrace describes how a prerequisite flag was checked during evaluation.
+type PrerequisiteTrace struct {
+	// Key is the key of the prerequisite flag.
+	Key string
+	// RequiredVariation is the variation index that the prerequisite flag must return.
+	RequiredVariation int
+	// Trace is the trace of the prerequisite flag's own evaluation, or nil if the flag was not found.
+	Trace *EvaluationTrace
+	// Satisfied is true if the prerequisite flag was on and returned the required variation.
+	Satisfied bool
+}
+
+// TargetTrace describes how a list of individually targeted users was checked during evaluation.
+type TargetTrace struct {
+	// Variation is the variation index for users in this list.
+	Variation int
+	// Matched is true if the user's key was in the list.
+	Matched bool
+}
+
+// RuleTrace describes how a flag rule was checked during evaluation.
+type RuleTrace struct {
+	// Index is the zero-based index of the rule within the flag.
+	Index int
+	// ID is the rule's unique identifier.
+	ID string
+	// Clauses describes each clause that was checked, in order. Clauses after the first one that does
+	// not match are not checked.
+	Clauses []ClauseTrace
+	// Matched is true if all of the rule's clauses matched.
+	Matched bool
+}
+
+// ClauseTrace describes how a clause was checked during evaluation.
+type ClauseTrace struct {
+	// Attribute is the name of the user attribute that the clause tests.
+	Attribute string
+	// Op is the clause's operator.
+	Op Operator
+	// Values are the values that the clause compares the user attribute with.
+	Values []ldvalue.Value
+	// Negate is true if the result of the clause is inverted.
+	Negate bool
+	// UserValue is the value of the user attribute, or a null value if the user does not have that
+	// attribute. It is not set for segmentMatch clauses.
+	UserValue ldvalue.Value
+	// Segments describes each segment that was checked, for a segmentMatch clause.
+	Segments []SegmentTrace
+	// Matched is the result of the clause, after taking Negate into account.
+	Matched bool
+}
+
+// SegmentTrace describes how a user's membership in a segment was determined during evaluation.
+type SegmentTrace struct {
+	// Key is the key of the segment.
+	Key string
+	// Found is false if the segment did not exist in the feature store.
+	Found bool
+	// Contained is true if the user is a member of the segment.
+	Contained bool
+	// Explanation describes why the user was or was not a member of the segment. It is nil if the user
+	// did not match anything in the segment.
+	Explanation *SegmentExplanation
+}
+
+// RolloutTrace describes how a user was assigned to a variation in a percentage rollout.
+type RolloutTrace struct {
+	// BucketBy is the user attribute that was used to compute the bucket.
+	BucketBy string
+	// BucketValue is the user's bucket, from 0 (inclusive) to 1 (exclusive). Each weighted variation
+	// in the rollout covers a consecutive range of buckets, in order.
+	BucketValue float32
+}
+
+// EvaluateTrace evaluates the feature flag for the given user in the same way as EvaluateDetail, but
+// also returns a trace of each step of the evaluation. It does not generate any events.
+func (f FeatureFlag) EvaluateTrace(user User, store FeatureStore) (EvaluationDetail, *EvaluationTrace) {
+	trace := &EvaluationTrace{}
+	detail, _ := f.evaluateDetail(user, store, false, &evaluationState{trace: trace})
+	detail.Value = deepCopyValue(detail.Value)
+	return detail, trace
+}
+
+// Records a rule, returning a pointer to it so that its clauses can be added. The pointer is only
+// valid until the next rule is added.
+func (t *EvaluationTrace) addRule(index int, rule Rule) *RuleTrace {
+	t.Rules = append(t.Rules, RuleTrace{Index: index, ID: rule.ID})
+	return &t.Rules[len(t.Rules)-1]
+}
+
+func newClauseTrace(c Clause) ClauseTrace {
+	values := make([]ldvalue.Value, len(c.Values))
+	for i, v := range c.Values {
+		values[i] = ldvalue.CopyArbitraryValue(v)
+	}
+	return ClauseTrace{Attribute: c.Attribute, Op: c.Op, Values: values, Negate: c.Negate}
+}
+
+func newRolloutTrace(r Rollout, user User, key, salt string) *RolloutTrace {
+	bucketBy := userKey
+	if r.BucketBy != nil {
+		bucketBy = *r.BucketBy
+	}
+	return &RolloutTrace{BucketBy: bucketBy, BucketValue: bucketUser(user, key, bucketBy, salt)}
+}
@@ -0,0 +1,238 @@
+package ldclient
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/launchdarkly/go-client.v4/ldvalue"
+)
+
+func TestTraceForFlagThatIsOff(t *testing.T) {
+	f := FeatureFlag{
+		Key:          "feature",
+		Version:      3,
+		OffVariation: intPtr(1),
+		Targets:      []Target{{Values: []string{"x"}, Variation: 0}},
+		Variations:   []interface{}{"on", "off"},
+	}
+
+	detail, trace := f.EvaluateTrace(flagUser, emptyFeatureStore)
+	assert.Equal(t, "off", detail.Value)
+	assert.Equal(t, &EvaluationTrace{FlagKey: "feature", FlagVersion: 3, Detail: detail}, trace)
+}
+
+func TestTraceRecordsTargetsRulesAndClauses(t *testing.T) {
+	email := "x@example.com"
+	user := User{Key: strPtr("x"), Email: &email}
+	f := FeatureFlag{
+		Key: "feature",
+		On:  true,
+		Targets: []Target{
+			{Values: []string{"a", "b"}, Variation: 0},
+		},
+		Rules: []Rule{
+			{
+				ID: "rule0",
+				Clauses: []Clause{
+					{Attribute: "email", Op: OperatorEndsWith, Values: []interface{}{"@other.com"}},
+					{Attribute: "key", Op: OperatorIn, Values: []interface{}{"x"}},
+				},
+				VariationOrRollout: VariationOrRollout{Variation: intPtr(0)},
+			},
+			{
+				ID: "rule1",
+				Clauses: []Clause{
+					{Attribute: "email", Op: OperatorEndsWith, Values: []interface{}{"@example.com"}},
+					{Attribute: "key", Op: OperatorIn, Values: []interface{}{"y"}, Negate: true},
+				},
+				VariationOrRollout: VariationOrRollout{Variation: intPtr(1)},
+			},
+			{
+				ID:                 "rule2",
+				VariationOrRollout: VariationOrRollout{Variation: intPtr(0)},
+			},
+		},
+		Fallthrough: VariationOrRollout{Variation: intPtr(0)},
+		Variations:  []interface{}{"a", "b"},
+	}
+
+	detail, trace := f.EvaluateTrace(user, emptyFeatureStore)
+	assert.Equal(t, "b", detail.Value)
+	assert.Equal(t, detail, trace.Detail)
+	assert.True(t, trace.On)
+	assert.Equal(t, []TargetTrace{{Variation: 0, Matched: false}}, trace.Targets)
+	assert.Equal(t, []RuleTrace{
+		{
+			Index: 0,
+			ID:    "rule0",
+			Clauses: []ClauseTrace{
+				{Attribute: "email", Op: OperatorEndsWith, Values: []ldvalue.Value{ldvalue.String("@other.com")},
+					UserValue: ldvalue.String(email), Matched: false},
+			},
+			Matched: false,
+		},
+		{
+			Index: 1,
+			ID:    "rule1",
+			Clauses: []ClauseTrace{
+				{Attribute: "email", Op: OperatorEndsWith, Values: []ldvalue.Value{ldvalue.String("@example.com")},
+					UserValue: ldvalue.String(email), Matched: true},
+				{Attribute: "key", Op: OperatorIn, Values: []ldvalue.Value{ldvalue.String("y")}, Negate: true,
+					UserValue: ldvalue.String("x"), Matched: true},
+			},
+			Matched: true,
+		},
+	}, trace.Rules)
+	assert.Nil(t, trace.Rollout)
+}
+
+func TestTraceRecordsMatchedTarget(t *testing.T) {
+	f := FeatureFlag{
+		Key: "feature",
+		On:  true,
+		Targets: []Target{
+			{Values: []string{"a"}, Variation: 0},
+			{Values: []string{"x"}, Variation: 1},
+			{Values: []string{"y"}, Variation: 0},
+		},
+		Rules:       []Rule{{Clauses: []Clause{}, VariationOrRollout: VariationOrRollout{Variation: intPtr(0)}}},
+		Fallthrough: VariationOrRollout{Variation: intPtr(0)},
+		Variations:  []interface{}{"a", "b"},
+	}
+
+	detail, trace := f.EvaluateTrace(flagUser, emptyFeatureStore)
+	assert.Equal(t, "b", detail.Value)
+	assert.Equal(t, []TargetTrace{{Variation: 0}, {Variation: 1, Matched: true}}, trace.Targets)
+	assert.Nil(t, trace.Rules)
+}
+
+func TestTraceRecordsRolloutBucket(t *testing.T) {
+	bucketBy := "name"
+	name := "Mina"
+	user := User{Key: strPtr("x"), Name: &name}
+	f := FeatureFlag{
+		Key:  "feature",
+		On:   true,
+		Salt: "salt",
+		Fallthrough: VariationOrRollout{Rollout: &Rollout{
+			BucketBy:   &bucketBy,
+			Variations: []WeightedVariation{{Variation: 0, Weight: 50000}, {Variation: 1, Weight: 50000}},
+		}},
+		Variations: []interface{}{"a", "b"},
+	}
+
+	detail, trace := f.EvaluateTrace(user, emptyFeatureStore)
+	require.NotNil(t, trace.Rollout)
+	assert.Equal(t, "name", trace.Rollout.BucketBy)
+	assert.Equal(t, bucketUser(user, "feature", "name", "salt"), trace.Rollout.BucketValue)
+	expectedIndex := 0
+	if trace.Rollout.BucketValue >= 0.5 {
+		expectedIndex = 1
+	}
+	assert.Equal(t, intPtr(expectedIndex), detail.VariationIndex)
+}
+
+func TestTraceRecordsSegmentMembership(t *testing.T) {
+	store := NewInMemoryFeatureStore(nil)
+	segment := Segment{Key: "segment", Included: []string{"x"}}
+	require.NoError(t, store.Upsert(Segments, &segment))
+	f := FeatureFlag{
+		Key: "feature",
+		On:  true,
+		Rules: []Rule{{
+			Clauses:            []Clause{{Op: OperatorSegmentMatch, Values: []interface{}{"missing", "segment"}}},
+			VariationOrRollout: VariationOrRollout{Variation: intPtr(1)},
+		}},
+		Fallthrough: VariationOrRollout{Variation: intPtr(0)},
+		Variations:  []interface{}{"a", "b"},
+	}
+
+	detail, trace := f.EvaluateTrace(flagUser, store)
+	assert.Equal(t, "b", detail.Value)
+	require.Len(t, trace.Rules, 1)
+	require.Len(t, trace.Rules[0].Clauses, 1)
+	clause := trace.Rules[0].Clauses[0]
+	assert.True(t, clause.Matched)
+	assert.Equal(t, []SegmentTrace{
+		{Key: "missing"},
+		{Key: "segment", Found: true, Contained: true, Explanation: &SegmentExplanation{Kind: "included"}},
+	}, clause.Segments)
+}
+
+func TestTraceRecordsPrerequisites(t *testing.T) {
+	store := NewInMemoryFeatureStore(nil)
+	f1 := FeatureFlag{
+		Key:         "feature1",
+		Version:     2,
+		On:          true,
+		Fallthrough: VariationOrRollout{Variation: intPtr(0)},
+		Variations:  []interface{}{"a", "b"},
+	}
+	require.NoError(t, store.Upsert(Features, &f1))
+	f0 := FeatureFlag{
+		Key:           "feature0",
+		On:            true,
+		Prerequisites: []Prerequisite{{Key: "feature1", Variation: 1}},
+		OffVariation:  intPtr(1),
+		Fallthrough:   VariationOrRollout{Variation: intPtr(0)},
+		Variations:    []interface{}{"a", "b"},
+	}
+
+	detail, trace := f0.EvaluateTrace(flagUser, store)
+	assert.Equal(t, "b", detail.Value)
+	assert.Equal(t, newEvalReasonPrerequisiteFailed("feature1"), detail.Reason)
+	require.Len(t, trace.Prerequisites, 1)
+	prereq := trace.Prerequisites[0]
+	assert.Equal(t, "feature1", prereq.Key)
+	assert.Equal(t, 1, prereq.RequiredVariation)
+	assert.False(t, prereq.Satisfied)
+	require.NotNil(t, prereq.Trace)
+	assert.Equal(t, "feature1", prereq.Trace.FlagKey)
+	assert.Equal(t, 2, prereq.Trace.FlagVersion)
+	assert.Equal(t, intPtr(0), prereq.Trace.Detail.VariationIndex)
+	assert.Nil(t, trace.Rules)
+}
+
+func TestTraceRecordsMissingPrerequisite(t *testing.T) {
+	f := FeatureFlag{
+		Key:           "feature0",
+		On:            true,
+		Prerequisites: []Prerequisite{{Key: "missing", Variation: 1}},
+		Fallthrough:   VariationOrRollout{Variation: intPtr(0)},
+		Variations:    []interface{}{"a", "b"},
+	}
+
+	_, trace := f.EvaluateTrace(flagUser, emptyFeatureStore)
+	assert.Equal(t, []PrerequisiteTrace{{Key: "missing", RequiredVariation: 1}}, trace.Prerequisites)
+}
+
+func TestClientTraceVariationReturnsTraceWithoutSendingEvent(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	flag := FeatureFlag{
+		Key:         "feature",
+		On:          true,
+		Fallthrough: VariationOrRollout{Variation: intPtr(1)},
+		Variations:  []interface{}{"a", "b"},
+	}
+	require.NoError(t, client.store.Upsert(Features, &flag))
+
+	detail, trace, err := client.TraceVariation("feature", flagUser, "default")
+	require.NoError(t, err)
+	assert.Equal(t, "b", detail.Value)
+	require.NotNil(t, trace)
+	assert.Equal(t, detail, trace.Detail)
+	assert.Empty(t, client.eventProcessor.(*testEventProcessor).events)
+}
+
+func TestClientTraceVariationReturnsErrorForUnknownFlag(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+
+	detail, trace, err := client.TraceVariation("unknown", flagUser, "default")
+	assert.Error(t, err)
+	assert.Equal(t, "default", detail.Value)
+	assert.Nil(t, trace)
+}
@@ -2,7 +2,9 @@ package ldclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
@@ -27,24 +29,42 @@ type EventProcessor interface {
 type nullEventProcessor struct{}
 
 type defaultEventProcessor struct {
-	inputCh   chan eventDispatcherMessage
-	closeOnce sync.Once
+	inputCh    chan eventDispatcherMessage
+	dispatcher *eventDispatcher
+	closeOnce  sync.Once
 }
 
 type eventDispatcher struct {
 	sdkKey            string
 	config            Config
+	logger            LeveledLogger
 	lastKnownPastTime uint64
 	disabled          bool
+	deliveryStatus    eventDeliveryStatus
 	stateLock         sync.Mutex
 }
 
+// Describes the outcome of recent attempts to deliver events, for the client's health report.
+type eventDeliveryStatus struct {
+	disabled    bool
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+}
+
+// Implemented by defaultEventProcessor. For any other EventProcessor, the health report does not
+// include the state of event delivery.
+type eventDeliveryStatusProvider interface {
+	getDeliveryStatus() eventDeliveryStatus
+}
+
 type eventBuffer struct {
 	events           []Event
 	summarizer       eventSummarizer
 	capacity         int
 	capacityExceeded bool
-	logger           Logger
+	logger           LeveledLogger
+	metrics          Metrics
 }
 
 type flushPayload struct {
@@ -55,10 +75,12 @@ type flushPayload struct {
 type sendEventsTask struct {
 	client    *http.Client
 	eventsURI string
-	logger    Logger
+	logger    LeveledLogger
 	sdkKey    string
 	userAgent string
 	formatter eventOutputFormatter
+	tracer    Tracer
+	metrics   Metrics
 }
 
 // Payload of the inputCh channel.
@@ -105,9 +127,10 @@ func NewDefaultEventProcessor(sdkKey string, config Config, client *http.Client)
 		client = &http.Client{}
 	}
 	inputCh := make(chan eventDispatcherMessage, config.Capacity)
-	startEventDispatcher(sdkKey, config, client, inputCh)
+	dispatcher := startEventDispatcher(sdkKey, config, client, inputCh)
 	return &defaultEventProcessor{
-		inputCh: inputCh,
+		inputCh:    inputCh,
+		dispatcher: dispatcher,
 	}
 }
 
@@ -129,11 +152,16 @@ func (ep *defaultEventProcessor) Close() error {
 	return nil
 }
 
+func (ep *defaultEventProcessor) getDeliveryStatus() eventDeliveryStatus {
+	return ep.dispatcher.getDeliveryStatus()
+}
+
 func startEventDispatcher(sdkKey string, config Config, client *http.Client,
-	inputCh <-chan eventDispatcherMessage) {
+	inputCh <-chan eventDispatcherMessage) *eventDispatcher {
 	ed := &eventDispatcher{
 		sdkKey: sdkKey,
 		config: config,
+		logger: loggerForConfig(config),
 	}
 
 	// Start a fixed-size pool of workers that wait on flushTriggerCh. This is the
@@ -142,22 +170,24 @@ func startEventDispatcher(sdkKey string, config Config, client *http.Client,
 	var workersGroup sync.WaitGroup
 	for i := 0; i < maxFlushWorkers; i++ {
 		startFlushTask(sdkKey, config, client, flushCh, &workersGroup,
-			func(r *http.Response) { ed.handleResponse(r) })
+			func(r *http.Response, err error) { ed.handleResponse(r, err) })
 	}
 	go ed.runMainLoop(inputCh, flushCh, &workersGroup)
+	return ed
 }
 
 func (ed *eventDispatcher) runMainLoop(inputCh <-chan eventDispatcherMessage,
 	flushCh chan<- *flushPayload, workersGroup *sync.WaitGroup) {
 	if err := recover(); err != nil {
-		ed.config.Logger.Printf("Unexpected panic in event processing thread: %+v", err)
+		ed.logger.Error("Unexpected panic in event processing thread", "error", err)
 	}
 
 	buffer := eventBuffer{
 		events:     make([]Event, 0, ed.config.Capacity),
 		summarizer: newEventSummarizer(),
 		capacity:   ed.config.Capacity,
-		logger:     ed.config.Logger,
+		logger:     ed.logger,
+		metrics:    metricsOrDefault(ed.config.Metrics),
 	}
 	userKeys := newLruCache(ed.config.UserKeysCapacity)
 
@@ -209,9 +239,14 @@ func (ed *eventDispatcher) processEvent(evt Event, buffer *eventBuffer, userKeys
 	// Decide whether to add the event to the payload. Feature events may be added twice, once for
 	// the event (if tracked) and once for debugging.
 	willAddFullEvent := false
+	userNoticed := false
 	var debugEvent Event
 	switch evt := evt.(type) {
+	case FeatureRequestSummaryEvent:
+		return // it has no user, and is only used for the summary
 	case FeatureRequestEvent:
+		// A UserEvaluator sends all of its events for one user, so only the first one needs to be checked
+		userNoticed = evt.userNoticed
 		if ed.shouldSampleEvent() {
 			willAddFullEvent = evt.TrackEvents
 			if ed.shouldDebugEvent(&evt) {
@@ -227,7 +262,7 @@ func (ed *eventDispatcher) processEvent(evt Event, buffer *eventBuffer, userKeys
 	// For each user we haven't seen before, we add an index event - unless this is already
 	// an identify event for that user. This should be added before the event that referenced
 	// the user, and can be omitted if that event will contain an inline user.
-	if !(willAddFullEvent && ed.config.InlineUsersInEvents) {
+	if !userNoticed && !(willAddFullEvent && ed.config.InlineUsersInEvents) {
 		user := evt.GetBase().User
 		if !noticeUser(userKeys, &user) {
 			if _, ok := evt.(IdentifyEvent); !ok {
@@ -305,19 +340,41 @@ func (ed *eventDispatcher) isDisabled() bool {
 	return ed.disabled
 }
 
-func (ed *eventDispatcher) handleResponse(resp *http.Response) {
+func (ed *eventDispatcher) getDeliveryStatus() eventDeliveryStatus {
+	ed.stateLock.Lock()
+	defer ed.stateLock.Unlock()
+	status := ed.deliveryStatus
+	status.disabled = ed.disabled
+	return status
+}
+
+// Called after each attempt to deliver events. If no response was received, resp is nil and
+// respErr is the I/O error.
+func (ed *eventDispatcher) handleResponse(resp *http.Response, respErr error) {
+	if resp == nil {
+		ed.stateLock.Lock()
+		defer ed.stateLock.Unlock()
+		ed.deliveryStatus.lastFailure = time.Now()
+		if respErr != nil {
+			ed.deliveryStatus.lastError = respErr.Error()
+		}
+		return
+	}
 	if err := checkForHttpError(resp.StatusCode, resp.Request.URL.String()); err != nil {
-		ed.config.Logger.Println(httpErrorMessage(resp.StatusCode, "posting events", "some events were dropped"))
+		ed.logger.Error(httpErrorMessage(resp.StatusCode, "posting events", "some events were dropped"))
+		ed.stateLock.Lock()
+		defer ed.stateLock.Unlock()
+		ed.deliveryStatus.lastFailure = time.Now()
+		ed.deliveryStatus.lastError = err.Error()
 		if !isHTTPErrorRecoverable(resp.StatusCode) {
-			ed.stateLock.Lock()
-			defer ed.stateLock.Unlock()
 			ed.disabled = true
 		}
 	} else {
+		ed.stateLock.Lock()
+		defer ed.stateLock.Unlock()
+		ed.deliveryStatus.lastSuccess = time.Now()
 		dt, err := http.ParseTime(resp.Header.Get("Date"))
 		if err == nil {
-			ed.stateLock.Lock()
-			defer ed.stateLock.Unlock()
 			ed.lastKnownPastTime = toUnixMillis(dt)
 		}
 	}
@@ -327,12 +384,14 @@ func (b *eventBuffer) addEvent(event Event) {
 	if len(b.events) >= b.capacity {
 		if !b.capacityExceeded {
 			b.capacityExceeded = true
-			b.logger.Printf("WARN: Exceeded event queue capacity. Increase capacity to avoid dropping events.")
+			b.logger.Warn("Exceeded event queue capacity; increase capacity to avoid dropping events")
 		}
+		b.metrics.AddCount(MetricEventsDropped, 1)
 		return
 	}
 	b.capacityExceeded = false
 	b.events = append(b.events, event)
+	b.metrics.SetGauge(MetricEventQueueDepth, float64(len(b.events)))
 }
 
 func (b *eventBuffer) addToSummary(event Event) {
@@ -349,10 +408,11 @@ func (b *eventBuffer) getPayload() flushPayload {
 func (b *eventBuffer) clear() {
 	b.events = make([]Event, 0, b.capacity)
 	b.summarizer.reset()
+	b.metrics.SetGauge(MetricEventQueueDepth, 0)
 }
 
 func startFlushTask(sdkKey string, config Config, client *http.Client, flushCh <-chan *flushPayload,
-	workersGroup *sync.WaitGroup, responseFn func(*http.Response)) {
+	workersGroup *sync.WaitGroup, responseFn func(*http.Response, error)) {
 	ef := eventOutputFormatter{
 		userFilter:  newUserFilter(config),
 		inlineUsers: config.InlineUsersInEvents,
@@ -364,15 +424,17 @@ func startFlushTask(sdkKey string, config Config, client *http.Client, flushCh <
 	t := sendEventsTask{
 		client:    client,
 		eventsURI: uri,
-		logger:    config.Logger,
+		logger:    loggerForConfig(config),
 		sdkKey:    sdkKey,
 		userAgent: config.UserAgent,
 		formatter: ef,
+		tracer:    tracerOrDefault(config.Tracer),
+		metrics:   metricsOrDefault(config.Metrics),
 	}
 	go t.run(flushCh, responseFn, workersGroup)
 }
 
-func (t *sendEventsTask) run(flushCh <-chan *flushPayload, responseFn func(*http.Response),
+func (t *sendEventsTask) run(flushCh <-chan *flushPayload, responseFn func(*http.Response, error),
 	workersGroup *sync.WaitGroup) {
 	for {
 		payload, more := <-flushCh
@@ -382,33 +444,44 @@ func (t *sendEventsTask) run(flushCh <-chan *flushPayload, responseFn func(*http
 		}
 		outputEvents := t.formatter.makeOutputEvents(payload.events, payload.summary)
 		if len(outputEvents) > 0 {
-			resp := t.postEvents(outputEvents)
-			if resp != nil {
-				responseFn(resp)
+			resp, err := t.postEvents(outputEvents)
+			if resp != nil || err != nil {
+				responseFn(resp, err)
 			}
 		}
 		workersGroup.Done() // Decrement the count of in-progress flushes
 	}
 }
 
-func (t *sendEventsTask) postEvents(outputEvents []interface{}) *http.Response {
+// Returns the last response, or if no response was received, the I/O error. If the request could
+// not be made at all, both are nil.
+func (t *sendEventsTask) postEvents(outputEvents []interface{}) (*http.Response, error) {
 	jsonPayload, marshalErr := json.Marshal(outputEvents)
 	if marshalErr != nil {
-		t.logger.Printf("Unexpected error marshalling event json: %+v", marshalErr)
-		return nil
+		t.logger.Error("Unexpected error marshalling event json", "error", marshalErr)
+		return nil, nil
 	}
 
+	_, span := t.tracer.StartSpan(context.Background(), SpanNamePostEvents)
+	defer span.End()
+	span.SetAttribute(SpanAttrEventCount, len(outputEvents))
+	span.SetAttribute(SpanAttrHTTPURL, t.eventsURI)
+	startTime := time.Now()
+	defer func() { t.metrics.ObserveDuration(MetricEventFlushDuration, time.Since(startTime)) }()
+
 	var resp *http.Response
 	var respErr error
 	for attempt := 0; attempt < 2; attempt++ {
+		span.SetAttribute(SpanAttrAttempts, attempt+1)
 		if attempt > 0 {
-			t.logger.Printf("Will retry posting events after 1 second")
+			t.logger.Warn("Will retry posting events after 1 second")
 			time.Sleep(1 * time.Second)
 		}
 		req, reqErr := http.NewRequest("POST", t.eventsURI, bytes.NewReader(jsonPayload))
 		if reqErr != nil {
-			t.logger.Printf("Unexpected error while creating event request: %+v", reqErr)
-			return nil
+			t.logger.Error("Unexpected error while creating event request", "error", reqErr)
+			t.metrics.AddCount(MetricEventFlushFailures, 1)
+			return nil, nil
 		}
 
 		req.Header.Add("Authorization", t.sdkKey)
@@ -424,14 +497,23 @@ func (t *sendEventsTask) postEvents(outputEvents []interface{}) *http.Response {
 		}
 
 		if respErr != nil {
-			t.logger.Printf("Unexpected error while sending events: %+v", respErr)
+			t.logger.Warn("Unexpected error while sending events", "error", respErr)
 			continue
-		} else if resp.StatusCode >= 400 && isHTTPErrorRecoverable(resp.StatusCode) {
-			t.logger.Printf("Received error status %d when sending events", resp.StatusCode)
+		}
+		span.SetAttribute(SpanAttrHTTPStatusCode, resp.StatusCode)
+		if resp.StatusCode >= 400 && isHTTPErrorRecoverable(resp.StatusCode) {
+			t.logger.Warn("Received error status when sending events", "status", resp.StatusCode)
 			continue
 		} else {
 			break
 		}
 	}
-	return resp
+	if respErr != nil {
+		span.RecordError(respErr)
+		t.metrics.AddCount(MetricEventFlushFailures, 1)
+	} else if resp != nil && resp.StatusCode >= 400 {
+		span.RecordError(fmt.Errorf("received error status %d when sending events", resp.StatusCode))
+		t.metrics.AddCount(MetricEventFlushFailures, 1)
+	}
+	return resp, respErr
 }
@@ -387,6 +387,25 @@ func TestTwoFeatureEventsForSameUserGenerateOnlyOneIndexEvent(t *testing.T) {
 	}
 }
 
+func TestFeatureEventForUserAlreadyNoticedByUserEvaluatorGeneratesNoIndexEvent(t *testing.T) {
+	ep, st := createEventProcessor(epDefaultConfig)
+	defer ep.Close()
+
+	flag := FeatureFlag{
+		Key:     "flagkey",
+		Version: 11,
+	}
+	value := "value"
+	fe := NewFeatureRequestEvent(flag.Key, &flag, epDefaultUser, intPtr(2), value, nil, nil)
+	fe.userNoticed = true
+	ep.SendEvent(fe)
+
+	output := flushAndGetEvents(ep, st)
+	if assert.Equal(t, 1, len(output)) {
+		assertSummaryEventHasCounter(t, flag, intPtr(2), value, 1, output[0])
+	}
+}
+
 func TestNonTrackedEventsAreSummarized(t *testing.T) {
 	ep, st := createEventProcessor(epDefaultConfig)
 	defer ep.Close()
@@ -421,6 +440,33 @@ func TestNonTrackedEventsAreSummarized(t *testing.T) {
 	}
 }
 
+func TestSummaryEventIsAddedToSummaryWithoutIndexEvent(t *testing.T) {
+	ep, st := createEventProcessor(epDefaultConfig)
+	defer ep.Close()
+
+	flag := FeatureFlag{
+		Key:     "flagkey",
+		Version: 11,
+	}
+	value := "value"
+	se := FeatureRequestSummaryEvent{
+		BaseEvent: BaseEvent{CreationDate: 2000},
+		StartDate: 1000,
+		Counters: []FeatureRequestCounter{
+			{Key: flag.Key, Version: &flag.Version, Variation: intPtr(2), Value: value, Count: 5},
+		},
+	}
+	ep.SendEvent(se)
+
+	output := flushAndGetEvents(ep, st)
+	if assert.Equal(t, 1, len(output)) {
+		seo := output[0]
+		assertSummaryEventHasCounter(t, flag, intPtr(2), value, 5, seo)
+		assert.Equal(t, float64(1000), seo["startDate"])
+		assert.Equal(t, float64(2000), seo["endDate"])
+	}
+}
+
 func TestCustomEventIsQueuedWithUser(t *testing.T) {
 	ep, st := createEventProcessor(epDefaultConfig)
 	defer ep.Close()
@@ -42,38 +42,46 @@ func newEventSummary() eventSummary {
 
 // Adds this event to our counters, if it is a type of event we need to count.
 func (s *eventSummarizer) summarizeEvent(evt Event) {
-	var fe FeatureRequestEvent
-	var ok bool
-	if fe, ok = evt.(FeatureRequestEvent); !ok {
-		return
+	switch e := evt.(type) {
+	case FeatureRequestEvent:
+		s.addCount(e.Key, e.Version, e.Variation, e.Value, e.Default, 1)
+		s.addDates(e.CreationDate, e.CreationDate)
+	case FeatureRequestSummaryEvent:
+		for _, c := range e.Counters {
+			s.addCount(c.Key, c.Version, c.Variation, c.Value, c.Default, c.Count)
+		}
+		s.addDates(e.StartDate, e.CreationDate)
 	}
+}
 
-	key := counterKey{key: fe.Key}
-	if fe.Variation != nil {
-		key.variation = *fe.Variation
+func (s *eventSummarizer) addCount(flagKey string, version, variation *int, value, defaultVal interface{}, count int) {
+	key := counterKey{key: flagKey}
+	if variation != nil {
+		key.variation = *variation
 	} else {
 		key.variation = nilVariation
 	}
-	if fe.Version != nil {
-		key.version = *fe.Version
+	if version != nil {
+		key.version = *version
 	}
 
-	if value, ok := s.eventsState.counters[key]; ok {
-		value.count++
+	if counter, ok := s.eventsState.counters[key]; ok {
+		counter.count += count
 	} else {
 		s.eventsState.counters[key] = &counterValue{
-			count:       1,
-			flagValue:   fe.Value,
-			flagDefault: fe.Default,
+			count:       count,
+			flagValue:   value,
+			flagDefault: defaultVal,
 		}
 	}
+}
 
-	creationDate := fe.CreationDate
-	if s.eventsState.startDate == 0 || creationDate < s.eventsState.startDate {
-		s.eventsState.startDate = creationDate
+func (s *eventSummarizer) addDates(startDate, endDate uint64) {
+	if s.eventsState.startDate == 0 || startDate < s.eventsState.startDate {
+		s.eventsState.startDate = startDate
 	}
-	if creationDate > s.eventsState.endDate {
-		s.eventsState.endDate = creationDate
+	if endDate > s.eventsState.endDate {
+		s.eventsState.endDate = endDate
 	}
 }
 
@@ -104,3 +104,33 @@ func TestCounterForNilVariationIsDistinctFromOthers(t *testing.T) {
 	}
 	assert.Equal(t, expectedCounters, data.counters)
 }
+
+func TestSummarizeEventAddsCountsFromSummaryEvent(t *testing.T) {
+	es := newEventSummarizer()
+	flag := FeatureFlag{
+		Key:     "key1",
+		Version: 11,
+	}
+	variation1 := 1
+	event1 := NewFeatureRequestEvent(flag.Key, &flag, user, &variation1, "value1", "default1", nil)
+	event1.BaseEvent.CreationDate = 1500
+	summaryEvent := FeatureRequestSummaryEvent{
+		BaseEvent: BaseEvent{CreationDate: 2000},
+		StartDate: 1000,
+		Counters: []FeatureRequestCounter{
+			{Key: flag.Key, Version: &flag.Version, Variation: &variation1, Value: "value1", Default: "default1", Count: 3},
+			{Key: "badkey", Value: "default2", Default: "default2", Count: 2},
+		},
+	}
+	es.summarizeEvent(event1)
+	es.summarizeEvent(summaryEvent)
+	data := es.snapshot()
+
+	expectedCounters := map[counterKey]*counterValue{
+		counterKey{flag.Key, variation1, flag.Version}: &counterValue{4, "value1", "default1"},
+		counterKey{"badkey", -1, 0}:                    &counterValue{2, "default2", "default2"},
+	}
+	assert.Equal(t, expectedCounters, data.counters)
+	assert.Equal(t, uint64(1000), data.startDate)
+	assert.Equal(t, uint64(2000), data.endDate)
+}
@@ -32,6 +32,29 @@ type FeatureRequestEvent struct {
 	TrackEvents          bool
 	Debug                bool
 	DebugEventsUntilDate *uint64
+	userNoticed          bool // true if an earlier event from the same UserEvaluator has been sent
+}
+
+// FeatureRequestSummaryEvent is generated by LDClient.EvaluateBatch. Instead of a FeatureRequestEvent
+// for each evaluation, it contains the number of evaluations that produced each result, which the
+// EventProcessor adds to the summary data that it sends to LaunchDarkly. CreationDate is the time of
+// the last evaluation, and StartDate is the time of the first.
+type FeatureRequestSummaryEvent struct {
+	BaseEvent
+	StartDate uint64
+	Counters  []FeatureRequestCounter
+}
+
+// FeatureRequestCounter is the number of times that a flag was evaluated with the same result, in a
+// FeatureRequestSummaryEvent. Version and Variation are nil if the flag was not found or could not be
+// evaluated.
+type FeatureRequestCounter struct {
+	Key       string
+	Version   *int
+	Variation *int
+	Value     interface{}
+	Default   interface{}
+	Count     int
 }
 
 // CustomEvent is generated by calling the client's Track method.
@@ -78,6 +101,11 @@ func (evt FeatureRequestEvent) GetBase() BaseEvent {
 	return evt.BaseEvent
 }
 
+// GetBase returns the BaseEvent
+func (evt FeatureRequestSummaryEvent) GetBase() BaseEvent {
+	return evt.BaseEvent
+}
+
 // NewCustomEvent constructs a new custom event, but does not send it. Typically, Track should be used to both create the
 // event and send it to LaunchDarkly.
 func NewCustomEvent(key string, user User, data interface{}) CustomEvent {
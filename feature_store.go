@@ -1,9 +1,12 @@
 package ldclient
 
 import (
+	"context"
+	"errors"
 	"log"
 	"os"
 	"sync"
+	"sync/atomic"
 )
 
 // FeatureStore is an interface describing a structure that maintains the live collection of features
@@ -47,72 +50,99 @@ type FeatureStore interface {
 	Initialized() bool
 }
 
-// InMemoryFeatureStore is a memory based FeatureStore implementation, backed by a lock-striped map.
+// FeatureStoreSnapshotProvider is an optional interface that a FeatureStore can implement if it is
+// able to provide a consistent, read-only view of its data at a point in time. If the configured
+// FeatureStore implements it, as InMemoryFeatureStore does, the client takes a snapshot at the start
+// of each evaluation, so that a flag, its prerequisites, and the segments it refers to all come from
+// the same version of the data even if the store is being updated at the same time.
+type FeatureStoreSnapshotProvider interface {
+	// Snapshot returns a FeatureStore whose contents will not change. Its Init, Upsert, and Delete
+	// methods return an error.
+	Snapshot() FeatureStore
+}
+
+// FeatureStoreWithContext is an optional interface that a FeatureStore can implement if its read
+// operations can be given a deadline or cancelled. The client uses it when a flag is evaluated with
+// one of the variation methods that take a context.Context, such as BoolVariationCtx. The database
+// integrations in the "redis", "ldconsul", and "lddynamodb" packages implement it.
+type FeatureStoreWithContext interface {
+	// GetWithContext is the same as Get, but should give up and return an error if the context is
+	// cancelled or its deadline passes before the query completes.
+	GetWithContext(ctx context.Context, kind VersionedDataKind, key string) (VersionedData, error)
+	// AllWithContext is the same as All, but should give up and return an error if the context is
+	// cancelled or its deadline passes before the query completes.
+	AllWithContext(ctx context.Context, kind VersionedDataKind) (map[string]VersionedData, error)
+}
+
+// ErrFeatureStoreSnapshotReadOnly is returned by the write methods of a FeatureStore that was
+// obtained from FeatureStoreSnapshotProvider.Snapshot.
+var ErrFeatureStoreSnapshotReadOnly = errors.New("feature store snapshot cannot be modified")
+
+// InMemoryFeatureStore is a memory based FeatureStore implementation. Its data is kept in an immutable
+// snapshot which is replaced atomically whenever the data changes, so reads never block; writes are
+// serialized, and copy only the collection that they modify.
 type InMemoryFeatureStore struct {
+	data         atomic.Value // always contains an *inMemoryFeatureStoreData
+	sync.RWMutex              // held by writers only
+	logger       LeveledLogger
+}
+
+// inMemoryFeatureStoreData is one version of the contents of an InMemoryFeatureStore. Once it has been
+// stored in InMemoryFeatureStore.data, neither it nor its maps are ever modified. It also serves as
+// the store's snapshot.
+type inMemoryFeatureStoreData struct {
 	allData       map[VersionedDataKind]map[string]VersionedData
 	isInitialized bool
-	sync.RWMutex
-	logger Logger
+	logger        LeveledLogger
 }
 
-// NewInMemoryFeatureStore creates a new in-memory FeatureStore instance.
+// NewInMemoryFeatureStore creates a new in-memory FeatureStore instance. It only logs debug messages,
+// such as for keys that are not found; when it is created by the client, these appear if
+// Config.MinLogLevel is LogLevelDebug.
 func NewInMemoryFeatureStore(logger Logger) *InMemoryFeatureStore {
 	if logger == nil {
 		logger = log.New(os.Stderr, "[LaunchDarkly InMemoryFeatureStore]", log.LstdFlags)
 	}
-	return &InMemoryFeatureStore{
-		allData:       make(map[VersionedDataKind]map[string]VersionedData),
-		isInitialized: false,
-		logger:        logger,
-	}
+	return newInMemoryFeatureStore(NewFilteredLeveledLogger(AsLeveledLogger(logger), LogLevelInfo, 0))
+}
+
+func newInMemoryFeatureStore(logger LeveledLogger) *InMemoryFeatureStore {
+	store := &InMemoryFeatureStore{logger: logger}
+	store.data.Store(&inMemoryFeatureStoreData{
+		allData: make(map[VersionedDataKind]map[string]VersionedData),
+		logger:  logger,
+	})
+	return store
+}
+
+func (store *InMemoryFeatureStore) current() *inMemoryFeatureStoreData {
+	return store.data.Load().(*inMemoryFeatureStoreData)
 }
 
 // Get returns an individual object of a given type from the store
 func (store *InMemoryFeatureStore) Get(kind VersionedDataKind, key string) (VersionedData, error) {
-	store.RLock()
-	defer store.RUnlock()
-	if store.allData[kind] == nil {
-		store.allData[kind] = make(map[string]VersionedData)
-	}
-	item := store.allData[kind][key]
-
-	if item == nil {
-		store.logger.Printf("WARN: Key: %s not found in \"%s\".", key, kind)
-		return nil, nil
-	} else if item.IsDeleted() {
-		store.logger.Printf("WARN: Attempted to get deleted item in \"%s\". Key: %s", kind, key)
-		return nil, nil
-	} else {
-		return item, nil
-	}
+	return store.current().Get(kind, key)
 }
 
 // All returns all the objects of a given kind from the store
 func (store *InMemoryFeatureStore) All(kind VersionedDataKind) (map[string]VersionedData, error) {
-	store.RLock()
-	defer store.RUnlock()
-	ret := make(map[string]VersionedData)
+	return store.current().All(kind)
+}
 
-	for k, v := range store.allData[kind] {
-		if !v.IsDeleted() {
-			ret[k] = v
-		}
-	}
-	return ret, nil
+// Snapshot returns a read-only view of the current contents of the store, which will not be affected
+// by later updates. This is cheap, since the store never modifies data that it has made visible.
+func (store *InMemoryFeatureStore) Snapshot() FeatureStore {
+	return store.current()
 }
 
 // Delete removes an item of a given kind from the store
 func (store *InMemoryFeatureStore) Delete(kind VersionedDataKind, key string, version int) error {
 	store.Lock()
 	defer store.Unlock()
-	if store.allData[kind] == nil {
-		store.allData[kind] = make(map[string]VersionedData)
-	}
-	items := store.allData[kind]
-	item := items[key]
+	old := store.current()
+	item := old.allData[kind][key]
 	if item == nil || item.GetVersion() < version {
-		deletedItem := kind.MakeDeletedItem(key, version)
-		items[key] = deletedItem
+		store.data.Store(old.withItem(kind, key, kind.MakeDeletedItem(key, version)))
 	}
 	return nil
 }
@@ -122,17 +152,18 @@ func (store *InMemoryFeatureStore) Init(allData map[VersionedDataKind]map[string
 	store.Lock()
 	defer store.Unlock()
 
-	store.allData = make(map[VersionedDataKind]map[string]VersionedData)
+	newData := make(map[VersionedDataKind]map[string]VersionedData)
 
 	for k, v := range allData {
 		items := make(map[string]VersionedData)
 		for k1, v1 := range v {
+			PreprocessItem(v1)
 			items[k1] = v1
 		}
-		store.allData[k] = items
+		newData[k] = items
 	}
 
-	store.isInitialized = true
+	store.data.Store(&inMemoryFeatureStoreData{allData: newData, isInitialized: true, logger: store.logger})
 	return nil
 }
 
@@ -140,21 +171,120 @@ func (store *InMemoryFeatureStore) Init(allData map[VersionedDataKind]map[string
 func (store *InMemoryFeatureStore) Upsert(kind VersionedDataKind, item VersionedData) error {
 	store.Lock()
 	defer store.Unlock()
-	if store.allData[kind] == nil {
-		store.allData[kind] = make(map[string]VersionedData)
-	}
-	items := store.allData[kind]
-	old := items[item.GetKey()]
+	old := store.current()
+	oldItem := old.allData[kind][item.GetKey()]
 
-	if old == nil || old.GetVersion() < item.GetVersion() {
-		items[item.GetKey()] = item
+	if oldItem == nil || oldItem.GetVersion() < item.GetVersion() {
+		PreprocessItem(item)
+		store.data.Store(old.withItem(kind, item.GetKey(), item))
 	}
 	return nil
 }
 
 // Initialized returns whether the store has been initialized with data
 func (store *InMemoryFeatureStore) Initialized() bool {
-	store.RLock()
-	defer store.RUnlock()
-	return store.isInitialized
+	return store.current().isInitialized
+}
+
+// Returns a copy of the data with one item added or replaced. Only the collection for that kind of
+// item is copied; the others are shared with the original.
+func (d *inMemoryFeatureStoreData) withItem(kind VersionedDataKind, key string, item VersionedData) *inMemoryFeatureStoreData {
+	newAllData := make(map[VersionedDataKind]map[string]VersionedData, len(d.allData)+1)
+	for k, v := range d.allData {
+		newAllData[k] = v
+	}
+	oldItems := d.allData[kind]
+	newItems := make(map[string]VersionedData, len(oldItems)+1)
+	for k, v := range oldItems {
+		newItems[k] = v
+	}
+	newItems[key] = item
+	newAllData[kind] = newItems
+	return &inMemoryFeatureStoreData{allData: newAllData, isInitialized: d.isInitialized, logger: d.logger}
+}
+
+// Get returns an individual object of a given type from the snapshot.
+func (d *inMemoryFeatureStoreData) Get(kind VersionedDataKind, key string) (VersionedData, error) {
+	item := d.allData[kind][key] // indexing a nil map is safe, so we don't need to create missing maps
+
+	if item == nil {
+		d.logger.Debug("Key not found in feature store", "kind", kind.GetNamespace(), "key", key)
+		return nil, nil
+	} else if item.IsDeleted() {
+		d.logger.Debug("Attempted to get deleted item from feature store", "kind", kind.GetNamespace(), "key", key)
+		return nil, nil
+	} else {
+		return item, nil
+	}
+}
+
+// All returns all the objects of a given kind from the snapshot.
+func (d *inMemoryFeatureStoreData) All(kind VersionedDataKind) (map[string]VersionedData, error) {
+	ret := make(map[string]VersionedData)
+
+	for k, v := range d.allData[kind] {
+		if !v.IsDeleted() {
+			ret[k] = v
+		}
+	}
+	return ret, nil
+}
+
+// Initialized returns whether the store had been initialized when the snapshot was taken.
+func (d *inMemoryFeatureStoreData) Initialized() bool {
+	return d.isInitialized
+}
+
+// Init always returns an error, because a snapshot cannot be modified.
+func (d *inMemoryFeatureStoreData) Init(allData map[VersionedDataKind]map[string]VersionedData) error {
+	return ErrFeatureStoreSnapshotReadOnly
+}
+
+// Upsert always returns an error, because a snapshot cannot be modified.
+func (d *inMemoryFeatureStoreData) Upsert(kind VersionedDataKind, item VersionedData) error {
+	return ErrFeatureStoreSnapshotReadOnly
+}
+
+// Delete always returns an error, because a snapshot cannot be modified.
+func (d *inMemoryFeatureStoreData) Delete(kind VersionedDataKind, key string, version int) error {
+	return ErrFeatureStoreSnapshotReadOnly
+}
+
+// contextFeatureStore is the FeatureStore that is used for an evaluation that was given a context.
+// Its reads use the context if the underlying store implements FeatureStoreWithContext; otherwise,
+// they fail if the context is already done, so that the rest of the evaluation is abandoned even
+// though a query that has started cannot be interrupted. Writes are passed through unchanged.
+type contextFeatureStore struct {
+	FeatureStore
+	ctx context.Context
+}
+
+// Returns a store whose reads use the given context, or the store itself if the context is the
+// background context. A context that can never be cancelled still has to be passed to the store if it
+// has values, since it may contain a tracing span.
+func featureStoreWithContext(ctx context.Context, store FeatureStore) FeatureStore {
+	if ctx == context.Background() {
+		return store
+	}
+	return contextFeatureStore{FeatureStore: store, ctx: ctx}
+}
+
+func (s contextFeatureStore) Get(kind VersionedDataKind, key string) (VersionedData, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if cs, ok := s.FeatureStore.(FeatureStoreWithContext); ok {
+		return cs.GetWithContext(s.ctx, kind, key)
+	}
+	return s.FeatureStore.Get(kind, key)
+}
+
+func (s contextFeatureStore) All(kind VersionedDataKind) (map[string]VersionedData, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if cs, ok := s.FeatureStore.(FeatureStoreWithContext); ok {
+		return cs.AllWithContext(s.ctx, kind)
+	}
+	return s.FeatureStore.All(kind)
 }
@@ -0,0 +1,81 @@
+package ldclient
+
+import "gopkg.in/launchdarkly/go-client.v4/internal/broadcast"
+
+// FeatureStoreStatus describes whether a FeatureStore that is backed by a database is currently
+// able to read and write data.
+type FeatureStoreStatus struct {
+	// Available is false if the last attempt to access the database failed, and the store has not
+	// yet detected that the database is working again.
+	Available bool
+	// NeedsRefresh is true if the database has become available again after an outage, but the store
+	// was not able to restore the data that it missed in the meantime, so it may be out of date until
+	// the data source next sends a full data set.
+	NeedsRefresh bool
+	// LastError is the most recent error returned by the database, if any.
+	LastError error
+}
+
+// FeatureStoreStatusProvider is an optional interface that a FeatureStore can implement if it is
+// able to report whether its underlying database is available. Stores created with
+// utils.FeatureStoreWrapper, including the Redis, Consul, and DynamoDB stores, implement it.
+type FeatureStoreStatusProvider interface {
+	// GetStoreStatus returns the current status of the store.
+	GetStoreStatus() FeatureStoreStatus
+	// AddStatusListener returns a channel that will receive a FeatureStoreStatus whenever the status
+	// of the store changes.
+	AddStatusListener() <-chan FeatureStoreStatus
+	// RemoveStatusListener unsubscribes and closes a channel that was returned by AddStatusListener.
+	RemoveStatusListener(ch <-chan FeatureStoreStatus)
+}
+
+// Relays status changes from the application's FeatureStore, if it is a FeatureStoreStatusProvider,
+// to listeners that were added through the client. This way, the channels that the client returns
+// are always closed when the client is closed, regardless of what kind of store is being used.
+type featureStoreStatusManager struct {
+	provider    FeatureStoreStatusProvider
+	providerCh  <-chan FeatureStoreStatus
+	broadcaster *broadcast.Broadcaster
+}
+
+func newFeatureStoreStatusManager(store FeatureStore) *featureStoreStatusManager {
+	m := &featureStoreStatusManager{broadcaster: broadcast.New()}
+	if provider, ok := store.(FeatureStoreStatusProvider); ok {
+		m.provider = provider
+		m.providerCh = provider.AddStatusListener()
+		go m.relay()
+	}
+	return m
+}
+
+func (m *featureStoreStatusManager) relay() {
+	for status := range m.providerCh {
+		m.broadcaster.Broadcast(status)
+	}
+}
+
+// Returns the store's status; a store that does not report its status is assumed to be available.
+func (m *featureStoreStatusManager) getStatus() FeatureStoreStatus {
+	if m.provider == nil {
+		return FeatureStoreStatus{Available: true}
+	}
+	return m.provider.GetStoreStatus()
+}
+
+func (m *featureStoreStatusManager) addListener() <-chan FeatureStoreStatus {
+	ch := make(chan FeatureStoreStatus, broadcast.ListenerBufferSize)
+	var receiveCh <-chan FeatureStoreStatus = ch
+	m.broadcaster.Add(broadcast.NewSubscription(ch, nil, broadcast.KeepLatest))
+	return receiveCh
+}
+
+func (m *featureStoreStatusManager) removeListener(ch <-chan FeatureStoreStatus) {
+	m.broadcaster.Remove(ch)
+}
+
+func (m *featureStoreStatusManager) close() {
+	if m.provider != nil {
+		m.provider.RemoveStatusListener(m.providerCh)
+	}
+	m.broadcaster.Close()
+}
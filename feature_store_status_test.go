@@ -0,0 +1,84 @@
+package ldclient
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type statusProvidingFeatureStore struct {
+	*InMemoryFeatureStore
+	status   FeatureStoreStatus
+	statusCh chan FeatureStoreStatus
+	removed  bool
+}
+
+func newStatusProvidingFeatureStore() *statusProvidingFeatureStore {
+	return &statusProvidingFeatureStore{
+		InMemoryFeatureStore: NewInMemoryFeatureStore(log.New(ioutil.Discard, "", 0)),
+		status:               FeatureStoreStatus{Available: true},
+		statusCh:             make(chan FeatureStoreStatus, 10),
+	}
+}
+
+func (s *statusProvidingFeatureStore) GetStoreStatus() FeatureStoreStatus {
+	return s.status
+}
+
+func (s *statusProvidingFeatureStore) AddStatusListener() <-chan FeatureStoreStatus {
+	return s.statusCh
+}
+
+func (s *statusProvidingFeatureStore) RemoveStatusListener(ch <-chan FeatureStoreStatus) {
+	s.removed = true
+	close(s.statusCh)
+}
+
+func makeTestClientWithFeatureStore(store FeatureStore) *LDClient {
+	client, _ := MakeCustomClient("sdkKey", Config{
+		Logger:                 log.New(ioutil.Discard, "", 0),
+		FeatureStore:           store,
+		UpdateProcessorFactory: updateProcessorFactory(mockUpdateProcessor{}),
+		EventProcessor:         &testEventProcessor{},
+	}, 0)
+	return client
+}
+
+func TestFeatureStoreStatusIsAvailableForStoreThatDoesNotReportStatus(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	assert.Equal(t, FeatureStoreStatus{Available: true}, client.GetFeatureStoreStatus())
+}
+
+func TestFeatureStoreStatusIsObtainedFromStatusProvider(t *testing.T) {
+	store := newStatusProvidingFeatureStore()
+	store.status = FeatureStoreStatus{Available: false, LastError: errors.New("sorry")}
+	client := makeTestClientWithFeatureStore(store)
+	defer client.Close()
+	assert.Equal(t, store.status, client.GetFeatureStoreStatus())
+}
+
+func TestFeatureStoreStatusListenerReceivesUpdatesFromStatusProvider(t *testing.T) {
+	store := newStatusProvidingFeatureStore()
+	client := makeTestClientWithFeatureStore(store)
+	ch := client.AddFeatureStoreStatusListener()
+
+	newStatus := FeatureStoreStatus{Available: false, LastError: errors.New("sorry")}
+	store.statusCh <- newStatus
+	select {
+	case status := <-ch:
+		assert.Equal(t, newStatus, status)
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out waiting for store status")
+	}
+
+	client.Close()
+	_, ok := <-ch
+	assert.False(t, ok)
+	assert.True(t, store.removed)
+}
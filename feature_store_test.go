@@ -1,8 +1,14 @@
 package ldclient_test
 
 import (
+	"io/ioutil"
+	"log"
+	"sync"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
 	ld "gopkg.in/launchdarkly/go-client.v4"
 	ldtest "gopkg.in/launchdarkly/go-client.v4/shared_test"
 )
@@ -14,3 +20,69 @@ func makeInMemoryStore() (ld.FeatureStore, error) {
 func TestInMemoryFeatureStore(t *testing.T) {
 	ldtest.RunFeatureStoreTests(t, makeInMemoryStore, nil, false)
 }
+
+func TestInMemoryFeatureStoreSnapshotIsNotAffectedByUpdates(t *testing.T) {
+	store := ld.NewInMemoryFeatureStore(nil)
+	flag1 := ld.FeatureFlag{Key: "flag1", Version: 1}
+	require.NoError(t, store.Init(ld.MakeAllVersionedDataMap(map[string]*ld.FeatureFlag{flag1.Key: &flag1}, nil)))
+
+	snapshot := store.Snapshot()
+
+	flag1v2 := ld.FeatureFlag{Key: "flag1", Version: 2}
+	flag2 := ld.FeatureFlag{Key: "flag2", Version: 1}
+	require.NoError(t, store.Upsert(ld.Features, &flag1v2))
+	require.NoError(t, store.Upsert(ld.Features, &flag2))
+	require.NoError(t, store.Delete(ld.Features, "flag1", 3))
+
+	assert.True(t, snapshot.Initialized())
+	item, err := snapshot.Get(ld.Features, "flag1")
+	require.NoError(t, err)
+	assert.Equal(t, &flag1, item)
+	item, err = snapshot.Get(ld.Features, "flag2")
+	require.NoError(t, err)
+	assert.Nil(t, item)
+	items, err := snapshot.All(ld.Features)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]ld.VersionedData{"flag1": &flag1}, items)
+
+	item, err = store.Get(ld.Features, "flag1")
+	require.NoError(t, err)
+	assert.Nil(t, item)
+	item, err = store.Get(ld.Features, "flag2")
+	require.NoError(t, err)
+	assert.Equal(t, &flag2, item)
+}
+
+func TestInMemoryFeatureStoreSnapshotCannotBeModified(t *testing.T) {
+	store := ld.NewInMemoryFeatureStore(nil)
+	snapshot := store.Snapshot()
+	assert.False(t, snapshot.Initialized())
+
+	flag := ld.FeatureFlag{Key: "flag", Version: 1}
+	assert.Equal(t, ld.ErrFeatureStoreSnapshotReadOnly, snapshot.Init(ld.MakeAllVersionedDataMap(nil, nil)))
+	assert.Equal(t, ld.ErrFeatureStoreSnapshotReadOnly, snapshot.Upsert(ld.Features, &flag))
+	assert.Equal(t, ld.ErrFeatureStoreSnapshotReadOnly, snapshot.Delete(ld.Features, "flag", 1))
+	assert.False(t, store.Initialized())
+}
+
+func TestInMemoryFeatureStoreConcurrentReadsAndWrites(t *testing.T) {
+	// This is mainly useful when run with -race
+	store := ld.NewInMemoryFeatureStore(log.New(ioutil.Discard, "", 0))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for v := 1; v <= 100; v++ {
+				_ = store.Upsert(ld.Features, &ld.FeatureFlag{Key: "flag", Version: v})
+				_, _ = store.Get(ld.Segments, "missing")
+				_, _ = store.All(ld.Features)
+				_ = store.Initialized()
+			}
+		}()
+	}
+	wg.Wait()
+	item, err := store.Get(ld.Features, "flag")
+	require.NoError(t, err)
+	assert.Equal(t, 100, item.GetVersion())
+}
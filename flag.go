@@ -1,12 +1,17 @@
 package ldclient
 
 import (
+	"bytes"
 	"crypto/sha1" // nolint:gas // just used for insecure hashing
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"io"
 	"reflect"
 	"strconv"
+	"strings"
+
+	"gopkg.in/launchdarkly/go-client.v4/ldvalue"
 )
 
 const (
@@ -31,6 +36,72 @@ type FeatureFlag struct {
 	Variations           []interface{}      `json:"variations" bson:"variations"`
 	DebugEventsUntilDate *uint64            `json:"debugEventsUntilDate" bson:"debugEventsUntilDate"`
 	ClientSide           bool               `json:"clientSide" bson:"-"`
+
+	preprocessed *preprocessedFlag // see flag_preprocessing.go
+	largeInts    map[int]int64     // see UnmarshalJSON
+}
+
+// Integers whose magnitude is no greater than this can be represented exactly as a float64.
+const maxExactFloat64Int = 1 << 53
+
+// UnmarshalJSON parses a flag from JSON in the same way as json.Unmarshal would by default, except
+// that if any variation is an integer too large to be represented exactly as a float64, its exact
+// value is also kept, so that Int64Variation can return it and MarshalJSON can write it.
+func (f *FeatureFlag) UnmarshalJSON(data []byte) error {
+	type featureFlagFields FeatureFlag // has the same fields, but not the same methods
+	var fields struct {
+		featureFlagFields
+		Variations []json.RawMessage `json:"variations"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*f = FeatureFlag(fields.featureFlagFields)
+	f.Variations = nil
+	f.largeInts = nil
+	if fields.Variations != nil {
+		f.Variations = make([]interface{}, len(fields.Variations))
+	}
+	for i, raw := range fields.Variations {
+		if err := json.Unmarshal(raw, &f.Variations[i]); err != nil {
+			return err
+		}
+		if _, isNumber := f.Variations[i].(float64); !isNumber {
+			continue
+		}
+		if n, err := strconv.ParseInt(string(bytes.TrimSpace(raw)), 10, 64); err == nil &&
+			(n > maxExactFloat64Int || n < -maxExactFloat64Int) {
+			if f.largeInts == nil {
+				f.largeInts = make(map[int]int64)
+			}
+			f.largeInts[i] = n
+		}
+	}
+	return nil
+}
+
+// MarshalJSON converts a flag to JSON in the same way as json.Marshal would by default, except that
+// integer variations that UnmarshalJSON kept the exact values of are written exactly.
+func (f FeatureFlag) MarshalJSON() ([]byte, error) {
+	type featureFlagFields FeatureFlag // has the same fields, but not the same methods
+	fields := featureFlagFields(f)
+	if len(f.largeInts) > 0 {
+		fields.Variations = append([]interface{}{}, f.Variations...)
+		for i := range fields.Variations {
+			if n, ok := f.largeIntVariation(i, fields.Variations[i]); ok {
+				fields.Variations[i] = json.Number(strconv.FormatInt(n, 10))
+			}
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// Returns the exact value of a large integer variation, if value is still that variation's value;
+// it might not be, if the flag's Variations were changed after parsing, or if an evaluation hook
+// substituted a different value.
+func (f *FeatureFlag) largeIntVariation(index int, value interface{}) (int64, bool) {
+	n, ok := f.largeInts[index]
+	return n, ok && value == float64(n)
 }
 
 // GetKey returns the string key for the feature flag
@@ -48,12 +119,94 @@ func (f *FeatureFlag) IsDeleted() bool {
 	return f.Deleted
 }
 
-// Clone returns a copy of a flag
+// Clone returns a deep copy of a flag, which can be modified without affecting the original.
 func (f *FeatureFlag) Clone() VersionedData {
 	f1 := *f
+	f1.preprocessed = nil
+	if f.Prerequisites != nil {
+		f1.Prerequisites = append([]Prerequisite{}, f.Prerequisites...)
+	}
+	if f.Targets != nil {
+		f1.Targets = make([]Target, len(f.Targets))
+		for i, t := range f.Targets {
+			f1.Targets[i] = Target{Values: copyStrings(t.Values), Variation: t.Variation}
+		}
+	}
+	if f.Rules != nil {
+		f1.Rules = make([]Rule, len(f.Rules))
+		for i, r := range f.Rules {
+			f1.Rules[i] = Rule{ID: r.ID, VariationOrRollout: r.VariationOrRollout.clone(), Clauses: cloneClauses(r.Clauses)}
+		}
+	}
+	f1.Fallthrough = f.Fallthrough.clone()
+	f1.OffVariation = copyIntPtr(f.OffVariation)
+	f1.Variations = deepCopyValues(f.Variations)
+	if f.DebugEventsUntilDate != nil {
+		d := *f.DebugEventsUntilDate
+		f1.DebugEventsUntilDate = &d
+	}
 	return &f1
 }
 
+// VariationValue returns one of the flag's variations as an immutable value, or a null value if the
+// index is out of range. If the flag has been put into a feature store, this does not need to copy
+// the variation.
+func (f *FeatureFlag) VariationValue(index int) ldvalue.Value {
+	if index < 0 || index >= len(f.Variations) {
+		return ldvalue.Null()
+	}
+	if f.preprocessed != nil {
+		return f.preprocessed.variationValues[index]
+	}
+	return ldvalue.CopyArbitraryValue(f.Variations[index])
+}
+
+func (vr VariationOrRollout) clone() VariationOrRollout {
+	ret := VariationOrRollout{Variation: copyIntPtr(vr.Variation)}
+	if vr.Rollout != nil {
+		r := Rollout{BucketBy: copyStringPtr(vr.Rollout.BucketBy)}
+		if vr.Rollout.Variations != nil {
+			r.Variations = append([]WeightedVariation{}, vr.Rollout.Variations...)
+		}
+		ret.Rollout = &r
+	}
+	return ret
+}
+
+func cloneClauses(clauses []Clause) []Clause {
+	if clauses == nil {
+		return nil
+	}
+	ret := make([]Clause, len(clauses))
+	for i, c := range clauses {
+		ret[i] = Clause{Attribute: c.Attribute, Op: c.Op, Values: deepCopyValues(c.Values), Negate: c.Negate}
+	}
+	return ret
+}
+
+func copyStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	return append([]string{}, values...)
+}
+
+func copyIntPtr(p *int) *int {
+	if p == nil {
+		return nil
+	}
+	n := *p
+	return &n
+}
+
+func copyStringPtr(p *string) *string {
+	if p == nil {
+		return nil
+	}
+	s := *p
+	return &s
+}
+
 // FeatureFlagVersionedDataKind implements VersionedDataKind and provides methods to build storage engine for flags
 type FeatureFlagVersionedDataKind struct{}
 
@@ -171,15 +324,53 @@ type EvalResult struct {
 	PrerequisiteRequestEvents []FeatureRequestEvent //to be sent to LD
 }
 
+// Tracks state that is shared by a single evaluation and all of the nested evaluations of its
+// prerequisite flags.
+type evaluationState struct {
+	prereqChain   []string // keys of the flags whose prerequisites are being evaluated, outermost first
+	cycleDetected bool
+	logger        LeveledLogger    // may be nil
+	trace         *EvaluationTrace // non-nil only if a trace was requested; this is the current flag's trace
+}
+
 // EvaluateDetail attempts to evaluate the feature flag for the given user and returns its
 // value, the reason for the value, and any events generated by prerequisite flags.
+//
+// If the flag's prerequisites refer back to the flag itself, directly or indirectly, the result
+// has an EvalErrorMalformedFlag reason and no value. The value is a new copy, which can be modified
+// freely.
 func (f FeatureFlag) EvaluateDetail(user User, store FeatureStore, sendReasonsInEvents bool) (EvaluationDetail, []FeatureRequestEvent) {
+	detail, events := f.evaluateDetail(user, store, sendReasonsInEvents, &evaluationState{})
+	detail.Value = deepCopyValue(detail.Value)
+	return detail, events
+}
+
+func (f FeatureFlag) evaluateDetail(user User, store FeatureStore, sendReasonsInEvents bool,
+	state *evaluationState) (EvaluationDetail, []FeatureRequestEvent) {
+	if state.trace == nil {
+		return f.evaluateDetailWithState(user, store, sendReasonsInEvents, state)
+	}
+	state.trace.FlagKey = f.Key
+	state.trace.FlagVersion = f.Version
+	state.trace.On = f.On
+	detail, events := f.evaluateDetailWithState(user, store, sendReasonsInEvents, state)
+	state.trace.Detail = detail
+	state.trace.Detail.Value = deepCopyValue(detail.Value) // the trace is returned to the caller
+	return detail, events
+}
+
+func (f FeatureFlag) evaluateDetailWithState(user User, store FeatureStore, sendReasonsInEvents bool,
+	state *evaluationState) (EvaluationDetail, []FeatureRequestEvent) {
 	if f.On {
-		prereqErrorReason, prereqEvents := f.checkPrerequisites(user, store, sendReasonsInEvents)
+		prereqErrorReason, prereqEvents := f.checkPrerequisites(user, store, sendReasonsInEvents, state)
+		if state.cycleDetected {
+			// The flag data is invalid, so none of the results of this evaluation are meaningful
+			return EvaluationDetail{Reason: newEvalReasonError(EvalErrorMalformedFlag)}, nil
+		}
 		if prereqErrorReason != nil {
 			return f.getOffValue(prereqErrorReason), prereqEvents
 		}
-		return f.evaluateInternal(user, store), prereqEvents
+		return f.evaluateInternal(user, store, state), prereqEvents
 	}
 	return f.getOffValue(evalReasonOffInstance), nil
 }
@@ -219,14 +410,36 @@ func (f FeatureFlag) EvaluateExplain(user User, store FeatureStore) (*EvalResult
 	}, err
 }
 
-// Returns nil if all prerequisites are OK, otherwise constructs an error reason that describes the failure
-func (f FeatureFlag) checkPrerequisites(user User, store FeatureStore, sendReasonsInEvents bool) (EvaluationReason, []FeatureRequestEvent) {
+// Returns nil if all prerequisites are OK, otherwise constructs an error reason that describes the failure.
+// If a prerequisite cycle is found, it sets state.cycleDetected and the return values should be ignored.
+func (f FeatureFlag) checkPrerequisites(user User, store FeatureStore, sendReasonsInEvents bool,
+	state *evaluationState) (EvaluationReason, []FeatureRequestEvent) {
 	if len(f.Prerequisites) == 0 {
 		return nil, nil
 	}
 
+	state.prereqChain = append(state.prereqChain, f.Key)
+	defer func() {
+		state.prereqChain = state.prereqChain[:len(state.prereqChain)-1]
+	}()
+
 	events := make([]FeatureRequestEvent, 0, len(f.Prerequisites))
 	for _, prereq := range f.Prerequisites {
+		if state.isInPrereqChain(prereq.Key) {
+			state.cycleDetected = true
+			if state.logger != nil {
+				state.logger.Error("Prerequisite cycle detected when evaluating feature flag",
+					"flag", state.prereqChain[0], "chain", formatKeyChain(state.prereqChain, prereq.Key))
+			}
+			return nil, nil
+		}
+		parentTrace := state.trace
+		var prereqTrace *PrerequisiteTrace
+		if parentTrace != nil {
+			parentTrace.Prerequisites = append(parentTrace.Prerequisites,
+				PrerequisiteTrace{Key: prereq.Key, RequiredVariation: prereq.Variation})
+			prereqTrace = &parentTrace.Prerequisites[len(parentTrace.Prerequisites)-1]
+		}
 		data, err := store.Get(Features, prereq.Key)
 		if err != nil || data == nil {
 			return newEvalReasonPrerequisiteFailed(prereq.Key), events
@@ -234,12 +447,23 @@ func (f FeatureFlag) checkPrerequisites(user User, store FeatureStore, sendReaso
 		prereqFeatureFlag, _ := data.(*FeatureFlag)
 		prereqOK := true
 
-		prereqResult, moreEvents := prereqFeatureFlag.EvaluateDetail(user, store, sendReasonsInEvents)
+		if parentTrace != nil {
+			prereqTrace.Trace = &EvaluationTrace{}
+			state.trace = prereqTrace.Trace
+		}
+		prereqResult, moreEvents := prereqFeatureFlag.evaluateDetail(user, store, sendReasonsInEvents, state)
+		state.trace = parentTrace
+		if state.cycleDetected {
+			return nil, nil
+		}
 		if !prereqFeatureFlag.On || prereqResult.VariationIndex == nil || *prereqResult.VariationIndex != prereq.Variation {
 			// Note that if the prerequisite flag is off, we don't consider it a match no matter what its
 			// off variation was. But we still need to evaluate it in order to generate an event.
 			prereqOK = false
 		}
+		if prereqTrace != nil {
+			prereqTrace.Satisfied = prereqOK
+		}
 
 		events = append(events, moreEvents...)
 		prereqEvent := NewFeatureRequestEvent(prereq.Key, prereqFeatureFlag, user,
@@ -256,27 +480,49 @@ func (f FeatureFlag) checkPrerequisites(user User, store FeatureStore, sendReaso
 	return nil, events
 }
 
-func (f FeatureFlag) evaluateInternal(user User, store FeatureStore) EvaluationDetail {
+func (s *evaluationState) isInPrereqChain(key string) bool {
+	for _, k := range s.prereqChain {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Formats a chain of flag keys for a log message, e.g. "a -> b -> a".
+func formatKeyChain(keys []string, lastKey string) string {
+	return strings.Join(keys, " -> ") + " -> " + lastKey
+}
+
+func (f FeatureFlag) evaluateInternal(user User, store FeatureStore, state *evaluationState) EvaluationDetail {
 	// Check to see if targets match
-	for _, target := range f.Targets {
-		for _, value := range target.Values {
-			if value == *user.Key {
-				return f.getVariation(target.Variation, evalReasonTargetMatchInstance)
-			}
+	for targetIndex, target := range f.Targets {
+		matched := stringSetContains(f.preprocessed.targetSet(targetIndex), target.Values, *user.Key)
+		if state.trace != nil {
+			state.trace.Targets = append(state.trace.Targets, TargetTrace{Variation: target.Variation, Matched: matched})
+		}
+		if matched {
+			return f.getVariation(target.Variation, evalReasonTargetMatchInstance)
 		}
 	}
 
 	// Now walk through the rules and see if any match
 	for ruleIndex, rule := range f.Rules {
-		if rule.matchesUser(store, user) {
+		var ruleTrace *RuleTrace
+		if state.trace != nil {
+			ruleTrace = state.trace.addRule(ruleIndex, rule)
+		}
+		if rule.matchesUser(store, user, f.preprocessed.ruleClauses(ruleIndex), ruleTrace) {
 			reason := newEvalReasonRuleMatch(ruleIndex, rule.ID)
-			return f.getValueForVariationOrRollout(rule.VariationOrRollout, user, reason)
+			return f.getValueForVariationOrRollout(rule.VariationOrRollout, user, reason, state)
 		}
 	}
 
-	return f.getValueForVariationOrRollout(f.Fallthrough, user, evalReasonFallthroughInstance)
+	return f.getValueForVariationOrRollout(f.Fallthrough, user, evalReasonFallthroughInstance, state)
 }
 
+// Returns the variation with the given index. The value is shared with the flag, so it must not be
+// modified; the methods that return an evaluation result to the application copy it (see deepCopyValue).
 func (f FeatureFlag) getVariation(index int, reason EvaluationReason) EvaluationDetail {
 	if index < 0 || index >= len(f.Variations) {
 		return EvaluationDetail{Reason: newEvalReasonError(EvalErrorMalformedFlag)}
@@ -295,7 +541,11 @@ func (f FeatureFlag) getOffValue(reason EvaluationReason) EvaluationDetail {
 	return f.getVariation(*f.OffVariation, reason)
 }
 
-func (f FeatureFlag) getValueForVariationOrRollout(vr VariationOrRollout, user User, reason EvaluationReason) EvaluationDetail {
+func (f FeatureFlag) getValueForVariationOrRollout(vr VariationOrRollout, user User, reason EvaluationReason,
+	state *evaluationState) EvaluationDetail {
+	if state.trace != nil && vr.Variation == nil && vr.Rollout != nil {
+		state.trace.Rollout = newRolloutTrace(*vr.Rollout, user, f.Key, f.Salt)
+	}
 	index := vr.variationIndexForUser(user, f.Key, f.Salt)
 	if index == nil {
 		return EvaluationDetail{Reason: newEvalReasonError(EvalErrorMalformedFlag)}
@@ -303,23 +553,34 @@ func (f FeatureFlag) getValueForVariationOrRollout(vr VariationOrRollout, user U
 	return f.getVariation(*index, reason)
 }
 
-func (r Rule) matchesUser(store FeatureStore, user User) bool {
-	for _, clause := range r.Clauses {
-		if !clause.matchesUser(store, user) {
+// If trace is non-nil, each clause that is checked is added to it.
+func (r Rule) matchesUser(store FeatureStore, user User, preprocessed []*preprocessedClause, trace *RuleTrace) bool {
+	for i, clause := range r.Clauses {
+		var clauseTrace *ClauseTrace
+		if trace != nil {
+			trace.Clauses = append(trace.Clauses, newClauseTrace(clause))
+			clauseTrace = &trace.Clauses[len(trace.Clauses)-1]
+		}
+		matched := clause.matchesUser(store, user, preprocessedClauseAt(preprocessed, i), clauseTrace)
+		if clauseTrace != nil {
+			clauseTrace.Matched = matched
+		}
+		if !matched {
 			return false
 		}
 	}
+	if trace != nil {
+		trace.Matched = true
+	}
 	return true
 }
 
-func (c Clause) matchesUserNoSegments(user User) bool {
+func (c Clause) matchesUserNoSegments(user User, preprocessed *preprocessedClause) bool {
 	uValue, pass := user.valueOf(c.Attribute)
 
 	if pass {
 		return false
 	}
-	matchFn := operatorFn(c.Op)
-
 	val := reflect.ValueOf(uValue)
 
 	// If the user value is an array or slice,
@@ -327,36 +588,55 @@ func (c Clause) matchesUserNoSegments(user User) bool {
 	// this clause matches
 	if val.Kind() == reflect.Array || val.Kind() == reflect.Slice {
 		for i := 0; i < val.Len(); i++ {
-			if matchAny(matchFn, val.Index(i).Interface(), c.Values) {
+			if c.matchAnyValue(val.Index(i).Interface(), preprocessed) {
 				return c.maybeNegate(true)
 			}
 		}
 		return c.maybeNegate(false)
 	}
 
-	return c.maybeNegate(matchAny(matchFn, uValue, c.Values))
+	return c.maybeNegate(c.matchAnyValue(uValue, preprocessed))
 }
 
-func (c Clause) matchesUser(store FeatureStore, user User) bool {
+func (c Clause) matchesUser(store FeatureStore, user User, preprocessed *preprocessedClause, trace *ClauseTrace) bool {
 	// In the case of a segment match operator, we check if the user is in any of the segments,
-	// and possibly negate
+	// and possibly negate. Segment rules are evaluated with matchesUserNoSegments, so a segment can't
+	// refer to another segment and we don't need to guard against segment cycles as we do for
+	// prerequisites.
 	if c.Op == OperatorSegmentMatch {
 		for _, value := range c.Values {
 			if vStr, ok := value.(string); ok {
 				data, _ := store.Get(Segments, vStr)
 				// If segment is not found or the store got an error, data will be nil and we'll just fall through
 				// the next block. Unfortunately we have no access to a logger here so this failure is silent.
-				if segment, segmentOk := data.(*Segment); segmentOk {
-					if matches, _ := segment.ContainsUser(user); matches {
-						return c.maybeNegate(true)
-					}
+				segment, segmentOk := data.(*Segment)
+				var matches bool
+				var explanation *SegmentExplanation
+				if segmentOk {
+					matches, explanation = segment.ContainsUser(user)
+				}
+				if trace != nil {
+					trace.Segments = append(trace.Segments, SegmentTrace{
+						Key:         vStr,
+						Found:       segmentOk,
+						Contained:   matches,
+						Explanation: explanation,
+					})
+				}
+				if matches {
+					return c.maybeNegate(true)
 				}
 			}
 		}
 		return c.maybeNegate(false)
 	}
 
-	return c.matchesUserNoSegments(user)
+	if trace != nil {
+		if uValue, pass := user.valueOf(c.Attribute); !pass {
+			trace.UserValue = ldvalue.CopyArbitraryValue(uValue)
+		}
+	}
+	return c.matchesUserNoSegments(user, preprocessed)
 }
 
 func (c Clause) maybeNegate(b bool) bool {
@@ -0,0 +1,51 @@
+package ldclient
+
+import "gopkg.in/launchdarkly/go-client.v4/internal/broadcast"
+
+// FlagChangeEvent is sent to flag change listeners (see LDClient.AddFlagChangeListener) whenever the
+// configuration of a feature flag has changed. This includes changes that only affect the flag
+// indirectly, such as a change to one of its prerequisite flags or to a user segment that it
+// references.
+//
+// A FlagChangeEvent does not mean that the flag now returns a different value for any particular user,
+// only that it might. For notifications about changes in a flag's value for a specific user, see
+// LDClient.AddFlagValueChangeListener.
+type FlagChangeEvent struct {
+	// Key is the key of the feature flag that changed.
+	Key string
+}
+
+// Delivers FlagChangeEvents to listeners, each of which may be interested in all flags or only one.
+type flagChangeBroadcaster struct {
+	broadcaster *broadcast.Broadcaster
+}
+
+func newFlagChangeBroadcaster() *flagChangeBroadcaster {
+	return &flagChangeBroadcaster{broadcaster: broadcast.New()}
+}
+
+func (b *flagChangeBroadcaster) addListener(key string, anyKey bool) <-chan FlagChangeEvent {
+	ch := make(chan FlagChangeEvent, broadcast.ListenerBufferSize)
+	var receiveCh <-chan FlagChangeEvent = ch
+	accepts := func(value interface{}) bool {
+		return anyKey || value.(FlagChangeEvent).Key == key
+	}
+	b.broadcaster.Add(broadcast.NewSubscription(ch, accepts, broadcast.DropDuplicates))
+	return receiveCh
+}
+
+func (b *flagChangeBroadcaster) removeListener(ch <-chan FlagChangeEvent) {
+	b.broadcaster.Remove(ch)
+}
+
+func (b *flagChangeBroadcaster) hasListeners() bool {
+	return b.broadcaster.HasSubscriptions()
+}
+
+func (b *flagChangeBroadcaster) broadcast(event FlagChangeEvent) {
+	b.broadcaster.Broadcast(event)
+}
+
+func (b *flagChangeBroadcaster) close() {
+	b.broadcaster.Close()
+}
@@ -0,0 +1,268 @@
+package ldclient
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/blang/semver"
+
+	"gopkg.in/launchdarkly/go-client.v4/ldvalue"
+)
+
+// Flags and segments are preprocessed when the client puts them into the FeatureStore (see
+// changeTrackingFeatureStore), or when they are put into an InMemoryFeatureStore directly, so that
+// evaluations do not have to repeat work that only depends on the flag data: compiling regular
+// expressions, parsing dates and semantic versions, and scanning lists of user keys. Items that are
+// read from a database are new objects, so utils.FeatureStoreWrapper preprocesses them as well, before
+// caching them. A custom FeatureStore that does not do so still works, just more slowly.
+//
+// Preprocessing modifies the item in place, so an item must not be modified after it has been put
+// into a store. The derived data is kept in a single unexported field of FeatureFlag and Segment,
+// rather than in Target and Clause, so that code which creates those types with unkeyed struct
+// literals still compiles.
+
+type preprocessedFlag struct {
+	targetSets        []map[string]struct{}   // same order as Targets
+	rules             [][]*preprocessedClause // rules[i][j] is for Rules[i].Clauses[j]
+	variationValues   []ldvalue.Value         // same order as Variations
+	decodedVariations decodedVariationCache   // filled in lazily by JsonVariationInto
+}
+
+type preprocessedSegment struct {
+	included map[string]struct{}
+	excluded map[string]struct{}
+	rules    [][]*preprocessedClause // rules[i][j] is for Rules[i].Clauses[j]
+}
+
+// Values derived from a Clause's Values.
+type preprocessedClause struct {
+	valuesSet map[string]struct{}       // for OperatorIn: all of the string values
+	values    []preprocessedClauseValue // for other operators that parse their values; same order as Values
+}
+
+type preprocessedClauseValue struct {
+	valid  bool // false if the value could not be parsed for this operator, in which case it never matches
+	regex  *regexp.Regexp
+	time   time.Time
+	semVer semver.Version
+}
+
+// PreprocessItem prepares a FeatureFlag or Segment for faster evaluation, unless that has already
+// been done; it has no effect on other types. This is done automatically for items that the client
+// puts into the FeatureStore. A FeatureStore implementation that creates new items when it reads them
+// from a database should call this before returning them. The item must not be modified afterward.
+func PreprocessItem(item VersionedData) {
+	switch i := item.(type) {
+	case *FeatureFlag:
+		i.preprocess()
+	case *Segment:
+		i.preprocess()
+	}
+}
+
+func (f *FeatureFlag) preprocess() {
+	if f.preprocessed != nil {
+		return
+	}
+	p := &preprocessedFlag{
+		targetSets:      make([]map[string]struct{}, len(f.Targets)),
+		rules:           make([][]*preprocessedClause, len(f.Rules)),
+		variationValues: make([]ldvalue.Value, len(f.Variations)),
+	}
+	for i, t := range f.Targets {
+		p.targetSets[i] = makeStringSet(t.Values)
+	}
+	for i, r := range f.Rules {
+		p.rules[i] = preprocessClauses(r.Clauses)
+	}
+	for i, v := range f.Variations {
+		p.variationValues[i] = ldvalue.CopyArbitraryValue(v)
+	}
+	f.preprocessed = p
+}
+
+func (s *Segment) preprocess() {
+	if s.preprocessed != nil {
+		return
+	}
+	p := &preprocessedSegment{
+		included: makeStringSet(s.Included),
+		excluded: makeStringSet(s.Excluded),
+		rules:    make([][]*preprocessedClause, len(s.Rules)),
+	}
+	for i, r := range s.Rules {
+		p.rules[i] = preprocessClauses(r.Clauses)
+	}
+	s.preprocessed = p
+}
+
+func preprocessClauses(clauses []Clause) []*preprocessedClause {
+	ret := make([]*preprocessedClause, len(clauses))
+	for i, c := range clauses {
+		ret[i] = preprocessClause(c)
+	}
+	return ret
+}
+
+// The following accessors return nil if the item was not preprocessed, so evaluation falls back to
+// using the original data. They also check the index, in case the item was modified afterward.
+
+func (p *preprocessedFlag) targetSet(targetIndex int) map[string]struct{} {
+	if p == nil || targetIndex >= len(p.targetSets) {
+		return nil
+	}
+	return p.targetSets[targetIndex]
+}
+
+func (p *preprocessedFlag) ruleClauses(ruleIndex int) []*preprocessedClause {
+	if p == nil || ruleIndex >= len(p.rules) {
+		return nil
+	}
+	return p.rules[ruleIndex]
+}
+
+func (p *preprocessedSegment) includedSet() map[string]struct{} {
+	if p == nil {
+		return nil
+	}
+	return p.included
+}
+
+func (p *preprocessedSegment) excludedSet() map[string]struct{} {
+	if p == nil {
+		return nil
+	}
+	return p.excluded
+}
+
+func (p *preprocessedSegment) ruleClauses(ruleIndex int) []*preprocessedClause {
+	if p == nil || ruleIndex >= len(p.rules) {
+		return nil
+	}
+	return p.rules[ruleIndex]
+}
+
+func preprocessedClauseAt(clauses []*preprocessedClause, clauseIndex int) *preprocessedClause {
+	if clauseIndex >= len(clauses) {
+		return nil
+	}
+	return clauses[clauseIndex]
+}
+
+func preprocessClause(c Clause) *preprocessedClause {
+	var parse func(value interface{}) preprocessedClauseValue
+	switch c.Op {
+	case OperatorIn:
+		valuesSet := make(map[string]struct{}, len(c.Values))
+		for _, value := range c.Values {
+			if s, ok := value.(string); ok {
+				valuesSet[s] = struct{}{}
+			}
+		}
+		return &preprocessedClause{valuesSet: valuesSet}
+	case OperatorMatches:
+		parse = func(value interface{}) preprocessedClauseValue {
+			if s, ok := value.(string); ok {
+				if r, err := regexp.Compile(s); err == nil {
+					return preprocessedClauseValue{valid: true, regex: r}
+				}
+			}
+			return preprocessedClauseValue{}
+		}
+	case OperatorBefore, OperatorAfter:
+		parse = func(value interface{}) preprocessedClauseValue {
+			if t := ParseTime(value); t != nil {
+				return preprocessedClauseValue{valid: true, time: *t}
+			}
+			return preprocessedClauseValue{}
+		}
+	case OperatorSemVerEqual, OperatorSemVerLessThan, OperatorSemVerGreaterThan:
+		parse = func(value interface{}) preprocessedClauseValue {
+			if v, ok := parseSemVer(value); ok {
+				return preprocessedClauseValue{valid: true, semVer: v}
+			}
+			return preprocessedClauseValue{}
+		}
+	default:
+		return nil // other operators are cheap enough to evaluate directly
+	}
+	values := make([]preprocessedClauseValue, len(c.Values))
+	for i, value := range c.Values {
+		values[i] = parse(value)
+	}
+	return &preprocessedClause{values: values}
+}
+
+func makeStringSet(values []string) map[string]struct{} {
+	if len(values) == 0 {
+		return nil
+	}
+	ret := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		ret[value] = struct{}{}
+	}
+	return ret
+}
+
+// Returns true if key is in values, using the set that was built by preprocessing if available.
+func stringSetContains(set map[string]struct{}, values []string, key string) bool {
+	if set != nil {
+		_, found := set[key]
+		return found
+	}
+	for _, value := range values {
+		if value == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the user value matches any of the clause's values, not taking Negate into account.
+// This has the same result as matchAny(operatorFn(c.Op), uValue, c.Values), but uses the
+// preprocessed values if available.
+func (c Clause) matchAnyValue(uValue interface{}, p *preprocessedClause) bool {
+	if p == nil || (p.values != nil && len(p.values) != len(c.Values)) {
+		return matchAny(operatorFn(c.Op), uValue, c.Values)
+	}
+	switch c.Op {
+	case OperatorIn:
+		if s, ok := uValue.(string); ok {
+			// A string can only be equal to another string, so the set contains all possible matches
+			_, found := p.valuesSet[s]
+			return found
+		}
+		return matchAny(operatorInFn, uValue, c.Values)
+	case OperatorMatches:
+		if s, ok := uValue.(string); ok {
+			for _, v := range p.values {
+				if v.valid && v.regex.MatchString(s) {
+					return true
+				}
+			}
+		}
+		return false
+	case OperatorBefore, OperatorAfter:
+		if uTime := ParseTime(uValue); uTime != nil {
+			for _, v := range p.values {
+				if v.valid && ((c.Op == OperatorBefore && uTime.Before(v.time)) ||
+					(c.Op == OperatorAfter && uTime.After(v.time))) {
+					return true
+				}
+			}
+		}
+		return false
+	case OperatorSemVerEqual, OperatorSemVerLessThan, OperatorSemVerGreaterThan:
+		if uVer, ok := parseSemVer(uValue); ok {
+			for _, v := range p.values {
+				if v.valid && ((c.Op == OperatorSemVerEqual && uVer.Equals(v.semVer)) ||
+					(c.Op == OperatorSemVerLessThan && uVer.LT(v.semVer)) ||
+					(c.Op == OperatorSemVerGreaterThan && uVer.GT(v.semVer))) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return matchAny(operatorFn(c.Op), uValue, c.Values)
+}
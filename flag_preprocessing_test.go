@@ -0,0 +1,285 @@
+package ldclient
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreprocessedClauseHasSameResultAsOriginal(t *testing.T) {
+	dateStr := "2018-06-01T00:00:00Z"
+	dateMillis := float64(time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond))
+	clauses := []Clause{
+		{Op: OperatorIn, Values: []interface{}{"a", "b", 3.0, true}},
+		{Op: OperatorIn, Values: []interface{}{}},
+		{Op: OperatorMatches, Values: []interface{}{"^x+$", "(unclosed", 3, "b.d"}},
+		{Op: OperatorBefore, Values: []interface{}{dateStr, "not a date"}},
+		{Op: OperatorAfter, Values: []interface{}{dateMillis, true}},
+		{Op: OperatorSemVerEqual, Values: []interface{}{"2.0.0", "2", "nope"}},
+		{Op: OperatorSemVerLessThan, Values: []interface{}{"2.0.1"}},
+		{Op: OperatorSemVerGreaterThan, Values: []interface{}{"2.0.0-rc.1"}},
+		{Op: OperatorStartsWith, Values: []interface{}{"x"}},
+		{Op: "unknown", Values: []interface{}{"a"}},
+	}
+	userValues := []interface{}{
+		"a", "c", "xxx", "abcd", "bd", 3, 3.0, int64(3), true, false, nil,
+		"2018-01-01T00:00:00Z", "2019-01-01T00:00:00Z", dateMillis - 1, dateMillis + 1,
+		"2.0.0", "2.0", "1.9.9", "2.0.1", "2.0.0-rc.2",
+		[]interface{}{"c", "a"}, []interface{}{"c", "d"},
+	}
+	for _, c := range clauses {
+		for _, negate := range []bool{false, true} {
+			c.Attribute = "attr"
+			c.Negate = negate
+			preprocessed := preprocessClause(c)
+			for _, value := range userValues {
+				user := User{Key: strPtr("key"), Custom: &map[string]interface{}{"attr": value}}
+				expected := c.matchesUserNoSegments(user, nil)
+				actual := c.matchesUserNoSegments(user, preprocessed)
+				assert.Equal(t, expected, actual, "op: %s, negate: %t, clause values: %v, user value: %v",
+					c.Op, negate, c.Values, value)
+			}
+		}
+	}
+}
+
+func TestPreprocessedFlagUsesTargetSets(t *testing.T) {
+	f := FeatureFlag{
+		Key: "feature",
+		On:  true,
+		Targets: []Target{
+			{Values: []string{"a", "b"}, Variation: 0},
+			{Values: []string{"x", "y"}, Variation: 1},
+		},
+		Fallthrough: VariationOrRollout{Variation: intPtr(2)},
+		Variations:  []interface{}{"zero", "one", "two"},
+	}
+	f.preprocess()
+	require.NotNil(t, f.preprocessed)
+	assert.Equal(t, map[string]struct{}{"x": {}, "y": {}}, f.preprocessed.targetSet(1))
+
+	result, _ := f.EvaluateDetail(NewUser("y"), emptyFeatureStore, false)
+	assert.Equal(t, "one", result.Value)
+	result, _ = f.EvaluateDetail(NewUser("z"), emptyFeatureStore, false)
+	assert.Equal(t, "two", result.Value)
+}
+
+func TestPreprocessedSegmentUsesIncludedAndExcludedSets(t *testing.T) {
+	s := Segment{
+		Key:      "segment",
+		Included: []string{"a", "b"},
+		Excluded: []string{"b", "c"},
+		Rules:    []SegmentRule{{Clauses: []Clause{{Attribute: "key", Op: OperatorMatches, Values: []interface{}{"^c"}}}}},
+	}
+	s.preprocess()
+	require.NotNil(t, s.preprocessed)
+
+	for key, expected := range map[string]bool{"a": true, "b": true, "c": false, "cd": true, "d": false} {
+		actual, _ := s.ContainsUser(NewUser(key))
+		assert.Equal(t, expected, actual, "user key: %s", key)
+	}
+}
+
+func TestFlagThatWasModifiedAfterPreprocessingCanStillBeEvaluated(t *testing.T) {
+	f := FeatureFlag{
+		Key:         "feature",
+		On:          true,
+		Fallthrough: VariationOrRollout{Variation: intPtr(0)},
+		Variations:  []interface{}{"zero", "one"},
+	}
+	f.preprocess()
+	f.Targets = []Target{{Values: []string{"x"}, Variation: 1}}
+	f.Rules = []Rule{{
+		Clauses:            []Clause{{Attribute: "key", Op: OperatorIn, Values: []interface{}{"y"}}},
+		VariationOrRollout: VariationOrRollout{Variation: intPtr(1)},
+	}}
+
+	result, _ := f.EvaluateDetail(NewUser("x"), emptyFeatureStore, false)
+	assert.Equal(t, "one", result.Value)
+	result, _ = f.EvaluateDetail(NewUser("y"), emptyFeatureStore, false)
+	assert.Equal(t, "one", result.Value)
+}
+
+func TestInMemoryFeatureStorePreprocessesItems(t *testing.T) {
+	store := NewInMemoryFeatureStore(nil)
+	flag1 := FeatureFlag{Key: "flag1", Version: 1}
+	segment1 := Segment{Key: "segment1", Version: 1}
+	require.NoError(t, store.Init(MakeAllVersionedDataMap(
+		map[string]*FeatureFlag{flag1.Key: &flag1},
+		map[string]*Segment{segment1.Key: &segment1})))
+	assert.NotNil(t, flag1.preprocessed)
+	assert.NotNil(t, segment1.preprocessed)
+
+	flag2 := FeatureFlag{Key: "flag2", Version: 1}
+	require.NoError(t, store.Upsert(Features, &flag2))
+	assert.NotNil(t, flag2.preprocessed)
+}
+
+func TestChangeTrackingStorePreprocessesItems(t *testing.T) {
+	store := &preprocessingCheckFeatureStore{NewInMemoryFeatureStore(nil)}
+	trackingStore := newChangeTrackingFeatureStore(store, newFlagChangeBroadcaster())
+	flag1 := FeatureFlag{Key: "flag1", Version: 1}
+	segment1 := Segment{Key: "segment1", Version: 1}
+	require.NoError(t, trackingStore.Init(MakeAllVersionedDataMap(
+		map[string]*FeatureFlag{flag1.Key: &flag1},
+		map[string]*Segment{segment1.Key: &segment1})))
+
+	flag2 := FeatureFlag{Key: "flag2", Version: 1}
+	require.NoError(t, trackingStore.Upsert(Features, &flag2))
+}
+
+// Fails if an item is written to it without having been preprocessed.
+type preprocessingCheckFeatureStore struct {
+	*InMemoryFeatureStore
+}
+
+func (s *preprocessingCheckFeatureStore) Init(allData map[VersionedDataKind]map[string]VersionedData) error {
+	for _, items := range allData {
+		for _, item := range items {
+			if err := checkPreprocessed(item); err != nil {
+				return err
+			}
+		}
+	}
+	return s.InMemoryFeatureStore.Init(allData)
+}
+
+func (s *preprocessingCheckFeatureStore) Upsert(kind VersionedDataKind, item VersionedData) error {
+	if err := checkPreprocessed(item); err != nil {
+		return err
+	}
+	return s.InMemoryFeatureStore.Upsert(kind, item)
+}
+
+func checkPreprocessed(item VersionedData) error {
+	switch i := item.(type) {
+	case *FeatureFlag:
+		if i.preprocessed == nil {
+			return fmt.Errorf("flag %s was not preprocessed", i.Key)
+		}
+	case *Segment:
+		if i.preprocessed == nil {
+			return fmt.Errorf("segment %s was not preprocessed", i.Key)
+		}
+	}
+	return nil
+}
+
+// The benchmarks below compare evaluation of the same flag before and after preprocessing.
+
+func makeFlagWithClauseForBenchmark(clause Clause) FeatureFlag {
+	return FeatureFlag{
+		Key: "feature",
+		On:  true,
+		Rules: []Rule{{
+			Clauses:            []Clause{clause},
+			VariationOrRollout: VariationOrRollout{Variation: intPtr(1)},
+		}},
+		Fallthrough: VariationOrRollout{Variation: intPtr(0)},
+		Variations:  []interface{}{false, true},
+	}
+}
+
+func makeKeysForBenchmark(count int) []string {
+	keys := make([]string, count)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("user-%d", i)
+	}
+	return keys
+}
+
+func benchmarkEvaluation(b *testing.B, f FeatureFlag, store FeatureStore, user User, preprocess bool) {
+	if preprocess {
+		f.preprocess()
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.EvaluateDetail(user, store, false)
+	}
+}
+
+func benchmarkClause(b *testing.B, clause Clause, userValue interface{}, preprocess bool) {
+	user := User{Key: strPtr("key"), Custom: &map[string]interface{}{"attr": userValue}}
+	clause.Attribute = "attr"
+	benchmarkEvaluation(b, makeFlagWithClauseForBenchmark(clause), emptyFeatureStore, user, preprocess)
+}
+
+func benchmarkTargets(b *testing.B, preprocess bool) {
+	f := makeFlagWithClauseForBenchmark(Clause{Attribute: "key", Op: OperatorIn})
+	f.Targets = []Target{{Values: makeKeysForBenchmark(10000), Variation: 1}}
+	benchmarkEvaluation(b, f, emptyFeatureStore, NewUser("not-targeted"), preprocess)
+}
+
+func benchmarkSegmentMatch(b *testing.B, preprocess bool) {
+	segment := Segment{Key: "segment", Included: makeKeysForBenchmark(10000)}
+	if preprocess {
+		segment.preprocess()
+	}
+	store := NewInMemoryFeatureStore(nil)
+	store.data.Store(&inMemoryFeatureStoreData{ // bypasses preprocessing in Init
+		allData: map[VersionedDataKind]map[string]VersionedData{Segments: {segment.Key: &segment}},
+		logger:  store.logger,
+	})
+	f := makeFlagWithClauseForBenchmark(Clause{Op: OperatorSegmentMatch, Values: []interface{}{segment.Key}})
+	benchmarkEvaluation(b, f, store, NewUser("not-included"), preprocess)
+}
+
+var benchmarkInValues = func() []interface{} {
+	keys := makeKeysForBenchmark(1000)
+	values := make([]interface{}, len(keys))
+	for i, k := range keys {
+		values[i] = k
+	}
+	return values
+}()
+
+func BenchmarkEvaluateInClause(b *testing.B) {
+	benchmarkClause(b, Clause{Op: OperatorIn, Values: benchmarkInValues}, "not-found", false)
+}
+
+func BenchmarkEvaluateInClausePreprocessed(b *testing.B) {
+	benchmarkClause(b, Clause{Op: OperatorIn, Values: benchmarkInValues}, "not-found", true)
+}
+
+func BenchmarkEvaluateMatchesClause(b *testing.B) {
+	benchmarkClause(b, Clause{Op: OperatorMatches, Values: []interface{}{`^[a-z]+@example\.com$`}}, "x@example.com", false)
+}
+
+func BenchmarkEvaluateMatchesClausePreprocessed(b *testing.B) {
+	benchmarkClause(b, Clause{Op: OperatorMatches, Values: []interface{}{`^[a-z]+@example\.com$`}}, "x@example.com", true)
+}
+
+func BenchmarkEvaluateSemVerClause(b *testing.B) {
+	benchmarkClause(b, Clause{Op: OperatorSemVerLessThan, Values: []interface{}{"2.1", "3.0.0-beta"}}, "2.0.5", false)
+}
+
+func BenchmarkEvaluateSemVerClausePreprocessed(b *testing.B) {
+	benchmarkClause(b, Clause{Op: OperatorSemVerLessThan, Values: []interface{}{"2.1", "3.0.0-beta"}}, "2.0.5", true)
+}
+
+func BenchmarkEvaluateDateClause(b *testing.B) {
+	benchmarkClause(b, Clause{Op: OperatorBefore, Values: []interface{}{"2018-06-01T00:00:00Z"}}, "2018-01-01T00:00:00Z", false)
+}
+
+func BenchmarkEvaluateDateClausePreprocessed(b *testing.B) {
+	benchmarkClause(b, Clause{Op: OperatorBefore, Values: []interface{}{"2018-06-01T00:00:00Z"}}, "2018-01-01T00:00:00Z", true)
+}
+
+func BenchmarkEvaluateLargeTargetList(b *testing.B) {
+	benchmarkTargets(b, false)
+}
+
+func BenchmarkEvaluateLargeTargetListPreprocessed(b *testing.B) {
+	benchmarkTargets(b, true)
+}
+
+func BenchmarkEvaluateLargeSegment(b *testing.B) {
+	benchmarkSegmentMatch(b, false)
+}
+
+func BenchmarkEvaluateLargeSegmentPreprocessed(b *testing.B) {
+	benchmarkSegmentMatch(b, true)
+}
@@ -1,9 +1,12 @@
 package ldclient
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/launchdarkly/go-client.v4/ldvalue"
 )
 
 var flagUser = NewUser("x")
@@ -327,6 +330,87 @@ func TestMultipleLevelsOfPrerequisiteProduceMultipleEvents(t *testing.T) {
 	assert.Equal(t, strPtr(f0.Key), e1.PrereqOf)
 }
 
+func TestPrerequisiteCycleReturnsMalformedFlagError(t *testing.T) {
+	f0 := FeatureFlag{
+		Key:           "feature0",
+		On:            true,
+		OffVariation:  intPtr(1),
+		Prerequisites: []Prerequisite{Prerequisite{"feature1", 1}},
+		Fallthrough:   VariationOrRollout{Variation: intPtr(0)},
+		Variations:    []interface{}{"fall", "off"},
+	}
+	f1 := FeatureFlag{
+		Key:           "feature1",
+		On:            true,
+		Prerequisites: []Prerequisite{Prerequisite{"feature2", 1}},
+		Fallthrough:   VariationOrRollout{Variation: intPtr(1)},
+		Variations:    []interface{}{"nogo", "go"},
+	}
+	f2 := FeatureFlag{
+		Key:           "feature2",
+		On:            true,
+		Prerequisites: []Prerequisite{Prerequisite{"feature0", 0}},
+		Fallthrough:   VariationOrRollout{Variation: intPtr(1)},
+		Variations:    []interface{}{"nogo", "go"},
+	}
+	featureStore := NewInMemoryFeatureStore(nil)
+	featureStore.Upsert(Features, &f0)
+	featureStore.Upsert(Features, &f1)
+	featureStore.Upsert(Features, &f2)
+
+	logger := &testLogger{}
+	result, events := f0.evaluateDetail(flagUser, featureStore, false, &evaluationState{logger: NewLeveledLoggerAdapter(logger)})
+	assert.Equal(t, EvaluationDetail{Reason: newEvalReasonError(EvalErrorMalformedFlag)}, result)
+	assert.Equal(t, 0, len(events))
+	assert.Equal(t, []string{`ERROR: Prerequisite cycle detected when evaluating feature flag flag=feature0 ` +
+		`chain="feature0 -> feature1 -> feature2 -> feature0"`}, logger.getOutput())
+}
+
+func TestFlagThatIsItsOwnPrerequisiteReturnsMalformedFlagError(t *testing.T) {
+	f0 := FeatureFlag{
+		Key:           "feature0",
+		On:            true,
+		Prerequisites: []Prerequisite{Prerequisite{"feature0", 0}},
+		Fallthrough:   VariationOrRollout{Variation: intPtr(0)},
+		Variations:    []interface{}{"fall"},
+	}
+	featureStore := NewInMemoryFeatureStore(nil)
+	featureStore.Upsert(Features, &f0)
+
+	result, _ := f0.EvaluateDetail(flagUser, featureStore, false)
+	assert.Equal(t, newEvalReasonError(EvalErrorMalformedFlag), result.Reason)
+}
+
+func TestSamePrerequisiteUsedTwiceIsNotACycle(t *testing.T) {
+	f0 := FeatureFlag{
+		Key:           "feature0",
+		On:            true,
+		Prerequisites: []Prerequisite{Prerequisite{"feature1", 0}, Prerequisite{"feature2", 0}},
+		Fallthrough:   VariationOrRollout{Variation: intPtr(0)},
+		Variations:    []interface{}{"fall"},
+	}
+	f1 := FeatureFlag{
+		Key:           "feature1",
+		On:            true,
+		Prerequisites: []Prerequisite{Prerequisite{"feature2", 0}},
+		Fallthrough:   VariationOrRollout{Variation: intPtr(0)},
+		Variations:    []interface{}{"go"},
+	}
+	f2 := FeatureFlag{
+		Key:         "feature2",
+		On:          true,
+		Fallthrough: VariationOrRollout{Variation: intPtr(0)},
+		Variations:  []interface{}{"go"},
+	}
+	featureStore := NewInMemoryFeatureStore(nil)
+	featureStore.Upsert(Features, &f1)
+	featureStore.Upsert(Features, &f2)
+
+	result, events := f0.EvaluateDetail(flagUser, featureStore, false)
+	assert.Equal(t, evalReasonFallthroughInstance, result.Reason)
+	assert.Equal(t, 3, len(events))
+}
+
 func TestFlagMatchesUserFromTargets(t *testing.T) {
 	f := FeatureFlag{
 		Key:          "feature",
@@ -611,6 +695,60 @@ func booleanFlagWithClause(clause Clause) FeatureFlag {
 	}
 }
 
+func TestCloneMakesDeepCopyOfFlag(t *testing.T) {
+	flag := FeatureFlag{
+		Key:           "feature",
+		Prerequisites: []Prerequisite{{Key: "prereq", Variation: 0}},
+		Targets:       []Target{{Values: []string{"a"}, Variation: 0}},
+		Rules: []Rule{{
+			ID:                 "rule",
+			VariationOrRollout: VariationOrRollout{Rollout: &Rollout{Variations: []WeightedVariation{{Variation: 0, Weight: 1}}, BucketBy: strPtr("key")}},
+			Clauses:            []Clause{{Attribute: "key", Op: OperatorIn, Values: []interface{}{[]interface{}{"x"}}}},
+		}},
+		Fallthrough:  VariationOrRollout{Variation: intPtr(0)},
+		OffVariation: intPtr(1),
+		Variations:   []interface{}{map[string]interface{}{"a": []interface{}{"b"}}, "off"},
+	}
+	flag.preprocess()
+	originalJSON, _ := json.Marshal(flag)
+
+	clone := flag.Clone().(*FeatureFlag)
+	assert.Nil(t, clone.preprocessed)
+	cloneJSON, _ := json.Marshal(clone)
+	assert.JSONEq(t, string(originalJSON), string(cloneJSON))
+
+	clone.Prerequisites[0].Key = "changed"
+	clone.Targets[0].Values[0] = "changed"
+	clone.Rules[0].Rollout.Variations[0].Weight = 2
+	*clone.Rules[0].Rollout.BucketBy = "changed"
+	clone.Rules[0].Clauses[0].Values[0].([]interface{})[0] = "changed"
+	*clone.Fallthrough.Variation = 2
+	*clone.OffVariation = 2
+	clone.Variations[0].(map[string]interface{})["a"].([]interface{})[0] = "changed"
+
+	afterJSON, _ := json.Marshal(flag)
+	assert.JSONEq(t, string(originalJSON), string(afterJSON))
+}
+
+func TestClonePreservesNilAndEmptySlices(t *testing.T) {
+	flag := FeatureFlag{Key: "feature", Targets: []Target{}, Rules: nil}
+	clone := flag.Clone().(*FeatureFlag)
+	assert.Equal(t, flag, *clone)
+}
+
+func TestVariationValue(t *testing.T) {
+	flag := FeatureFlag{Key: "feature", Variations: []interface{}{"a", map[string]interface{}{"b": true}}}
+	expected := ldvalue.ObjectOf(map[string]ldvalue.Value{"b": ldvalue.Bool(true)})
+
+	assert.True(t, expected.Equal(flag.VariationValue(1)))
+	assert.True(t, flag.VariationValue(2).IsNull())
+	assert.True(t, flag.VariationValue(-1).IsNull())
+
+	flag.preprocess()
+	assert.Equal(t, ldvalue.String("a"), flag.VariationValue(0))
+	assert.True(t, expected.Equal(flag.VariationValue(1)))
+}
+
 func newEvalErrorResult(kind EvalErrorKind) EvaluationDetail {
 	return EvaluationDetail{Reason: newEvalReasonError(kind)}
 }
@@ -636,3 +774,24 @@ func makeFlagToMatchUser(user User, variationOrRollout VariationOrRollout) Featu
 		Variations:  []interface{}{"fall", "off", "on"},
 	}
 }
+
+func TestFlagJSONKeepsLargeIntegerVariationsExact(t *testing.T) {
+	flagJSON := `{"key":"flag","version":1,"on":false,"trackEvents":false,"deleted":false,"prerequisites":null,` +
+		`"salt":"","sel":"","targets":null,"rules":null,"fallthrough":{},"offVariation":null,` +
+		`"variations":[9007199254740993,2,"x",1e300],"debugEventsUntilDate":null,"clientSide":false}`
+	var flag FeatureFlag
+	assert.NoError(t, json.Unmarshal([]byte(flagJSON), &flag))
+	assert.Equal(t, []interface{}{float64(9007199254740993), float64(2), "x", 1e300}, flag.Variations)
+	assert.Equal(t, map[int]int64{0: 9007199254740993}, flag.largeInts)
+
+	data, err := json.Marshal(&flag)
+	assert.NoError(t, err)
+	assert.JSONEq(t, flagJSON, string(data))
+	assert.Contains(t, string(data), "[9007199254740993,")
+}
+
+func TestFlagJSONDoesNotKeepExactValueOfSmallIntegers(t *testing.T) {
+	var flag FeatureFlag
+	assert.NoError(t, json.Unmarshal([]byte(`{"key":"flag","variations":[1,-9007199254740992]}`), &flag))
+	assert.Nil(t, flag.largeInts)
+}
@@ -0,0 +1,303 @@
+package ldclient
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// FeatureDataDiagnosticKind identifies the type of problem described by a FeatureDataDiagnostic.
+type FeatureDataDiagnosticKind string
+
+const (
+	// DiagnosticVariationOutOfRange means that a target, rule, rollout, fallthrough, off variation, or
+	// prerequisite refers to a variation index that the flag does not have. Evaluations that reach it
+	// return an EvalErrorMalformedFlag reason (or, for a prerequisite, always fail the prerequisite).
+	DiagnosticVariationOutOfRange FeatureDataDiagnosticKind = "VARIATION_OUT_OF_RANGE"
+	// DiagnosticMissingVariationOrRollout means that a rule or fallthrough has neither a variation nor
+	// a rollout.
+	DiagnosticMissingVariationOrRollout FeatureDataDiagnosticKind = "MISSING_VARIATION_OR_ROLLOUT"
+	// DiagnosticEmptyRollout means that a rollout has no variations.
+	DiagnosticEmptyRollout FeatureDataDiagnosticKind = "EMPTY_ROLLOUT"
+	// DiagnosticRolloutWeights means that the weights of a rollout do not add up to 100000, or that a
+	// segment rule's weight is outside of the range 0 to 100000.
+	DiagnosticRolloutWeights FeatureDataDiagnosticKind = "ROLLOUT_WEIGHTS"
+	// DiagnosticUnknownOperator means that a clause uses an operator that the SDK does not recognize,
+	// or one that is not supported in that context; such a clause never matches.
+	DiagnosticUnknownOperator FeatureDataDiagnosticKind = "UNKNOWN_OPERATOR"
+	// DiagnosticInvalidRegex means that a value in a "matches" clause is not a valid regular expression.
+	DiagnosticInvalidRegex FeatureDataDiagnosticKind = "INVALID_REGEX"
+	// DiagnosticInvalidSemVer means that a value in a semantic version clause cannot be parsed.
+	DiagnosticInvalidSemVer FeatureDataDiagnosticKind = "INVALID_SEMVER"
+	// DiagnosticInvalidDate means that a value in a "before" or "after" clause cannot be parsed as a date.
+	DiagnosticInvalidDate FeatureDataDiagnosticKind = "INVALID_DATE"
+	// DiagnosticMissingPrerequisite means that a flag has a prerequisite flag that does not exist.
+	DiagnosticMissingPrerequisite FeatureDataDiagnosticKind = "MISSING_PREREQUISITE"
+	// DiagnosticMissingSegment means that a "segmentMatch" clause refers to a segment that does not exist.
+	DiagnosticMissingSegment FeatureDataDiagnosticKind = "MISSING_SEGMENT"
+	// DiagnosticPrerequisiteCycle means that a flag's prerequisites lead back to itself.
+	DiagnosticPrerequisiteCycle FeatureDataDiagnosticKind = "PREREQUISITE_CYCLE"
+)
+
+// FeatureDataDiagnostic describes a problem found by ValidateFeatureData.
+type FeatureDataDiagnostic struct {
+	// Kind is the type of problem.
+	Kind FeatureDataDiagnosticKind
+	// ItemKind is Features or Segments.
+	ItemKind VersionedDataKind
+	// Key is the key of the flag or segment that has the problem.
+	Key string
+	// Path is the location of the problem within the flag or segment, such as "rules[0].clauses[1]".
+	Path string
+	// Message is a human-readable description of the problem.
+	Message string
+}
+
+// String returns a description of the problem in the form `features "key" path: message`.
+func (d FeatureDataDiagnostic) String() string {
+	return fmt.Sprintf("%s %q %s: %s", d.ItemKind, d.Key, d.Path, d.Message)
+}
+
+// ValidateFeatureData checks a set of feature flags and segments for problems that would not cause
+// an error when the data is stored, but that would cause evaluations to behave unexpectedly-- for
+// instance, a clause with an unknown operator never matches, and a rule that refers to a nonexistent
+// variation produces an error result. It returns a diagnostic for each problem found, or nil if
+// there are none. The order of the diagnostics is deterministic.
+//
+// The data set has the same format that is passed to FeatureStore.Init. Deleted items are ignored.
+// To check every data set that is received from LaunchDarkly, you could call this from the Init
+// method of a FeatureStore that wraps your real store.
+func ValidateFeatureData(allData map[VersionedDataKind]map[string]VersionedData) []FeatureDataDiagnostic {
+	v := featureDataValidator{
+		flags:    make(map[string]*FeatureFlag),
+		segments: make(map[string]*Segment),
+	}
+	for key, item := range allData[Features] {
+		if flag, ok := item.(*FeatureFlag); ok && !flag.Deleted {
+			v.flags[key] = flag
+		}
+	}
+	for key, item := range allData[Segments] {
+		if segment, ok := item.(*Segment); ok && !segment.Deleted {
+			v.segments[key] = segment
+		}
+	}
+	for _, key := range sortedKeys(v.flags) {
+		v.validateFlag(key, v.flags[key])
+	}
+	segmentKeys := make([]string, 0, len(v.segments))
+	for key := range v.segments {
+		segmentKeys = append(segmentKeys, key)
+	}
+	sort.Strings(segmentKeys)
+	for _, key := range segmentKeys {
+		v.validateSegment(key, v.segments[key])
+	}
+	if cycle := findPrerequisiteCycle(v.flags); cycle != nil {
+		v.add(DiagnosticPrerequisiteCycle, Features, cycle[0], "prerequisites",
+			"prerequisite cycle detected: %s", formatKeyChain(cycle, cycle[0]))
+	}
+	return v.diagnostics
+}
+
+type featureDataValidator struct {
+	flags       map[string]*FeatureFlag
+	segments    map[string]*Segment
+	diagnostics []FeatureDataDiagnostic
+}
+
+func (v *featureDataValidator) add(kind FeatureDataDiagnosticKind, itemKind VersionedDataKind, key, path,
+	format string, args ...interface{}) {
+	v.diagnostics = append(v.diagnostics, FeatureDataDiagnostic{
+		Kind:     kind,
+		ItemKind: itemKind,
+		Key:      key,
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *featureDataValidator) validateFlag(key string, f *FeatureFlag) {
+	checkVariation := func(path string, index int) {
+		if index < 0 || index >= len(f.Variations) {
+			v.add(DiagnosticVariationOutOfRange, Features, key, path,
+				"variation index %d is out of range (flag has %d variations)", index, len(f.Variations))
+		}
+	}
+	checkVariationOrRollout := func(path string, vr VariationOrRollout) {
+		if vr.Variation != nil {
+			checkVariation(path+".variation", *vr.Variation)
+			return
+		}
+		if vr.Rollout == nil {
+			v.add(DiagnosticMissingVariationOrRollout, Features, key, path, "has neither a variation nor a rollout")
+			return
+		}
+		if len(vr.Rollout.Variations) == 0 {
+			v.add(DiagnosticEmptyRollout, Features, key, path+".rollout", "rollout has no variations")
+			return
+		}
+		totalWeight := 0
+		for i, wv := range vr.Rollout.Variations {
+			checkVariation(fmt.Sprintf("%s.rollout.variations[%d].variation", path, i), wv.Variation)
+			totalWeight += wv.Weight
+		}
+		if totalWeight != 100000 {
+			v.add(DiagnosticRolloutWeights, Features, key, path+".rollout",
+				"rollout weights add up to %d, not 100000", totalWeight)
+		}
+	}
+
+	if f.OffVariation != nil {
+		checkVariation("offVariation", *f.OffVariation)
+	}
+	for i, p := range f.Prerequisites {
+		path := fmt.Sprintf("prerequisites[%d]", i)
+		prereqFlag := v.flags[p.Key]
+		if prereqFlag == nil {
+			v.add(DiagnosticMissingPrerequisite, Features, key, path, "prerequisite flag %q does not exist", p.Key)
+		} else if p.Variation < 0 || p.Variation >= len(prereqFlag.Variations) {
+			v.add(DiagnosticVariationOutOfRange, Features, key, path+".variation",
+				"variation index %d is out of range (prerequisite flag %q has %d variations)",
+				p.Variation, p.Key, len(prereqFlag.Variations))
+		}
+	}
+	for i, t := range f.Targets {
+		checkVariation(fmt.Sprintf("targets[%d].variation", i), t.Variation)
+	}
+	for i, r := range f.Rules {
+		path := fmt.Sprintf("rules[%d]", i)
+		for j, c := range r.Clauses {
+			v.validateClause(Features, key, fmt.Sprintf("%s.clauses[%d]", path, j), c, true)
+		}
+		checkVariationOrRollout(path, r.VariationOrRollout)
+	}
+	checkVariationOrRollout("fallthrough", f.Fallthrough)
+}
+
+func (v *featureDataValidator) validateSegment(key string, s *Segment) {
+	for i, r := range s.Rules {
+		path := fmt.Sprintf("rules[%d]", i)
+		for j, c := range r.Clauses {
+			v.validateClause(Segments, key, fmt.Sprintf("%s.clauses[%d]", path, j), c, false)
+		}
+		if r.Weight != nil && (*r.Weight < 0 || *r.Weight > 100000) {
+			v.add(DiagnosticRolloutWeights, Segments, key, path+".weight",
+				"weight %d is not in the range 0 to 100000", *r.Weight)
+		}
+	}
+}
+
+func (v *featureDataValidator) validateClause(itemKind VersionedDataKind, key, path string, c Clause,
+	segmentsAllowed bool) {
+	if c.Op == OperatorSegmentMatch {
+		if !segmentsAllowed {
+			v.add(DiagnosticUnknownOperator, itemKind, key, path, "segmentMatch cannot be used in a segment rule")
+			return
+		}
+		for i, value := range c.Values {
+			segmentKey, _ := value.(string)
+			if v.segments[segmentKey] == nil {
+				v.add(DiagnosticMissingSegment, itemKind, key, fmt.Sprintf("%s.values[%d]", path, i),
+					"segment %v does not exist", value)
+			}
+		}
+		return
+	}
+	if _, ok := allOps[c.Op]; !ok {
+		v.add(DiagnosticUnknownOperator, itemKind, key, path+".op", "unknown operator %q", c.Op)
+		return
+	}
+	for i, value := range c.Values {
+		valuePath := fmt.Sprintf("%s.values[%d]", path, i)
+		switch c.Op {
+		case OperatorMatches:
+			if s, ok := value.(string); !ok {
+				v.add(DiagnosticInvalidRegex, itemKind, key, valuePath, "value %v is not a string", value)
+			} else if _, err := regexp.Compile(s); err != nil {
+				v.add(DiagnosticInvalidRegex, itemKind, key, valuePath, "invalid regular expression: %s", err)
+			}
+		case OperatorSemVerEqual, OperatorSemVerLessThan, OperatorSemVerGreaterThan:
+			if _, ok := parseSemVer(value); !ok {
+				v.add(DiagnosticInvalidSemVer, itemKind, key, valuePath, "value %v is not a valid semantic version", value)
+			}
+		case OperatorBefore, OperatorAfter:
+			if ParseTime(value) == nil {
+				v.add(DiagnosticInvalidDate, itemKind, key, valuePath, "value %v is not a valid date", value)
+			}
+		}
+	}
+}
+
+// CheckPrerequisiteCycles returns an error if any feature flag in the data set has prerequisites that
+// lead back to itself, directly or indirectly. Such flags cannot be evaluated (evaluation returns an
+// EvalErrorMalformedFlag reason), so a data source may wish to reject the data instead. The error
+// message includes the chain of flag keys that forms the cycle.
+//
+// The data set has the same format that is passed to FeatureStore.Init; only the Features
+// collection is examined, and deleted flags are ignored.
+func CheckPrerequisiteCycles(allData map[VersionedDataKind]map[string]VersionedData) error {
+	flags := make(map[string]*FeatureFlag)
+	for key, item := range allData[Features] {
+		if flag, ok := item.(*FeatureFlag); ok && !flag.Deleted {
+			flags[key] = flag
+		}
+	}
+	if cycle := findPrerequisiteCycle(flags); cycle != nil {
+		return fmt.Errorf("prerequisite cycle detected: %s", formatKeyChain(cycle, cycle[0]))
+	}
+	return nil
+}
+
+// Returns the keys of the flags in the first prerequisite cycle found, or nil if there is none.
+func findPrerequisiteCycle(flags map[string]*FeatureFlag) []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	states := make(map[string]int, len(flags))
+	var path []string
+	var visit func(key string) []string
+	visit = func(key string) []string {
+		switch states[key] {
+		case visited:
+			return nil
+		case visiting:
+			for i, k := range path {
+				if k == key {
+					return path[i:]
+				}
+			}
+		}
+		flag := flags[key]
+		if flag == nil {
+			return nil // a missing prerequisite is not a cycle
+		}
+		states[key] = visiting
+		path = append(path, key)
+		for _, prereq := range flag.Prerequisites {
+			if cycle := visit(prereq.Key); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		states[key] = visited
+		return nil
+	}
+	for _, key := range sortedKeys(flags) { // so that the same data set always produces the same result
+		if cycle := visit(key); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+func sortedKeys(flags map[string]*FeatureFlag) []string {
+	keys := make([]string, 0, len(flags))
+	for key := range flags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
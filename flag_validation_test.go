@@ -0,0 +1,192 @@
+package ldclient
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckPrerequisiteCyclesAcceptsDataWithoutCycles(t *testing.T) {
+	data := MakeAllVersionedDataMap(map[string]*FeatureFlag{
+		"a": {Key: "a", Prerequisites: []Prerequisite{{Key: "b"}, {Key: "c"}}},
+		"b": {Key: "b", Prerequisites: []Prerequisite{{Key: "c"}}},
+		"c": {Key: "c"},
+		"d": {Key: "d", Prerequisites: []Prerequisite{{Key: "missing"}}},
+	}, nil)
+	assert.NoError(t, CheckPrerequisiteCycles(data))
+}
+
+func TestCheckPrerequisiteCyclesReportsCycle(t *testing.T) {
+	data := MakeAllVersionedDataMap(map[string]*FeatureFlag{
+		"a": {Key: "a", Prerequisites: []Prerequisite{{Key: "b"}}},
+		"b": {Key: "b", Prerequisites: []Prerequisite{{Key: "c"}}},
+		"c": {Key: "c", Prerequisites: []Prerequisite{{Key: "a"}}},
+	}, nil)
+	err := CheckPrerequisiteCycles(data)
+	if assert.Error(t, err) {
+		assert.Equal(t, "prerequisite cycle detected: a -> b -> c -> a", err.Error())
+	}
+}
+
+func TestCheckPrerequisiteCyclesReportsFlagThatIsItsOwnPrerequisite(t *testing.T) {
+	data := MakeAllVersionedDataMap(map[string]*FeatureFlag{
+		"a": {Key: "a"},
+		"b": {Key: "b", Prerequisites: []Prerequisite{{Key: "b"}}},
+	}, nil)
+	err := CheckPrerequisiteCycles(data)
+	if assert.Error(t, err) {
+		assert.Equal(t, "prerequisite cycle detected: b -> b", err.Error())
+	}
+}
+
+func TestCheckPrerequisiteCyclesIgnoresDeletedFlags(t *testing.T) {
+	data := MakeAllVersionedDataMap(map[string]*FeatureFlag{
+		"a": {Key: "a", Prerequisites: []Prerequisite{{Key: "b"}}},
+		"b": {Key: "b", Prerequisites: []Prerequisite{{Key: "a"}}, Deleted: true},
+	}, nil)
+	assert.NoError(t, CheckPrerequisiteCycles(data))
+}
+
+func diagnosticSummaries(diagnostics []FeatureDataDiagnostic) []string {
+	ret := make([]string, 0, len(diagnostics))
+	for _, d := range diagnostics {
+		ret = append(ret, string(d.Kind)+" "+d.ItemKind.GetNamespace()+" "+d.Key+" "+d.Path)
+	}
+	return ret
+}
+
+func TestValidateFeatureDataAcceptsValidData(t *testing.T) {
+	zero := 0
+	one := 1
+	weight := 50000
+	data := MakeAllVersionedDataMap(map[string]*FeatureFlag{
+		"a": {
+			Key:           "a",
+			Variations:    []interface{}{false, true},
+			OffVariation:  &zero,
+			Prerequisites: []Prerequisite{{Key: "b", Variation: 0}},
+			Targets:       []Target{{Values: []string{"u"}, Variation: 1}},
+			Rules: []Rule{
+				{
+					Clauses: []Clause{
+						{Attribute: "email", Op: OperatorMatches, Values: []interface{}{"^.*@example\\.com$"}},
+						{Attribute: "version", Op: OperatorSemVerLessThan, Values: []interface{}{"2.0.0"}},
+						{Attribute: "created", Op: OperatorAfter, Values: []interface{}{"2018-01-01T00:00:00Z", 0}},
+						{Op: OperatorSegmentMatch, Values: []interface{}{"s"}},
+					},
+					VariationOrRollout: VariationOrRollout{Variation: &one},
+				},
+			},
+			Fallthrough: VariationOrRollout{Rollout: &Rollout{Variations: []WeightedVariation{
+				{Variation: 0, Weight: 40000}, {Variation: 1, Weight: 60000},
+			}}},
+		},
+		"b": {Key: "b", Variations: []interface{}{"x"}, Fallthrough: VariationOrRollout{Variation: &zero}},
+	}, map[string]*Segment{
+		"s": {Key: "s", Rules: []SegmentRule{{
+			Clauses: []Clause{{Attribute: "key", Op: OperatorIn, Values: []interface{}{"u"}}},
+			Weight:  &weight,
+		}}},
+	})
+	assert.Nil(t, ValidateFeatureData(data))
+}
+
+func TestValidateFeatureDataReportsVariationProblems(t *testing.T) {
+	minusOne := -1
+	two := 2
+	data := MakeAllVersionedDataMap(map[string]*FeatureFlag{
+		"a": {
+			Key:           "a",
+			Variations:    []interface{}{false, true},
+			OffVariation:  &two,
+			Prerequisites: []Prerequisite{{Key: "b", Variation: 1}, {Key: "missing"}},
+			Targets:       []Target{{Values: []string{"u"}, Variation: 2}},
+			Rules: []Rule{
+				{VariationOrRollout: VariationOrRollout{Variation: &minusOne}},
+				{VariationOrRollout: VariationOrRollout{Rollout: &Rollout{}}},
+				{VariationOrRollout: VariationOrRollout{Rollout: &Rollout{Variations: []WeightedVariation{
+					{Variation: 0, Weight: 40000}, {Variation: 5, Weight: 50000},
+				}}}},
+			},
+		},
+		"b": {Key: "b", Variations: []interface{}{"x"}, Fallthrough: VariationOrRollout{Variation: &two}},
+	}, nil)
+	assert.Equal(t, []string{
+		"VARIATION_OUT_OF_RANGE features a offVariation",
+		"VARIATION_OUT_OF_RANGE features a prerequisites[0].variation",
+		"MISSING_PREREQUISITE features a prerequisites[1]",
+		"VARIATION_OUT_OF_RANGE features a targets[0].variation",
+		"VARIATION_OUT_OF_RANGE features a rules[0].variation",
+		"EMPTY_ROLLOUT features a rules[1].rollout",
+		"VARIATION_OUT_OF_RANGE features a rules[2].rollout.variations[1].variation",
+		"ROLLOUT_WEIGHTS features a rules[2].rollout",
+		"MISSING_VARIATION_OR_ROLLOUT features a fallthrough",
+		"VARIATION_OUT_OF_RANGE features b fallthrough.variation",
+	}, diagnosticSummaries(ValidateFeatureData(data)))
+}
+
+func TestValidateFeatureDataReportsClauseProblems(t *testing.T) {
+	zero := 0
+	clauses := []Clause{
+		{Attribute: "key", Op: "isOneOf", Values: []interface{}{"x"}},
+		{Attribute: "email", Op: OperatorMatches, Values: []interface{}{"(unclosed", 3}},
+		{Attribute: "version", Op: OperatorSemVerEqual, Values: []interface{}{"1.2.3", "not-a-version"}},
+		{Attribute: "created", Op: OperatorBefore, Values: []interface{}{"yesterday"}},
+		{Op: OperatorSegmentMatch, Values: []interface{}{"s", "missing"}},
+	}
+	data := MakeAllVersionedDataMap(map[string]*FeatureFlag{
+		"a": {
+			Key:         "a",
+			Variations:  []interface{}{true},
+			Rules:       []Rule{{Clauses: clauses, VariationOrRollout: VariationOrRollout{Variation: &zero}}},
+			Fallthrough: VariationOrRollout{Variation: &zero},
+		},
+	}, map[string]*Segment{
+		"s": {Key: "s", Rules: []SegmentRule{{Clauses: []Clause{{Op: OperatorSegmentMatch, Values: []interface{}{"s"}}}}}},
+	})
+	diagnostics := ValidateFeatureData(data)
+	assert.Equal(t, []string{
+		"UNKNOWN_OPERATOR features a rules[0].clauses[0].op",
+		"INVALID_REGEX features a rules[0].clauses[1].values[0]",
+		"INVALID_REGEX features a rules[0].clauses[1].values[1]",
+		"INVALID_SEMVER features a rules[0].clauses[2].values[1]",
+		"INVALID_DATE features a rules[0].clauses[3].values[0]",
+		"MISSING_SEGMENT features a rules[0].clauses[4].values[1]",
+		"UNKNOWN_OPERATOR segments s rules[0].clauses[0]",
+	}, diagnosticSummaries(diagnostics))
+	assert.Equal(t, `features "a" rules[0].clauses[0].op: unknown operator "isOneOf"`, diagnostics[0].String())
+}
+
+func TestValidateFeatureDataReportsSegmentWeightOutOfRange(t *testing.T) {
+	weight := 100001
+	data := MakeAllVersionedDataMap(nil, map[string]*Segment{
+		"s": {Key: "s", Rules: []SegmentRule{{Weight: &weight}}},
+	})
+	assert.Equal(t, []string{"ROLLOUT_WEIGHTS segments s rules[0].weight"},
+		diagnosticSummaries(ValidateFeatureData(data)))
+}
+
+func TestValidateFeatureDataReportsPrerequisiteCycle(t *testing.T) {
+	zero := 0
+	data := MakeAllVersionedDataMap(map[string]*FeatureFlag{
+		"a": {Key: "a", Variations: []interface{}{true}, Fallthrough: VariationOrRollout{Variation: &zero},
+			Prerequisites: []Prerequisite{{Key: "b"}}},
+		"b": {Key: "b", Variations: []interface{}{true}, Fallthrough: VariationOrRollout{Variation: &zero},
+			Prerequisites: []Prerequisite{{Key: "a"}}},
+	}, nil)
+	diagnostics := ValidateFeatureData(data)
+	if assert.Len(t, diagnostics, 1) {
+		assert.Equal(t, DiagnosticPrerequisiteCycle, diagnostics[0].Kind)
+		assert.Equal(t, "a", diagnostics[0].Key)
+		assert.Equal(t, "prerequisite cycle detected: a -> b -> a", diagnostics[0].Message)
+	}
+}
+
+func TestValidateFeatureDataIgnoresDeletedItems(t *testing.T) {
+	data := MakeAllVersionedDataMap(map[string]*FeatureFlag{
+		"a": {Key: "a", Deleted: true},
+	}, map[string]*Segment{
+		"s": {Key: "s", Deleted: true, Rules: []SegmentRule{{Clauses: []Clause{{Op: "bogus"}}}}},
+	})
+	assert.Nil(t, ValidateFeatureData(data))
+}
@@ -0,0 +1,161 @@
+package ldclient
+
+import (
+	"reflect"
+	"sync"
+
+	"gopkg.in/launchdarkly/go-client.v4/internal/broadcast"
+)
+
+// FlagValueChangeEvent is sent to flag value change listeners (see LDClient.AddFlagValueChangeListener)
+// when the value of a feature flag for a particular user has changed.
+type FlagValueChangeEvent struct {
+	// Key is the key of the feature flag that changed.
+	Key string
+	// User is the user for whom the flag was evaluated.
+	User User
+	// OldDetail is the result of the last evaluation of the flag for this user, before the change.
+	OldDetail EvaluationDetail
+	// NewDetail is the result of evaluating the flag for this user after the change.
+	NewDetail EvaluationDetail
+}
+
+type flagValueChangeSubscription struct {
+	*broadcast.Subscription
+	key        string
+	user       User
+	defaultVal interface{}
+	lastDetail EvaluationDetail // only accessed while holding detailLock
+	detailLock sync.Mutex
+}
+
+// Re-evaluates registered (flag key, user) pairs whenever the flag change machinery reports that a
+// flag has changed. Registrations are indexed by flag key, so each change only causes evaluations for
+// the registrations that are interested in that flag, and by channel, so that removing one is cheap.
+// Evaluations are done without holding the tracker lock, so they do not hold up adding and removing
+// listeners.
+type flagValueChangeTracker struct {
+	broadcaster   *flagChangeBroadcaster
+	evaluate      func(key string, user User, defaultVal interface{}) EvaluationDetail
+	registrations map[string][]*flagValueChangeSubscription
+	byChannel     map[<-chan FlagValueChangeEvent]*flagValueChangeSubscription
+	started       bool
+	closed        bool
+	lock          sync.Mutex
+}
+
+func newFlagValueChangeTracker(broadcaster *flagChangeBroadcaster,
+	evaluate func(key string, user User, defaultVal interface{}) EvaluationDetail) *flagValueChangeTracker {
+	return &flagValueChangeTracker{
+		broadcaster:   broadcaster,
+		evaluate:      evaluate,
+		registrations: make(map[string][]*flagValueChangeSubscription),
+		byChannel:     make(map[<-chan FlagValueChangeEvent]*flagValueChangeSubscription),
+	}
+}
+
+func (t *flagValueChangeTracker) addListener(key string, user User, defaultVal interface{}) <-chan FlagValueChangeEvent {
+	ch := make(chan FlagValueChangeEvent, broadcast.ListenerBufferSize)
+	var receiveCh <-chan FlagValueChangeEvent = ch
+	sub := &flagValueChangeSubscription{
+		Subscription: broadcast.NewSubscription(ch, nil, mergeFlagValueChangeEvents),
+		key:          key,
+		user:         user,
+		defaultVal:   defaultVal,
+	}
+	// The initial evaluation is done after the registration is in place, but before the change handler
+	// can look at this subscription, so that a change that happens in the meantime is not missed.
+	sub.detailLock.Lock()
+	defer sub.detailLock.Unlock()
+
+	t.lock.Lock()
+	if t.closed {
+		t.lock.Unlock()
+		close(ch)
+		return receiveCh
+	}
+	if !t.started {
+		// We don't subscribe to flag changes until we need to, since having any listener causes the
+		// change-tracking store to do extra work on every Init.
+		t.started = true
+		go t.run(t.broadcaster.addListener("", true))
+	}
+	t.registrations[key] = append(t.registrations[key], sub)
+	t.byChannel[receiveCh] = sub
+	t.lock.Unlock()
+
+	sub.lastDetail = t.evaluate(key, user, defaultVal)
+	return receiveCh
+}
+
+func (t *flagValueChangeTracker) removeListener(ch <-chan FlagValueChangeEvent) {
+	t.lock.Lock()
+	removed, ok := t.byChannel[ch]
+	if ok {
+		delete(t.byChannel, ch)
+		subs := t.registrations[removed.key]
+		for i, s := range subs {
+			if s == removed {
+				if len(subs) == 1 {
+					delete(t.registrations, removed.key)
+				} else {
+					t.registrations[removed.key] = append(subs[:i:i], subs[i+1:]...)
+				}
+				break
+			}
+		}
+	}
+	t.lock.Unlock()
+	if ok {
+		removed.Close()
+	}
+}
+
+func (t *flagValueChangeTracker) run(flagChanges <-chan FlagChangeEvent) {
+	for event := range flagChanges {
+		t.lock.Lock()
+		subs := t.registrations[event.Key] // never modified in place, so it is safe to use after unlocking
+		t.lock.Unlock()
+		for _, s := range subs {
+			if change, changed := s.reevaluate(t.evaluate); changed {
+				s.Send(change)
+			}
+		}
+	}
+}
+
+// Evaluates the flag again, and returns an event if the value has changed since the last evaluation.
+func (s *flagValueChangeSubscription) reevaluate(
+	evaluate func(key string, user User, defaultVal interface{}) EvaluationDetail) (FlagValueChangeEvent, bool) {
+	s.detailLock.Lock()
+	defer s.detailLock.Unlock()
+	newDetail := evaluate(s.key, s.user, s.defaultVal)
+	oldDetail := s.lastDetail
+	s.lastDetail = newDetail
+	if reflect.DeepEqual(oldDetail.Value, newDetail.Value) {
+		return FlagValueChangeEvent{}, false
+	}
+	return FlagValueChangeEvent{Key: s.key, User: s.user, OldDetail: oldDetail, NewDetail: newDetail}, true
+}
+
+// If the application falls behind in reading from a channel, successive changes that are waiting to be
+// delivered are combined into one, so that the queue for that channel does not keep growing.
+func mergeFlagValueChangeEvents(waiting, newer interface{}) (interface{}, bool) {
+	merged := waiting.(FlagValueChangeEvent)
+	merged.NewDetail = newer.(FlagValueChangeEvent).NewDetail
+	return merged, true
+}
+
+func (t *flagValueChangeTracker) close() {
+	t.lock.Lock()
+	registrations := t.registrations
+	t.registrations = make(map[string][]*flagValueChangeSubscription)
+	t.byChannel = make(map[<-chan FlagValueChangeEvent]*flagValueChangeSubscription)
+	t.closed = true
+	t.lock.Unlock()
+	for _, subs := range registrations {
+		for _, s := range subs {
+			s.Close()
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package ldclient
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/launchdarkly/go-client.v4/internal/broadcast"
+)
+
+func expectFlagValueChangeEvent(t *testing.T, ch <-chan FlagValueChangeEvent) FlagValueChangeEvent {
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out waiting for flag value change event")
+	}
+	return FlagValueChangeEvent{}
+}
+
+func expectNoFlagValueChangeEvent(t *testing.T, ch <-chan FlagValueChangeEvent) {
+	select {
+	case e := <-ch:
+		assert.Fail(t, "received unexpected flag value change event", "%+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestFlagValueChangeListenerReceivesEventWhenValueChanges(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	store := client.config.FeatureStore
+	require.NoError(t, store.Upsert(Features, makeTestFlag("flag", 0, "a", "b")))
+
+	ch := client.AddFlagValueChangeListener("flag", evalTestUser, "default")
+
+	flagv2 := makeTestFlag("flag", 1, "a", "b")
+	flagv2.Version = 2
+	require.NoError(t, store.Upsert(Features, flagv2))
+
+	e := expectFlagValueChangeEvent(t, ch)
+	assert.Equal(t, "flag", e.Key)
+	assert.Equal(t, evalTestUser, e.User)
+	assert.Equal(t, "a", e.OldDetail.Value)
+	assert.Equal(t, "b", e.NewDetail.Value)
+	assert.Equal(t, evalReasonFallthroughInstance, e.NewDetail.Reason)
+}
+
+func TestFlagValueChangeListenerReceivesNoEventWhenValueIsUnchanged(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	store := client.config.FeatureStore
+	require.NoError(t, store.Upsert(Features, makeTestFlag("flag", 0, "a", "b")))
+
+	ch := client.AddFlagValueChangeListener("flag", evalTestUser, "default")
+
+	flagv2 := makeTestFlag("flag", 0, "a", "b")
+	flagv2.Version = 2
+	flagv2.Targets = []Target{{Values: []string{"some-other-user"}, Variation: 1}}
+	require.NoError(t, store.Upsert(Features, flagv2))
+
+	expectNoFlagValueChangeEvent(t, ch)
+}
+
+func TestFlagValueChangeListenerReceivesEventWhenPrerequisiteChanges(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	store := client.config.FeatureStore
+	prereq := makeTestFlag("prereq", 1, "x", "y")
+	flag := makeTestFlag("flag", 1, "off", "on")
+	flag.OffVariation = intPtr(0)
+	flag.Prerequisites = []Prerequisite{{Key: "prereq", Variation: 1}}
+	require.NoError(t, store.Upsert(Features, prereq))
+	require.NoError(t, store.Upsert(Features, flag))
+
+	ch := client.AddFlagValueChangeListener("flag", evalTestUser, "default")
+
+	prereqv2 := makeTestFlag("prereq", 0, "x", "y")
+	prereqv2.Version = 2
+	require.NoError(t, store.Upsert(Features, prereqv2))
+
+	e := expectFlagValueChangeEvent(t, ch)
+	assert.Equal(t, "on", e.OldDetail.Value)
+	assert.Equal(t, "off", e.NewDetail.Value)
+	assert.Equal(t, newEvalReasonPrerequisiteFailed("prereq"), e.NewDetail.Reason)
+}
+
+func TestFlagValueChangeListenerReceivesDefaultValueWhenFlagIsDeleted(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	store := client.config.FeatureStore
+	require.NoError(t, store.Upsert(Features, makeTestFlag("flag", 0, "a", "b")))
+
+	ch := client.AddFlagValueChangeListener("flag", evalTestUser, "default")
+
+	require.NoError(t, store.Delete(Features, "flag", 2))
+
+	e := expectFlagValueChangeEvent(t, ch)
+	assert.Equal(t, "a", e.OldDetail.Value)
+	assert.Equal(t, "default", e.NewDetail.Value)
+	assert.Equal(t, newEvalReasonError(EvalErrorFlagNotFound), e.NewDetail.Reason)
+}
+
+func TestFlagValueChangeListenerGeneratesNoAnalyticsEvents(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	store := client.config.FeatureStore
+	require.NoError(t, store.Upsert(Features, makeTestFlag("flag", 0, "a", "b")))
+
+	ch := client.AddFlagValueChangeListener("flag", evalTestUser, "default")
+	flagv2 := makeTestFlag("flag", 1, "a", "b")
+	flagv2.Version = 2
+	require.NoError(t, store.Upsert(Features, flagv2))
+	expectFlagValueChangeEvent(t, ch)
+
+	assert.Equal(t, 0, len(client.eventProcessor.(*testEventProcessor).events))
+}
+
+func TestRemovedFlagValueChangeListenerIsClosed(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+
+	ch := client.AddFlagValueChangeListener("flag", evalTestUser, "default")
+	client.RemoveFlagValueChangeListener(ch)
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestFlagValueChangeEvaluationDoesNotBlockAddingOrRemovingListeners(t *testing.T) {
+	broadcaster := newFlagChangeBroadcaster()
+	defer broadcaster.close()
+	evaluating := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var blocking int32
+	tracker := newFlagValueChangeTracker(broadcaster, func(key string, user User, defaultVal interface{}) EvaluationDetail {
+		if atomic.LoadInt32(&blocking) == 1 && *user.Key == "slow-user" {
+			evaluating <- struct{}{}
+			<-release
+		}
+		return EvaluationDetail{Value: "a"}
+	})
+	defer tracker.close()
+	tracker.addListener("flag", NewUser("slow-user"), "default")
+	atomic.StoreInt32(&blocking, 1)
+
+	broadcaster.broadcast(FlagChangeEvent{Key: "flag"})
+	select {
+	case <-evaluating:
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out waiting for re-evaluation")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ch := tracker.addListener("flag", NewUser("other-user"), "default")
+		tracker.removeListener(ch)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail(t, "adding or removing a listener was blocked by a re-evaluation")
+	}
+	close(release)
+}
+
+func TestStalledFlagValueChangeListenerDoesNotBlockOtherListeners(t *testing.T) {
+	broadcaster := newFlagChangeBroadcaster()
+	defer broadcaster.close()
+	var current int32
+	tracker := newFlagValueChangeTracker(broadcaster, func(key string, user User, defaultVal interface{}) EvaluationDetail {
+		return EvaluationDetail{Value: int(atomic.LoadInt32(&current))}
+	})
+	defer tracker.close()
+	stalledCh := tracker.addListener("flag", NewUser("stalled-user"), 0)
+	healthyCh := tracker.addListener("flag", NewUser("healthy-user"), 0)
+
+	count := broadcast.ListenerBufferSize * 3
+	for i := 1; i <= count; i++ {
+		atomic.StoreInt32(&current, int32(i))
+		broadcaster.broadcast(FlagChangeEvent{Key: "flag"})
+		e := expectFlagValueChangeEvent(t, healthyCh)
+		assert.Equal(t, i-1, e.OldDetail.Value)
+		assert.Equal(t, i, e.NewDetail.Value)
+	}
+
+	// The stalled listener gets the events that fit in its buffer, and then the rest combined into as
+	// few events as possible, with no gaps between them
+	received, last := 0, 0
+	for last < count {
+		e := expectFlagValueChangeEvent(t, stalledCh)
+		require.Equal(t, last, e.OldDetail.Value)
+		last = e.NewDetail.Value.(int)
+		received++
+	}
+	assert.True(t, received <= broadcast.ListenerBufferSize+2, "received %d events", received)
+	expectNoFlagValueChangeEvent(t, stalledCh)
+}
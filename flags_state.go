@@ -108,7 +108,7 @@ func (s FeatureFlagsState) IsValid() bool {
 // GetFlagValue returns the value of an individual feature flag at the time the state was recorded. The
 // return value will be nil if the flag returned the default value, or if there was no such flag.
 func (s FeatureFlagsState) GetFlagValue(key string) interface{} {
-	return s.flagValues[key]
+	return deepCopyValue(s.flagValues[key])
 }
 
 // GetFlagReason returns the evaluation reason for an individual feature flag at the time the state was
@@ -121,12 +121,19 @@ func (s FeatureFlagsState) GetFlagReason(key string) EvaluationReason {
 }
 
 // ToValuesMap returns a map of flag keys to flag values. If a flag would have evaluated to the default
-// value, its value will be nil.
+// value, its value will be nil. The map and its values are a new copy, which can be modified freely.
 //
 // Do not use this method if you are passing data to the front end to "bootstrap" the JavaScript client.
 // Instead, convert the state object to JSON using json.Marshal.
 func (s FeatureFlagsState) ToValuesMap() map[string]interface{} {
-	return s.flagValues
+	if s.flagValues == nil {
+		return nil
+	}
+	ret := make(map[string]interface{}, len(s.flagValues))
+	for k, v := range s.flagValues {
+		ret[k] = deepCopyValue(v)
+	}
+	return ret
 }
 
 // MarshalJSON implements a custom JSON serialization for FeatureFlagsState, to produce the correct
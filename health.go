@@ -0,0 +1,185 @@
+package ldclient
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// HealthOptions configures the thresholds that LDClient.GetHealthStatus and LDClient.HealthHandler
+// use to decide whether the client is ready. Regardless of these settings, the client is not ready
+// if it has not been initialized, if its data source has shut down, if the FeatureStore is
+// unavailable, or if LaunchDarkly has rejected the SDK key when events were delivered.
+type HealthOptions struct {
+	// MaxInterruption is how long the data source may be interrupted (for instance, by a dropped
+	// stream connection) before the client is reported as not ready. If it is zero, an interruption
+	// does not make the client not ready, since flag evaluations can still use the last known data.
+	MaxInterruption time.Duration
+	// MaxDataAge is how long the client can go without receiving data from LaunchDarkly before it is
+	// reported as not ready. If it is zero, the age of the data is not checked. In polling mode, data
+	// is received after every poll; in streaming mode, it is only received when flags change, so it is
+	// usually better to use MaxInterruption instead. It is ignored in offline mode and LDD mode.
+	MaxDataAge time.Duration
+}
+
+// HealthStatus is the health report returned by LDClient.GetHealthStatus. HealthHandler serves it
+// as JSON.
+type HealthStatus struct {
+	// Ready is true if the client is initialized and, within the thresholds of HealthOptions, has
+	// current feature flag data and a working FeatureStore.
+	Ready bool `json:"ready"`
+	// Reasons explains why the client is not ready. It is empty if Ready is true.
+	Reasons []string `json:"reasons,omitempty"`
+	// DataSource describes the component that receives feature flag data from LaunchDarkly.
+	DataSource HealthDataSourceStatus `json:"dataSource"`
+	// FeatureStore describes the availability of the FeatureStore.
+	FeatureStore HealthFeatureStoreStatus `json:"featureStore"`
+	// Events describes the delivery of analytics events.
+	Events HealthEventsStatus `json:"events"`
+}
+
+// HealthDataSourceStatus is the part of HealthStatus that describes the data source.
+type HealthDataSourceStatus struct {
+	// Initialized is the same as LDClient.Initialized.
+	Initialized bool `json:"initialized"`
+	// Connected is true if the data source is in DataSourceStateValid, meaning that, as far as we
+	// know, it is receiving updates from LaunchDarkly.
+	Connected bool `json:"connected"`
+	// State and StateSince are the same as in DataSourceStatus.
+	State      DataSourceState `json:"state"`
+	StateSince time.Time       `json:"stateSince"`
+	// LastUpdate is the last time the data source received valid data, or nil if it never has.
+	LastUpdate *time.Time `json:"lastUpdate,omitempty"`
+	// SecondsSinceLastUpdate is the time elapsed since LastUpdate, or nil if LastUpdate is nil.
+	SecondsSinceLastUpdate *float64 `json:"secondsSinceLastUpdate,omitempty"`
+	// LastError is the message of the most recent error from DataSourceStatus, if any.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// HealthFeatureStoreStatus is the part of HealthStatus that describes the FeatureStore.
+type HealthFeatureStoreStatus struct {
+	// Available and NeedsRefresh are the same as in FeatureStoreStatus.
+	Available    bool `json:"available"`
+	NeedsRefresh bool `json:"needsRefresh"`
+	// LastError is the message of FeatureStoreStatus.LastError, if any.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// HealthEventsStatus is the part of HealthStatus that describes the delivery of analytics events.
+type HealthEventsStatus struct {
+	// Enabled is true if the client is delivering analytics events with the default EventProcessor.
+	// It is false if Config.SendEvents is false, if the client is offline, or if Config.EventProcessor
+	// is set, in which case the other fields are not reported.
+	Enabled bool `json:"enabled"`
+	// Disabled is true if LaunchDarkly rejected the SDK key when events were delivered (for instance,
+	// with a 401 error), so the client has stopped sending events.
+	Disabled bool `json:"disabled"`
+	// LastSuccess is the last time events were delivered, or nil if they never have been.
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	// LastFailure is the last time an attempt to deliver events failed, or nil if none has.
+	LastFailure *time.Time `json:"lastFailure,omitempty"`
+	// LastError describes the most recent failure, if any.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// GetHealthStatus returns a report of the client's health, and whether it is ready according to the
+// thresholds in options.
+func (client *LDClient) GetHealthStatus(options HealthOptions) HealthStatus {
+	now := time.Now()
+	var health HealthStatus
+
+	dsStatus := client.dataSourceStatus.getStatus()
+	health.DataSource = HealthDataSourceStatus{
+		Initialized: client.Initialized(),
+		Connected:   dsStatus.State == DataSourceStateValid,
+		State:       dsStatus.State,
+		StateSince:  dsStatus.StateSince,
+		LastError:   dsStatus.LastError.Message,
+	}
+	if lastUpdate := client.dataSourceStatus.getLastUpdate(); !lastUpdate.IsZero() {
+		age := now.Sub(lastUpdate).Seconds()
+		health.DataSource.LastUpdate = &lastUpdate
+		health.DataSource.SecondsSinceLastUpdate = &age
+	}
+
+	storeStatus := client.featureStoreStatus.getStatus()
+	health.FeatureStore = HealthFeatureStoreStatus{
+		Available:    storeStatus.Available,
+		NeedsRefresh: storeStatus.NeedsRefresh,
+	}
+	if storeStatus.LastError != nil {
+		health.FeatureStore.LastError = storeStatus.LastError.Error()
+	}
+
+	if p, ok := client.eventProcessor.(eventDeliveryStatusProvider); ok {
+		eventsStatus := p.getDeliveryStatus()
+		health.Events = HealthEventsStatus{
+			Enabled:     true,
+			Disabled:    eventsStatus.disabled,
+			LastSuccess: timeOrNil(eventsStatus.lastSuccess),
+			LastFailure: timeOrNil(eventsStatus.lastFailure),
+			LastError:   eventsStatus.lastError,
+		}
+	}
+
+	health.Reasons = client.getNotReadyReasons(options, health, now)
+	health.Ready = len(health.Reasons) == 0
+	return health
+}
+
+func (client *LDClient) getNotReadyReasons(options HealthOptions, health HealthStatus, now time.Time) []string {
+	var reasons []string
+	ds := health.DataSource
+	if !ds.Initialized {
+		reasons = append(reasons, "client is not initialized")
+	}
+	externalData := client.IsOffline() || client.config.UseLdd
+	switch {
+	case ds.State == DataSourceStateOff:
+		reasons = append(reasons, "data source is off")
+	case ds.State == DataSourceStateInterrupted && options.MaxInterruption > 0 &&
+		now.Sub(ds.StateSince) > options.MaxInterruption:
+		reasons = append(reasons, "data source has been interrupted since "+ds.StateSince.Format(time.RFC3339))
+	}
+	if options.MaxDataAge > 0 && !externalData && ds.Initialized {
+		if ds.SecondsSinceLastUpdate == nil || *ds.SecondsSinceLastUpdate > options.MaxDataAge.Seconds() {
+			reasons = append(reasons, "data has not been updated within "+options.MaxDataAge.String())
+		}
+	}
+	if !health.FeatureStore.Available {
+		reasons = append(reasons, "feature store is not available")
+	}
+	if health.Events.Disabled {
+		reasons = append(reasons, "event delivery was disabled because the SDK key was rejected")
+	}
+	return reasons
+}
+
+// HealthHandler returns an http.Handler that serves the result of GetHealthStatus as JSON. The
+// status code is 200 if the client is ready, or 503 (Service Unavailable) if not, so the handler can
+// be used directly as a readiness check.
+func (client *LDClient) HealthHandler(options HealthOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		health := client.GetHealthStatus(options)
+		data, err := json.Marshal(health)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		if health.Ready {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, _ = w.Write(data)
+	})
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
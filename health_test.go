@@ -0,0 +1,152 @@
+package ldclient
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getHealthFromHandler(t *testing.T, client *LDClient, options HealthOptions) (int, map[string]interface{}) {
+	rec := httptest.NewRecorder()
+	client.HealthHandler(options).ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	return rec.Code, body
+}
+
+func TestHealthHandlerReportsReadyClient(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	client.dataSourceStatus.updateStatus(DataSourceStateValid, nil)
+
+	code, body := getHealthFromHandler(t, client, HealthOptions{})
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, body["ready"])
+	assert.Nil(t, body["reasons"])
+	dataSource := body["dataSource"].(map[string]interface{})
+	assert.Equal(t, true, dataSource["initialized"])
+	assert.Equal(t, true, dataSource["connected"])
+	assert.Equal(t, "VALID", dataSource["state"])
+	assert.NotNil(t, dataSource["lastUpdate"])
+	assert.Equal(t, map[string]interface{}{"available": true, "needsRefresh": false}, body["featureStore"])
+	assert.Equal(t, map[string]interface{}{"enabled": false, "disabled": false}, body["events"])
+}
+
+func TestHealthHandlerReportsUninitializedClientAsNotReady(t *testing.T) {
+	client := makeTestClientWithConfig(func(c *Config) {
+		c.UpdateProcessorFactory = updateProcessorFactory(mockUpdateProcessor{})
+	})
+	defer client.Close()
+
+	code, body := getHealthFromHandler(t, client, HealthOptions{})
+
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, false, body["ready"])
+	assert.Equal(t, []interface{}{"client is not initialized"}, body["reasons"])
+	dataSource := body["dataSource"].(map[string]interface{})
+	assert.Equal(t, "INITIALIZING", dataSource["state"])
+	assert.Nil(t, dataSource["lastUpdate"])
+}
+
+func TestHealthStatusAllowsInterruptionUpToThreshold(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	client.dataSourceStatus.updateStatus(DataSourceStateValid, nil)
+	client.dataSourceStatus.updateStatus(DataSourceStateInterrupted,
+		newDataSourceErrorInfo(DataSourceErrorKindNetworkError, errors.New("sorry")))
+
+	health := client.GetHealthStatus(HealthOptions{})
+	assert.True(t, health.Ready)
+	assert.False(t, health.DataSource.Connected)
+	assert.Equal(t, "sorry", health.DataSource.LastError)
+
+	assert.True(t, client.GetHealthStatus(HealthOptions{MaxInterruption: time.Hour}).Ready)
+
+	time.Sleep(time.Millisecond * 10)
+	health = client.GetHealthStatus(HealthOptions{MaxInterruption: time.Millisecond})
+	assert.False(t, health.Ready)
+	require.Len(t, health.Reasons, 1)
+	assert.Contains(t, health.Reasons[0], "interrupted")
+}
+
+func TestHealthStatusChecksDataAge(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	client.dataSourceStatus.updateStatus(DataSourceStateValid, nil)
+
+	assert.True(t, client.GetHealthStatus(HealthOptions{MaxDataAge: time.Hour}).Ready)
+
+	time.Sleep(time.Millisecond * 10)
+	health := client.GetHealthStatus(HealthOptions{MaxDataAge: time.Millisecond})
+	assert.False(t, health.Ready)
+	assert.Equal(t, []string{"data has not been updated within 1ms"}, health.Reasons)
+
+	client.dataSourceStatus.recordUpdate()
+	assert.True(t, client.GetHealthStatus(HealthOptions{MaxDataAge: time.Second}).Ready)
+}
+
+func TestHealthStatusIgnoresDataAgeInOfflineMode(t *testing.T) {
+	client := makeTestClientWithConfig(func(c *Config) { c.Offline = true })
+	defer client.Close()
+
+	assert.True(t, client.GetHealthStatus(HealthOptions{MaxDataAge: time.Nanosecond}).Ready)
+}
+
+func TestHealthStatusReportsUnavailableFeatureStore(t *testing.T) {
+	store := newStatusProvidingFeatureStore()
+	store.status = FeatureStoreStatus{Available: false, NeedsRefresh: true, LastError: errors.New("sorry")}
+	client := makeTestClientWithConfig(func(c *Config) { c.FeatureStore = store })
+	defer client.Close()
+
+	health := client.GetHealthStatus(HealthOptions{})
+
+	assert.False(t, health.Ready)
+	assert.Equal(t, []string{"feature store is not available"}, health.Reasons)
+	assert.Equal(t, HealthFeatureStoreStatus{Available: false, NeedsRefresh: true, LastError: "sorry"}, health.FeatureStore)
+}
+
+func TestHealthStatusReportsEventDelivery(t *testing.T) {
+	ep, _ := createEventProcessor(epDefaultConfig)
+	defer ep.Close()
+	client := makeTestClientWithConfig(func(c *Config) { c.EventProcessor = ep })
+	defer client.Close()
+
+	health := client.GetHealthStatus(HealthOptions{})
+	assert.Equal(t, HealthEventsStatus{Enabled: true}, health.Events)
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.Flush()
+	ep.waitUntilInactive()
+
+	health = client.GetHealthStatus(HealthOptions{})
+	assert.True(t, health.Ready)
+	assert.NotNil(t, health.Events.LastSuccess)
+	assert.Nil(t, health.Events.LastFailure)
+}
+
+func TestHealthStatusIsNotReadyAfterEventsAreDisabledByUnauthorizedError(t *testing.T) {
+	ep, st := createEventProcessor(epDefaultConfig)
+	defer ep.Close()
+	st.statusCode = 401
+	client := makeTestClientWithConfig(func(c *Config) { c.EventProcessor = ep })
+	defer client.Close()
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.Flush()
+	ep.waitUntilInactive()
+
+	health := client.GetHealthStatus(HealthOptions{})
+	assert.False(t, health.Ready)
+	assert.True(t, health.Events.Disabled)
+	assert.NotNil(t, health.Events.LastFailure)
+	assert.NotEqual(t, "", health.Events.LastError)
+	assert.Equal(t, []string{"event delivery was disabled because the SDK key was rejected"}, health.Reasons)
+}
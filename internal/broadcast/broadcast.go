@@ -0,0 +1,208 @@
+// Package broadcast provides the listener channel machinery that is shared by the client and by the
+// utils package. It is for internal use only.
+package broadcast
+
+import (
+	"reflect"
+	"sync"
+)
+
+// ListenerBufferSize is the size of the buffer for channels that are returned by the various
+// Add...Listener methods.
+const ListenerBufferSize = 10
+
+// A MergeFunc combines a value that is waiting to be delivered to a subscriber with a newer value. If
+// it returns true, the waiting value is replaced with the merged value, and the newer value is not
+// queued separately. This keeps the queue for a subscriber that has stopped reading from growing
+// without limit.
+type MergeFunc func(waiting, newer interface{}) (merged interface{}, ok bool)
+
+// KeepLatest is a MergeFunc for subscribers that only need the most recent value, such as the current
+// status of a component.
+func KeepLatest(waiting, newer interface{}) (interface{}, bool) {
+	return newer, true
+}
+
+// DropDuplicates is a MergeFunc that discards a value if an equal one is already waiting. The values
+// must be of a comparable type.
+func DropDuplicates(waiting, newer interface{}) (interface{}, bool) {
+	return waiting, waiting == newer
+}
+
+// A Subscription represents one channel that was returned to the application by an Add...Listener
+// method. Sending to it never blocks: if the channel's buffer is full, the value is queued, and a
+// goroutine delivers the queued values as the application reads them. That goroutine only exists
+// while there is a backlog, so an idle subscription costs nothing but its channel.
+type Subscription struct {
+	sendCh        reflect.Value // the bidirectional channel
+	channel       interface{}   // the receive-only channel that was returned to the application
+	accepts       func(value interface{}) bool
+	merge         MergeFunc
+	done          chan struct{}
+	doneOnce      sync.Once
+	queue         []interface{} // values that did not fit in the channel's buffer
+	delivering    bool          // true if a goroutine is delivering the queued values
+	closed        bool
+	lock          sync.Mutex
+	sendLock      sync.Mutex // held during a blocking send, so that the channel is not closed meanwhile
+	channelClosed bool       // only accessed while holding sendLock
+}
+
+// NewSubscription creates a Subscription for a channel, which must be a bidirectional channel; the
+// application should be given the receive-only form of it. If accepts is nil, the subscription
+// receives every value that is broadcast. If merge is nil, values are never merged, so the queue can
+// grow as long as the application is not reading.
+func NewSubscription(channel interface{}, accepts func(interface{}) bool, merge MergeFunc) *Subscription {
+	sendCh := reflect.ValueOf(channel)
+	receiveType := reflect.ChanOf(reflect.RecvDir, sendCh.Type().Elem())
+	return &Subscription{
+		sendCh:  sendCh,
+		channel: sendCh.Convert(receiveType).Interface(),
+		accepts: accepts,
+		merge:   merge,
+		done:    make(chan struct{}),
+	}
+}
+
+// Send sends a value to the subscriber without waiting for it to be read. Values are always delivered
+// in the order they were sent, except for any that are merged.
+func (s *Subscription) Send(value interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	if len(s.queue) == 0 && !s.delivering {
+		// Close cannot close the channel while we hold the lock, since it sets closed first
+		if s.sendCh.TrySend(reflect.ValueOf(value)) {
+			return
+		}
+	}
+	if s.merge != nil {
+		for i := len(s.queue) - 1; i >= 0; i-- {
+			if merged, ok := s.merge(s.queue[i], value); ok {
+				s.queue[i] = merged
+				return
+			}
+		}
+	}
+	s.queue = append(s.queue, value)
+	if !s.delivering {
+		s.delivering = true
+		go s.deliverQueued()
+	}
+}
+
+func (s *Subscription) deliverQueued() {
+	for {
+		s.lock.Lock()
+		if s.closed || len(s.queue) == 0 {
+			s.delivering = false
+			s.lock.Unlock()
+			return
+		}
+		value := s.queue[0]
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		s.lock.Unlock()
+
+		s.sendLock.Lock()
+		if !s.channelClosed {
+			_, _, _ = reflect.Select([]reflect.SelectCase{
+				{Dir: reflect.SelectSend, Chan: s.sendCh, Send: reflect.ValueOf(value)},
+				{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.done)},
+			})
+		}
+		s.sendLock.Unlock()
+	}
+}
+
+// Close discards any values that have not been delivered, and closes the channel.
+func (s *Subscription) Close() {
+	s.lock.Lock()
+	s.closed = true
+	s.queue = nil
+	s.lock.Unlock()
+	s.doneOnce.Do(func() { close(s.done) }) // unblocks any send that is in progress
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	if !s.channelClosed {
+		s.channelClosed = true
+		s.sendCh.Close()
+	}
+}
+
+// A Broadcaster delivers values to any number of subscriptions. Broadcasting never blocks, so a
+// listener that stops reading cannot hold up the component that is producing the values, or any
+// other listener. The broadcaster's lock is never held while sending.
+type Broadcaster struct {
+	subscriptions []*Subscription
+	closed        bool
+	lock          sync.Mutex
+}
+
+// New creates a Broadcaster.
+func New() *Broadcaster {
+	return &Broadcaster{}
+}
+
+// Add adds a subscription, unless the broadcaster has already been closed, in which case the subscription
+// is closed immediately.
+func (b *Broadcaster) Add(s *Subscription) {
+	b.lock.Lock()
+	if !b.closed {
+		b.subscriptions = append(b.subscriptions, s)
+		b.lock.Unlock()
+		return
+	}
+	b.lock.Unlock()
+	s.Close()
+}
+
+// Remove removes and closes the subscription for the specified receive-only channel, if any.
+func (b *Broadcaster) Remove(channel interface{}) {
+	b.lock.Lock()
+	var removed *Subscription
+	for i, s := range b.subscriptions {
+		if s.channel == channel {
+			removed = s
+			b.subscriptions = append(b.subscriptions[:i:i], b.subscriptions[i+1:]...)
+			break
+		}
+	}
+	b.lock.Unlock()
+	if removed != nil {
+		removed.Close()
+	}
+}
+
+// HasSubscriptions returns true if there are any subscriptions.
+func (b *Broadcaster) HasSubscriptions() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.subscriptions) > 0
+}
+
+// Broadcast sends a value to every subscription that accepts it.
+func (b *Broadcaster) Broadcast(value interface{}) {
+	b.lock.Lock()
+	subscriptions := b.subscriptions // never modified in place, so it is safe to use after unlocking
+	b.lock.Unlock()
+	for _, s := range subscriptions {
+		if s.accepts == nil || s.accepts(value) {
+			s.Send(value)
+		}
+	}
+}
+
+// Close closes every subscription, and causes any that are added later to be closed immediately.
+func (b *Broadcaster) Close() {
+	b.lock.Lock()
+	subscriptions := b.subscriptions
+	b.subscriptions = nil
+	b.closed = true
+	b.lock.Unlock()
+	for _, s := range subscriptions {
+		s.Close()
+	}
+}
@@ -0,0 +1,258 @@
+package ldclient
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// JsonVariationInto evaluates a feature flag whose variations are JSON values, and decodes the result
+// into out, which must be a non-nil pointer, in the same way as json.Unmarshal. This is a faster and
+// simpler alternative to calling JsonVariation and then unmarshaling the result yourself.
+//
+// If the flag cannot be evaluated, or its value cannot be decoded into the type that out points to,
+// *out is set to defaultVal and an error is returned; defaultVal must be assignable to that type, or
+// nil to use the zero value. In the second case, the EvaluationReason is ERROR(WRONG_TYPE).
+//
+// The decoded value of each variation is cached along with the flag data, as long as that version of
+// the flag is in the feature store, so that evaluating the same flag again does not repeat the work.
+// Each caller gets its own copy of the cached value, so the result can be modified freely. Values of
+// types that implement json.Unmarshaler or encoding.TextUnmarshaler, or that contain such types, are
+// not cached, since they may keep data in unexported fields that cannot be copied.
+func (client *LDClient) JsonVariationInto(key string, user User, out interface{}, defaultVal interface{}) error {
+	_, err := client.jsonVariationInto(context.Background(), key, user, out, defaultVal, false)
+	return err
+}
+
+// JsonVariationIntoDetail is the same as JsonVariationInto, but also returns further information about
+// how the value was calculated. The "reason" data will also be included in analytics events.
+func (client *LDClient) JsonVariationIntoDetail(key string, user User, out interface{}, defaultVal interface{}) (EvaluationDetail, error) {
+	return client.jsonVariationInto(context.Background(), key, user, out, defaultVal, true)
+}
+
+// JsonVariationIntoCtx is the same as JsonVariationInto, but uses a context for feature store queries.
+func (client *LDClient) JsonVariationIntoCtx(ctx context.Context, key string, user User, out interface{}, defaultVal interface{}) error {
+	_, err := client.jsonVariationInto(ctx, key, user, out, defaultVal, false)
+	return err
+}
+
+// JsonVariationIntoDetailCtx is the same as JsonVariationIntoDetail, but uses a context for feature
+// store queries.
+func (client *LDClient) JsonVariationIntoDetailCtx(ctx context.Context, key string, user User, out interface{}, defaultVal interface{}) (EvaluationDetail, error) {
+	return client.jsonVariationInto(ctx, key, user, out, defaultVal, true)
+}
+
+func (client *LDClient) jsonVariationInto(ctx context.Context, key string, user User, out interface{}, defaultVal interface{},
+	sendReasonsInEvents bool) (EvaluationDetail, error) {
+	outValue := reflect.ValueOf(out)
+	if outValue.Kind() != reflect.Ptr || outValue.IsNil() {
+		return EvaluationDetail{Value: defaultVal, Reason: newEvalReasonError(EvalErrorException)},
+			errors.New("JsonVariationInto requires a non-nil pointer")
+	}
+	target := outValue.Elem()
+	defaultValue := reflect.Zero(target.Type())
+	if defaultVal != nil {
+		defaultValue = reflect.ValueOf(defaultVal)
+		if !defaultValue.Type().AssignableTo(target.Type()) {
+			return EvaluationDetail{Value: defaultVal, Reason: newEvalReasonError(EvalErrorException)},
+				fmt.Errorf("default value of type %s cannot be assigned to %s", defaultValue.Type(), target.Type())
+		}
+	}
+
+	targetType := target.Type()
+	decode := func(flag *FeatureFlag, index int, value interface{}) (interface{}, error) {
+		var decoded reflect.Value
+		var err error
+		if flag != nil && client.isFlagVariationValue(flag, index, value) {
+			decoded, err = flag.decodeVariation(index, targetType)
+		} else {
+			decoded, err = decodeValue(value, targetType)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return decoded.Interface(), nil
+	}
+	detail, flag, err := client.convertedVariation(ctx, key, user, defaultVal, decode, sendReasonsInEvents)
+	if err != nil || flag == nil || detail.VariationIndex == nil {
+		target.Set(defaultValue)
+		detail.Value = defaultVal
+		return detail, err
+	}
+	if detail.Value == nil {
+		target.Set(reflect.Zero(targetType))
+	} else {
+		target.Set(reflect.ValueOf(detail.Value))
+	}
+	return detail, nil
+}
+
+// Returns true if value is the flag's variation with the given index, rather than a value that an
+// evaluation hook substituted. That is always the case if there are no hooks, so the comparison is
+// skipped then.
+func (client *LDClient) isFlagVariationValue(flag *FeatureFlag, index int, value interface{}) bool {
+	if index < 0 || index >= len(flag.Variations) {
+		return false
+	}
+	return len(client.config.EvaluationHooks) == 0 || reflect.DeepEqual(value, flag.Variations[index])
+}
+
+// decodedVariationCache holds the results of decoding a flag's variations into Go types.
+type decodedVariationCache struct {
+	values map[decodedVariationKey]reflect.Value
+	lock   sync.RWMutex
+}
+
+type decodedVariationKey struct {
+	index int
+	typ   reflect.Type
+}
+
+// Decodes one of the flag's variations into a value of the given type, using the cache in the flag's
+// preprocessed data if there is one. The cache only holds values that copyDecodedValue can copy, and
+// the caller always gets a copy, so it cannot modify the cached value. Variations that cannot be
+// decoded are not cached, since that is an error condition that we do not need to optimize.
+func (f *FeatureFlag) decodeVariation(index int, typ reflect.Type) (reflect.Value, error) {
+	if index < 0 || index >= len(f.Variations) {
+		return reflect.Value{}, fmt.Errorf("invalid variation index %d for flag %s", index, f.Key)
+	}
+	var cache *decodedVariationCache
+	if f.preprocessed != nil {
+		cache = &f.preprocessed.decodedVariations
+	}
+	key := decodedVariationKey{index, typ}
+	if cache != nil {
+		cache.lock.RLock()
+		value, found := cache.values[key]
+		cache.lock.RUnlock()
+		if found {
+			return copyDecodedValue(value), nil
+		}
+	}
+	value, err := decodeValue(f.Variations[index], typ)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if cache != nil && canCopyDecodedType(typ, make(map[reflect.Type]bool)) {
+		cache.lock.Lock()
+		if cache.values == nil {
+			cache.values = make(map[decodedVariationKey]reflect.Value)
+		}
+		cache.values[key] = value
+		cache.lock.Unlock()
+		return copyDecodedValue(value), nil
+	}
+	return value, nil
+}
+
+// Decodes a flag value into a new value of the given type, in the same way as json.Unmarshal.
+func decodeValue(value interface{}, typ reflect.Type) (reflect.Value, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	ptr := reflect.New(typ)
+	if err := json.Unmarshal(data, ptr.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+	return ptr.Elem(), nil
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// Returns true if copyDecodedValue can make a complete copy of any value of this type that was
+// produced by json.Unmarshal. That is not the case if the type has its own unmarshaling logic, which
+// might store data in unexported fields, or if it embeds an unexported struct type, whose promoted
+// fields json.Unmarshal sets but reflection cannot.
+func canCopyDecodedType(typ reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[typ] {
+		return true
+	}
+	seen[typ] = true
+	ptrType := reflect.PtrTo(typ)
+	if ptrType.Implements(jsonUnmarshalerType) || ptrType.Implements(textUnmarshalerType) {
+		return false
+	}
+	switch typ.Kind() {
+	case reflect.Map:
+		return canCopyDecodedType(typ.Key(), seen) && canCopyDecodedType(typ.Elem(), seen)
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return canCopyDecodedType(typ.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if field.PkgPath != "" {
+				if field.Anonymous {
+					return false
+				}
+				continue // json.Unmarshal never sets other unexported fields
+			}
+			if !canCopyDecodedType(field.Type, seen) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Returns a deep copy of a value that was produced by json.Unmarshal, whose type has been checked
+// with canCopyDecodedType.
+func copyDecodedValue(value reflect.Value) reflect.Value {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			return value
+		}
+		ptr := reflect.New(value.Type().Elem())
+		ptr.Elem().Set(copyDecodedValue(value.Elem()))
+		return ptr
+	case reflect.Interface:
+		if value.IsNil() {
+			return value
+		}
+		result := reflect.New(value.Type()).Elem()
+		result.Set(copyDecodedValue(value.Elem()))
+		return result
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+		result := reflect.MakeMap(value.Type())
+		for _, k := range value.MapKeys() {
+			result.SetMapIndex(k, copyDecodedValue(value.MapIndex(k)))
+		}
+		return result
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+		result := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		for i := 0; i < value.Len(); i++ {
+			result.Index(i).Set(copyDecodedValue(value.Index(i)))
+		}
+		return result
+	case reflect.Array:
+		result := reflect.New(value.Type()).Elem()
+		for i := 0; i < value.Len(); i++ {
+			result.Index(i).Set(copyDecodedValue(value.Index(i)))
+		}
+		return result
+	case reflect.Struct:
+		result := reflect.New(value.Type()).Elem()
+		result.Set(value)
+		for i := 0; i < value.NumField(); i++ {
+			if field := result.Field(i); field.CanSet() {
+				field.Set(copyDecodedValue(value.Field(i)))
+			}
+		}
+		return result
+	}
+	return value
+}
@@ -0,0 +1,240 @@
+package ldclient
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type jsonVariationTestConfig struct {
+	Name    string   `json:"name"`
+	Limit   int      `json:"limit"`
+	Servers []string `json:"servers"`
+}
+
+func makeJSONVariationTestFlag() *FeatureFlag {
+	return makeTestFlag("jsonFlag", 1,
+		map[string]interface{}{"name": "a"},
+		map[string]interface{}{"name": "b", "limit": float64(3), "servers": []interface{}{"x", "y"}},
+		"not an object")
+}
+
+func TestJsonVariationIntoDecodesValue(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	flag := makeJSONVariationTestFlag()
+	client.store.Upsert(Features, flag)
+
+	var config jsonVariationTestConfig
+	err := client.JsonVariationInto("jsonFlag", evalTestUser, &config, nil)
+
+	require.NoError(t, err)
+	expected := jsonVariationTestConfig{Name: "b", Limit: 3, Servers: []string{"x", "y"}}
+	assert.Equal(t, expected, config)
+	assertEvalEvent(t, client, flag, evalTestUser, flag.Variations[1], 1, nil, nil)
+}
+
+func TestJsonVariationIntoDetailReturnsDecodedValueAndReason(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	flag := makeJSONVariationTestFlag()
+	client.store.Upsert(Features, flag)
+
+	var config jsonVariationTestConfig
+	detail, err := client.JsonVariationIntoDetail("jsonFlag", evalTestUser, &config, nil)
+
+	require.NoError(t, err)
+	assert.Equal(t, config, detail.Value)
+	assert.Equal(t, intPtr(1), detail.VariationIndex)
+	assert.Equal(t, evalReasonFallthroughInstance, detail.Reason)
+	assertEvalEvent(t, client, flag, evalTestUser, flag.Variations[1], 1, nil, detail.Reason)
+}
+
+func TestJsonVariationIntoReturnsDefaultForWrongType(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	flag := makeJSONVariationTestFlag()
+	flag.Fallthrough = VariationOrRollout{Variation: intPtr(2)}
+	client.store.Upsert(Features, flag)
+
+	defaultConfig := jsonVariationTestConfig{Name: "default"}
+	config := jsonVariationTestConfig{Name: "previous"}
+	detail, err := client.JsonVariationIntoDetail("jsonFlag", evalTestUser, &config, defaultConfig)
+
+	assert.Error(t, err)
+	assert.Equal(t, defaultConfig, config)
+	assert.Equal(t, defaultConfig, detail.Value)
+	assert.Nil(t, detail.VariationIndex)
+	assert.Equal(t, newEvalReasonError(EvalErrorWrongType), detail.Reason)
+	assertWrongTypeEvent(t, client, flag, defaultConfig)
+}
+
+func TestJsonVariationIntoReturnsCopyOfCachedValue(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	client.store.Upsert(Features, makeJSONVariationTestFlag())
+
+	var config1, config2 jsonVariationTestConfig
+	require.NoError(t, client.JsonVariationInto("jsonFlag", evalTestUser, &config1, nil))
+	config1.Servers[0] = "modified"
+	require.NoError(t, client.JsonVariationInto("jsonFlag", evalTestUser, &config2, nil))
+
+	assert.Equal(t, []string{"x", "y"}, config2.Servers)
+}
+
+func TestJsonVariationIntoDecodesValueSubstitutedByHook(t *testing.T) {
+	hooks := newRecordingHooks("a")
+	hooks[0].override = func(detail EvaluationDetail) EvaluationDetail {
+		detail.Value = map[string]interface{}{"name": "from hook"}
+		return detail
+	}
+	client := makeHookTestClient(func(c *Config) {}, hooks...)
+	defer client.Close()
+	client.store.Upsert(Features, makeJSONVariationTestFlag())
+
+	var config jsonVariationTestConfig
+	err := client.JsonVariationInto("jsonFlag", evalTestUser, &config, nil)
+
+	require.NoError(t, err)
+	assert.Equal(t, jsonVariationTestConfig{Name: "from hook"}, config)
+}
+
+func TestJsonVariationIntoReturnsDefaultForUnknownFlag(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+
+	defaultConfig := jsonVariationTestConfig{Name: "default"}
+	var config jsonVariationTestConfig
+	detail, err := client.JsonVariationIntoDetail("unknownFlag", evalTestUser, &config, defaultConfig)
+
+	assert.Error(t, err)
+	assert.Equal(t, defaultConfig, config)
+	assert.Equal(t, newEvalReasonError(EvalErrorFlagNotFound), detail.Reason)
+}
+
+func TestJsonVariationIntoUsesZeroValueForNilDefault(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+
+	config := jsonVariationTestConfig{Name: "previous"}
+	err := client.JsonVariationInto("unknownFlag", evalTestUser, &config, nil)
+
+	assert.Error(t, err)
+	assert.Equal(t, jsonVariationTestConfig{}, config)
+}
+
+func TestJsonVariationIntoRejectsInvalidParameters(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	client.store.Upsert(Features, makeJSONVariationTestFlag())
+
+	var config jsonVariationTestConfig
+	assert.Error(t, client.JsonVariationInto("jsonFlag", evalTestUser, config, nil))
+	assert.Error(t, client.JsonVariationInto("jsonFlag", evalTestUser, (*jsonVariationTestConfig)(nil), nil))
+	assert.Error(t, client.JsonVariationInto("jsonFlag", evalTestUser, &config, "wrong default type"))
+	assert.Empty(t, client.eventProcessor.(*testEventProcessor).events)
+}
+
+func TestDecodedVariationsAreCachedForPreprocessedFlag(t *testing.T) {
+	flag := makeJSONVariationTestFlag()
+	flag.preprocess()
+	configType := reflect.TypeOf(jsonVariationTestConfig{})
+	mapType := reflect.TypeOf(map[string]interface{}{})
+
+	value1, err := flag.decodeVariation(1, configType)
+	require.NoError(t, err)
+	value2, err := flag.decodeVariation(1, configType)
+	require.NoError(t, err)
+	assert.Equal(t, value1.Interface(), value2.Interface())
+	assert.Len(t, flag.preprocessed.decodedVariations.values, 1)
+
+	_, err = flag.decodeVariation(1, mapType)
+	require.NoError(t, err)
+	assert.Len(t, flag.preprocessed.decodedVariations.values, 2)
+
+	_, err = flag.decodeVariation(2, configType)
+	assert.Error(t, err)
+	assert.Len(t, flag.preprocessed.decodedVariations.values, 2)
+}
+
+func TestDecodedVariationsAreNotCachedForTypesWithCustomUnmarshaling(t *testing.T) {
+	flag := makeTestFlag("timeFlag", 1, "2019-01-02T03:04:05Z", map[string]interface{}{"T": "2019-01-02T03:04:05Z"})
+	flag.preprocess()
+	expectedTime := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	value, err := flag.decodeVariation(0, reflect.TypeOf(time.Time{}))
+	require.NoError(t, err)
+	assert.Equal(t, expectedTime, value.Interface())
+	value, err = flag.decodeVariation(1, reflect.TypeOf(struct{ T *time.Time }{}))
+	require.NoError(t, err)
+	assert.Equal(t, struct{ T *time.Time }{&expectedTime}, value.Interface())
+	assert.Len(t, flag.preprocessed.decodedVariations.values, 0)
+}
+
+func TestCopyDecodedValueMakesDeepCopy(t *testing.T) {
+	type inner struct {
+		Values []int
+	}
+	type outer struct {
+		Inner    *inner
+		Map      map[string]interface{}
+		Array    [1][]string
+		internal []int
+	}
+	original := outer{
+		Inner:    &inner{Values: []int{1}},
+		Map:      map[string]interface{}{"a": []interface{}{"b"}},
+		Array:    [1][]string{{"c"}},
+		internal: []int{2},
+	}
+	require.True(t, canCopyDecodedType(reflect.TypeOf(original), make(map[reflect.Type]bool)))
+
+	result := copyDecodedValue(reflect.ValueOf(original)).Interface().(outer)
+	assert.Equal(t, original, result)
+	result.Inner.Values[0] = 9
+	result.Map["a"].([]interface{})[0] = "z"
+	result.Array[0][0] = "z"
+	assert.Equal(t, 1, original.Inner.Values[0])
+	assert.Equal(t, "b", original.Map["a"].([]interface{})[0])
+	assert.Equal(t, "c", original.Array[0][0])
+}
+
+func TestDecodeVariationWorksForFlagThatWasNotPreprocessed(t *testing.T) {
+	flag := makeJSONVariationTestFlag()
+
+	value, err := flag.decodeVariation(0, reflect.TypeOf(jsonVariationTestConfig{}))
+	require.NoError(t, err)
+	assert.Equal(t, jsonVariationTestConfig{Name: "a"}, value.Interface())
+	assert.Nil(t, flag.preprocessed)
+}
+
+func BenchmarkJsonVariationThenUnmarshal(b *testing.B) {
+	client := makeTestClient()
+	defer client.Close()
+	client.store.Upsert(Features, makeJSONVariationTestFlag())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var config jsonVariationTestConfig
+		raw, _ := client.JsonVariation("jsonFlag", evalTestUser, nil)
+		if err := json.Unmarshal(raw, &config); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkJsonVariationInto(b *testing.B) {
+	client := makeTestClient()
+	defer client.Close()
+	client.store.Upsert(Features, makeJSONVariationTestFlag())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var config jsonVariationTestConfig
+		if err := client.JsonVariationInto("jsonFlag", evalTestUser, &config, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,12 +1,14 @@
 package ldclient
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"reflect"
@@ -21,14 +23,22 @@ const Version = "4.7.2"
 // Applications should instantiate a single instance for the lifetime
 // of their application.
 type LDClient struct {
-	sdkKey          string
-	config          Config
-	eventProcessor  EventProcessor
-	updateProcessor UpdateProcessor
-	store           FeatureStore
-}
-
-// Logger is a generic logger interface.
+	sdkKey                string
+	config                Config
+	eventProcessor        EventProcessor
+	updateProcessor       UpdateProcessor
+	store                 FeatureStore
+	flagChangeBroadcaster *flagChangeBroadcaster
+	flagValueTracker      *flagValueChangeTracker
+	dataSourceStatus      *dataSourceStatusManager
+	featureStoreStatus    *featureStoreStatusManager
+	tracer                Tracer
+	metrics               Metrics
+	logger                LeveledLogger
+}
+
+// Logger is a generic logger interface. The client writes to it through NewLeveledLoggerAdapter, with
+// the level at the start of each line, unless Config.LeveledLogger is set.
 type Logger interface {
 	Println(...interface{})
 	Printf(string, ...interface{})
@@ -62,6 +72,19 @@ type Config struct {
 	PollInterval time.Duration
 	// An object
 	Logger Logger
+	// An object that receives log messages with a level and structured fields. If set, it is used
+	// instead of Logger. See LeveledLogger.
+	LeveledLogger LeveledLogger
+	// The minimum level of log messages that are written to Logger or LeveledLogger. The default is
+	// LogLevelInfo; LogLevelDebug includes messages such as flag keys that were not found.
+	MinLogLevel LogLevel
+	// If greater than zero, a log message that has the same level and text as one that was written less
+	// than this long ago is dropped; the next one that is written has a "suppressed" field with the
+	// number that were dropped. This prevents a repeated condition, such as evaluating flags before the
+	// client is initialized, from flooding the log. Messages are compared only by their text, not by
+	// their fields, so messages about different flags or errors with the same text are also dropped.
+	// The default is zero, which turns this off. SDK keys are always redacted from log messages.
+	LogRateLimitInterval time.Duration
 	// The connection timeout to use when making polling requests to LaunchDarkly.
 	Timeout time.Duration
 	// Sets the implementation of FeatureStore for holding feature flags and related data received from
@@ -70,6 +93,36 @@ type Config struct {
 	// Sets whether streaming mode should be enabled. By default, streaming is enabled. It should only be
 	// disabled on the advice of LaunchDarkly support.
 	Stream bool
+	// The delay before the first attempt to reconnect after the streaming connection fails or is lost.
+	// Each consecutive failure doubles the delay, up to StreamMaxReconnectDelay, and a random jitter of
+	// up to half the delay is subtracted, so that many clients do not all reconnect at once. The delay
+	// starts over once a connection has stayed up for a minute. If zero, DefaultStreamInitialReconnectDelay
+	// is used.
+	StreamInitialReconnectDelay time.Duration
+	// The maximum delay between attempts to reconnect the streaming connection. If zero,
+	// DefaultStreamMaxReconnectDelay is used.
+	StreamMaxReconnectDelay time.Duration
+	// If no data is received on the streaming connection for this long, including the heartbeats that
+	// LaunchDarkly sends periodically, the connection is assumed to have failed silently (as can happen
+	// with some proxies), and it is closed and reconnected. If zero, DefaultStreamReadTimeout is used.
+	StreamReadTimeout time.Duration
+	// If greater than zero, the client switches from streaming to polling after this many consecutive
+	// failures to connect the stream, or to receive data from it. This is for networks that block the
+	// streaming connection. While polling, the client tries to connect the stream again every
+	// StreamFallbackProbeInterval, and switches back to streaming if it succeeds.
+	StreamFallbackFailures int
+	// If greater than zero, the client switches from streaming to polling if the stream has not been
+	// working for this long, either since the client started or since the connection was lost. This can
+	// be used with or without StreamFallbackFailures.
+	StreamFallbackTimeout time.Duration
+	// How often the client tries to connect the stream again after falling back to polling. If zero,
+	// DefaultStreamFallbackProbeInterval is used.
+	StreamFallbackProbeInterval time.Duration
+	// If greater than zero, while streaming the client also requests all flag data at this interval, and
+	// stores any flags or segments that are newer than the ones it has. This repairs data that was missed
+	// because a request made for a stream update failed. Unchanged data is not downloaded again. Values
+	// less than MinimumPollInterval will be set to MinimumPollInterval.
+	StreamReconciliationInterval time.Duration
 	// Sets whether this client should use the LaunchDarkly relay in daemon mode. In this mode, the client does
 	// not subscribe to the streaming or polling API, but reads data only from the feature store. See:
 	// https://docs.launchdarkly.com/docs/the-relay-proxy
@@ -104,10 +157,34 @@ type Config struct {
 	// The interval at which the event processor will reset its set of known user keys.
 	UserKeysFlushInterval time.Duration
 	UserAgent             string
-}
+	// Sets whether IntVariation and Int64Variation should reject a flag value that is not a whole number,
+	// or is out of range for the result type, returning the default value with an EvalErrorWrongType
+	// reason rather than truncating it.
+	StrictNumericVariations bool
+	// Hooks that are called before and after each feature flag evaluation, in the order in which they
+	// appear in the slice. See EvaluationHook.
+	EvaluationHooks []EvaluationHook
+	// An object that receives tracing spans for flag evaluations, feature store operations, and requests
+	// to LaunchDarkly. If nil, no tracing is done. See Tracer.
+	Tracer Tracer
+	// An object that records metrics for flag evaluations, analytics event delivery, the connection
+	// to LaunchDarkly, and the feature store cache. If nil, no metrics are recorded. See Metrics.
+	Metrics Metrics
+}
+
+// Default values for Config.StreamInitialReconnectDelay, Config.StreamMaxReconnectDelay, and
+// Config.StreamReadTimeout.
+const (
+	DefaultStreamInitialReconnectDelay = time.Second
+	DefaultStreamMaxReconnectDelay     = 30 * time.Second
+	DefaultStreamReadTimeout           = 5 * time.Minute
+)
+
+// DefaultStreamFallbackProbeInterval is the default value for Config.StreamFallbackProbeInterval.
+const DefaultStreamFallbackProbeInterval = 5 * time.Minute
 
-// MinimumPollInterval describes the minimum value for Config.PollInterval. If you specify a smaller interval,
-// the minimum will be used instead.
+// MinimumPollInterval describes the minimum value for Config.PollInterval and Config.StreamReconciliationInterval.
+// If you specify a smaller interval, the minimum will be used instead.
 const MinimumPollInterval = 30 * time.Second
 
 // UpdateProcessor describes the interface for an object that receives feature flag data.
@@ -140,22 +217,25 @@ func (n nullUpdateProcessor) Start(closeWhenReady chan<- struct{}) {
 //   var config = DefaultConfig
 //   config.Capacity = 2000
 var DefaultConfig = Config{
-	BaseUri:               "https://app.launchdarkly.com",
-	StreamUri:             "https://stream.launchdarkly.com",
-	EventsUri:             "https://events.launchdarkly.com",
-	Capacity:              10000,
-	FlushInterval:         5 * time.Second,
-	PollInterval:          MinimumPollInterval,
-	Logger:                log.New(os.Stderr, "[LaunchDarkly]", log.LstdFlags),
-	Timeout:               3000 * time.Millisecond,
-	Stream:                true,
-	FeatureStore:          nil,
-	UseLdd:                false,
-	SendEvents:            true,
-	Offline:               false,
-	UserKeysCapacity:      1000,
-	UserKeysFlushInterval: 5 * time.Minute,
-	UserAgent:             "",
+	BaseUri:                     "https://app.launchdarkly.com",
+	StreamUri:                   "https://stream.launchdarkly.com",
+	EventsUri:                   "https://events.launchdarkly.com",
+	Capacity:                    10000,
+	FlushInterval:               5 * time.Second,
+	PollInterval:                MinimumPollInterval,
+	Logger:                      log.New(os.Stderr, "[LaunchDarkly]", log.LstdFlags),
+	Timeout:                     3000 * time.Millisecond,
+	Stream:                      true,
+	FeatureStore:                nil,
+	UseLdd:                      false,
+	SendEvents:                  true,
+	Offline:                     false,
+	UserKeysCapacity:            1000,
+	UserKeysFlushInterval:       5 * time.Minute,
+	UserAgent:                   "",
+	StreamInitialReconnectDelay: DefaultStreamInitialReconnectDelay,
+	StreamMaxReconnectDelay:     DefaultStreamMaxReconnectDelay,
+	StreamReadTimeout:           DefaultStreamReadTimeout,
 }
 
 // Initialization errors
@@ -182,17 +262,40 @@ func MakeCustomClient(sdkKey string, config Config, waitFor time.Duration) (*LDC
 	if config.PollInterval < MinimumPollInterval {
 		config.PollInterval = MinimumPollInterval
 	}
+	if config.StreamReconciliationInterval > 0 && config.StreamReconciliationInterval < MinimumPollInterval {
+		config.StreamReconciliationInterval = MinimumPollInterval
+	}
 	config.UserAgent = strings.TrimSpace("GoClient/" + Version + " " + config.UserAgent)
+	// All of the client's components use this logger, so that they share the rate limiting.
+	logger := newLoggerForConfig(config, sdkKey)
+	config.LeveledLogger = logger
 
 	if config.FeatureStore == nil {
-		config.FeatureStore = NewInMemoryFeatureStore(config.Logger)
+		config.FeatureStore = newInMemoryFeatureStore(logger)
+	}
+	if r, ok := config.FeatureStore.(TracerReceiver); ok && config.Tracer != nil {
+		r.SetTracer(config.Tracer)
+	}
+	if r, ok := config.FeatureStore.(MetricsReceiver); ok && config.Metrics != nil {
+		r.SetMetrics(config.Metrics)
 	}
+	featureStoreStatus := newFeatureStoreStatusManager(config.FeatureStore)
+	// All updates from the UpdateProcessor go through this wrapper so that we can detect flag changes.
+	flagChangeBroadcaster := newFlagChangeBroadcaster()
+	config.FeatureStore = newChangeTrackingFeatureStore(config.FeatureStore, flagChangeBroadcaster)
 
 	client := LDClient{
-		sdkKey: sdkKey,
-		config: config,
-		store:  config.FeatureStore,
-	}
+		sdkKey:                sdkKey,
+		config:                config,
+		store:                 config.FeatureStore,
+		flagChangeBroadcaster: flagChangeBroadcaster,
+		dataSourceStatus:      newDataSourceStatusManager(),
+		featureStoreStatus:    featureStoreStatus,
+		tracer:                tracerOrDefault(config.Tracer),
+		metrics:               metricsOrDefault(config.Metrics),
+		logger:                logger,
+	}
+	client.flagValueTracker = newFlagValueChangeTracker(flagChangeBroadcaster, client.evaluateWithoutEvents)
 
 	if config.EventProcessor != nil {
 		client.eventProcessor = config.EventProcessor
@@ -215,6 +318,11 @@ func MakeCustomClient(sdkKey string, config Config, waitFor time.Duration) (*LDC
 			return nil, err
 		}
 	}
+	if p, ok := client.updateProcessor.(dataSourceStatusReportingUpdateProcessor); ok {
+		p.setDataSourceStatusManager(client.dataSourceStatus)
+	} else {
+		go client.dataSourceStatus.inferStatus(client.updateProcessor, closeWhenReady)
+	}
 	client.updateProcessor.Start(closeWhenReady)
 	timeout := time.After(waitFor)
 	for {
@@ -224,11 +332,11 @@ func MakeCustomClient(sdkKey string, config Config, waitFor time.Duration) (*LDC
 				return &client, ErrInitializationFailed
 			}
 
-			config.Logger.Println("Successfully initialized LaunchDarkly client!")
+			logger.Info("Successfully initialized LaunchDarkly client")
 			return &client, nil
 		case <-timeout:
 			if waitFor > 0 {
-				config.Logger.Println("Timeout exceeded when initializing LaunchDarkly client.")
+				logger.Warn("Timeout exceeded when initializing LaunchDarkly client")
 				return &client, ErrInitializationTimeout
 			}
 
@@ -240,18 +348,21 @@ func MakeCustomClient(sdkKey string, config Config, waitFor time.Duration) (*LDC
 
 func createDefaultUpdateProcessor(sdkKey string, config Config) (UpdateProcessor, error) {
 	if config.Offline {
-		config.Logger.Println("Started LaunchDarkly in offline mode")
+		loggerForConfig(config).Info("Started LaunchDarkly in offline mode")
 		return nullUpdateProcessor{}, nil
 	}
 	if config.UseLdd {
-		config.Logger.Println("Started LaunchDarkly in LDD mode")
+		loggerForConfig(config).Info("Started LaunchDarkly in LDD mode")
 		return nullUpdateProcessor{}, nil
 	}
 	requestor := newRequestor(sdkKey, config)
 	if config.Stream {
+		if config.StreamFallbackFailures > 0 || config.StreamFallbackTimeout > 0 {
+			return newStreamingWithPollingFallback(sdkKey, config, requestor), nil
+		}
 		return newStreamProcessor(sdkKey, config, requestor), nil
 	}
-	config.Logger.Println("You should only disable the streaming API if instructed to do so by LaunchDarkly support")
+	loggerForConfig(config).Warn("You should only disable the streaming API if instructed to do so by LaunchDarkly support")
 	return newPollingProcessor(config, requestor), nil
 }
 
@@ -261,7 +372,7 @@ func (client *LDClient) Identify(user User) error {
 		return nil
 	}
 	if user.Key == nil || *user.Key == "" {
-		client.config.Logger.Printf("WARN: Identify called with empty/nil user key!")
+		client.logger.Warn("Identify called with empty/nil user key")
 		return nil // Don't return an error value because we didn't in the past and it might confuse users
 	}
 	evt := NewIdentifyEvent(user)
@@ -276,7 +387,7 @@ func (client *LDClient) Track(key string, user User, data interface{}) error {
 		return nil
 	}
 	if user.Key == nil || *user.Key == "" {
-		client.config.Logger.Printf("WARN: Track called with empty/nil user key!")
+		client.logger.Warn("Track called with empty/nil user key")
 		return nil // Don't return an error value because we didn't in the past and it might confuse users
 	}
 	evt := NewCustomEvent(key, user, data)
@@ -307,19 +418,120 @@ func (client *LDClient) Initialized() bool {
 }
 
 // Close shuts down the LaunchDarkly client. After calling this, the LaunchDarkly client
-// should no longer be used.
+// should no longer be used. If the FeatureStore implements io.Closer, as the Redis, Consul, and
+// DynamoDB stores do, it is closed too.
 func (client *LDClient) Close() error {
-	client.config.Logger.Println("Closing LaunchDarkly Client")
-	if client.IsOffline() {
-		return nil
+	client.logger.Info("Closing LaunchDarkly client")
+	client.flagChangeBroadcaster.close()
+	client.flagValueTracker.close()
+	client.dataSourceStatus.close()
+	client.featureStoreStatus.close()
+	if !client.IsOffline() {
+		_ = client.eventProcessor.Close()
+		if !client.config.UseLdd {
+			_ = client.updateProcessor.Close()
+		}
 	}
-	_ = client.eventProcessor.Close()
-	if !client.config.UseLdd {
-		_ = client.updateProcessor.Close()
+	// The store is closed last, since the update processor may write to it until it is closed.
+	if closer, ok := client.store.(io.Closer); ok {
+		_ = closer.Close()
 	}
 	return nil
 }
 
+// GetDataSourceStatus returns the current status of the component that receives feature flag data
+// from LaunchDarkly. This allows you to tell whether the client has never connected, is connected and
+// receiving updates, or had connected but is now serving possibly stale data while it tries to recover.
+func (client *LDClient) GetDataSourceStatus() DataSourceStatus {
+	return client.dataSourceStatus.getStatus()
+}
+
+// AddDataSourceStatusListener returns a channel that will receive a DataSourceStatus whenever the
+// status of the data source changes, or a new error is reported. The channel is closed when the client
+// is closed, or when it is passed to RemoveDataSourceStatusListener. Sending to the channel never
+// holds up the data source; if the application falls behind in reading from it, only the latest
+// status that is waiting to be delivered is kept.
+//
+// If you are using a custom UpdateProcessor, the status is only changed to DataSourceStateValid or
+// DataSourceStateOff once the UpdateProcessor has finished starting up, depending on whether it has
+// been initialized.
+func (client *LDClient) AddDataSourceStatusListener() <-chan DataSourceStatus {
+	return client.dataSourceStatus.addListener()
+}
+
+// RemoveDataSourceStatusListener unsubscribes a channel that was returned by AddDataSourceStatusListener,
+// and closes it.
+func (client *LDClient) RemoveDataSourceStatusListener(ch <-chan DataSourceStatus) {
+	client.dataSourceStatus.removeListener(ch)
+}
+
+// GetFeatureStoreStatus returns the current status of the FeatureStore. This is only meaningful for a
+// store that is backed by a database, such as Redis; any store that does not implement
+// FeatureStoreStatusProvider is always reported as available.
+func (client *LDClient) GetFeatureStoreStatus() FeatureStoreStatus {
+	return client.featureStoreStatus.getStatus()
+}
+
+// AddFeatureStoreStatusListener returns a channel that will receive a FeatureStoreStatus whenever the
+// FeatureStore becomes unavailable or available again. The channel is closed when the client is closed,
+// or when it is passed to RemoveFeatureStoreStatusListener.
+func (client *LDClient) AddFeatureStoreStatusListener() <-chan FeatureStoreStatus {
+	return client.featureStoreStatus.addListener()
+}
+
+// RemoveFeatureStoreStatusListener unsubscribes a channel that was returned by
+// AddFeatureStoreStatusListener, and closes it.
+func (client *LDClient) RemoveFeatureStoreStatusListener(ch <-chan FeatureStoreStatus) {
+	client.featureStoreStatus.removeListener(ch)
+}
+
+// AddFlagChangeListener returns a channel that will receive a FlagChangeEvent whenever the
+// configuration of any feature flag changes. This includes flags that change indirectly, because a
+// prerequisite flag or a user segment that they reference has changed. Changes are detected whenever
+// new data is written to the FeatureStore, regardless of what kind of UpdateProcessor is being used.
+//
+// Sending to the channel never holds up updates to the FeatureStore. If the application falls behind
+// in reading from it, events are queued for that listener, and an event for a flag that is already
+// waiting to be delivered is not queued again. To stop receiving events, call RemoveFlagChangeListener.
+// The channel is closed when the client is closed.
+func (client *LDClient) AddFlagChangeListener() <-chan FlagChangeEvent {
+	return client.flagChangeBroadcaster.addListener("", true)
+}
+
+// AddFlagChangeListenerForKey is the same as AddFlagChangeListener, except that the channel will
+// only receive events for the feature flag with the specified key.
+func (client *LDClient) AddFlagChangeListenerForKey(key string) <-chan FlagChangeEvent {
+	return client.flagChangeBroadcaster.addListener(key, false)
+}
+
+// RemoveFlagChangeListener unregisters a channel that was returned by AddFlagChangeListener or
+// AddFlagChangeListenerForKey, and closes it.
+func (client *LDClient) RemoveFlagChangeListener(ch <-chan FlagChangeEvent) {
+	client.flagChangeBroadcaster.removeListener(ch)
+}
+
+// AddFlagValueChangeListener returns a channel that will receive a FlagValueChangeEvent whenever the
+// value of the specified feature flag changes for the specified user. The flag is evaluated when the
+// listener is registered, and re-evaluated whenever the flag or anything it depends on (prerequisite
+// flags or user segments) changes; an event is sent only if the resulting value is different from the
+// previous one. The defaultVal parameter is used in the same way as for the Variation methods, if the
+// flag cannot be evaluated. These evaluations do not generate analytics events.
+//
+// Sending to the channel never holds up updates to the FeatureStore or other listeners. If the
+// application falls behind in reading from it, changes that are waiting to be delivered are combined
+// into one event, whose OldDetail is the result before the first of those changes and whose NewDetail
+// is the latest result; if the value has changed back in the meantime, these may have the same value.
+// To stop receiving events, call RemoveFlagValueChangeListener.
+func (client *LDClient) AddFlagValueChangeListener(key string, user User, defaultVal interface{}) <-chan FlagValueChangeEvent {
+	return client.flagValueTracker.addListener(key, user, defaultVal)
+}
+
+// RemoveFlagValueChangeListener unregisters a channel that was returned by AddFlagValueChangeListener,
+// and closes it.
+func (client *LDClient) RemoveFlagValueChangeListener(ch <-chan FlagValueChangeEvent) {
+	client.flagValueTracker.removeListener(ch)
+}
+
 // Flush immediately flushes queued events.
 func (client *LDClient) Flush() {
 	client.eventProcessor.Flush()
@@ -345,18 +557,20 @@ func (client *LDClient) AllFlags(user User) map[string]interface{} {
 // The most common use case for this method is to bootstrap a set of client-side feature flags
 // from a back-end service.
 func (client *LDClient) AllFlagsState(user User, options ...FlagsStateOption) FeatureFlagsState {
+	ctx, span := client.tracer.StartSpan(context.Background(), SpanNameAllFlags)
+	defer span.End()
 	valid := true
 	if client.IsOffline() {
-		client.config.Logger.Println("WARN: Called AllFlagsState in offline mode. Returning empty state")
+		client.logger.Warn("Called AllFlagsState in offline mode; returning empty state")
 		valid = false
 	} else if user.Key == nil {
-		client.config.Logger.Println("WARN: Called AllFlagsState with nil user key. Returning empty state")
+		client.logger.Warn("Called AllFlagsState with nil user key; returning empty state")
 		valid = false
 	} else if !client.Initialized() {
 		if client.store.Initialized() {
-			client.config.Logger.Println("WARN: Called AllFlagsState before client initialization; using last known values from feature store")
+			client.logger.Warn("Called AllFlagsState before client initialization; using last known values from feature store")
 		} else {
-			client.config.Logger.Println("WARN: Called AllFlagsState before client initialization. Feature store not available; returning empty state")
+			client.logger.Warn("Called AllFlagsState before client initialization; feature store not available, returning empty state")
 			valid = false
 		}
 	}
@@ -365,9 +579,11 @@ func (client *LDClient) AllFlagsState(user User, options ...FlagsStateOption) Fe
 		return FeatureFlagsState{valid: false}
 	}
 
-	items, err := client.store.All(Features)
+	store := featureStoreWithContext(ctx, client.storeForEvaluation())
+	items, err := store.All(Features)
 	if err != nil {
-		client.config.Logger.Println("WARN: Unable to fetch flags from feature store. Returning empty state. Error: " + err.Error())
+		client.logger.Warn("Unable to fetch flags from feature store; returning empty state", "error", err)
+		span.RecordError(err)
 		return FeatureFlagsState{valid: false}
 	}
 
@@ -380,7 +596,11 @@ func (client *LDClient) AllFlagsState(user User, options ...FlagsStateOption) Fe
 			if clientSideOnly && !flag.ClientSide {
 				continue
 			}
-			result, _ := flag.EvaluateDetail(user, client.store, false)
+			result, _, _ := client.evaluateWithHooks(ctx, EvaluationHookParams{FlagKey: flag.Key, User: user},
+				func() (EvaluationDetail, *FeatureFlag, error) {
+					result, _ := flag.evaluateDetail(user, store, false, &evaluationState{logger: client.logger})
+					return result, flag, nil
+				})
 			var reason EvaluationReason
 			if withReasons {
 				reason = result.Reason
@@ -388,6 +608,7 @@ func (client *LDClient) AllFlagsState(user User, options ...FlagsStateOption) Fe
 			state.addFlag(flag, result.Value, result.VariationIndex, reason, detailsOnlyIfTracked)
 		}
 	}
+	span.SetAttribute(SpanAttrFlagCount, len(state.flagValues))
 
 	return state
 }
@@ -396,88 +617,155 @@ func (client *LDClient) AllFlagsState(user User, options ...FlagsStateOption) Fe
 // there is an error, if the flag doesn't exist, the client hasn't completed initialization,
 // or the feature is turned off and has no off variation.
 func (client *LDClient) BoolVariation(key string, user User, defaultVal bool) (bool, error) {
-	detail, err := client.variationWithType(key, user, defaultVal, reflect.TypeOf(true), false)
-	result, _ := detail.Value.(bool)
-	return result, err
+	return client.BoolVariationCtx(context.Background(), key, user, defaultVal)
 }
 
 // BoolVariationDetail is the same as BoolVariation, but also returns further information about how
 // the value was calculated. The "reason" data will also be included in analytics events.
 func (client *LDClient) BoolVariationDetail(key string, user User, defaultVal bool) (bool, EvaluationDetail, error) {
-	detail, err := client.variationWithType(key, user, defaultVal, reflect.TypeOf(true), true)
-	result, _ := detail.Value.(bool)
-	return result, detail, err
+	return client.BoolVariationDetailCtx(context.Background(), key, user, defaultVal)
 }
 
 // IntVariation returns the value of a feature flag (whose variations are integers) for the given user.
 // Returns defaultVal if there is an error, if the flag doesn't exist, or the feature is turned off and
-// has no off variation.
+// has no off variation. A value with a fractional part is truncated, unless Config.StrictNumericVariations
+// is set.
 func (client *LDClient) IntVariation(key string, user User, defaultVal int) (int, error) {
-	detail, err := client.variationWithType(key, user, float64(defaultVal), reflect.TypeOf(float64(0)), false)
-	result, _ := detail.Value.(float64)
-	return int(result), err
+	return client.IntVariationCtx(context.Background(), key, user, defaultVal)
 }
 
 // IntVariationDetail is the same as IntVariation, but also returns further information about how
 // the value was calculated. The "reason" data will also be included in analytics events.
 func (client *LDClient) IntVariationDetail(key string, user User, defaultVal int) (int, EvaluationDetail, error) {
-	detail, err := client.variationWithType(key, user, float64(defaultVal), reflect.TypeOf(float64(0)), true)
-	result, _ := detail.Value.(float64)
-	return int(result), detail, err
+	return client.IntVariationDetailCtx(context.Background(), key, user, defaultVal)
 }
 
 // Float64Variation returns the value of a feature flag (whose variations are floats) for the given user.
 // Returns defaultVal if there is an error, if the flag doesn't exist, or the feature is turned off and
 // has no off variation.
 func (client *LDClient) Float64Variation(key string, user User, defaultVal float64) (float64, error) {
-	detail, err := client.variationWithType(key, user, defaultVal, reflect.TypeOf(float64(0)), false)
-	result, _ := detail.Value.(float64)
-	return result, err
+	return client.Float64VariationCtx(context.Background(), key, user, defaultVal)
 }
 
 // Float64VariationDetail is the same as Float64Variation, but also returns further information about how
 // the value was calculated. The "reason" data will also be included in analytics events.
 func (client *LDClient) Float64VariationDetail(key string, user User, defaultVal float64) (float64, EvaluationDetail, error) {
-	detail, err := client.variationWithType(key, user, defaultVal, reflect.TypeOf(float64(0)), true)
-	result, _ := detail.Value.(float64)
-	return result, detail, err
+	return client.Float64VariationDetailCtx(context.Background(), key, user, defaultVal)
 }
 
 // StringVariation returns the value of a feature flag (whose variations are strings) for the given user.
 // Returns defaultVal if there is an error, if the flag doesn't exist, or the feature is turned off and has
 // no off variation.
 func (client *LDClient) StringVariation(key string, user User, defaultVal string) (string, error) {
-	detail, err := client.variationWithType(key, user, defaultVal, reflect.TypeOf(string("string")), false)
-	result, _ := detail.Value.(string)
-	return result, err
+	return client.StringVariationCtx(context.Background(), key, user, defaultVal)
 }
 
 // StringVariationDetail is the same as StringVariation, but also returns further information about how
 // the value was calculated. The "reason" data will also be included in analytics events.
 func (client *LDClient) StringVariationDetail(key string, user User, defaultVal string) (string, EvaluationDetail, error) {
-	detail, err := client.variationWithType(key, user, defaultVal, reflect.TypeOf(string("string")), true)
-	result, _ := detail.Value.(string)
-	return result, detail, err
+	return client.StringVariationDetailCtx(context.Background(), key, user, defaultVal)
 }
 
 // JsonVariation returns the value of a feature flag (whose variations are JSON) for the given user.
 // Returns defaultVal if there is an error, if the flag doesn't exist, or the feature is turned off.
 func (client *LDClient) JsonVariation(key string, user User, defaultVal json.RawMessage) (json.RawMessage, error) {
-	detail, err := client.variation(key, user, defaultVal, false)
-	if err != nil {
-		return defaultVal, err
-	}
-	valueJSONRawMessage, err := ToJsonRawMessage(detail.Value)
-	if err != nil {
-		return defaultVal, err
-	}
-	return valueJSONRawMessage, nil
+	return client.JsonVariationCtx(context.Background(), key, user, defaultVal)
 }
 
 // JsonVariationDetail is the same as JsonVariation, but also returns further information about how
 // the value was calculated. The "reason" data will also be included in analytics events.
 func (client *LDClient) JsonVariationDetail(key string, user User, defaultVal json.RawMessage) (json.RawMessage, EvaluationDetail, error) {
-	detail, err := client.variation(key, user, defaultVal, true)
+	return client.JsonVariationDetailCtx(context.Background(), key, user, defaultVal)
+}
+
+// The ...Ctx variation methods take a context.Context whose deadline and cancellation apply to the
+// feature store queries made during the evaluation. This is mainly useful with a database-backed
+// feature store, so that a slow database cannot hold up a request handler past its own deadline;
+// see FeatureStoreWithContext. If the context is done before the evaluation completes, they return
+// the default value with an EvaluationReason of ERROR(EXCEPTION), and the context's error.
+
+// BoolVariationCtx is the same as BoolVariation, but uses a context for feature store queries.
+func (client *LDClient) BoolVariationCtx(ctx context.Context, key string, user User, defaultVal bool) (bool, error) {
+	detail, err := client.variationWithType(ctx, key, user, defaultVal, reflect.TypeOf(true), false)
+	result, _ := detail.Value.(bool)
+	return result, err
+}
+
+// BoolVariationDetailCtx is the same as BoolVariationDetail, but uses a context for feature store
+// queries.
+func (client *LDClient) BoolVariationDetailCtx(ctx context.Context, key string, user User, defaultVal bool) (bool, EvaluationDetail, error) {
+	detail, err := client.variationWithType(ctx, key, user, defaultVal, reflect.TypeOf(true), true)
+	result, _ := detail.Value.(bool)
+	return result, detail, err
+}
+
+// IntVariationCtx is the same as IntVariation, but uses a context for feature store queries.
+func (client *LDClient) IntVariationCtx(ctx context.Context, key string, user User, defaultVal int) (int, error) {
+	result, _, err := client.intVariation(ctx, key, user, defaultVal, false)
+	return result, err
+}
+
+// IntVariationDetailCtx is the same as IntVariationDetail, but uses a context for feature store
+// queries.
+func (client *LDClient) IntVariationDetailCtx(ctx context.Context, key string, user User, defaultVal int) (int, EvaluationDetail, error) {
+	return client.intVariation(ctx, key, user, defaultVal, true)
+}
+
+func (client *LDClient) intVariation(ctx context.Context, key string, user User, defaultVal int, sendReasonsInEvents bool) (int, EvaluationDetail, error) {
+	if client.config.StrictNumericVariations {
+		detail, _, err := client.convertedVariation(ctx, key, user, float64(defaultVal), valueConverter(intConverter).withFlag(), sendReasonsInEvents)
+		result, _ := detail.Value.(float64)
+		return int(result), detail, err
+	}
+	detail, err := client.variationWithType(ctx, key, user, float64(defaultVal), reflect.TypeOf(float64(0)), sendReasonsInEvents)
+	result, _ := detail.Value.(float64)
+	return int(result), detail, err
+}
+
+// Float64VariationCtx is the same as Float64Variation, but uses a context for feature store queries.
+func (client *LDClient) Float64VariationCtx(ctx context.Context, key string, user User, defaultVal float64) (float64, error) {
+	detail, err := client.variationWithType(ctx, key, user, defaultVal, reflect.TypeOf(float64(0)), false)
+	result, _ := detail.Value.(float64)
+	return result, err
+}
+
+// Float64VariationDetailCtx is the same as Float64VariationDetail, but uses a context for feature
+// store queries.
+func (client *LDClient) Float64VariationDetailCtx(ctx context.Context, key string, user User, defaultVal float64) (float64, EvaluationDetail, error) {
+	detail, err := client.variationWithType(ctx, key, user, defaultVal, reflect.TypeOf(float64(0)), true)
+	result, _ := detail.Value.(float64)
+	return result, detail, err
+}
+
+// StringVariationCtx is the same as StringVariation, but uses a context for feature store queries.
+func (client *LDClient) StringVariationCtx(ctx context.Context, key string, user User, defaultVal string) (string, error) {
+	detail, err := client.variationWithType(ctx, key, user, defaultVal, reflect.TypeOf(string("string")), false)
+	result, _ := detail.Value.(string)
+	return result, err
+}
+
+// StringVariationDetailCtx is the same as StringVariationDetail, but uses a context for feature store
+// queries.
+func (client *LDClient) StringVariationDetailCtx(ctx context.Context, key string, user User, defaultVal string) (string, EvaluationDetail, error) {
+	detail, err := client.variationWithType(ctx, key, user, defaultVal, reflect.TypeOf(string("string")), true)
+	result, _ := detail.Value.(string)
+	return result, detail, err
+}
+
+// JsonVariationCtx is the same as JsonVariation, but uses a context for feature store queries.
+func (client *LDClient) JsonVariationCtx(ctx context.Context, key string, user User, defaultVal json.RawMessage) (json.RawMessage, error) {
+	value, _, err := client.jsonVariation(ctx, key, user, defaultVal, false)
+	return value, err
+}
+
+// JsonVariationDetailCtx is the same as JsonVariationDetail, but uses a context for feature store
+// queries.
+func (client *LDClient) JsonVariationDetailCtx(ctx context.Context, key string, user User, defaultVal json.RawMessage) (json.RawMessage, EvaluationDetail, error) {
+	return client.jsonVariation(ctx, key, user, defaultVal, true)
+}
+
+func (client *LDClient) jsonVariation(ctx context.Context, key string, user User, defaultVal json.RawMessage, sendReasonsInEvents bool) (json.RawMessage, EvaluationDetail, error) {
+	detail, _, err := client.variation(ctx, key, user, defaultVal, sendReasonsInEvents)
 	if err != nil {
 		return defaultVal, detail, err
 	}
@@ -486,13 +774,14 @@ func (client *LDClient) JsonVariationDetail(key string, user User, defaultVal js
 		detail.Value = defaultVal
 		return defaultVal, detail, err
 	}
+	detail.Value = deepCopyValue(detail.Value)
 	return valueJSONRawMessage, detail, nil
 }
 
 // Generic method for evaluating a feature flag for a given user. The type of the returned interface{}
 // will always be expectedType or the actual defaultValue will be returned.
-func (client *LDClient) variationWithType(key string, user User, defaultVal interface{}, expectedType reflect.Type, sendReasonsInEvents bool) (EvaluationDetail, error) {
-	result, err := client.variation(key, user, defaultVal, sendReasonsInEvents)
+func (client *LDClient) variationWithType(ctx context.Context, key string, user User, defaultVal interface{}, expectedType reflect.Type, sendReasonsInEvents bool) (EvaluationDetail, error) {
+	result, _, err := client.variation(ctx, key, user, defaultVal, sendReasonsInEvents)
 	if err != nil && result.Value != nil {
 		valueType := reflect.TypeOf(result.Value)
 		if expectedType != valueType {
@@ -501,40 +790,97 @@ func (client *LDClient) variationWithType(key string, user User, defaultVal inte
 			result.Reason = newEvalReasonError(EvalErrorWrongType)
 		}
 	}
+	result.Value = deepCopyValue(result.Value) // in case it is not a simple value after all
 	return result, err
 }
 
-// Generic method for evaluating a feature flag for a given user.
-func (client *LDClient) variation(key string, user User, defaultVal interface{}, sendReasonsInEvents bool) (EvaluationDetail, error) {
-	if client.IsOffline() {
-		return EvaluationDetail{Value: defaultVal, Reason: newEvalReasonError(EvalErrorClientNotReady)}, nil
-	}
-	result, flag, err := client.evaluateInternal(key, user, defaultVal, sendReasonsInEvents)
-	if err != nil {
-		result.Value = defaultVal
-		result.VariationIndex = nil
+// Generic method for evaluating a feature flag for a given user. The flag is nil if it was not found.
+func (client *LDClient) variation(ctx context.Context, key string, user User, defaultVal interface{}, sendReasonsInEvents bool) (EvaluationDetail, *FeatureFlag, error) {
+	return client.convertedVariation(ctx, key, user, defaultVal, nil, sendReasonsInEvents)
+}
+
+// Same as variation, but if convert is non-nil, it is used to convert the flag's value to the type that
+// the caller wants. If the conversion fails, the result is the default value with an ERROR(WRONG_TYPE)
+// reason, and the analytics event reflects that. The event always contains the flag's original value,
+// since a converted value may not have a meaningful JSON representation. Evaluation hooks see the
+// result before it is converted, so a value that a hook substitutes is converted in the same way.
+func (client *LDClient) convertedVariation(ctx context.Context, key string, user User, defaultVal interface{},
+	convert flagValueConverter, sendReasonsInEvents bool) (EvaluationDetail, *FeatureFlag, error) {
+	ctx, span := client.tracer.StartSpan(ctx, SpanNameEvaluation)
+	defer span.End()
+	span.SetAttribute(SpanAttrFlagKey, key)
+	params := EvaluationHookParams{FlagKey: key, User: user, Default: defaultVal}
+	result, flag, err := client.evaluateWithHooks(ctx, params, func() (EvaluationDetail, *FeatureFlag, error) {
+		if client.IsOffline() {
+			return EvaluationDetail{Value: defaultVal, Reason: newEvalReasonError(EvalErrorClientNotReady)}, nil, nil
+		}
+		result, flag, err := client.evaluateInternal(ctx, key, user, defaultVal, sendReasonsInEvents, nil)
+		if err != nil {
+			result.Value = defaultVal
+			result.VariationIndex = nil
+		}
+		return result, flag, err
+	})
+	var converted interface{}
+	if err == nil && convert != nil && result.VariationIndex != nil {
+		var convertErr error
+		if converted, convertErr = convert(flag, *result.VariationIndex, result.Value); convertErr != nil {
+			result = EvaluationDetail{Value: defaultVal, Reason: newEvalReasonError(EvalErrorWrongType)}
+			err = fmt.Errorf("value of feature flag %s cannot be used: %s. Returning default value", key, convertErr)
+		}
 	}
 
-	evt := NewFeatureRequestEvent(key, flag, user, result.VariationIndex, result.Value, defaultVal, nil)
-	if sendReasonsInEvents {
-		evt.Reason.Reason = result.Reason
+	setEvaluationSpanAttributes(span, result, err)
+	recordEvaluationMetric(client.metrics, key, result)
+
+	if !client.IsOffline() {
+		evt := NewFeatureRequestEvent(key, flag, user, result.VariationIndex, result.Value, defaultVal, nil)
+		if sendReasonsInEvents {
+			evt.Reason.Reason = result.Reason
+		}
+		evt.userNoticed = evaluationMemoFromContext(ctx).userAlreadyNoticed()
+		client.eventProcessor.SendEvent(evt)
 	}
-	client.eventProcessor.SendEvent(evt)
 
-	return result, err
+	if err == nil && converted != nil {
+		result.Value = converted
+	}
+	return result, flag, err
 }
 
 // Evaluate returns the value of a feature for a specified user
 func (client *LDClient) Evaluate(key string, user User, defaultVal interface{}) (interface{}, *int, error) {
-	result, _, err := client.evaluateInternal(key, user, defaultVal, false)
-	return result.Value, result.VariationIndex, err
+	result, _, err := client.evaluateInternal(context.Background(), key, user, defaultVal, false, nil)
+	return deepCopyValue(result.Value), result.VariationIndex, err
+}
+
+// TraceVariation evaluates a feature flag for a user and returns the result along with a trace of
+// every step of the evaluation: which targets, rules, clauses, segments, and prerequisites were
+// checked, what the user's attribute values were, and the bucket value for any percentage rollout.
+// This is meant for troubleshooting, such as finding out why a user did not receive a particular
+// variation; it is slower than a normal evaluation.
+//
+// Like Evaluate, this does not send an analytics event for the flag itself. The trace is nil if the
+// flag could not be evaluated at all, in which case the error is also returned.
+func (client *LDClient) TraceVariation(key string, user User, defaultVal interface{}) (EvaluationDetail, *EvaluationTrace, error) {
+	trace := &EvaluationTrace{}
+	result, flag, err := client.evaluateInternal(context.Background(), key, user, defaultVal, false, trace)
+	result.Value = deepCopyValue(result.Value)
+	if flag == nil || err != nil {
+		return result, nil, err
+	}
+	return result, trace, nil
 }
 
 // Performs all the steps of evaluation except for sending the feature request event (the main one;
 // events for prerequisites will be sent).
-func (client *LDClient) evaluateInternal(key string, user User, defaultVal interface{}, sendReasonsInEvents bool) (EvaluationDetail, *FeatureFlag, error) {
-	if user.Key != nil && *user.Key == "" {
-		client.config.Logger.Printf("WARN: User.Key is blank when evaluating flag: %s. Flag evaluation will proceed, but the user will not be stored in LaunchDarkly.", key)
+// If trace is non-nil, the steps of the evaluation are recorded in it. Feature store queries use ctx.
+// If ctx comes from a UserEvaluator, the result may be taken from, or saved in, its memo.
+func (client *LDClient) evaluateInternal(ctx context.Context, key string, user User, defaultVal interface{}, sendReasonsInEvents bool,
+	trace *EvaluationTrace) (EvaluationDetail, *FeatureFlag, error) {
+	memo := evaluationMemoFromContext(ctx) // if there is one, a UserEvaluator has already checked the user
+	if memo == nil && user.Key != nil && *user.Key == "" {
+		client.logger.Warn("User.Key is blank when evaluating flag; flag evaluation will proceed, but the user will not be stored in LaunchDarkly", "flag", key)
 	}
 
 	var feature *FeatureFlag
@@ -543,17 +889,38 @@ func (client *LDClient) evaluateInternal(key string, user User, defaultVal inter
 
 	if !client.Initialized() {
 		if client.store.Initialized() {
-			client.config.Logger.Printf("WARN: Feature flag evaluation called before LaunchDarkly client initialization completed; using last known values from feature store")
+			client.logger.Warn("Feature flag evaluation called before LaunchDarkly client initialization completed; using last known values from feature store")
 		} else {
 			detail := EvaluationDetail{Value: defaultVal, Reason: newEvalReasonError(EvalErrorClientNotReady)}
 			return detail, nil, ErrClientNotInitialized
 		}
 	}
 
-	data, storeErr := client.store.Get(Features, key)
+	// The generation must be read before the store, so that if the data changes in between, the result
+	// will be treated as stale.
+	generation, canMemoize := client.storeGeneration()
+	canMemoize = canMemoize && memo != nil && trace == nil
+	if canMemoize {
+		if m, found := memo.get(key, generation); found {
+			detail := m.detail
+			prereqEvents := make([]FeatureRequestEvent, len(m.prereqEvents))
+			for i, event := range m.prereqEvents {
+				event.CreationDate = now()
+				prereqEvents[i] = event
+			}
+			return client.completeEvaluation(memo, detail, prereqEvents, defaultVal, sendReasonsInEvents), m.flag, nil
+		}
+	}
+
+	store := featureStoreWithContext(ctx, client.storeForEvaluation())
+	data, storeErr := store.Get(Features, key)
 
 	if storeErr != nil {
-		client.config.Logger.Printf("Encountered error fetching feature from store: %+v", storeErr)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			detail := EvaluationDetail{Value: defaultVal, Reason: newEvalReasonError(EvalErrorException)}
+			return detail, nil, ctxErr
+		}
+		client.logger.Error("Encountered error fetching feature from store", "flag", key, "error", storeErr)
 		detail := EvaluationDetail{Value: defaultVal, Reason: newEvalReasonError(EvalErrorException)}
 		return detail, nil, storeErr
 	}
@@ -569,17 +936,85 @@ func (client *LDClient) evaluateInternal(key string, user User, defaultVal inter
 		return detail, nil, fmt.Errorf("unknown feature key: %s Verify that this feature key exists. Returning default value", key)
 	}
 
-	if user.Key == nil {
+	if memo != nil && memo.userErr != nil {
+		detail := EvaluationDetail{Value: defaultVal, Reason: newEvalReasonError(EvalErrorUserNotSpecified)}
+		return detail, feature, memo.userErr
+	}
+	if memo == nil && user.Key == nil {
 		detail := EvaluationDetail{Value: defaultVal, Reason: newEvalReasonError(EvalErrorUserNotSpecified)}
 		return detail, feature, fmt.Errorf("user.Key cannot be nil for user: %+v when evaluating flag: %s", user, key)
 	}
 
-	detail, prereqEvents := feature.EvaluateDetail(user, client.store, sendReasonsInEvents)
+	// A memoized result may be used later with or without reasons, so the prerequisite events always
+	// include them; completeEvaluation removes them if they are not wanted.
+	detail, prereqEvents := feature.evaluateDetail(user, store, sendReasonsInEvents || canMemoize,
+		&evaluationState{logger: client.logger, trace: trace})
+	if canMemoize && ctx.Err() == nil {
+		memo.put(key, generation, memoizedEvaluation{detail: detail, flag: feature, prereqEvents: prereqEvents})
+	}
+	detail = client.completeEvaluation(memo, detail, prereqEvents, defaultVal, sendReasonsInEvents)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		// A query for a prerequisite or segment may have failed because the context was done, in which
+		// case the result cannot be trusted.
+		return EvaluationDetail{Value: defaultVal, Reason: newEvalReasonError(EvalErrorException)}, feature, ctxErr
+	}
+	return detail, feature, nil
+}
+
+// Substitutes the default value if the flag evaluated to one, and sends the events for prerequisites.
+func (client *LDClient) completeEvaluation(memo *evaluationMemo, detail EvaluationDetail, prereqEvents []FeatureRequestEvent,
+	defaultVal interface{}, sendReasonsInEvents bool) EvaluationDetail {
 	if detail.IsDefaultValue() {
 		detail.Value = defaultVal
 	}
 	for _, event := range prereqEvents {
+		if !sendReasonsInEvents {
+			event.Reason.Reason = nil
+		}
+		event.userNoticed = memo.userAlreadyNoticed()
 		client.eventProcessor.SendEvent(event)
 	}
-	return detail, feature, nil
+	return detail
+}
+
+// Returns a number that changes whenever the contents of the feature store change, or false if such
+// changes cannot be detected. That is the case in LDD mode, where the store is updated by another
+// process.
+func (client *LDClient) storeGeneration() (uint64, bool) {
+	if s, ok := client.store.(*changeTrackingFeatureStore); ok && !client.config.UseLdd {
+		return s.currentGeneration(), true
+	}
+	return 0, false
+}
+
+// Evaluates a flag using the current contents of the feature store, without generating any analytics
+// events. This is used for flag value change notifications.
+func (client *LDClient) evaluateWithoutEvents(key string, user User, defaultVal interface{}) EvaluationDetail {
+	store := client.storeForEvaluation()
+	data, err := store.Get(Features, key)
+	if err != nil {
+		return EvaluationDetail{Value: defaultVal, Reason: newEvalReasonError(EvalErrorException)}
+	}
+	feature, ok := data.(*FeatureFlag)
+	if !ok {
+		return EvaluationDetail{Value: defaultVal, Reason: newEvalReasonError(EvalErrorFlagNotFound)}
+	}
+	if user.Key == nil {
+		return EvaluationDetail{Value: defaultVal, Reason: newEvalReasonError(EvalErrorUserNotSpecified)}
+	}
+	detail, _ := feature.evaluateDetail(user, store, false, &evaluationState{logger: client.logger})
+	if detail.IsDefaultValue() {
+		detail.Value = defaultVal
+	}
+	detail.Value = deepCopyValue(detail.Value) // it will be passed to listeners
+	return detail
+}
+
+// Returns the store that should be used for one evaluation, or for a set of evaluations that should
+// all see the same data. This is a snapshot if the feature store supports it.
+func (client *LDClient) storeForEvaluation() FeatureStore {
+	if p, ok := client.store.(FeatureStoreSnapshotProvider); ok {
+		return p.Snapshot()
+	}
+	return client.store
 }
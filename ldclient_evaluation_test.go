@@ -1,11 +1,15 @@
 package ldclient
 
 import (
+	"context"
 	"encoding/json"
+	"io/ioutil"
+	"log"
 	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var evalTestUser = NewUser("userkey")
@@ -604,3 +608,131 @@ func TestAllFlagsStateReturnsEmptyStateForNilUserKey(t *testing.T) {
 	assert.False(t, state.IsValid())
 	assert.Nil(t, state.ToValuesMap())
 }
+
+// A FeatureStore that implements FeatureStoreWithContext, recording each context it is given. It does
+// not expose the snapshot method of the underlying store, so that the client queries it directly.
+type contextRecordingFeatureStore struct {
+	FeatureStore
+	contexts []context.Context
+	onGet    func(kind VersionedDataKind, key string)
+}
+
+func (s *contextRecordingFeatureStore) GetWithContext(ctx context.Context, kind VersionedDataKind, key string) (VersionedData, error) {
+	s.contexts = append(s.contexts, ctx)
+	if s.onGet != nil {
+		s.onGet(kind, key)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Get(kind, key)
+}
+
+func (s *contextRecordingFeatureStore) AllWithContext(ctx context.Context, kind VersionedDataKind) (map[string]VersionedData, error) {
+	s.contexts = append(s.contexts, ctx)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.All(kind)
+}
+
+func makeContextRecordingFeatureStore(t *testing.T, items ...VersionedData) *contextRecordingFeatureStore {
+	allData := map[VersionedDataKind]map[string]VersionedData{Features: {}, Segments: {}}
+	for _, item := range items {
+		switch item.(type) {
+		case *FeatureFlag:
+			allData[Features][item.GetKey()] = item
+		case *Segment:
+			allData[Segments][item.GetKey()] = item
+		}
+	}
+	store := NewInMemoryFeatureStore(log.New(ioutil.Discard, "", 0))
+	require.NoError(t, store.Init(allData))
+	return &contextRecordingFeatureStore{FeatureStore: store}
+}
+
+func TestVariationCtxPassesContextToFeatureStore(t *testing.T) {
+	flag := makeTestFlag("validFeatureKey", 1, false, true)
+	store := makeContextRecordingFeatureStore(t, flag)
+	client := makeTestClientWithFeatureStore(store)
+	defer client.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	actual, err := client.BoolVariationCtx(ctx, "validFeatureKey", evalTestUser, false)
+
+	assert.NoError(t, err)
+	assert.True(t, actual)
+	assert.Equal(t, []context.Context{ctx}, store.contexts)
+	assertEvalEvent(t, client, flag, evalTestUser, true, 1, false, nil)
+}
+
+func TestVariationCtxReturnsDefaultIfContextIsDone(t *testing.T) {
+	flag := makeTestFlag("validFeatureKey", 1, "a", "b")
+	client := makeTestClient()
+	defer client.Close()
+	client.store.Upsert(Features, flag)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	actual, detail, err := client.StringVariationDetailCtx(ctx, "validFeatureKey", evalTestUser, "default")
+
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, "default", actual)
+	assert.Equal(t, "default", detail.Value)
+	assert.Nil(t, detail.VariationIndex)
+	assert.Equal(t, newEvalReasonError(EvalErrorException), detail.Reason)
+}
+
+func TestVariationCtxReturnsDefaultIfContextIsDoneDuringEvaluation(t *testing.T) {
+	flag := FeatureFlag{
+		Key:     "flagKey",
+		Version: 1,
+		On:      true,
+		Rules: []Rule{{
+			Clauses:            []Clause{{Op: OperatorSegmentMatch, Values: []interface{}{"segmentKey"}}},
+			VariationOrRollout: VariationOrRollout{Variation: intPtr(1)},
+		}},
+		Fallthrough: VariationOrRollout{Variation: intPtr(0)},
+		Variations:  []interface{}{"a", "b"},
+	}
+	segment := Segment{Key: "segmentKey", Included: []string{*evalTestUser.Key}}
+	store := makeContextRecordingFeatureStore(t, &flag, &segment)
+	ctx, cancel := context.WithCancel(context.Background())
+	store.onGet = func(kind VersionedDataKind, key string) {
+		if kind == Segments {
+			cancel()
+		}
+	}
+	client := makeTestClientWithFeatureStore(store)
+	defer client.Close()
+
+	actual, detail, err := client.StringVariationDetailCtx(ctx, "flagKey", evalTestUser, "default")
+
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, "default", actual)
+	assert.Equal(t, newEvalReasonError(EvalErrorException), detail.Reason)
+	events := client.eventProcessor.(*testEventProcessor).events
+	require.Len(t, events, 1)
+	e := events[0].(FeatureRequestEvent)
+	assert.Equal(t, "default", e.Value)
+	assert.Nil(t, e.Variation)
+}
+
+func TestVariationCtxChecksContextForStoreWithoutContextSupport(t *testing.T) {
+	flag := makeTestFlag("validFeatureKey", 1, 1.0, 2.0)
+	store := NewInMemoryFeatureStore(log.New(ioutil.Discard, "", 0))
+	require.NoError(t, store.Init(map[VersionedDataKind]map[string]VersionedData{Features: {flag.Key: flag}}))
+	client := makeTestClientWithFeatureStore(struct{ FeatureStore }{store})
+	defer client.Close()
+
+	actual, err := client.IntVariationCtx(context.Background(), "validFeatureKey", evalTestUser, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, actual)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	actual, err = client.IntVariationCtx(ctx, "validFeatureKey", evalTestUser, 0)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 0, actual)
+}
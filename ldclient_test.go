@@ -1,13 +1,17 @@
 package ldclient
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type mockUpdateProcessor struct {
@@ -40,12 +44,41 @@ func updateProcessorFactory(u UpdateProcessor) func(string, Config) (UpdateProce
 	}
 }
 
+// Logger implementation that captures output for tests.
+type testLogger struct {
+	output []string
+	lock   sync.Mutex
+}
+
+func (l *testLogger) Println(values ...interface{}) {
+	l.append(strings.TrimSuffix(fmt.Sprintln(values...), "\n"))
+}
+
+func (l *testLogger) Printf(format string, values ...interface{}) {
+	l.append(fmt.Sprintf(format, values...))
+}
+
+func (l *testLogger) append(line string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.output = append(l.output, line)
+}
+
+func (l *testLogger) getOutput() []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]string(nil), l.output...)
+}
+
 type testEventProcessor struct {
 	events []Event
+	lock   sync.Mutex
 }
 
 func (t *testEventProcessor) SendEvent(e Event) {
+	t.lock.Lock()
 	t.events = append(t.events, e)
+	t.lock.Unlock()
 }
 
 func (t *testEventProcessor) Flush() {}
@@ -179,7 +212,29 @@ func TestMakeCustomClient_WithFailedInitialization(t *testing.T) {
 	assert.Equal(t, err, ErrInitializationFailed)
 }
 
+type closeableFeatureStore struct {
+	FeatureStore
+	closed bool
+}
+
+func (s *closeableFeatureStore) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestCloseClosesFeatureStoreIfItIsCloseable(t *testing.T) {
+	store := &closeableFeatureStore{FeatureStore: NewInMemoryFeatureStore(nil)}
+	client := makeTestClientWithConfig(func(c *Config) { c.FeatureStore = store })
+
+	require.NoError(t, client.Close())
+	assert.True(t, store.closed)
+}
+
 func makeTestClient() *LDClient {
+	return makeTestClientWithConfig(func(c *Config) {})
+}
+
+func makeTestClientWithConfig(modify func(*Config)) *LDClient {
 	config := Config{
 		Logger:       log.New(os.Stderr, "[LaunchDarkly]", log.LstdFlags),
 		Offline:      false,
@@ -191,6 +246,7 @@ func makeTestClient() *LDClient {
 		EventProcessor:        &testEventProcessor{},
 		UserKeysFlushInterval: 30 * time.Second,
 	}
+	modify(&config)
 
 	client, _ := MakeCustomClient("sdkKey", config, time.Duration(0))
 	return client
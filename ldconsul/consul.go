@@ -22,6 +22,7 @@
 package ldconsul
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -69,7 +70,7 @@ type featureStore struct {
 	prefix     string
 	client     *c.Client
 	cacheTTL   time.Duration
-	logger     ld.Logger
+	logger     ld.LeveledLogger
 	testTxHook func() // for unit testing of concurrent modifications
 }
 
@@ -142,7 +143,9 @@ func (o cacheTTLOption) apply(store *featureStore) error {
 
 // CacheTTL creates an option for NewConsulFeatureStore, to specify how long flag data should be
 // cached in memory to avoid rereading it from Consul. If this is zero, the feature store will
-// not use an in-memory cache. The default value is DefaultCacheTTL.
+// not use an in-memory cache. If it is negative, the cache never expires, which allows the store to
+// keep serving the last known data while Consul is unavailable and to restore that data into Consul
+// when it becomes available again. The default value is DefaultCacheTTL.
 //
 //     store, err := ldconsul.NewConsulFeatureStore(ldconsul.CacheTTL(30*time.Second))
 func CacheTTL(ttl time.Duration) FeatureStoreOption {
@@ -150,7 +153,7 @@ func CacheTTL(ttl time.Duration) FeatureStoreOption {
 }
 
 type loggerOption struct {
-	logger ld.Logger
+	logger ld.LeveledLogger
 }
 
 func (o loggerOption) apply(store *featureStore) error {
@@ -159,11 +162,20 @@ func (o loggerOption) apply(store *featureStore) error {
 }
 
 // Logger creates an option for NewConsulFeatureStore, to specify where to send log output.
-// If not specified, a log.Logger is used.
+// If not specified, a log.Logger is used. Messages are written with the level at the start of each
+// line, and debug messages are omitted; to receive those, use LeveledLogger instead.
 //
 //     store, err := ldconsul.NewConsulFeatureStore(ldconsul.Logger(myLogger))
 func Logger(logger ld.Logger) FeatureStoreOption {
-	return loggerOption{logger}
+	return loggerOption{ld.NewFilteredLeveledLogger(ld.AsLeveledLogger(logger), ld.LogLevelInfo, 0)}
+}
+
+// LeveledLogger creates an option for NewConsulFeatureStore, to send log output to an
+// ld.LeveledLogger, including debug messages.
+//
+//     store, err := ldconsul.NewConsulFeatureStore(ldconsul.LeveledLogger(myLogger))
+func LeveledLogger(logger ld.LeveledLogger) FeatureStoreOption {
+	return loggerOption{ld.NewFilteredLeveledLogger(logger, ld.LogLevelDebug, 0)}
 }
 
 // NewConsulFeatureStore creates a new Consul-backed feature store with an optional memory cache. You
@@ -196,7 +208,7 @@ func newConsulFeatureStoreInternal(options ...FeatureStoreOption) (*featureStore
 		store.prefix = DefaultPrefix
 	}
 
-	store.logger.Printf("ConsulFeatureStore: Using config: %+v", store.config)
+	store.logger.Info("ConsulFeatureStore: Using config", "config", fmt.Sprintf("%+v", store.config))
 
 	client, err := c.NewClient(&store.config)
 	if err != nil {
@@ -211,17 +223,28 @@ func (store *featureStore) GetCacheTTL() time.Duration {
 }
 
 func (store *featureStore) GetInternal(kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
-	item, _, err := store.getEvenIfDeleted(kind, key)
+	return store.GetInternalWithContext(context.Background(), kind, key)
+}
+
+func (store *featureStore) GetInternalWithContext(ctx context.Context, kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
+	item, _, err := store.getEvenIfDeleted(ctx, kind, key)
 	return item, err
 }
 
 func (store *featureStore) GetAllInternal(kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
+	return store.GetAllInternalWithContext(context.Background(), kind)
+}
+
+func (store *featureStore) GetAllInternalWithContext(ctx context.Context, kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
 	results := make(map[string]ld.VersionedData)
 
 	kv := store.client.KV()
-	pairs, _, err := kv.List(store.featuresKey(kind), nil)
+	pairs, _, err := kv.List(store.featuresKey(kind), (&c.QueryOptions{}).WithContext(ctx))
 
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err() // the caller gave up, so this is not a database error
+		}
 		return results, err
 	}
 
@@ -295,7 +318,7 @@ func (store *featureStore) UpsertInternal(kind ld.VersionedDataKind, newItem ld.
 	// We will potentially keep retrying to store indefinitely until someone's write succeeds
 	for {
 		// Get the item
-		oldItem, modifyIndex, err := store.getEvenIfDeleted(kind, key)
+		oldItem, modifyIndex, err := store.getEvenIfDeleted(context.Background(), kind, key)
 
 		if err != nil {
 			return nil, err
@@ -331,7 +354,7 @@ func (store *featureStore) UpsertInternal(kind ld.VersionedDataKind, newItem ld.
 			return newItem, nil // success
 		}
 		// If we failed, retry the whole shebang
-		store.logger.Printf("ConsulFeatureStore: DEBUG: Concurrent modification detected, retrying")
+		store.logger.Debug("ConsulFeatureStore: Concurrent modification detected, retrying")
 	}
 }
 
@@ -341,18 +364,22 @@ func (store *featureStore) InitializedInternal() bool {
 	return pair != nil && err == nil
 }
 
-func defaultLogger() *log.Logger {
-	return log.New(os.Stderr, "[LaunchDarkly]", log.LstdFlags)
+func defaultLogger() ld.LeveledLogger {
+	return ld.NewFilteredLeveledLogger(ld.NewLeveledLoggerAdapter(log.New(os.Stderr, "[LaunchDarkly]", log.LstdFlags)),
+		ld.LogLevelInfo, 0)
 }
 
-func (store *featureStore) getEvenIfDeleted(kind ld.VersionedDataKind, key string) (retrievedItem ld.VersionedData,
+func (store *featureStore) getEvenIfDeleted(ctx context.Context, kind ld.VersionedDataKind, key string) (retrievedItem ld.VersionedData,
 	modifyIndex uint64, err error) {
 	var defaultModifyIndex = uint64(0)
 
 	kv := store.client.KV()
 
-	pair, _, err := kv.Get(store.featureKeyFor(kind, key), nil)
+	pair, _, err := kv.Get(store.featureKeyFor(kind, key), (&c.QueryOptions{}).WithContext(ctx))
 
+	if err != nil && ctx.Err() != nil {
+		return nil, defaultModifyIndex, ctx.Err() // the caller gave up, so this is not a database error
+	}
 	if err != nil || pair == nil {
 		return nil, defaultModifyIndex, err
 	}
@@ -19,6 +19,10 @@ func TestConsulFeatureStoreCached(t *testing.T) {
 	ldtest.RunFeatureStoreTests(t, makeConsulStoreWithCacheTTL(30*time.Second), clearExistingData, true)
 }
 
+func TestConsulFeatureStoreWithContext(t *testing.T) {
+	ldtest.RunFeatureStoreContextTests(t, makeConsulStoreWithCacheTTL(0), clearExistingData)
+}
+
 func TestConsulFeatureStorePrefixes(t *testing.T) {
 	ldtest.RunFeatureStorePrefixIndependenceTests(t,
 		func(prefix string) (ld.FeatureStore, error) {
@@ -61,6 +61,7 @@ package lddynamodb
 // stored as a single item, this mechanism will not work for extremely large flags or segments.
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"log"
@@ -105,7 +106,7 @@ type dynamoDBFeatureStore struct {
 	cacheTTL       time.Duration
 	configs        []*aws.Config
 	sessionOptions session.Options
-	logger         ld.Logger
+	logger         ld.LeveledLogger
 	testUpdateHook func() // Used only by unit tests - see updateWithVersioning
 }
 
@@ -146,7 +147,9 @@ func (o cacheTTLOption) apply(store *dynamoDBFeatureStore) error {
 // CacheTTL creates an option for NewDynamoDBFeatureStore to set the amount of time
 // that recently read or updated items should remain in an in-memory cache. This reduces the
 // amount of database access if the same feature flags are being evaluated repeatedly. If it
-// is zero, there will be no in-memory caching. The default value is DefaultCacheTTL.
+// is zero, there will be no in-memory caching. If it is negative, the cache never expires, which
+// allows the store to keep serving the last known data while DynamoDB is unavailable and to restore
+// that data into the table when it becomes available again. The default value is DefaultCacheTTL.
 //
 //     store, err := lddynamodb.NewDynamoDBFeatureStore("my-table-name", lddynamodb.CacheTTL(30*time.Second))
 func CacheTTL(ttl time.Duration) FeatureStoreOption {
@@ -209,7 +212,7 @@ func SessionOptions(options session.Options) FeatureStoreOption {
 }
 
 type loggerOption struct {
-	logger ld.Logger
+	logger ld.LeveledLogger
 }
 
 func (o loggerOption) apply(store *dynamoDBFeatureStore) error {
@@ -218,11 +221,20 @@ func (o loggerOption) apply(store *dynamoDBFeatureStore) error {
 }
 
 // Logger creates an option for NewDynamoDBFeatureStore, to specify where to send log output.
-// If not specified, a log.Logger is used.
+// If not specified, a log.Logger is used. Messages are written with the level at the start of each
+// line, and debug messages are omitted; to receive those, use LeveledLogger instead.
 //
 //     store, err := lddynamodb.NewDynamoDBFeatureStore("my-table-name", lddynamodb.Logger(myLogger))
 func Logger(logger ld.Logger) FeatureStoreOption {
-	return loggerOption{logger}
+	return loggerOption{ld.NewFilteredLeveledLogger(ld.AsLeveledLogger(logger), ld.LogLevelInfo, 0)}
+}
+
+// LeveledLogger creates an option for NewDynamoDBFeatureStore, to send log output to an
+// ld.LeveledLogger, including debug messages.
+//
+//     store, err := lddynamodb.NewDynamoDBFeatureStore("my-table-name", lddynamodb.LeveledLogger(myLogger))
+func LeveledLogger(logger ld.LeveledLogger) FeatureStoreOption {
+	return loggerOption{ld.NewFilteredLeveledLogger(logger, ld.LogLevelDebug, 0)}
 }
 
 // NewDynamoDBFeatureStore creates a new DynamoDB feature store to be used by the LaunchDarkly client.
@@ -255,7 +267,9 @@ func newDynamoDBFeatureStoreInternal(table string, options ...FeatureStoreOption
 	}
 
 	if store.logger == nil {
-		store.logger = log.New(os.Stderr, "[LaunchDarkly DynamoDBFeatureStore]", log.LstdFlags)
+		store.logger = ld.NewFilteredLeveledLogger(
+			ld.NewLeveledLoggerAdapter(log.New(os.Stderr, "[LaunchDarkly DynamoDBFeatureStore]", log.LstdFlags)),
+			ld.LogLevelInfo, 0)
 	}
 
 	if store.client == nil {
@@ -277,7 +291,7 @@ func (store *dynamoDBFeatureStore) InitCollectionsInternal(allData []utils.Store
 	// Start by reading the existing keys; we will later delete any of these that weren't in allData.
 	unusedOldKeys, err := store.readExistingKeys(allData)
 	if err != nil {
-		store.logger.Printf("ERROR: Failed to get existing items prior to Init: %s", err)
+		store.logger.Error("Failed to get existing items prior to Init", "error", err)
 		return err
 	}
 
@@ -290,7 +304,7 @@ func (store *dynamoDBFeatureStore) InitCollectionsInternal(allData []utils.Store
 			key := item.GetKey()
 			av, err := store.marshalItem(coll.Kind, item)
 			if err != nil {
-				store.logger.Printf("ERROR: Failed to marshal item (key=%s): %s", key, err)
+				store.logger.Error("Failed to marshal item", "key", key, "error", err)
 				return err
 			}
 			requests = append(requests, &dynamodb.WriteRequest{
@@ -326,11 +340,11 @@ func (store *dynamoDBFeatureStore) InitCollectionsInternal(allData []utils.Store
 	})
 
 	if err := batchWriteRequests(store.client, store.table, requests); err != nil {
-		store.logger.Printf("ERROR: Failed to write %d item(s) in batches: %s", len(requests), err)
+		store.logger.Error("Failed to write items in batches", "count", len(requests), "error", err)
 		return err
 	}
 
-	store.logger.Printf("INFO: Initialized table %q with %d item(s)", store.table, numItems)
+	store.logger.Info("Initialized table", "table", store.table, "count", numItems)
 
 	return nil
 }
@@ -348,15 +362,22 @@ func (store *dynamoDBFeatureStore) InitializedInternal() bool {
 }
 
 func (store *dynamoDBFeatureStore) GetAllInternal(kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
+	return store.GetAllInternalWithContext(context.Background(), kind)
+}
+
+func (store *dynamoDBFeatureStore) GetAllInternalWithContext(ctx context.Context, kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
 	var items []map[string]*dynamodb.AttributeValue
 
-	err := store.client.QueryPages(store.makeQueryForKind(kind),
+	err := store.client.QueryPagesWithContext(ctx, store.makeQueryForKind(kind),
 		func(out *dynamodb.QueryOutput, lastPage bool) bool {
 			items = append(items, out.Items...)
 			return !lastPage
 		})
 	if err != nil {
-		store.logger.Printf("ERROR: Failed to get all %q items: %s", kind.GetNamespace(), err)
+		if ctx.Err() != nil {
+			return nil, ctx.Err() // the caller gave up, so this is not a database error
+		}
+		store.logger.Error("Failed to get all items", "kind", kind.GetNamespace(), "error", err)
 		return nil, err
 	}
 
@@ -365,7 +386,7 @@ func (store *dynamoDBFeatureStore) GetAllInternal(kind ld.VersionedDataKind) (ma
 	for _, i := range items {
 		item, err := unmarshalItem(kind, i)
 		if err != nil {
-			store.logger.Printf("ERROR: Failed to unmarshal item: %s", err)
+			store.logger.Error("Failed to unmarshal item", "error", err)
 			return nil, err
 		}
 		results[item.GetKey()] = item
@@ -375,7 +396,11 @@ func (store *dynamoDBFeatureStore) GetAllInternal(kind ld.VersionedDataKind) (ma
 }
 
 func (store *dynamoDBFeatureStore) GetInternal(kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
-	result, err := store.client.GetItem(&dynamodb.GetItemInput{
+	return store.GetInternalWithContext(context.Background(), kind, key)
+}
+
+func (store *dynamoDBFeatureStore) GetInternalWithContext(ctx context.Context, kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
+	result, err := store.client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
 		TableName:      aws.String(store.table),
 		ConsistentRead: aws.Bool(true),
 		Key: map[string]*dynamodb.AttributeValue{
@@ -384,18 +409,21 @@ func (store *dynamoDBFeatureStore) GetInternal(kind ld.VersionedDataKind, key st
 		},
 	})
 	if err != nil {
-		store.logger.Printf("ERROR: Failed to get item (key=%s): %s", key, err)
+		if ctx.Err() != nil {
+			return nil, ctx.Err() // the caller gave up, so this is not a database error
+		}
+		store.logger.Error("Failed to get item", "kind", kind.GetNamespace(), "key", key, "error", err)
 		return nil, err
 	}
 
 	if len(result.Item) == 0 {
-		store.logger.Printf("DEBUG: Item not found (key=%s)", key)
+		store.logger.Debug("Item not found", "kind", kind.GetNamespace(), "key", key)
 		return nil, nil
 	}
 
 	item, err := unmarshalItem(kind, result.Item)
 	if err != nil {
-		store.logger.Printf("ERROR: Failed to unmarshal item (key=%s): %s", key, err)
+		store.logger.Error("Failed to unmarshal item", "kind", kind.GetNamespace(), "key", key, "error", err)
 		return nil, err
 	}
 
@@ -405,7 +433,7 @@ func (store *dynamoDBFeatureStore) GetInternal(kind ld.VersionedDataKind, key st
 func (store *dynamoDBFeatureStore) UpsertInternal(kind ld.VersionedDataKind, item ld.VersionedData) (ld.VersionedData, error) {
 	av, err := store.marshalItem(kind, item)
 	if err != nil {
-		store.logger.Printf("ERROR: Failed to marshal item (key=%s): %s", item.GetKey(), err)
+		store.logger.Error("Failed to marshal item", "kind", kind.GetNamespace(), "key", item.GetKey(), "error", err)
 		return nil, err
 	}
 
@@ -432,13 +460,13 @@ func (store *dynamoDBFeatureStore) UpsertInternal(kind ld.VersionedDataKind, ite
 	})
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
-			store.logger.Printf("DEBUG: Not updating item due to condition (namespace=%s key=%s version=%d)",
-				kind.GetNamespace(), item.GetKey(), item.GetVersion())
+			store.logger.Debug("Not updating item due to condition",
+				"kind", kind.GetNamespace(), "key", item.GetKey(), "version", item.GetVersion())
 			// We must now read the item that's in the database and return it, so FeatureStoreWrapper can cache it
 			oldItem, err := store.GetInternal(kind, item.GetKey())
 			return oldItem, err
 		}
-		store.logger.Printf("ERROR: Failed to put item (namespace=%s key=%s): %s", kind.GetNamespace(), item.GetKey(), err)
+		store.logger.Error("Failed to put item", "kind", kind.GetNamespace(), "key", item.GetKey(), "error", err)
 		return nil, err
 	}
 
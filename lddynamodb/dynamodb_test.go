@@ -34,6 +34,13 @@ func TestDynamoDBFeatureStoreCached(t *testing.T) {
 	ldtest.RunFeatureStoreTests(t, makeStoreWithCacheTTL(30*time.Second), clearExistingData, true)
 }
 
+func TestDynamoDBFeatureStoreWithContext(t *testing.T) {
+	err := createTableIfNecessary()
+	require.NoError(t, err)
+
+	ldtest.RunFeatureStoreContextTests(t, makeStoreWithCacheTTL(0), clearExistingData)
+}
+
 func TestDynamoDBFeatureStorePrefixes(t *testing.T) {
 	ldtest.RunFeatureStorePrefixIndependenceTests(t,
 		func(prefix string) (ld.FeatureStore, error) {
@@ -5,8 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
-	"os"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -18,7 +16,7 @@ import (
 )
 
 // FileDataSourceOption is the interface for optional configuration parameters that can be
-// passed to NewFileDataSourceFactory. These include FilePaths and UseLogger.
+// passed to NewFileDataSourceFactory. These include FilePaths, UseLogger, and UseLeveledLogger.
 type FileDataSourceOption interface {
 	apply(fp *fileDataSource) error
 }
@@ -43,7 +41,7 @@ func FilePaths(paths ...string) FileDataSourceOption {
 }
 
 type loggerOption struct {
-	logger ld.Logger
+	logger ld.LeveledLogger
 }
 
 func (o loggerOption) apply(fs *fileDataSource) error {
@@ -52,13 +50,21 @@ func (o loggerOption) apply(fs *fileDataSource) error {
 }
 
 // UseLogger creates an option for NewFileDataSourceFactory, to specify where to send
-// log output. If not specified, a log.Logger is used.
+// log output. If neither this nor UseLeveledLogger is specified, the client's logger is used.
+// Messages are written with the level at the start of each line, and debug messages are omitted.
 func UseLogger(logger ld.Logger) FileDataSourceOption {
-	return loggerOption{logger}
+	return loggerOption{ld.NewFilteredLeveledLogger(ld.AsLeveledLogger(logger), ld.LogLevelInfo, 0)}
+}
+
+// UseLeveledLogger creates an option for NewFileDataSourceFactory, to send log output to an
+// ld.LeveledLogger, including debug messages.
+func UseLeveledLogger(logger ld.LeveledLogger) FileDataSourceOption {
+	return loggerOption{ld.NewFilteredLeveledLogger(logger, ld.LogLevelDebug, 0)}
 }
 
 // ReloaderFactory is a function type used with UseReloader, to specify a mechanism for detecting when
-// data files should be reloaded. Its standard implementation is in the ldfilewatch package.
+// data files should be reloaded. Its standard implementation is in the ldfilewatch package. The logger
+// also implements ld.LeveledLogger, so ld.AsLeveledLogger can be used to get a leveled logger from it.
 type ReloaderFactory func(paths []string, logger ld.Logger, reload func(), closeCh <-chan struct{}) error
 
 type reloaderOption struct {
@@ -81,7 +87,7 @@ func UseReloader(reloaderFactory ReloaderFactory) FileDataSourceOption {
 type fileDataSource struct {
 	store           ld.FeatureStore
 	reloaderFactory ReloaderFactory
-	logger          ld.Logger
+	logger          ld.LeveledLogger
 	isInitialized   bool
 	absFilePaths    []string
 	readyCh         chan<- struct{}
@@ -163,11 +169,43 @@ type fileDataSource struct {
 // segment key more than once, either in a single file or across multiple files.
 //
 // If the data source encounters any error in any file-- malformed content, a missing file, or a
-// duplicate key-- it will not load flags from any of the files.
+// duplicate key, or a prerequisite cycle-- it will not load flags from any of the files. Other problems
+// in the flag data that are detected by ld.ValidateFeatureData are logged as warnings, but do not
+// prevent the data from being loaded.
 func NewFileDataSourceFactory(options ...FileDataSourceOption) ld.UpdateProcessorFactory {
 	return func(sdkKey string, config ld.Config) (ld.UpdateProcessor, error) {
-		return newFileDataSource(config.FeatureStore, options...)
+		fs, err := newFileDataSource(config.FeatureStore, options...)
+		if err != nil {
+			return nil, err
+		}
+		if fs.logger == nil {
+			// MakeCustomClient puts the client's own logger in the configuration.
+			fs.logger = config.LeveledLogger
+			if fs.logger == nil {
+				fs.logger = ld.NewFilteredLeveledLogger(ld.AsLeveledLogger(config.Logger), config.MinLogLevel, 0)
+			}
+		}
+		return fs, nil
+	}
+}
+
+// ValidateFiles reads the specified files in the same way as the file data source, and checks the
+// resulting flag data with ld.ValidateFeatureData. It returns an error if any file cannot be read or
+// parsed, or if a key is used more than once. This is useful for checking flag files in a CI build.
+func ValidateFiles(paths ...string) ([]ld.FeatureDataDiagnostic, error) {
+	filesData := make([]fileData, 0, len(paths))
+	for _, path := range paths {
+		data, err := readFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s [%s]", err, path)
+		}
+		filesData = append(filesData, data)
+	}
+	storeData, err := mergeFileData(filesData...)
+	if err != nil {
+		return nil, err
 	}
+	return ld.ValidateFeatureData(storeData), nil
 }
 
 func newFileDataSource(featureStore ld.FeatureStore, options ...FileDataSourceOption) (*fileDataSource, error) {
@@ -183,9 +221,6 @@ func newFileDataSource(featureStore ld.FeatureStore, options ...FileDataSourceOp
 			return nil, err
 		}
 	}
-	if fs.logger == nil {
-		fs.logger = log.New(os.Stderr, "[LaunchDarkly FileDataSource] ", log.LstdFlags)
-	}
 	return fs, nil
 }
 
@@ -209,9 +244,9 @@ func (fs *fileDataSource) Start(closeWhenReady chan<- struct{}) {
 	// If there is a reloader, and if we haven't yet successfully loaded data, then the
 	// readiness signal will happen the first time we do get valid data (in reload).
 	fs.closeReloaderCh = make(chan struct{})
-	err := fs.reloaderFactory(fs.absFilePaths, fs.logger, fs.reload, fs.closeReloaderCh)
+	err := fs.reloaderFactory(fs.absFilePaths, ld.NewPrintfLoggerAdapter(fs.logger), fs.reload, fs.closeReloaderCh)
 	if err != nil {
-		fs.logger.Printf("ERROR: Unable to start reloader: %s\n", err)
+		fs.logger.Error("Unable to start reloader", "error", err)
 	}
 }
 
@@ -225,17 +260,24 @@ func (fs *fileDataSource) reload() {
 		if err == nil {
 			filesData = append(filesData, data)
 		} else {
-			fs.logger.Printf("ERROR: Unable to load flags: %s [%s]", err, path)
+			fs.logger.Error("Unable to load flags", "path", path, "error", err)
 			return
 		}
 	}
 	storeData, err := mergeFileData(filesData...)
 	if err == nil {
+		// Flags with a prerequisite cycle could never be evaluated, so it's better to reject the data
+		err = ld.CheckPrerequisiteCycles(storeData)
+	}
+	if err == nil {
+		for _, d := range ld.ValidateFeatureData(storeData) {
+			fs.logger.Warn("Problem in flag data", "diagnostic", d)
+		}
 		err = fs.store.Init(storeData)
 		fs.signalStartComplete(true)
 	}
 	if err != nil {
-		fs.logger.Printf("ERROR: %s", err)
+		fs.logger.Error("Unable to load flag data", "error", err)
 	}
 }
 
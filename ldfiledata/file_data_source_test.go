@@ -129,6 +129,25 @@ func TestNewFileDataSourceBadData(t *testing.T) {
 	assert.False(t, dataSource.Initialized())
 }
 
+func TestNewFileDataSourceRejectsPrerequisiteCycle(t *testing.T) {
+	filename := makeTempFile(t, `{"flags": {
+		"flag1": {"on": true, "prerequisites": [{"key": "flag2", "variation": 0}]},
+		"flag2": {"on": true, "prerequisites": [{"key": "flag1", "variation": 0}]}
+	}}`)
+	defer os.Remove(filename)
+
+	store := ld.NewInMemoryFeatureStore(nil)
+
+	factory := NewFileDataSourceFactory(FilePaths(filename))
+	dataSource, err := factory("", ld.Config{FeatureStore: store})
+	require.NoError(t, err)
+	closeWhenReady := make(chan struct{})
+	dataSource.Start(closeWhenReady)
+	<-closeWhenReady
+	assert.False(t, dataSource.Initialized())
+	assert.False(t, store.Initialized())
+}
+
 func TestNewFileDataSourceMissingFile(t *testing.T) {
 	filename := makeTempFile(t, "")
 	os.Remove(filename)
@@ -168,3 +187,26 @@ flagValues:
 	require.True(t, flag.(*ld.FeatureFlag).On)
 	assert.Equal(t, 0, *flag.(*ld.FeatureFlag).Fallthrough.Variation)
 }
+
+func TestValidateFilesReturnsDiagnostics(t *testing.T) {
+	filename1 := makeTempFile(t, `{"flags": {"flag1": {"on": true, "variations": [true], "fallthrough": {"variation": 1}}}}`)
+	defer os.Remove(filename1)
+	filename2 := makeTempFile(t, `{"flagValues": {"flag2": "x"}}`)
+	defer os.Remove(filename2)
+
+	diagnostics, err := ValidateFiles(filename1, filename2)
+	require.NoError(t, err)
+	if assert.Len(t, diagnostics, 1) {
+		assert.Equal(t, ld.DiagnosticVariationOutOfRange, diagnostics[0].Kind)
+		assert.Equal(t, "flag1", diagnostics[0].Key)
+		assert.Equal(t, "fallthrough.variation", diagnostics[0].Path)
+	}
+}
+
+func TestValidateFilesReturnsErrorForBadFile(t *testing.T) {
+	filename := makeTempFile(t, `bad data`)
+	defer os.Remove(filename)
+
+	_, err := ValidateFiles(filename)
+	assert.Error(t, err)
+}
@@ -19,7 +19,7 @@ const retryDuration = time.Second
 
 type fileWatcher struct {
 	watcher  *fsnotify.Watcher
-	logger   ld.Logger
+	logger   ld.LeveledLogger
 	reload   func()
 	paths    []string
 	absPaths map[string]bool
@@ -38,7 +38,7 @@ func WatchFiles(paths []string, logger ld.Logger, reload func(), closeCh <-chan
 	}
 	fw := &fileWatcher{
 		watcher:  watcher,
-		logger:   logger,
+		logger:   ld.AsLeveledLogger(logger),
 		reload:   reload,
 		paths:    paths,
 		absPaths: make(map[string]bool),
@@ -59,7 +59,7 @@ func (fw *fileWatcher) run(closeCh <-chan struct{}) {
 	}
 	for {
 		if err := fw.setupWatches(); err != nil {
-			fw.logger.Printf(err.Error())
+			fw.logger.Error("Unable to watch files", "error", err)
 			scheduleRetry()
 		}
 
@@ -101,7 +101,7 @@ func (fw *fileWatcher) waitForEvents(closeCh <-chan struct{}, retryCh <-chan str
 		case <-closeCh:
 			err := fw.watcher.Close()
 			if err != nil {
-				fw.logger.Printf("Error closing Watcher: %s", err)
+				fw.logger.Error("Error closing watcher", "error", err)
 			}
 			return true
 		case event := <-fw.watcher.Events:
@@ -111,7 +111,7 @@ func (fw *fileWatcher) waitForEvents(closeCh <-chan struct{}, retryCh <-chan str
 			fw.consumeExtraEvents()
 			return false
 		case err := <-fw.watcher.Errors:
-			fw.logger.Println("ERROR: ", err)
+			fw.logger.Error("Error from file watcher", "error", err)
 		case <-retryCh:
 			consumeExtraRetries(retryCh)
 			return false
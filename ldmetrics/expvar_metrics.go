@@ -0,0 +1,142 @@
+// Package ldmetrics provides an implementation of the LaunchDarkly client's Metrics interface that
+// publishes the metrics with the standard expvar package, and can also serve them in the Prometheus
+// text exposition format without requiring the Prometheus client library. It is a separate package
+// so that applications that do not use it do not import expvar, which registers a handler for
+// /debug/vars on http.DefaultServeMux.
+package ldmetrics
+
+import (
+	"expvar"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ld "gopkg.in/launchdarkly/go-client.v4"
+)
+
+// ExpvarMetrics is an implementation of ldclient.Metrics that keeps the metrics in an expvar.Map.
+// Each metric is an entry in the map; a counter is an expvar.Int, a gauge is an expvar.Float, and
+// a duration is a JSON object with the number of observations ("count") and their total in seconds
+// ("sum"). A metric with labels is instead a nested expvar.Map, whose keys are the labels in the
+// Prometheus format, such as `flag="my-flag",reason="FALLTHROUGH"`.
+//
+// To use it, set Config.Metrics to an instance created with NewExpvarMetrics.
+type ExpvarMetrics struct {
+	root *expvar.Map
+	vars map[string]expvar.Var // keyed by metric name and labels
+	lock sync.RWMutex
+}
+
+var _ ld.Metrics = (*ExpvarMetrics)(nil)
+
+// NewExpvarMetrics creates an ExpvarMetrics. If name is not empty, the metrics are published with
+// expvar under that name, so they appear in the output of /debug/vars; like expvar.NewMap, this
+// panics if the name is already in use. If name is empty, the metrics are not published, but can
+// still be served by PrometheusHandler.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	var root *expvar.Map
+	if name == "" {
+		root = new(expvar.Map).Init()
+	} else {
+		root = expvar.NewMap(name)
+	}
+	return &ExpvarMetrics{root: root, vars: make(map[string]expvar.Var)}
+}
+
+// Map returns the expvar.Map containing the metrics.
+func (m *ExpvarMetrics) Map() *expvar.Map {
+	return m.root
+}
+
+// AddCount adds to the value of a counter. This is part of the ldclient.Metrics interface.
+func (m *ExpvarMetrics) AddCount(name string, delta int64, labels ...string) {
+	v := m.getOrCreate(name, labels, func() expvar.Var { return new(expvar.Int) })
+	if counter, ok := v.(*expvar.Int); ok {
+		counter.Add(delta)
+	}
+}
+
+// SetGauge sets the current value of a gauge. This is part of the ldclient.Metrics interface.
+func (m *ExpvarMetrics) SetGauge(name string, value float64, labels ...string) {
+	v := m.getOrCreate(name, labels, func() expvar.Var { return new(expvar.Float) })
+	if gauge, ok := v.(*expvar.Float); ok {
+		gauge.Set(value)
+	}
+}
+
+// ObserveDuration records how long one occurrence of an operation took. This is part of the
+// ldclient.Metrics interface.
+func (m *ExpvarMetrics) ObserveDuration(name string, duration time.Duration, labels ...string) {
+	v := m.getOrCreate(name, labels, func() expvar.Var { return &durationVar{} })
+	if d, ok := v.(*durationVar); ok {
+		d.observe(duration)
+	}
+}
+
+// Returns the variable for a metric with the specified labels, creating it if necessary.
+func (m *ExpvarMetrics) getOrCreate(name string, labels []string, newVar func() expvar.Var) expvar.Var {
+	labelsKey := formatLabels(labels)
+	key := name + "{" + labelsKey + "}"
+	m.lock.RLock()
+	v := m.vars[key]
+	m.lock.RUnlock()
+	if v != nil {
+		return v
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if v = m.vars[key]; v != nil {
+		return v
+	}
+	v = newVar()
+	if labelsKey == "" {
+		m.root.Set(name, v)
+	} else {
+		labeled, ok := m.root.Get(name).(*expvar.Map)
+		if !ok {
+			labeled = new(expvar.Map).Init()
+			m.root.Set(name, labeled)
+		}
+		labeled.Set(labelsKey, v)
+	}
+	m.vars[key] = v
+	return v
+}
+
+// Formats name/value pairs in the Prometheus format. A name without a value is ignored.
+func formatLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, labels[i]+`="`+labelValueEscaper.Replace(labels[i+1])+`"`)
+	}
+	return strings.Join(parts, ",")
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// An expvar.Var that accumulates durations.
+type durationVar struct {
+	count int64
+	nanos int64
+}
+
+func (d *durationVar) observe(duration time.Duration) {
+	atomic.AddInt64(&d.count, 1)
+	atomic.AddInt64(&d.nanos, int64(duration))
+}
+
+// Returns the number of observations and their total in seconds.
+func (d *durationVar) values() (int64, float64) {
+	return atomic.LoadInt64(&d.count), time.Duration(atomic.LoadInt64(&d.nanos)).Seconds()
+}
+
+func (d *durationVar) String() string {
+	count, sum := d.values()
+	return fmt.Sprintf(`{"count":%d,"sum":%s}`, count, formatFloat(sum))
+}
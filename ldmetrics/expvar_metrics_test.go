@@ -0,0 +1,71 @@
+package ldmetrics
+
+import (
+	"expvar"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounterWithoutLabels(t *testing.T) {
+	m := NewExpvarMetrics("")
+	m.AddCount("c", 1)
+	m.AddCount("c", 2)
+
+	assert.Equal(t, "3", m.Map().Get("c").String())
+}
+
+func TestCountersWithLabels(t *testing.T) {
+	m := NewExpvarMetrics("")
+	m.AddCount("c", 1, "flag", "a", "reason", "OFF")
+	m.AddCount("c", 1, "flag", "b", "reason", "OFF")
+	m.AddCount("c", 1, "flag", "a", "reason", "OFF")
+
+	assert.Equal(t, `{"flag=\"a\",reason=\"OFF\"": 2, "flag=\"b\",reason=\"OFF\"": 1}`, m.Map().Get("c").String())
+}
+
+func TestGauge(t *testing.T) {
+	m := NewExpvarMetrics("")
+	m.SetGauge("g", 3)
+	m.SetGauge("g", 1.5)
+
+	assert.Equal(t, "1.5", m.Map().Get("g").String())
+}
+
+func TestDuration(t *testing.T) {
+	m := NewExpvarMetrics("")
+	m.ObserveDuration("d", time.Second)
+	m.ObserveDuration("d", 500*time.Millisecond)
+
+	assert.Equal(t, `{"count":2,"sum":1.5}`, m.Map().Get("d").String())
+}
+
+func TestLabelValuesAreEscaped(t *testing.T) {
+	assert.Equal(t, `flag="a\"b\\c\nd"`, formatLabels([]string{"flag", "a\"b\\c\nd"}))
+}
+
+func TestMetricsArePublishedWithName(t *testing.T) {
+	m := NewExpvarMetrics("ldmetrics-test")
+	m.AddCount("c", 1)
+
+	assert.Equal(t, m.Map(), expvar.Get("ldmetrics-test"))
+}
+
+func TestConcurrentUpdatesAreNotLost(t *testing.T) {
+	m := NewExpvarMetrics("")
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.AddCount("c", 1, "flag", "a")
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, `{"flag=\"a\"": 1000}`, m.Map().Get("c").String())
+}
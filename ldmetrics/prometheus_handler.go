@@ -0,0 +1,81 @@
+package ldmetrics
+
+import (
+	"bufio"
+	"expvar"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// PrometheusContentType is the content type of the output of PrometheusHandler.
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// PrometheusHandler returns an http.Handler that serves the metrics in the Prometheus text
+// exposition format, so that a Prometheus server can scrape them. Counters and gauges have the same
+// names as in ExpvarMetrics; a duration is reported as a summary, with a _sum (in seconds) and a
+// _count for each set of labels.
+func (m *ExpvarMetrics) PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", PrometheusContentType)
+		bw := bufio.NewWriter(w)
+		m.WritePrometheus(bw)
+		_ = bw.Flush()
+	})
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format. Metrics are sorted
+// by name, and then by labels.
+func (m *ExpvarMetrics) WritePrometheus(w io.Writer) {
+	m.root.Do(func(kv expvar.KeyValue) {
+		if labeled, ok := kv.Value.(*expvar.Map); ok {
+			wroteType := false
+			labeled.Do(func(lkv expvar.KeyValue) {
+				if !wroteType {
+					writePrometheusType(w, kv.Key, lkv.Value)
+					wroteType = true
+				}
+				writePrometheusValue(w, kv.Key, "{"+lkv.Key+"}", lkv.Value)
+			})
+			return
+		}
+		writePrometheusType(w, kv.Key, kv.Value)
+		writePrometheusValue(w, kv.Key, "", kv.Value)
+	})
+}
+
+func writePrometheusType(w io.Writer, name string, v expvar.Var) {
+	var metricType string
+	switch v.(type) {
+	case *expvar.Int:
+		metricType = "counter"
+	case *expvar.Float:
+		metricType = "gauge"
+	case *durationVar:
+		metricType = "summary"
+	default:
+		return
+	}
+	_, _ = io.WriteString(w, "# TYPE "+name+" "+metricType+"\n")
+}
+
+func writePrometheusValue(w io.Writer, name, labels string, v expvar.Var) {
+	switch value := v.(type) {
+	case *expvar.Int:
+		writePrometheusLine(w, name, labels, strconv.FormatInt(value.Value(), 10))
+	case *expvar.Float:
+		writePrometheusLine(w, name, labels, formatFloat(value.Value()))
+	case *durationVar:
+		count, sum := value.values()
+		writePrometheusLine(w, name+"_sum", labels, formatFloat(sum))
+		writePrometheusLine(w, name+"_count", labels, strconv.FormatInt(count, 10))
+	}
+}
+
+func writePrometheusLine(w io.Writer, name, labels, value string) {
+	_, _ = io.WriteString(w, name+labels+" "+value+"\n")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,33 @@
+package ldmetrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	m := NewExpvarMetrics("")
+	m.AddCount("requests_total", 2, "result", "success")
+	m.AddCount("requests_total", 1, "result", "error")
+	m.SetGauge("queue_depth", 4)
+	m.ObserveDuration("flush_duration_seconds", 250*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	m.PrometheusHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, PrometheusContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, `# TYPE flush_duration_seconds summary
+flush_duration_seconds_sum 0.25
+flush_duration_seconds_count 1
+# TYPE queue_depth gauge
+queue_depth 4
+# TYPE requests_total counter
+requests_total{result="error"} 1
+requests_total{result="success"} 2
+`, w.Body.String())
+}
@@ -0,0 +1,441 @@
+// Package ldvalue provides an immutable representation of JSON values, for use with feature flag
+// variations, clause values, custom user attributes, and evaluation results.
+//
+// Values that come from the SDK's feature store are shared by every goroutine that evaluates flags,
+// so they must never be modified. A Value cannot be modified: its arrays and objects can only be
+// read through accessor methods, and any method that returns a Go slice or map returns a new copy.
+//
+// For compatibility within this major version, the fields of the data model (FeatureFlag.Variations,
+// Clause.Values, User.Custom) and EvaluationDetail.Value are still of type interface{}. The client
+// uses Value internally for flag variations, returns it from ValueVariation, and returns deep copies
+// wherever it returns an interface{} value to the application, so that neither can change the flag.
+//
+//	value := ldvalue.ObjectOf(map[string]ldvalue.Value{
+//	    "name":  ldvalue.String("x"),
+//	    "limit": ldvalue.Int(3),
+//	})
+//	limit := value.GetByKey("limit").IntValue()
+package ldvalue
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// ValueType indicates which kind of JSON value a Value is.
+type ValueType int
+
+const (
+	// NullType describes a JSON null, or the absence of a value.
+	NullType ValueType = iota
+	// BoolType describes a JSON boolean.
+	BoolType
+	// NumberType describes a JSON number. All numbers are represented as float64.
+	NumberType
+	// StringType describes a JSON string.
+	StringType
+	// ArrayType describes a JSON array.
+	ArrayType
+	// ObjectType describes a JSON object.
+	ObjectType
+)
+
+// String returns the name of the type, such as "number".
+func (t ValueType) String() string {
+	switch t {
+	case BoolType:
+		return "bool"
+	case NumberType:
+		return "number"
+	case StringType:
+		return "string"
+	case ArrayType:
+		return "array"
+	case ObjectType:
+		return "object"
+	default:
+		return "null"
+	}
+}
+
+// Value is an immutable JSON value. The zero value is a null.
+//
+// Values can be compared with Equal; they should not be compared with ==, since two arrays or
+// objects with the same contents are not necessarily represented the same way.
+type Value struct {
+	valueType   ValueType
+	boolValue   bool
+	numberValue float64
+	stringValue string
+	arrayValue  []Value          // never modified once the Value has been created
+	objectValue map[string]Value // never modified once the Value has been created
+}
+
+// Null returns a null Value.
+func Null() Value {
+	return Value{}
+}
+
+// Bool returns a boolean Value.
+func Bool(value bool) Value {
+	return Value{valueType: BoolType, boolValue: value}
+}
+
+// Int returns a numeric Value with an integer value.
+func Int(value int) Value {
+	return Float64(float64(value))
+}
+
+// Float64 returns a numeric Value.
+func Float64(value float64) Value {
+	return Value{valueType: NumberType, numberValue: value}
+}
+
+// String returns a string Value.
+func String(value string) Value {
+	return Value{valueType: StringType, stringValue: value}
+}
+
+// ArrayOf returns an array Value containing the specified values. The slice is copied.
+func ArrayOf(items ...Value) Value {
+	a := make([]Value, len(items))
+	copy(a, items)
+	return Value{valueType: ArrayType, arrayValue: a}
+}
+
+// ObjectOf returns an object Value containing the specified properties. The map is copied.
+func ObjectOf(properties map[string]Value) Value {
+	o := make(map[string]Value, len(properties))
+	for k, v := range properties {
+		o[k] = v
+	}
+	return Value{valueType: ObjectType, objectValue: o}
+}
+
+// CopyArbitraryValue converts any Go value to a Value, making a deep copy of any slices or maps.
+//
+// Booleans, numbers of any type, strings, []interface{}, map[string]interface{}, and Value itself
+// are converted directly. Anything else, such as a struct or json.RawMessage, is converted by
+// marshaling it to JSON and parsing the result; if it cannot be marshaled, the result is a null.
+func CopyArbitraryValue(anyValue interface{}) Value {
+	switch v := anyValue.(type) {
+	case nil:
+		return Null()
+	case Value:
+		return v
+	case bool:
+		return Bool(v)
+	case string:
+		return String(v)
+	case float64:
+		return Float64(v)
+	case float32:
+		return Float64(float64(v))
+	case int:
+		return Float64(float64(v))
+	case int8:
+		return Float64(float64(v))
+	case int16:
+		return Float64(float64(v))
+	case int32:
+		return Float64(float64(v))
+	case int64:
+		return Float64(float64(v))
+	case uint:
+		return Float64(float64(v))
+	case uint8:
+		return Float64(float64(v))
+	case uint16:
+		return Float64(float64(v))
+	case uint32:
+		return Float64(float64(v))
+	case uint64:
+		return Float64(float64(v))
+	case []interface{}:
+		a := make([]Value, len(v))
+		for i, item := range v {
+			a[i] = CopyArbitraryValue(item)
+		}
+		return Value{valueType: ArrayType, arrayValue: a}
+	case []Value:
+		return ArrayOf(v...)
+	case map[string]interface{}:
+		o := make(map[string]Value, len(v))
+		for k, item := range v {
+			o[k] = CopyArbitraryValue(item)
+		}
+		return Value{valueType: ObjectType, objectValue: o}
+	case map[string]Value:
+		return ObjectOf(v)
+	case json.RawMessage:
+		return parseJSONOrNull(v)
+	default:
+		if rv := reflect.ValueOf(anyValue); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return Null()
+		}
+		data, err := json.Marshal(anyValue)
+		if err != nil {
+			return Null()
+		}
+		return parseJSONOrNull(data)
+	}
+}
+
+func parseJSONOrNull(data []byte) Value {
+	var v Value
+	if err := json.Unmarshal(data, &v); err != nil {
+		return Null()
+	}
+	return v
+}
+
+// Type returns the type of the value.
+func (v Value) Type() ValueType {
+	return v.valueType
+}
+
+// IsNull returns true if the value is a null.
+func (v Value) IsNull() bool {
+	return v.valueType == NullType
+}
+
+// IsNumber returns true if the value is numeric.
+func (v Value) IsNumber() bool {
+	return v.valueType == NumberType
+}
+
+// IsInt returns true if the value is numeric and has no fractional part.
+func (v Value) IsInt() bool {
+	return v.valueType == NumberType && v.numberValue == float64(int64(v.numberValue))
+}
+
+// BoolValue returns the value if it is a boolean, or false otherwise.
+func (v Value) BoolValue() bool {
+	return v.valueType == BoolType && v.boolValue
+}
+
+// IntValue returns the value, truncated to an integer, if it is numeric; or zero otherwise.
+func (v Value) IntValue() int {
+	if v.valueType != NumberType {
+		return 0
+	}
+	return int(v.numberValue)
+}
+
+// Float64Value returns the value if it is numeric, or zero otherwise.
+func (v Value) Float64Value() float64 {
+	if v.valueType != NumberType {
+		return 0
+	}
+	return v.numberValue
+}
+
+// StringValue returns the value if it is a string, or an empty string otherwise. To get a JSON
+// representation of any value, use JSONString.
+func (v Value) StringValue() string {
+	if v.valueType != StringType {
+		return ""
+	}
+	return v.stringValue
+}
+
+// Count returns the number of elements in an array or properties in an object, or zero for any
+// other type.
+func (v Value) Count() int {
+	switch v.valueType {
+	case ArrayType:
+		return len(v.arrayValue)
+	case ObjectType:
+		return len(v.objectValue)
+	}
+	return 0
+}
+
+// GetByIndex returns an element of an array, or a null if the value is not an array or the index is
+// out of range.
+func (v Value) GetByIndex(index int) Value {
+	if v.valueType != ArrayType || index < 0 || index >= len(v.arrayValue) {
+		return Null()
+	}
+	return v.arrayValue[index]
+}
+
+// GetByKey returns a property of an object, or a null if the value is not an object or does not
+// have that property. Use TryGetByKey to distinguish a missing property from a null one.
+func (v Value) GetByKey(key string) Value {
+	ret, _ := v.TryGetByKey(key)
+	return ret
+}
+
+// TryGetByKey is the same as GetByKey, but also returns false if the property does not exist.
+func (v Value) TryGetByKey(key string) (Value, bool) {
+	if v.valueType != ObjectType {
+		return Null(), false
+	}
+	ret, ok := v.objectValue[key]
+	return ret, ok
+}
+
+// Keys returns the property names of an object in sorted order, or nil for any other type.
+func (v Value) Keys() []string {
+	if v.valueType != ObjectType {
+		return nil
+	}
+	keys := make([]string, 0, len(v.objectValue))
+	for k := range v.objectValue {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// AsArbitraryValue converts the value to the Go types that json.Unmarshal would produce for it: nil,
+// bool, float64, string, []interface{}, or map[string]interface{}. Arrays and objects are copied, so
+// the result can be modified freely.
+func (v Value) AsArbitraryValue() interface{} {
+	switch v.valueType {
+	case BoolType:
+		return v.boolValue
+	case NumberType:
+		return v.numberValue
+	case StringType:
+		return v.stringValue
+	case ArrayType:
+		a := make([]interface{}, len(v.arrayValue))
+		for i, item := range v.arrayValue {
+			a[i] = item.AsArbitraryValue()
+		}
+		return a
+	case ObjectType:
+		o := make(map[string]interface{}, len(v.objectValue))
+		for k, item := range v.objectValue {
+			o[k] = item.AsArbitraryValue()
+		}
+		return o
+	}
+	return nil
+}
+
+// Equal returns true if the two values have the same type and the same contents.
+func (v Value) Equal(other Value) bool {
+	if v.valueType != other.valueType {
+		return false
+	}
+	switch v.valueType {
+	case BoolType:
+		return v.boolValue == other.boolValue
+	case NumberType:
+		return v.numberValue == other.numberValue
+	case StringType:
+		return v.stringValue == other.stringValue
+	case ArrayType:
+		if len(v.arrayValue) != len(other.arrayValue) {
+			return false
+		}
+		for i, item := range v.arrayValue {
+			if !item.Equal(other.arrayValue[i]) {
+				return false
+			}
+		}
+	case ObjectType:
+		if len(v.objectValue) != len(other.objectValue) {
+			return false
+		}
+		for k, item := range v.objectValue {
+			otherItem, ok := other.objectValue[k]
+			if !ok || !item.Equal(otherItem) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// JSONString returns the JSON representation of the value.
+func (v Value) JSONString() string {
+	data, _ := v.MarshalJSON() // can't fail, since a Value can only contain JSON-compatible data
+	return string(data)
+}
+
+// String returns the JSON representation of the value, so that it is readable in log output.
+func (v Value) String() string {
+	return v.JSONString()
+}
+
+// MarshalJSON converts the value to JSON.
+func (v Value) MarshalJSON() ([]byte, error) {
+	switch v.valueType {
+	case BoolType:
+		return []byte(strconv.FormatBool(v.boolValue)), nil
+	case NumberType:
+		return json.Marshal(v.numberValue)
+	case StringType:
+		return json.Marshal(v.stringValue)
+	case ArrayType:
+		if len(v.arrayValue) == 0 {
+			return []byte("[]"), nil
+		}
+		return json.Marshal(v.arrayValue)
+	case ObjectType:
+		if len(v.objectValue) == 0 {
+			return []byte("{}"), nil
+		}
+		return json.Marshal(v.objectValue)
+	}
+	return []byte("null"), nil
+}
+
+// UnmarshalJSON parses a Value from JSON.
+func (v *Value) UnmarshalJSON(data []byte) error {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] == 'n' {
+		var x interface{} // this is just to get the same error as json.Unmarshal for invalid input
+		if err := json.Unmarshal(data, &x); err != nil {
+			return err
+		}
+		*v = Null()
+		return nil
+	}
+	switch trimmed[0] {
+	case 't', 'f':
+		var b bool
+		if err := json.Unmarshal(data, &b); err != nil {
+			return err
+		}
+		*v = Bool(b)
+	case '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*v = String(s)
+	case '[':
+		var a []Value
+		if err := json.Unmarshal(data, &a); err != nil {
+			return err
+		}
+		if a == nil {
+			a = []Value{}
+		}
+		*v = Value{valueType: ArrayType, arrayValue: a}
+	case '{':
+		var o map[string]Value
+		if err := json.Unmarshal(data, &o); err != nil {
+			return err
+		}
+		if o == nil {
+			o = map[string]Value{}
+		}
+		*v = Value{valueType: ObjectType, objectValue: o}
+	default:
+		var n float64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return err
+		}
+		*v = Float64(n)
+	}
+	return nil
+}
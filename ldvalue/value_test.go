@@ -0,0 +1,165 @@
+package ldvalue
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZeroValueIsNull(t *testing.T) {
+	var v Value
+	assert.True(t, v.IsNull())
+	assert.Equal(t, NullType, v.Type())
+	assert.True(t, v.Equal(Null()))
+}
+
+func TestPrimitiveAccessors(t *testing.T) {
+	assert.True(t, Bool(true).BoolValue())
+	assert.False(t, String("true").BoolValue())
+
+	assert.Equal(t, 3, Int(3).IntValue())
+	assert.Equal(t, 2, Float64(2.5).IntValue())
+	assert.Equal(t, 2.5, Float64(2.5).Float64Value())
+	assert.True(t, Int(3).IsInt())
+	assert.False(t, Float64(2.5).IsInt())
+	assert.Equal(t, 0, String("3").IntValue())
+
+	assert.Equal(t, "x", String("x").StringValue())
+	assert.Equal(t, "", Int(1).StringValue())
+}
+
+func TestArrayAccessors(t *testing.T) {
+	items := []Value{String("a"), Int(1)}
+	v := ArrayOf(items...)
+	items[0] = String("changed")
+
+	assert.Equal(t, ArrayType, v.Type())
+	assert.Equal(t, 2, v.Count())
+	assert.Equal(t, String("a"), v.GetByIndex(0))
+	assert.Equal(t, Int(1), v.GetByIndex(1))
+	assert.True(t, v.GetByIndex(2).IsNull())
+	assert.True(t, v.GetByIndex(-1).IsNull())
+	assert.True(t, String("a").GetByIndex(0).IsNull())
+}
+
+func TestObjectAccessors(t *testing.T) {
+	props := map[string]Value{"b": Int(2), "a": Null()}
+	v := ObjectOf(props)
+	props["c"] = Int(3)
+
+	assert.Equal(t, ObjectType, v.Type())
+	assert.Equal(t, 2, v.Count())
+	assert.Equal(t, []string{"a", "b"}, v.Keys())
+	assert.Equal(t, Int(2), v.GetByKey("b"))
+	_, found := v.TryGetByKey("a")
+	assert.True(t, found)
+	_, found = v.TryGetByKey("c")
+	assert.False(t, found)
+	assert.Nil(t, Int(1).Keys())
+}
+
+func TestCopyArbitraryValueMakesDeepCopy(t *testing.T) {
+	inner := []interface{}{"x"}
+	original := map[string]interface{}{"a": inner, "b": float64(1), "c": nil, "d": true}
+	v := CopyArbitraryValue(original)
+	inner[0] = "changed"
+	original["b"] = float64(2)
+
+	expected := ObjectOf(map[string]Value{
+		"a": ArrayOf(String("x")),
+		"b": Int(1),
+		"c": Null(),
+		"d": Bool(true),
+	})
+	assert.True(t, expected.Equal(v), "got %s", v)
+}
+
+func TestCopyArbitraryValueConvertsOtherTypes(t *testing.T) {
+	assert.Equal(t, Int(3), CopyArbitraryValue(int64(3)))
+	assert.Equal(t, Int(3), CopyArbitraryValue(uint8(3)))
+	assert.Equal(t, Float64(1.5), CopyArbitraryValue(float32(1.5)))
+	assert.True(t, ArrayOf(Int(1)).Equal(CopyArbitraryValue(json.RawMessage(`[1]`))))
+	assert.True(t, Null().Equal(CopyArbitraryValue((*struct{})(nil))))
+	assert.True(t, Null().Equal(CopyArbitraryValue(make(chan int))))
+
+	type config struct {
+		Name string `json:"name"`
+	}
+	assert.True(t, ObjectOf(map[string]Value{"name": String("x")}).Equal(CopyArbitraryValue(config{Name: "x"})))
+}
+
+func TestAsArbitraryValueReturnsNewCopy(t *testing.T) {
+	v := CopyArbitraryValue(map[string]interface{}{"a": []interface{}{"x"}})
+
+	copy1 := v.AsArbitraryValue().(map[string]interface{})
+	copy1["a"].([]interface{})[0] = "changed"
+	copy1["b"] = "added"
+
+	assert.Equal(t, map[string]interface{}{"a": []interface{}{"x"}}, v.AsArbitraryValue())
+	assert.Nil(t, Null().AsArbitraryValue())
+	assert.Equal(t, float64(2), Int(2).AsArbitraryValue())
+}
+
+func TestEqual(t *testing.T) {
+	values := []Value{
+		Null(), Bool(false), Bool(true), Int(0), Int(1), String(""), String("a"),
+		ArrayOf(), ArrayOf(Int(1)), ArrayOf(Int(2)), ArrayOf(Int(1), Int(2)),
+		ObjectOf(nil), ObjectOf(map[string]Value{"a": Int(1)}), ObjectOf(map[string]Value{"a": Int(2)}),
+		ObjectOf(map[string]Value{"b": Int(1)}),
+	}
+	for i, v1 := range values {
+		for j, v2 := range values {
+			assert.Equal(t, i == j, v1.Equal(v2), "%s, %s", v1, v2)
+		}
+	}
+	assert.True(t, ArrayOf(Int(1)).Equal(CopyArbitraryValue([]interface{}{1})))
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, s := range []string{
+		`null`, `true`, `false`, `3`, `2.5`, `"x"`, `[]`, `{}`,
+		`[1,"a",null,[true]]`, `{"a":{"b":[1,2]},"c":null}`,
+	} {
+		t.Run(s, func(t *testing.T) {
+			var v Value
+			require.NoError(t, json.Unmarshal([]byte(s), &v))
+			assert.Equal(t, s, v.JSONString())
+
+			data, err := json.Marshal(v)
+			require.NoError(t, err)
+			assert.Equal(t, s, string(data))
+		})
+	}
+}
+
+func TestValueInsideOtherStructMarshalsAsJSON(t *testing.T) {
+	type wrapper struct {
+		V Value `json:"v"`
+	}
+	data, err := json.Marshal(wrapper{V: ArrayOf(String("x"))})
+	require.NoError(t, err)
+	assert.Equal(t, `{"v":["x"]}`, string(data))
+
+	var w wrapper
+	require.NoError(t, json.Unmarshal([]byte(`{"v":{"a":1}}`), &w))
+	assert.True(t, ObjectOf(map[string]Value{"a": Int(1)}).Equal(w.V))
+}
+
+func TestUnmarshalInvalidJSONReturnsError(t *testing.T) {
+	var v Value
+	assert.Error(t, v.UnmarshalJSON([]byte(``)))
+	assert.Error(t, v.UnmarshalJSON([]byte(`nul`)))
+	assert.Error(t, v.UnmarshalJSON([]byte(`[1,`)))
+	assert.Error(t, v.UnmarshalJSON([]byte(`x`)))
+}
+
+func TestValueTypeString(t *testing.T) {
+	assert.Equal(t, "null", NullType.String())
+	assert.Equal(t, "bool", BoolType.String())
+	assert.Equal(t, "number", NumberType.String())
+	assert.Equal(t, "string", StringType.String())
+	assert.Equal(t, "array", ArrayType.String())
+	assert.Equal(t, "object", ObjectType.String())
+}
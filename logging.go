@@ -0,0 +1,366 @@
+package ldclient
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is the severity of a log message; see LeveledLogger.
+type LogLevel int
+
+const (
+	// LogLevelDebug is for messages that are only useful when troubleshooting, such as a flag key that
+	// was not found in the feature store.
+	LogLevelDebug LogLevel = iota + 1
+	// LogLevelInfo is for messages about normal operation, such as the client starting up. It is the
+	// default minimum level if Config.MinLogLevel is not set.
+	LogLevelInfo
+	// LogLevelWarn is for messages about conditions that may cause unexpected results, such as an
+	// evaluation for a user with no key.
+	LogLevelWarn
+	// LogLevelError is for messages about failures, such as a request to LaunchDarkly that failed.
+	LogLevelError
+	// LogLevelNone is a minimum level that disables all logging.
+	LogLevelNone
+)
+
+// String returns the name of the level, such as "WARN".
+func (level LogLevel) String() string {
+	switch level {
+	case LogLevelDebug:
+		return "DEBUG"
+	case LogLevelInfo:
+		return "INFO"
+	case LogLevelWarn:
+		return "WARN"
+	case LogLevelError:
+		return "ERROR"
+	case LogLevelNone:
+		return "NONE"
+	default:
+		return "LogLevel(" + strconv.Itoa(int(level)) + ")"
+	}
+}
+
+// LeveledLogger is an interface for log output with a severity level and structured fields. It is set
+// with Config.LeveledLogger, or with the options of the feature store and file data source packages.
+// Each method takes a message that does not vary from one occurrence to the next, such as "Unknown
+// feature flag", followed by alternating field names and values, such as "flag", "my-flag-key"; this
+// is the same convention as in logging libraries such as zap's SugaredLogger and logr, so an adapter
+// for one of those only needs to delegate to it.
+//
+// The methods are called from many goroutines, so they must be thread-safe.
+type LeveledLogger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// NewLeveledLoggerAdapter returns a LeveledLogger that writes to a Logger, with each message on a
+// line like "WARN: Unknown feature flag flag=my-flag-key". If logger is nil, it writes to standard
+// error.
+func NewLeveledLoggerAdapter(logger Logger) LeveledLogger {
+	if logger == nil {
+		logger = log.New(os.Stderr, "[LaunchDarkly]", log.LstdFlags)
+	}
+	return loggerAdapter{logger}
+}
+
+// AsLeveledLogger returns logger itself if it also implements LeveledLogger, or otherwise the result
+// of NewLeveledLoggerAdapter.
+func AsLeveledLogger(logger Logger) LeveledLogger {
+	if l, ok := logger.(LeveledLogger); ok {
+		return l
+	}
+	return NewLeveledLoggerAdapter(logger)
+}
+
+// NewPrintfLoggerAdapter returns a Logger that writes to a LeveledLogger; it is the reverse of
+// NewLeveledLoggerAdapter, for passing a LeveledLogger to code that expects a Logger. A line that
+// starts with a level prefix such as "WARN: " is written at that level, without the prefix; any
+// other line is written at LogLevelInfo. The result also implements LeveledLogger by calling the
+// same methods of logger, so AsLeveledLogger returns it unchanged.
+func NewPrintfLoggerAdapter(logger LeveledLogger) Logger {
+	return printfLoggerAdapter{logger}
+}
+
+type printfLoggerAdapter struct {
+	LeveledLogger
+}
+
+func (a printfLoggerAdapter) Println(values ...interface{}) {
+	a.log(strings.TrimSuffix(fmt.Sprintln(values...), "\n"))
+}
+
+func (a printfLoggerAdapter) Printf(format string, values ...interface{}) {
+	a.log(fmt.Sprintf(format, values...))
+}
+
+func (a printfLoggerAdapter) log(line string) {
+	line = strings.TrimSpace(line)
+	for _, p := range []struct {
+		prefix string
+		log    func(string, ...interface{})
+	}{
+		{"DEBUG:", a.Debug},
+		{"INFO:", a.Info},
+		{"WARN:", a.Warn},
+		{"ERROR:", a.Error},
+	} {
+		if strings.HasPrefix(line, p.prefix) {
+			p.log(strings.TrimSpace(strings.TrimPrefix(line, p.prefix)))
+			return
+		}
+	}
+	a.Info(line)
+}
+
+type loggerAdapter struct {
+	logger Logger
+}
+
+func (a loggerAdapter) Debug(msg string, keysAndValues ...interface{}) {
+	a.log(LogLevelDebug, msg, keysAndValues)
+}
+
+func (a loggerAdapter) Info(msg string, keysAndValues ...interface{}) {
+	a.log(LogLevelInfo, msg, keysAndValues)
+}
+
+func (a loggerAdapter) Warn(msg string, keysAndValues ...interface{}) {
+	a.log(LogLevelWarn, msg, keysAndValues)
+}
+
+func (a loggerAdapter) Error(msg string, keysAndValues ...interface{}) {
+	a.log(LogLevelError, msg, keysAndValues)
+}
+
+func (a loggerAdapter) log(level LogLevel, msg string, keysAndValues []interface{}) {
+	a.logger.Printf("%s", FormatLogMessage(level, msg, keysAndValues...))
+}
+
+// FormatLogMessage returns the text that NewLeveledLoggerAdapter writes for a message. Field values
+// that are empty or contain spaces, quotes, or equal signs are quoted.
+func FormatLogMessage(level LogLevel, msg string, keysAndValues ...interface{}) string {
+	var b bytes.Buffer
+	b.WriteString(level.String())
+	b.WriteString(": ")
+	b.WriteString(msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		b.WriteByte(' ')
+		if i+1 == len(keysAndValues) {
+			b.WriteString("!BADKEY=")
+			b.WriteString(formatLogValue(keysAndValues[i]))
+			break
+		}
+		b.WriteString(fmt.Sprint(keysAndValues[i]))
+		b.WriteByte('=')
+		b.WriteString(formatLogValue(keysAndValues[i+1]))
+	}
+	return b.String()
+}
+
+func formatLogValue(value interface{}) string {
+	s := fmt.Sprint(value)
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// NewFilteredLeveledLogger returns a LeveledLogger that passes messages to logger if they are at
+// minLevel or higher, and redacts anything in them that looks like a LaunchDarkly SDK key. If
+// rateLimitInterval is greater than zero, a message with the same level and text as one that was
+// passed on less than that long ago is dropped, and the next one that is passed on has a
+// "suppressed" field with the number that were dropped. The client applies this to its logger
+// according to Config.MinLogLevel and Config.LogRateLimitInterval; the feature store packages use
+// it with LogLevelInfo and no rate limit if they are given a Logger rather than a LeveledLogger.
+func NewFilteredLeveledLogger(logger LeveledLogger, minLevel LogLevel, rateLimitInterval time.Duration) LeveledLogger {
+	return newFilteredLogger(logger, minLevel, rateLimitInterval, "")
+}
+
+// A LeveledLogger that applies the minimum level, rate limiting, and SDK key redaction.
+type filteredLogger struct {
+	target            LeveledLogger
+	minLevel          LogLevel
+	rateLimitInterval time.Duration
+	sdkKey            string // redacted in addition to anything matching sdkKeyPattern
+	recent            map[logMessageKey]*recentLogMessage
+	lock              sync.Mutex
+}
+
+type logMessageKey struct {
+	level LogLevel
+	msg   string
+}
+
+type recentLogMessage struct {
+	lastLogged time.Time
+	suppressed int
+}
+
+// Limits the number of distinct messages that are remembered for rate limiting. The messages
+// logged by the SDK do not vary, so this is only reached if an application logs varying text
+// through the same logger; in that case we start over rather than using unbounded memory.
+const maxRecentLogMessages = 1000
+
+// SDK keys, other than in very old environments, are "sdk-" followed by a UUID.
+var sdkKeyPattern = regexp.MustCompile(`sdk-[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
+func newFilteredLogger(logger LeveledLogger, minLevel LogLevel, rateLimitInterval time.Duration, sdkKey string) *filteredLogger {
+	if minLevel == 0 {
+		minLevel = LogLevelInfo
+	}
+	return &filteredLogger{
+		target:            logger,
+		minLevel:          minLevel,
+		rateLimitInterval: rateLimitInterval,
+		sdkKey:            sdkKey,
+		recent:            make(map[logMessageKey]*recentLogMessage),
+	}
+}
+
+// Returns the logger that a component created from the configuration should use. MakeCustomClient
+// sets Config.LeveledLogger to its own logger, so all of the components of a client share one logger;
+// a component created without a client gets a new one based on the configuration.
+func loggerForConfig(config Config) LeveledLogger {
+	if l, ok := config.LeveledLogger.(*filteredLogger); ok {
+		return l
+	}
+	return newLoggerForConfig(config, "")
+}
+
+func newLoggerForConfig(config Config, sdkKey string) *filteredLogger {
+	target := config.LeveledLogger
+	if target == nil {
+		target = AsLeveledLogger(config.Logger)
+	}
+	return newFilteredLogger(target, config.MinLogLevel, config.LogRateLimitInterval, sdkKey)
+}
+
+func (f *filteredLogger) Debug(msg string, keysAndValues ...interface{}) {
+	if f.shouldLog(LogLevelDebug, msg, &keysAndValues) {
+		f.target.Debug(f.redact(msg), f.redactFields(keysAndValues)...)
+	}
+}
+
+func (f *filteredLogger) Info(msg string, keysAndValues ...interface{}) {
+	if f.shouldLog(LogLevelInfo, msg, &keysAndValues) {
+		f.target.Info(f.redact(msg), f.redactFields(keysAndValues)...)
+	}
+}
+
+func (f *filteredLogger) Warn(msg string, keysAndValues ...interface{}) {
+	if f.shouldLog(LogLevelWarn, msg, &keysAndValues) {
+		f.target.Warn(f.redact(msg), f.redactFields(keysAndValues)...)
+	}
+}
+
+func (f *filteredLogger) Error(msg string, keysAndValues ...interface{}) {
+	if f.shouldLog(LogLevelError, msg, &keysAndValues) {
+		f.target.Error(f.redact(msg), f.redactFields(keysAndValues)...)
+	}
+}
+
+// Checks the level and the rate limit. If messages were suppressed since the last time this one was
+// logged, the count is added to the fields.
+func (f *filteredLogger) shouldLog(level LogLevel, msg string, keysAndValues *[]interface{}) bool {
+	if level < f.minLevel {
+		return false
+	}
+	if f.rateLimitInterval <= 0 {
+		return true
+	}
+	key := logMessageKey{level, msg}
+	now := time.Now()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	recent := f.recent[key]
+	if recent == nil {
+		if len(f.recent) >= maxRecentLogMessages {
+			f.recent = make(map[logMessageKey]*recentLogMessage)
+		}
+		f.recent[key] = &recentLogMessage{lastLogged: now}
+		return true
+	}
+	if now.Sub(recent.lastLogged) < f.rateLimitInterval {
+		recent.suppressed++
+		return false
+	}
+	if recent.suppressed > 0 {
+		*keysAndValues = append((*keysAndValues)[:len(*keysAndValues):len(*keysAndValues)], "suppressed", recent.suppressed)
+	}
+	recent.lastLogged = now
+	recent.suppressed = 0
+	return true
+}
+
+func (f *filteredLogger) redact(s string) string {
+	if f.sdkKey != "" && strings.Contains(s, f.sdkKey) {
+		s = strings.Replace(s, f.sdkKey, redactSDKKey(f.sdkKey), -1)
+	}
+	return sdkKeyPattern.ReplaceAllStringFunc(s, redactSDKKey)
+}
+
+// Redacts the values of fields, returning a new slice if any of them changed. Values that cannot
+// contain a key, such as numbers, are passed on unchanged; any other value that is not a string,
+// error, or Stringer, such as a map, struct, or slice, is formatted as it would be in the log line
+// and then redacted, so a field that contained a key is replaced by its redacted string form.
+func (f *filteredLogger) redactFields(keysAndValues []interface{}) []interface{} {
+	var ret []interface{}
+	for i, value := range keysAndValues {
+		var s string
+		switch v := value.(type) {
+		case nil:
+			continue
+		case string:
+			s = v
+		case error:
+			s = v.Error()
+		case fmt.Stringer:
+			s = v.String()
+		default:
+			if isScalarKind(reflect.TypeOf(v).Kind()) {
+				continue
+			}
+			s = fmt.Sprint(v)
+		}
+		if redacted := f.redact(s); redacted != s {
+			if ret == nil {
+				ret = append([]interface{}(nil), keysAndValues...)
+			}
+			ret[i] = redacted
+		}
+	}
+	if ret == nil {
+		return keysAndValues
+	}
+	return ret
+}
+
+func isScalarKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return true
+	}
+	return false
+}
+
+// Replaces all but the last 4 characters of an SDK key with asterisks.
+func redactSDKKey(key string) string {
+	if len(key) <= 4 {
+		return strings.Repeat("*", len(key))
+	}
+	return strings.Repeat("*", len(key)-4) + key[len(key)-4:]
+}
@@ -0,0 +1,235 @@
+package ldclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSDKKey = "sdk-01234567-89ab-cdef-0123-456789abcdef"
+
+// LeveledLogger implementation that captures output for tests, in the format of FormatLogMessage.
+type testLeveledLogger struct {
+	output []string
+	lock   sync.Mutex
+}
+
+func (l *testLeveledLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.append(LogLevelDebug, msg, keysAndValues)
+}
+
+func (l *testLeveledLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.append(LogLevelInfo, msg, keysAndValues)
+}
+
+func (l *testLeveledLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.append(LogLevelWarn, msg, keysAndValues)
+}
+
+func (l *testLeveledLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.append(LogLevelError, msg, keysAndValues)
+}
+
+func (l *testLeveledLogger) append(level LogLevel, msg string, keysAndValues []interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.output = append(l.output, FormatLogMessage(level, msg, keysAndValues...))
+}
+
+func (l *testLeveledLogger) getOutput() []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]string(nil), l.output...)
+}
+
+func TestFormatLogMessage(t *testing.T) {
+	assert.Equal(t, "WARN: Unknown feature flag", FormatLogMessage(LogLevelWarn, "Unknown feature flag"))
+	assert.Equal(t, `ERROR: Failed flag=a count=2 error="no way" empty=""`,
+		FormatLogMessage(LogLevelError, "Failed", "flag", "a", "count", 2, "error", errors.New("no way"), "empty", ""))
+	assert.Equal(t, "INFO: Odd flag=a !BADKEY=b", FormatLogMessage(LogLevelInfo, "Odd", "flag", "a", "b"))
+}
+
+func TestLeveledLoggerAdapterWritesToLogger(t *testing.T) {
+	logger := &testLogger{}
+	adapter := NewLeveledLoggerAdapter(logger)
+
+	adapter.Debug("a")
+	adapter.Info("b", "x", 1)
+	adapter.Warn("c")
+	adapter.Error("d")
+
+	assert.Equal(t, []string{"DEBUG: a", "INFO: b x=1", "WARN: c", "ERROR: d"}, logger.getOutput())
+}
+
+func TestAsLeveledLoggerReturnsLoggerThatIsAlreadyLeveled(t *testing.T) {
+	target := &testLeveledLogger{}
+	printfLogger := NewPrintfLoggerAdapter(target)
+
+	AsLeveledLogger(printfLogger).Warn("message", "flag", "a")
+
+	assert.Equal(t, []string{"WARN: message flag=a"}, target.getOutput())
+}
+
+func TestPrintfLoggerAdapterUsesLevelPrefix(t *testing.T) {
+	target := &testLeveledLogger{}
+	logger := NewPrintfLoggerAdapter(target)
+
+	logger.Printf("ERROR: Unable to watch %s", "path")
+	logger.Println("WARN:", "careful")
+	logger.Printf("no level")
+
+	assert.Equal(t, []string{"ERROR: Unable to watch path", "WARN: careful", "INFO: no level"}, target.getOutput())
+}
+
+func TestFilteredLoggerOmitsMessagesBelowMinimumLevel(t *testing.T) {
+	target := &testLeveledLogger{}
+	logger := NewFilteredLeveledLogger(target, LogLevelWarn, 0)
+
+	logger.Debug("a")
+	logger.Info("b")
+	logger.Warn("c")
+	logger.Error("d")
+
+	assert.Equal(t, []string{"WARN: c", "ERROR: d"}, target.getOutput())
+}
+
+func TestFilteredLoggerDefaultsToInfoLevel(t *testing.T) {
+	target := &testLeveledLogger{}
+	logger := NewFilteredLeveledLogger(target, 0, 0)
+
+	logger.Debug("a")
+	logger.Info("b")
+
+	assert.Equal(t, []string{"INFO: b"}, target.getOutput())
+}
+
+func TestFilteredLoggerRateLimitsRepeatedMessages(t *testing.T) {
+	target := &testLeveledLogger{}
+	interval := 100 * time.Millisecond
+	logger := NewFilteredLeveledLogger(target, LogLevelDebug, interval)
+
+	logger.Warn("repeated", "flag", "a")
+	logger.Warn("repeated", "flag", "b")
+	logger.Warn("repeated", "flag", "c")
+	logger.Error("repeated")
+	logger.Warn("different")
+	time.Sleep(interval)
+	logger.Warn("repeated", "flag", "d")
+	logger.Warn("repeated", "flag", "e")
+
+	assert.Equal(t, []string{
+		"WARN: repeated flag=a",
+		"ERROR: repeated",
+		"WARN: different",
+		"WARN: repeated flag=d suppressed=2",
+	}, target.getOutput())
+}
+
+func TestFilteredLoggerRedactsSDKKeys(t *testing.T) {
+	target := &testLeveledLogger{}
+	logger := NewFilteredLeveledLogger(target, LogLevelInfo, 0)
+	redacted := strings.Repeat("*", len(testSDKKey)-4) + "cdef"
+
+	logger.Info("key is "+testSDKKey, "header", "Authorization: "+testSDKKey, "error",
+		fmt.Errorf("bad key %s", testSDKKey), "count", 1)
+
+	assert.Equal(t, []string{fmt.Sprintf(`INFO: key is %s header="Authorization: %s" error="bad key %s" count=1`,
+		redacted, redacted, redacted)}, target.getOutput())
+}
+
+func TestFilteredLoggerRedactsSDKKeysInsideOtherValues(t *testing.T) {
+	target := &testLeveledLogger{}
+	logger := NewFilteredLeveledLogger(target, LogLevelInfo, 0)
+	redacted := strings.Repeat("*", len(testSDKKey)-4) + "cdef"
+
+	logger.Info("request", "headers", http.Header{"Authorization": {testSDKKey}},
+		"config", struct{ SDKKey string }{testSDKKey}, "keys", []string{testSDKKey}, "enabled", true)
+
+	assert.Equal(t, []string{fmt.Sprintf(`INFO: request headers=map[Authorization:[%s]] config={%s} keys=[%s] enabled=true`,
+		redacted, redacted, redacted)}, target.getOutput())
+}
+
+func TestClientRedactsItsOwnSDKKey(t *testing.T) {
+	target := &testLeveledLogger{}
+	logger := newFilteredLogger(target, LogLevelInfo, 0, "my-old-style-key")
+
+	logger.Error("Request failed", "url", "https://example/my-old-style-key/all")
+
+	assert.Equal(t, []string{"ERROR: Request failed url=https://example/************-key/all"}, target.getOutput())
+}
+
+func TestClientUsesLeveledLoggerAndMinimumLevel(t *testing.T) {
+	target := &testLeveledLogger{}
+	client := makeTestClientWithConfig(func(c *Config) {
+		c.LeveledLogger = target
+		c.MinLogLevel = LogLevelDebug
+		c.FeatureStore = nil // so that the client creates a store that uses its logger
+	})
+	defer client.Close()
+
+	_, _ = client.BoolVariation("unknown-flag", evalTestUser, false)
+	_ = client.Identify(User{})
+
+	output := target.getOutput()
+	assert.Contains(t, output, "DEBUG: Key not found in feature store kind=features key=unknown-flag")
+	assert.Contains(t, output, "WARN: Identify called with empty/nil user key")
+}
+
+func TestClientOmitsDebugMessagesByDefault(t *testing.T) {
+	logger := &testLogger{}
+	client := makeTestClientWithConfig(func(c *Config) { c.Logger = logger })
+	defer client.Close()
+
+	_, _ = client.BoolVariation("unknown-flag", evalTestUser, false)
+
+	for _, line := range logger.getOutput() {
+		require.False(t, strings.HasPrefix(line, "DEBUG:"), line)
+	}
+}
+
+func TestClientRateLimitsRepeatedWarnings(t *testing.T) {
+	target := &testLeveledLogger{}
+	client := makeTestClientWithConfig(func(c *Config) {
+		c.LeveledLogger = target
+		c.LogRateLimitInterval = time.Hour
+	})
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		_ = client.Identify(User{})
+	}
+
+	assert.Equal(t, 1, countLines(target.getOutput(), "WARN: Identify called with empty/nil user key"))
+}
+
+func TestClientDoesNotRateLimitWarningsByDefault(t *testing.T) {
+	target := &testLeveledLogger{}
+	client := makeTestClientWithConfig(func(c *Config) {
+		c.LeveledLogger = target
+		c.LogRateLimitInterval = DefaultConfig.LogRateLimitInterval
+	})
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		_ = client.Identify(User{})
+	}
+
+	assert.Equal(t, 3, countLines(target.getOutput(), "WARN: Identify called with empty/nil user key"))
+}
+
+func countLines(lines []string, line string) int {
+	n := 0
+	for _, l := range lines {
+		if l == line {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,119 @@
+package ldclient
+
+import "time"
+
+// Metrics is an interface for recording measurements of the client's internal operations, such as
+// the number of evaluations and the latency of event deliveries. It is set with Config.Metrics. The
+// ldmetrics package provides an implementation based on expvar, which can also be exposed in the
+// Prometheus text format; an adapter for another metrics library only needs to implement these
+// three methods.
+//
+// The metric names are the Metric... constants. Labels are given as alternating names and values,
+// such as MetricLabelFlag, "my-flag", MetricLabelReason, "FALLTHROUGH"; a given metric always has
+// the same label names in the same order. The methods are called from many goroutines, including
+// the ones that call the variation methods, so they must be thread-safe and fast.
+type Metrics interface {
+	// AddCount adds to the value of a counter.
+	AddCount(name string, delta int64, labels ...string)
+	// SetGauge sets the current value of a gauge.
+	SetGauge(name string, value float64, labels ...string)
+	// ObserveDuration records how long one occurrence of an operation took.
+	ObserveDuration(name string, duration time.Duration, labels ...string)
+}
+
+// MetricsReceiver is an optional interface for a component that can record metrics. If
+// Config.FeatureStore implements it, and Config.Metrics is set, the client passes the Metrics to
+// SetMetrics before using the store.
+type MetricsReceiver interface {
+	SetMetrics(metrics Metrics)
+}
+
+// Names of the metrics reported to Metrics.
+const (
+	// MetricEvaluations is a counter of calls to the variation methods, with the labels
+	// MetricLabelFlag and MetricLabelReason (the kind of the EvaluationReason).
+	MetricEvaluations = "launchdarkly_evaluations_total"
+	// MetricEventQueueDepth is a gauge of the number of analytics events waiting for the next flush.
+	MetricEventQueueDepth = "launchdarkly_event_queue_depth"
+	// MetricEventsDropped is a counter of analytics events that were discarded because the event
+	// buffer was full; see Config.Capacity.
+	MetricEventsDropped = "launchdarkly_events_dropped_total"
+	// MetricEventFlushDuration is the time taken to deliver a batch of analytics events, including
+	// any retry.
+	MetricEventFlushDuration = "launchdarkly_event_flush_duration_seconds"
+	// MetricEventFlushFailures is a counter of batches of analytics events that could not be delivered.
+	MetricEventFlushFailures = "launchdarkly_event_flush_failures_total"
+	// MetricStreamConnectionAttempts is a counter of attempts to open the streaming connection,
+	// including reconnections after it was lost, with the label MetricLabelResult ("success" or "error").
+	MetricStreamConnectionAttempts = "launchdarkly_stream_connection_attempts_total"
+	// MetricStreamConnectionDuration is the time taken by each attempt to open the streaming
+	// connection, with the label MetricLabelResult.
+	MetricStreamConnectionDuration = "launchdarkly_stream_connection_duration_seconds"
+	// MetricStreamSessionDuration is how long each streaming connection stayed open.
+	MetricStreamSessionDuration = "launchdarkly_stream_session_duration_seconds"
+	// MetricStreamReconnectDelay is the delay before each attempt to reconnect the streaming
+	// connection; see Config.StreamInitialReconnectDelay.
+	MetricStreamReconnectDelay = "launchdarkly_stream_reconnect_delay_seconds"
+	// MetricPolls is a counter of polling requests in polling mode, with the label MetricLabelResult
+	// ("success" or "error").
+	MetricPolls = "launchdarkly_polls_total"
+	// MetricStoreCacheRequests is a counter of queries to the cache of a persistent feature store
+	// based on utils.FeatureStoreWrapper, with the labels MetricLabelKind (such as "features") and
+	// MetricLabelResult ("hit" or "miss").
+	MetricStoreCacheRequests = "launchdarkly_store_cache_requests_total"
+)
+
+// Names of the labels of metrics.
+const (
+	// MetricLabelFlag is the label for a flag key.
+	MetricLabelFlag = "flag"
+	// MetricLabelReason is the label for the kind of an EvaluationReason.
+	MetricLabelReason = "reason"
+	// MetricLabelResult is the label for the outcome of an operation.
+	MetricLabelResult = "result"
+	// MetricLabelKind is the label for the namespace of a kind of data in a feature store.
+	MetricLabelKind = "kind"
+)
+
+// Values of the MetricLabelResult label.
+const (
+	MetricResultSuccess = "success"
+	MetricResultError   = "error"
+	MetricResultHit     = "hit"
+	MetricResultMiss    = "miss"
+)
+
+// NullMetrics is a Metrics implementation that does nothing. It is used if Config.Metrics is nil.
+type NullMetrics struct{}
+
+// AddCount does nothing.
+func (m NullMetrics) AddCount(name string, delta int64, labels ...string) {}
+
+// SetGauge does nothing.
+func (m NullMetrics) SetGauge(name string, value float64, labels ...string) {}
+
+// ObserveDuration does nothing.
+func (m NullMetrics) ObserveDuration(name string, duration time.Duration, labels ...string) {}
+
+func metricsOrDefault(metrics Metrics) Metrics {
+	if metrics == nil {
+		return NullMetrics{}
+	}
+	return metrics
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return MetricResultError
+	}
+	return MetricResultSuccess
+}
+
+// Counts an evaluation by flag key and reason kind.
+func recordEvaluationMetric(metrics Metrics, key string, detail EvaluationDetail) {
+	var reasonKind EvalReasonKind
+	if detail.Reason != nil {
+		reasonKind = detail.Reason.GetKind()
+	}
+	metrics.AddCount(MetricEvaluations, 1, MetricLabelFlag, key, MetricLabelReason, string(reasonKind))
+}
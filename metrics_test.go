@@ -0,0 +1,173 @@
+package ldclient
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Records the values of metrics, keyed by the metric name followed by its labels.
+type recordingMetrics struct {
+	counts    map[string]int64
+	gauges    map[string]float64
+	durations map[string][]time.Duration
+	lock      sync.Mutex
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{
+		counts:    make(map[string]int64),
+		gauges:    make(map[string]float64),
+		durations: make(map[string][]time.Duration),
+	}
+}
+
+func metricKey(name string, labels []string) string {
+	return strings.Join(append([]string{name}, labels...), " ")
+}
+
+func (m *recordingMetrics) AddCount(name string, delta int64, labels ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.counts[metricKey(name, labels)] += delta
+}
+
+func (m *recordingMetrics) SetGauge(name string, value float64, labels ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.gauges[metricKey(name, labels)] = value
+}
+
+func (m *recordingMetrics) ObserveDuration(name string, duration time.Duration, labels ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := metricKey(name, labels)
+	m.durations[key] = append(m.durations[key], duration)
+}
+
+func (m *recordingMetrics) getCount(name string, labels ...string) int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.counts[metricKey(name, labels)]
+}
+
+func (m *recordingMetrics) getGauge(name string, labels ...string) float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.gauges[metricKey(name, labels)]
+}
+
+func (m *recordingMetrics) getDurationCount(name string, labels ...string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.durations[metricKey(name, labels)])
+}
+
+func TestEvaluationsAreCountedByFlagAndReason(t *testing.T) {
+	metrics := newRecordingMetrics()
+	client := makeTestClientWithConfig(func(c *Config) { c.Metrics = metrics })
+	defer client.Close()
+	client.store.Upsert(Features, makeTestFlag("flag", 1, "a", "b"))
+
+	_, _ = client.StringVariation("flag", evalTestUser, "default")
+	_, _ = client.StringVariation("flag", evalTestUser, "default")
+	_, _ = client.StringVariation("unknown", evalTestUser, "default")
+
+	assert.Equal(t, int64(2), metrics.getCount(MetricEvaluations, MetricLabelFlag, "flag", MetricLabelReason, "FALLTHROUGH"))
+	assert.Equal(t, int64(1), metrics.getCount(MetricEvaluations, MetricLabelFlag, "unknown", MetricLabelReason, "ERROR"))
+}
+
+func TestEventQueueMetrics(t *testing.T) {
+	metrics := newRecordingMetrics()
+	config := epDefaultConfig
+	config.Capacity = 1
+	config.Metrics = metrics
+	ep, st := createEventProcessor(config)
+	defer ep.Close()
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.waitUntilInactive()
+
+	assert.Equal(t, float64(1), metrics.getGauge(MetricEventQueueDepth))
+	assert.Equal(t, int64(1), metrics.getCount(MetricEventsDropped))
+
+	flushAndGetEvents(ep, st)
+
+	assert.Equal(t, float64(0), metrics.getGauge(MetricEventQueueDepth))
+	assert.Equal(t, 1, metrics.getDurationCount(MetricEventFlushDuration))
+	assert.Equal(t, int64(0), metrics.getCount(MetricEventFlushFailures))
+}
+
+func TestEventFlushFailureMetric(t *testing.T) {
+	metrics := newRecordingMetrics()
+	config := epDefaultConfig
+	config.Metrics = metrics
+	ep, st := createEventProcessor(config)
+	defer ep.Close()
+	st.error = errors.New("sorry")
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.Flush()
+	ep.waitUntilInactive()
+
+	assert.Equal(t, int64(1), metrics.getCount(MetricEventFlushFailures))
+	assert.Equal(t, 1, metrics.getDurationCount(MetricEventFlushDuration))
+}
+
+func TestPollingMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"flags": {}, "segments": {}}`))
+	}))
+	defer ts.Close()
+	metrics := newRecordingMetrics()
+	cfg := Config{
+		FeatureStore: NewInMemoryFeatureStore(nil),
+		Logger:       log.New(ioutil.Discard, "", 0),
+		PollInterval: time.Hour,
+		BaseUri:      ts.URL,
+		Metrics:      metrics,
+	}
+	p := newPollingProcessor(cfg, newFakeRequestor(ts, cfg))
+	defer p.Close()
+	p.setDataSourceStatusManager(newDataSourceStatusManager())
+
+	closeWhenReady := make(chan struct{})
+	p.Start(closeWhenReady)
+	<-closeWhenReady
+
+	assert.Equal(t, int64(1), metrics.getCount(MetricPolls, MetricLabelResult, MetricResultSuccess))
+}
+
+func TestStreamConnectionMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(401)
+	}))
+	defer ts.Close()
+	metrics := newRecordingMetrics()
+	cfg := Config{
+		StreamUri:    ts.URL,
+		FeatureStore: NewInMemoryFeatureStore(nil),
+		Logger:       log.New(ioutil.Discard, "", 0),
+		Metrics:      metrics,
+	}
+	sp := newStreamProcessor("sdkKey", cfg, nil)
+	defer sp.Close()
+	sp.setDataSourceStatusManager(newDataSourceStatusManager())
+
+	closeWhenReady := make(chan struct{})
+	sp.Start(closeWhenReady)
+	<-closeWhenReady
+
+	require.Equal(t, int64(1), metrics.getCount(MetricStreamConnectionAttempts, MetricLabelResult, MetricResultError))
+	assert.Equal(t, 1, metrics.getDurationCount(MetricStreamConnectionDuration, MetricLabelResult, MetricResultError))
+}
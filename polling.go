@@ -13,6 +13,9 @@ type pollingProcessor struct {
 	isInitialized      bool
 	quit               chan struct{}
 	closeOnce          sync.Once
+	status             *dataSourceStatusManager
+	metrics            Metrics
+	logger             LeveledLogger
 }
 
 func newPollingProcessor(config Config, requestor *requestor) *pollingProcessor {
@@ -21,13 +24,19 @@ func newPollingProcessor(config Config, requestor *requestor) *pollingProcessor
 		requestor: requestor,
 		config:    config,
 		quit:      make(chan struct{}),
+		metrics:   metricsOrDefault(config.Metrics),
+		logger:    loggerForConfig(config),
 	}
 
 	return pp
 }
 
+func (pp *pollingProcessor) setDataSourceStatusManager(m *dataSourceStatusManager) {
+	pp.status = m
+}
+
 func (pp *pollingProcessor) Start(closeWhenReady chan<- struct{}) {
-	pp.config.Logger.Printf("Starting LaunchDarkly polling processor with interval: %+v", pp.config.PollInterval)
+	pp.logger.Info("Starting LaunchDarkly polling processor", "interval", pp.config.PollInterval)
 
 	ticker := newTickerWithInitialTick(pp.config.PollInterval)
 
@@ -46,20 +55,25 @@ func (pp *pollingProcessor) Start(closeWhenReady chan<- struct{}) {
 		for {
 			select {
 			case <-pp.quit:
-				pp.config.Logger.Printf("Polling Processor closed.")
+				pp.logger.Info("Polling processor closed")
 				return
 			case <-ticker.C:
-				if err := pp.poll(); err != nil {
-					pp.config.Logger.Printf("ERROR: Error when requesting feature updates: %+v", err)
+				err := pp.poll()
+				pp.metrics.AddCount(MetricPolls, 1, MetricLabelResult, resultLabel(err))
+				if err != nil {
+					pp.logger.Error("Error when requesting feature updates", "error", err)
 					if hse, ok := err.(HttpStatusError); ok {
-						pp.config.Logger.Printf("ERROR: %s", httpErrorMessage(hse.Code, "polling request", "will retry"))
+						pp.logger.Error(httpErrorMessage(hse.Code, "polling request", "will retry"))
 						if !isHTTPErrorRecoverable(hse.Code) {
+							pp.status.updateStatus(DataSourceStateOff, makeDataSourceErrorInfo(err))
 							notifyReady()
 							return
 						}
 					}
+					pp.status.updateStatus(DataSourceStateInterrupted, makeDataSourceErrorInfo(err))
 					continue
 				}
+				pp.status.updateStatus(DataSourceStateValid, nil)
 				pp.setInitializedOnce.Do(func() {
 					pp.isInitialized = true
 					notifyReady()
@@ -78,14 +92,16 @@ func (pp *pollingProcessor) poll() error {
 
 	// We initialize the store only if the request wasn't cached
 	if !cached {
-		return pp.store.Init(MakeAllVersionedDataMap(allData.Flags, allData.Segments))
+		if err := pp.store.Init(MakeAllVersionedDataMap(allData.Flags, allData.Segments)); err != nil {
+			return dataSourceStoreError{err}
+		}
 	}
 	return nil
 }
 
 func (pp *pollingProcessor) Close() error {
 	pp.closeOnce.Do(func() {
-		pp.config.Logger.Printf("Closing Polling Processor")
+		pp.logger.Info("Closing polling processor")
 		close(pp.quit)
 	})
 	return nil
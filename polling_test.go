@@ -60,6 +60,8 @@ func TestPollingProcessor_Initialization(t *testing.T) {
 	}
 	req := newFakeRequestor(ts, cfg)
 	p := newPollingProcessor(cfg, req)
+	status := newDataSourceStatusManager()
+	p.setDataSourceStatusManager(status)
 
 	closeWhenReady := make(chan struct{})
 	p.Start(closeWhenReady)
@@ -70,6 +72,7 @@ func TestPollingProcessor_Initialization(t *testing.T) {
 		assert.Fail(t, "Failed to initialize")
 		return
 	}
+	assert.Equal(t, DataSourceStateValid, status.getStatus().State)
 
 	flag, err := store.Get(Features, "my-flag")
 	if assert.NoError(t, err) {
@@ -126,6 +129,8 @@ func TestPollingProcessorRequestResponseCodes(t *testing.T) {
 			}
 			req := newFakeRequestor(ts, cfg)
 			p := newPollingProcessor(cfg, req)
+			status := newDataSourceStatusManager()
+			p.setDataSourceStatusManager(status)
 			closeWhenReady := make(chan struct{})
 			p.Start(closeWhenReady)
 
@@ -143,11 +148,13 @@ func TestPollingProcessorRequestResponseCodes(t *testing.T) {
 						break
 					}
 				}
+				assert.Equal(t, DataSourceStateInitializing, status.getStatus().State)
 			} else {
 				select {
 				case <-closeWhenReady:
 					assert.Len(t, polls, 1) // should be ready after a single attempt
 					assert.False(t, p.Initialized())
+					assert.Equal(t, DataSourceStateOff, status.getStatus().State)
 				case <-time.After(time.Second):
 					assert.Fail(t, "channel was not closed immediately")
 				}
@@ -161,6 +168,7 @@ func newFakeRequestor(server *httptest.Server, config Config) *requestor {
 		sdkKey:     "fake",
 		httpClient: http.DefaultClient,
 		config:     config,
+		tracer:     tracerOrDefault(config.Tracer),
 	}
 
 	return &httpRequestor
@@ -28,9 +28,11 @@
 package redis
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"time"
 
@@ -140,7 +142,9 @@ func (o cacheTTLOption) apply(store *redisFeatureStoreCore) error {
 // CacheTTL creates an option for NewRedisFeatureStoreWithDefaults to set the amount of time
 // that recently read or updated items should remain in an in-memory cache. This reduces the
 // amount of database access if the same feature flags are being evaluated repeatedly. If it
-// is zero, there will be no in-memory caching. The default value is DefaultCacheTTL.
+// is zero, there will be no in-memory caching. If it is negative, the cache never expires, which
+// allows the store to keep serving the last known data while Redis is unavailable and to restore
+// that data into Redis when it becomes available again. The default value is DefaultCacheTTL.
 //
 //     store, err := redis.NewRedisFeatureStoreWithDefaults(redis.CacheTTL(30*time.Second))
 func CacheTTL(ttl time.Duration) FeatureStoreOption {
@@ -148,7 +152,7 @@ func CacheTTL(ttl time.Duration) FeatureStoreOption {
 }
 
 type loggerOption struct {
-	logger ld.Logger
+	logger ld.LeveledLogger
 }
 
 func (o loggerOption) apply(store *redisFeatureStoreCore) error {
@@ -156,12 +160,21 @@ func (o loggerOption) apply(store *redisFeatureStoreCore) error {
 	return nil
 }
 
-// Logger creates an option for NewDynamoDBFeatureStore, to specify where to send log output.
-// If not specified, a log.Logger is used.
+// Logger creates an option for NewRedisFeatureStoreWithDefaults, to specify where to send log output.
+// If not specified, a log.Logger is used. Messages are written with the level at the start of each
+// line, and debug messages are omitted; to receive those, use LeveledLogger instead.
 //
 //     store, err := redis.NewRedisFeatureStoreWithDefaults(redis.Logger(myLogger))
 func Logger(logger ld.Logger) FeatureStoreOption {
-	return loggerOption{logger}
+	return loggerOption{ld.NewFilteredLeveledLogger(ld.AsLeveledLogger(logger), ld.LogLevelInfo, 0)}
+}
+
+// LeveledLogger creates an option for NewRedisFeatureStoreWithDefaults, to send log output to an
+// ld.LeveledLogger, including debug messages.
+//
+//     store, err := redis.NewRedisFeatureStoreWithDefaults(redis.LeveledLogger(myLogger))
+func LeveledLogger(logger ld.LeveledLogger) FeatureStoreOption {
+	return loggerOption{ld.NewFilteredLeveledLogger(logger, ld.LogLevelDebug, 0)}
 }
 
 // RedisFeatureStore is a Redis-backed feature store implementation.
@@ -179,7 +192,7 @@ type redisFeatureStoreCore struct {
 	pool       *r.Pool
 	redisURL   string
 	cacheTTL   time.Duration
-	logger     ld.Logger
+	logger     ld.LeveledLogger
 	testTxHook func()
 }
 
@@ -274,7 +287,7 @@ func newRedisFeatureStoreInternal(options ...FeatureStoreOption) (*redisFeatureS
 		core.logger = defaultLogger()
 	}
 	if core.pool == nil {
-		core.logger.Printf("RedisFeatureStore: Using url: %s", core.redisURL)
+		core.logger.Info("RedisFeatureStore: Using url", "url", core.redisURL)
 		core.pool = newPool(core.redisURL)
 	}
 
@@ -291,6 +304,18 @@ func (store *RedisFeatureStore) All(kind ld.VersionedDataKind) (map[string]ld.Ve
 	return store.wrapper.All(kind)
 }
 
+// GetWithContext is the same as Get, but uses the context's deadline, if any, as the timeout for reading
+// from Redis. This is part of the ldclient.FeatureStoreWithContext interface.
+func (store *RedisFeatureStore) GetWithContext(ctx context.Context, kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
+	return store.wrapper.GetWithContext(ctx, kind, key)
+}
+
+// AllWithContext is the same as All, but uses the context's deadline, if any, as the timeout for
+// reading from Redis. This is part of the ldclient.FeatureStoreWithContext interface.
+func (store *RedisFeatureStore) AllWithContext(ctx context.Context, kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
+	return store.wrapper.AllWithContext(ctx, kind)
+}
+
 // Init populates the store with a complete set of versioned data
 func (store *RedisFeatureStore) Init(allData map[ld.VersionedDataKind]map[string]ld.VersionedData) error {
 	return store.wrapper.Init(allData)
@@ -311,6 +336,41 @@ func (store *RedisFeatureStore) Initialized() bool {
 	return store.wrapper.Initialized()
 }
 
+// GetStoreStatus returns whether Redis is currently available. This is part of the
+// ldclient.FeatureStoreStatusProvider interface.
+func (store *RedisFeatureStore) GetStoreStatus() ld.FeatureStoreStatus {
+	return store.wrapper.GetStoreStatus()
+}
+
+// AddStatusListener returns a channel that will receive a new status whenever Redis becomes
+// unavailable or available again. This is part of the ldclient.FeatureStoreStatusProvider interface.
+func (store *RedisFeatureStore) AddStatusListener() <-chan ld.FeatureStoreStatus {
+	return store.wrapper.AddStatusListener()
+}
+
+// RemoveStatusListener unsubscribes and closes a channel that was returned by AddStatusListener.
+func (store *RedisFeatureStore) RemoveStatusListener(ch <-chan ld.FeatureStoreStatus) {
+	store.wrapper.RemoveStatusListener(ch)
+}
+
+// Close stops any polling for Redis to become available again after an outage, and closes all status
+// listener channels. It does not close the connection pool. LDClient.Close calls this automatically.
+func (store *RedisFeatureStore) Close() error {
+	return store.wrapper.Close()
+}
+
+// SetTracer specifies a Tracer that will receive a span for each operation on the store. This is part
+// of the ldclient.TracerReceiver interface.
+func (store *RedisFeatureStore) SetTracer(tracer ld.Tracer) {
+	store.wrapper.SetTracer(tracer)
+}
+
+// SetMetrics specifies a Metrics object that will count the hits and misses of the cache. This is part
+// of the ldclient.MetricsReceiver interface.
+func (store *RedisFeatureStore) SetMetrics(metrics ld.Metrics) {
+	store.wrapper.SetMetrics(metrics)
+}
+
 // Actual implementation methods are below - these are called by FeatureStoreWrapper, which adds
 // caching behavior if necessary.
 
@@ -319,14 +379,15 @@ func (store *redisFeatureStoreCore) GetCacheTTL() time.Duration {
 }
 
 func (store *redisFeatureStoreCore) GetInternal(kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
-	c := store.getConn()
-	defer c.Close() // nolint:errcheck
+	return store.GetInternalWithContext(context.Background(), kind, key)
+}
 
-	jsonStr, err := r.String(c.Do("HGET", store.featuresKey(kind), key))
+func (store *redisFeatureStoreCore) GetInternalWithContext(ctx context.Context, kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
+	jsonStr, err := r.String(store.doWithContext(ctx, "HGET", store.featuresKey(kind), key))
 
 	if err != nil {
 		if err == r.ErrNil {
-			store.logger.Printf("RedisFeatureStore: DEBUG: Key: %s not found in \"%s\"", key, kind.GetNamespace())
+			store.logger.Debug("RedisFeatureStore: Key not found", "kind", kind.GetNamespace(), "key", key)
 			return nil, nil
 		}
 		return nil, err
@@ -340,12 +401,13 @@ func (store *redisFeatureStoreCore) GetInternal(kind ld.VersionedDataKind, key s
 }
 
 func (store *redisFeatureStoreCore) GetAllInternal(kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
-	results := make(map[string]ld.VersionedData)
+	return store.GetAllInternalWithContext(context.Background(), kind)
+}
 
-	c := store.getConn()
-	defer c.Close() // nolint:errcheck
+func (store *redisFeatureStoreCore) GetAllInternalWithContext(ctx context.Context, kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
+	results := make(map[string]ld.VersionedData)
 
-	values, err := r.StringMap(c.Do("HGETALL", store.featuresKey(kind)))
+	values, err := r.StringMap(store.doWithContext(ctx, "HGETALL", store.featuresKey(kind)))
 
 	if err != nil && err != r.ErrNil {
 		return nil, err
@@ -435,7 +497,7 @@ func (store *redisFeatureStoreCore) UpsertInternal(kind ld.VersionedDataKind, ne
 			if err == nil {
 				if result == nil {
 					// if exec returned nothing, it means the watch was triggered and we should retry
-					store.logger.Printf("RedisFeatureStore: DEBUG: Concurrent modification detected, retrying")
+					store.logger.Debug("RedisFeatureStore: Concurrent modification detected, retrying")
 					continue
 				}
 			}
@@ -464,6 +526,62 @@ func (store *redisFeatureStoreCore) getConn() r.Conn {
 	return store.pool.Get()
 }
 
-func defaultLogger() *log.Logger {
-	return log.New(os.Stderr, "[LaunchDarkly]", log.LstdFlags)
+// Redigo does not support contexts, so the context's deadline, if any, is used as the read timeout for
+// the command, and the command is run on another goroutine so that we can stop waiting for it as soon
+// as the context is done. Redigo connections cannot be closed while a command is in progress, so in
+// that case the connection is returned to the pool once the command finishes.
+func (store *redisFeatureStoreCore) doWithContext(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	deadline, hasDeadline := ctx.Deadline()
+	var timeout time.Duration
+	if hasDeadline {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+	}
+	c := store.getConn()
+	if ctx.Done() == nil { // the context can never be cancelled
+		defer c.Close() // nolint:errcheck
+		return c.Do(cmd, args...)
+	}
+
+	type result struct {
+		reply interface{}
+		err   error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		defer c.Close() // nolint:errcheck
+		var res result
+		if hasDeadline {
+			res.reply, res.err = r.DoWithTimeout(c, timeout, cmd, args...)
+		} else {
+			res.reply, res.err = c.Do(cmd, args...)
+		}
+		resultCh <- res
+	}()
+	select {
+	case res := <-resultCh:
+		if res.err != nil {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			// The read timeout is the context's deadline, so a timeout means that the deadline was
+			// reached, even if the context has not noticed it yet; this is the caller's doing, not a
+			// sign that Redis is unavailable.
+			if netErr, ok := res.err.(net.Error); ok && netErr.Timeout() && hasDeadline {
+				return nil, context.DeadlineExceeded
+			}
+		}
+		return res.reply, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func defaultLogger() ld.LeveledLogger {
+	return ld.NewFilteredLeveledLogger(ld.NewLeveledLoggerAdapter(log.New(os.Stderr, "[LaunchDarkly]", log.LstdFlags)),
+		ld.LogLevelInfo, 0)
 }
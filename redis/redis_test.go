@@ -1,9 +1,12 @@
 package redis
 
 import (
+	"context"
+	"net"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	r "github.com/garyburd/redigo/redis"
@@ -38,6 +41,12 @@ func TestRedisFeatureStoreCachedWithDeprecatedConstructor(t *testing.T) {
 	}, clearExistingData, true)
 }
 
+func TestRedisFeatureStoreWithContext(t *testing.T) {
+	ldtest.RunFeatureStoreContextTests(t, func() (ld.FeatureStore, error) {
+		return NewRedisFeatureStoreWithDefaults(CacheTTL(0))
+	}, clearExistingData)
+}
+
 func TestRedisFeatureStorePrefixes(t *testing.T) {
 	ldtest.RunFeatureStorePrefixIndependenceTests(t,
 		func(prefix string) (ld.FeatureStore, error) {
@@ -56,6 +65,55 @@ func TestRedisFeatureStoreConcurrentModification(t *testing.T) {
 	})
 }
 
+func TestRedisFeatureStoreCloseClosesStatusListeners(t *testing.T) {
+	store := NewRedisFeatureStoreFromUrl(redisURL, "", 0, nil)
+	ch := store.AddStatusListener()
+
+	require.NoError(t, store.Close())
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+// Starts a TCP server that accepts connections but never responds, as a Redis server that is hung would.
+func startUnresponsiveServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	return listener
+}
+
+func TestRedisFeatureStoreQueryStopsWhenContextIsCancelled(t *testing.T) {
+	listener := startUnresponsiveServer(t)
+	defer listener.Close()
+	core, err := newRedisFeatureStoreInternal(URL("redis://" + listener.Addr().String()))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = core.GetInternalWithContext(ctx, ld.Features, "flag")
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestRedisFeatureStoreQueryReturnsDeadlineErrorWhenDeadlineIsReached(t *testing.T) {
+	listener := startUnresponsiveServer(t)
+	defer listener.Close()
+	core, err := newRedisFeatureStoreInternal(URL("redis://" + listener.Addr().String()))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = core.GetAllInternalWithContext(ctx, ld.Features)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
 func makeStoreWithCacheTTL(ttl time.Duration) func() (ld.FeatureStore, error) {
 	return func() (ld.FeatureStore, error) {
 		return NewRedisFeatureStoreFromUrl(redisURL, "", ttl, nil), nil
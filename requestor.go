@@ -1,6 +1,7 @@
 package ldclient
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -22,6 +23,7 @@ type requestor struct {
 	sdkKey     string
 	httpClient *http.Client
 	config     Config
+	tracer     Tracer
 }
 
 func newRequestor(sdkKey string, config Config) *requestor {
@@ -44,18 +46,25 @@ func newRequestor(sdkKey string, config Config) *requestor {
 		sdkKey:     sdkKey,
 		httpClient: httpClient,
 		config:     config,
+		tracer:     tracerOrDefault(config.Tracer),
 	}
 
 	return &httpRequestor
 }
 
 func (r *requestor) requestAll() (allData, bool, error) {
+	return r.requestAllData(false)
+}
+
+// Same as requestAll, but if parseCached is true, the data is returned even if the response came from
+// the HTTP cache; otherwise it is empty in that case, since the caller already has it.
+func (r *requestor) requestAllData(parseCached bool) (allData, bool, error) {
 	var data allData
 	body, cached, err := r.makeRequest(LatestAllPath)
 	if err != nil {
 		return allData{}, false, err
 	}
-	if cached {
+	if cached && !parseCached {
 		return allData{}, true, nil
 	}
 	jsonErr := json.Unmarshal(body, &data)
@@ -89,11 +98,23 @@ func (r *requestor) requestResource(kind VersionedDataKind, key string) (Version
 }
 
 func (r *requestor) makeRequest(resource string) ([]byte, bool, error) {
+	_, span := r.tracer.StartSpan(context.Background(), SpanNameRequest)
+	defer span.End()
+	body, cached, err := r.makeRequestWithSpan(resource, span)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return body, cached, err
+}
+
+func (r *requestor) makeRequestWithSpan(resource string, span Span) ([]byte, bool, error) {
 	req, reqErr := http.NewRequest("GET", r.config.BaseUri+resource, nil)
 	if reqErr != nil {
 		return nil, false, reqErr
 	}
 	url := req.URL.String()
+	span.SetAttribute(SpanAttrHTTPMethod, req.Method)
+	span.SetAttribute(SpanAttrHTTPURL, url)
 
 	req.Header.Add("Authorization", r.sdkKey)
 	req.Header.Add("User-Agent", r.config.UserAgent)
@@ -109,11 +130,13 @@ func (r *requestor) makeRequest(resource string) ([]byte, bool, error) {
 		_ = res.Body.Close()
 	}()
 
+	span.SetAttribute(SpanAttrHTTPStatusCode, res.StatusCode)
 	if err := checkForHttpError(res.StatusCode, url); err != nil {
 		return nil, false, err
 	}
 
 	cached := res.Header.Get(httpcache.XFromCache) != ""
+	span.SetAttribute(SpanAttrHTTPCached, cached)
 
 	body, ioErr := ioutil.ReadAll(res.Body)
 
@@ -9,6 +9,8 @@ type Segment struct {
 	Rules    []SegmentRule `json:"rules" bson:"rules"`
 	Version  int           `json:"version" bson:"version"`
 	Deleted  bool          `json:"deleted" bson:"deleted"`
+
+	preprocessed *preprocessedSegment // see flag_preprocessing.go
 }
 
 // GetKey returns the unique key describing a segment
@@ -26,9 +28,23 @@ func (s *Segment) IsDeleted() bool {
 	return s.Deleted
 }
 
-// Clone returns a copy of a segment
+// Clone returns a deep copy of a segment, which can be modified without affecting the original.
 func (s *Segment) Clone() VersionedData {
 	s1 := *s
+	s1.preprocessed = nil
+	s1.Included = copyStrings(s.Included)
+	s1.Excluded = copyStrings(s.Excluded)
+	if s.Rules != nil {
+		s1.Rules = make([]SegmentRule, len(s.Rules))
+		for i, r := range s.Rules {
+			s1.Rules[i] = SegmentRule{
+				Id:       r.Id,
+				Clauses:  cloneClauses(r.Clauses),
+				Weight:   copyIntPtr(r.Weight),
+				BucketBy: copyStringPtr(r.BucketBy),
+			}
+		}
+	}
 	return &s1
 }
 
@@ -79,22 +95,18 @@ func (s Segment) ContainsUser(user User) (bool, *SegmentExplanation) {
 	}
 
 	// Check if the user is included in the segment by key
-	for _, key := range s.Included {
-		if *user.Key == key {
-			return true, &SegmentExplanation{Kind: "included"}
-		}
+	if stringSetContains(s.preprocessed.includedSet(), s.Included, *user.Key) {
+		return true, &SegmentExplanation{Kind: "included"}
 	}
 
 	// Check if the user is excluded from the segment by key
-	for _, key := range s.Excluded {
-		if *user.Key == key {
-			return false, &SegmentExplanation{Kind: "excluded"}
-		}
+	if stringSetContains(s.preprocessed.excludedSet(), s.Excluded, *user.Key) {
+		return false, &SegmentExplanation{Kind: "excluded"}
 	}
 
 	// Check if any of the segment rules match
-	for _, rule := range s.Rules {
-		if rule.MatchesUser(user, s.Key, s.Salt) {
+	for i, rule := range s.Rules {
+		if rule.matchesUser(user, s.Key, s.Salt, s.preprocessed.ruleClauses(i)) {
 			reason := rule
 			return true, &SegmentExplanation{Kind: "rule", MatchedRule: &reason}
 		}
@@ -105,8 +117,12 @@ func (s Segment) ContainsUser(user User) (bool, *SegmentExplanation) {
 
 // MatchesUser returns whether a rule applies to a user
 func (r SegmentRule) MatchesUser(user User, key, salt string) bool {
-	for _, clause := range r.Clauses {
-		if !clause.matchesUserNoSegments(user) {
+	return r.matchesUser(user, key, salt, nil)
+}
+
+func (r SegmentRule) matchesUser(user User, key, salt string, preprocessed []*preprocessedClause) bool {
+	for i, clause := range r.Clauses {
+		if !clause.matchesUserNoSegments(user, preprocessedClauseAt(preprocessed, i)) {
 			return false
 		}
 	}
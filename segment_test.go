@@ -1,6 +1,7 @@
 package ldclient
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -252,3 +253,33 @@ func TestNonMatchingRuleWithMultipleClauses(t *testing.T) {
 	assert.False(t, containsUser, "Segment %+v should not contain user %+v", segment, user)
 	assert.Nil(t, reason, "Reason should be nil")
 }
+
+func TestCloneMakesDeepCopyOfSegment(t *testing.T) {
+	weight := 50000
+	segment := Segment{
+		Key:      "test",
+		Included: []string{"a"},
+		Excluded: []string{"b"},
+		Rules: []SegmentRule{{
+			Clauses:  []Clause{{Attribute: "email", Op: OperatorIn, Values: []interface{}{"x"}}},
+			Weight:   &weight,
+			BucketBy: strPtr("key"),
+		}},
+	}
+	segment.preprocess()
+	originalJSON, _ := json.Marshal(segment)
+
+	clone := segment.Clone().(*Segment)
+	assert.Nil(t, clone.preprocessed)
+	cloneJSON, _ := json.Marshal(clone)
+	assert.JSONEq(t, string(originalJSON), string(cloneJSON))
+
+	clone.Included[0] = "changed"
+	clone.Excluded[0] = "changed"
+	clone.Rules[0].Clauses[0].Values[0] = "changed"
+	*clone.Rules[0].Weight = 1
+	*clone.Rules[0].BucketBy = "changed"
+
+	afterJSON, _ := json.Marshal(segment)
+	assert.JSONEq(t, string(originalJSON), string(afterJSON))
+}
@@ -1,7 +1,9 @@
 package shared_test
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -426,6 +428,52 @@ func RunFeatureStoreConcurrentModificationTests(t *testing.T, store1 ld.FeatureS
 	})
 }
 
+// RunFeatureStoreContextTests runs tests of the ldclient.FeatureStoreWithContext methods, for store
+// implementations that support them. The store must not have caching enabled, since a cached item is
+// returned without querying the database.
+func RunFeatureStoreContextTests(t *testing.T, storeFactory func() (ld.FeatureStore, error), clearExistingData func() error) {
+	flag := ld.FeatureFlag{Key: "flag", Version: 1}
+
+	makeStore := func(t *testing.T) ld.FeatureStoreWithContext {
+		if clearExistingData != nil {
+			require.NoError(t, clearExistingData())
+		}
+		store, err := storeFactory()
+		require.NoError(t, err)
+		require.NoError(t, store.Init(makeAllVersionedDataMap(map[string]*ld.FeatureFlag{flag.Key: &flag}, nil)))
+		storeWithContext, ok := store.(ld.FeatureStoreWithContext)
+		require.True(t, ok, "store does not implement FeatureStoreWithContext")
+		return storeWithContext
+	}
+
+	t.Run("queries succeed with context that is not done", func(t *testing.T) {
+		store := makeStore(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		item, err := store.GetWithContext(ctx, ld.Features, flag.Key)
+		require.NoError(t, err)
+		require.NotNil(t, item)
+		assert.Equal(t, flag.Version, item.GetVersion())
+
+		items, err := store.AllWithContext(ctx, ld.Features)
+		require.NoError(t, err)
+		assert.Len(t, items, 1)
+	})
+
+	t.Run("queries fail with context that is done", func(t *testing.T) {
+		store := makeStore(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := store.GetWithContext(ctx, ld.Features, flag.Key)
+		assert.Equal(t, context.Canceled, err)
+
+		_, err = store.AllWithContext(ctx, ld.Features)
+		assert.Equal(t, context.Canceled, err)
+	})
+}
+
 func makeAllVersionedDataMap(
 	features map[string]*ld.FeatureFlag,
 	segments map[string]*ld.Segment) map[ld.VersionedDataKind]map[string]ld.VersionedData {
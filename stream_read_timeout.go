@@ -0,0 +1,94 @@
+package ldclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Returned from reads of the stream when no data was received within Config.StreamReadTimeout.
+var errStreamReadTimeout = errors.New("no data was received from the stream within the read timeout")
+
+// An http.RoundTripper that cancels the request if the response headers are not received within the
+// timeout, and closes the body of a successful response if no data is read from it within the timeout.
+// LaunchDarkly sends a heartbeat comment on the stream periodically, so this detects a connection that
+// is still open but is no longer delivering data, as some proxies do; the eventsource library then
+// reports the error, and the streamProcessor reconnects.
+type readTimeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t readTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(t.timeout, cancel)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if !timer.Stop() {
+		// The timer fired, so the request was cancelled, or will be very soon
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		cancel()
+		return nil, errStreamReadTimeout
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The request's context must stay alive until the caller is done with the body
+	resp.Body = cancelOnCloseBody{ReadCloser: resp.Body, cancel: cancel}
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	resp.Body = newReadTimeoutBody(resp.Body, t.timeout)
+	return resp, nil
+}
+
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+type readTimeoutBody struct {
+	body     io.ReadCloser
+	timeout  time.Duration
+	timer    *time.Timer
+	timedOut int32
+}
+
+func newReadTimeoutBody(body io.ReadCloser, timeout time.Duration) *readTimeoutBody {
+	b := &readTimeoutBody{body: body, timeout: timeout}
+	b.timer = time.AfterFunc(timeout, b.expire)
+	return b
+}
+
+// Closing the body causes any read that is in progress to return an error.
+func (b *readTimeoutBody) expire() {
+	atomic.StoreInt32(&b.timedOut, 1)
+	_ = b.body.Close()
+}
+
+func (b *readTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if err != nil && atomic.LoadInt32(&b.timedOut) == 1 {
+		return n, errStreamReadTimeout
+	}
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
+
+func (b *readTimeoutBody) Close() error {
+	b.timer.Stop()
+	return b.body.Close()
+}
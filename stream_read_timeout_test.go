@@ -0,0 +1,217 @@
+package ldclient
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadTimeoutBodyReturnsErrorIfNoDataIsReceived(t *testing.T) {
+	r, w := io.Pipe()
+	body := newReadTimeoutBody(r, time.Millisecond*20)
+	defer body.Close()
+	go func() {
+		_, _ = w.Write([]byte("a"))
+	}()
+
+	buf := make([]byte, 10)
+	n, err := body.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = body.Read(buf)
+	assert.Equal(t, errStreamReadTimeout, err)
+}
+
+func TestReadTimeoutBodyIsKeptOpenByData(t *testing.T) {
+	r, w := io.Pipe()
+	body := newReadTimeoutBody(r, time.Millisecond*50)
+	defer body.Close()
+	go func() {
+		for i := 0; i < 10; i++ {
+			time.Sleep(time.Millisecond * 10)
+			_, _ = w.Write([]byte(":\n"))
+		}
+		_ = w.Close()
+	}()
+
+	data, err := ioutil.ReadAll(body)
+	assert.NoError(t, err)
+	assert.Len(t, data, 20)
+}
+
+func TestReadTimeoutTransportDoesNotWrapErrorResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer ts.Close()
+	client := &http.Client{Transport: readTimeoutTransport{base: http.DefaultTransport, timeout: time.Minute}}
+
+	resp, err := client.Get(ts.URL)
+
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	_, wrapped := resp.Body.(*readTimeoutBody)
+	assert.False(t, wrapped)
+}
+
+func TestReadTimeoutTransportReturnsErrorIfNoResponseIsReceived(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+	client := &http.Client{Transport: readTimeoutTransport{base: http.DefaultTransport, timeout: time.Millisecond * 50}}
+
+	started := time.Now()
+	resp, err := client.Get(ts.URL)
+
+	assert.Error(t, err)
+	assert.Nil(t, resp)
+	assert.True(t, time.Since(started) < time.Second*2)
+}
+
+func TestReadTimeoutTransportDoesNotCancelRequestThatWasAnsweredInTime(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.(http.Flusher).Flush()
+		time.Sleep(time.Millisecond * 30)
+		_, _ = w.Write([]byte("data"))
+	}))
+	defer ts.Close()
+	client := &http.Client{Transport: readTimeoutTransport{base: http.DefaultTransport, timeout: time.Millisecond * 50}}
+
+	resp, err := client.Get(ts.URL)
+
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	time.Sleep(time.Millisecond * 40) // the timer for the headers would have fired by now if it were not stopped
+	data, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+}
+
+// Starts a stream server that sends a put event on each connection and then, if heartbeatInterval is
+// nonzero, sends a comment at that interval; otherwise it sends nothing until the client disconnects.
+func startSilentStreamServer(heartbeatInterval time.Duration, attempts *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempt := atomic.AddInt32(attempts, 1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(200)
+		fmt.Fprintf(w, "event: put\ndata: {\"path\": \"/\", \"data\": {\"flags\": {\"my-flag\": {\"key\": \"my-flag\", \"version\": %d}}, \"segments\": {}}}\n\n", attempt)
+		w.(http.Flusher).Flush()
+		if heartbeatInterval == 0 {
+			<-r.Context().Done()
+			return
+		}
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_, _ = w.Write([]byte(":\n"))
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+}
+
+func TestStreamProcessorReconnectsAfterReadTimeout(t *testing.T) {
+	var attempts int32
+	ts := startSilentStreamServer(0, &attempts)
+	defer ts.Close()
+
+	cfg := Config{
+		StreamUri:                   ts.URL,
+		FeatureStore:                NewInMemoryFeatureStore(log.New(ioutil.Discard, "", 0)),
+		Logger:                      log.New(ioutil.Discard, "", 0),
+		StreamInitialReconnectDelay: time.Millisecond,
+		StreamReadTimeout:           time.Millisecond * 50,
+	}
+	sp := newStreamProcessor("sdkKey", cfg, nil)
+	defer sp.Close()
+	status := newDataSourceStatusManager()
+	statusCh := status.addListener()
+	sp.setDataSourceStatusManager(status)
+
+	closeWhenReady := make(chan struct{})
+	sp.Start(closeWhenReady)
+	<-closeWhenReady
+
+	interrupted := waitForDataSourceState(t, statusCh, DataSourceStateInterrupted)
+	assert.Equal(t, DataSourceErrorKindNetworkError, interrupted.LastError.Kind)
+	assert.Equal(t, errStreamReadTimeout.Error(), interrupted.LastError.Message)
+	waitForDataSourceState(t, statusCh, DataSourceStateValid)
+	assert.True(t, atomic.LoadInt32(&attempts) >= 2)
+}
+
+func TestStreamProcessorDoesNotTimeOutWhileReceivingHeartbeats(t *testing.T) {
+	var attempts int32
+	ts := startSilentStreamServer(time.Millisecond*10, &attempts)
+	defer ts.Close()
+
+	cfg := Config{
+		StreamUri:         ts.URL,
+		FeatureStore:      NewInMemoryFeatureStore(log.New(ioutil.Discard, "", 0)),
+		Logger:            log.New(ioutil.Discard, "", 0),
+		StreamReadTimeout: time.Millisecond * 100,
+	}
+	sp := newStreamProcessor("sdkKey", cfg, nil)
+	defer sp.Close()
+	status := newDataSourceStatusManager()
+	sp.setDataSourceStatusManager(status)
+
+	closeWhenReady := make(chan struct{})
+	sp.Start(closeWhenReady)
+	<-closeWhenReady
+	time.Sleep(time.Millisecond * 300)
+
+	assert.Equal(t, DataSourceStateValid, status.getStatus().State)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestStreamProcessorReconnectsIfServerDoesNotRespond(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			<-r.Context().Done() // like a proxy that accepts the connection but never responds
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(200)
+		fmt.Fprint(w, "event: put\ndata: {\"path\": \"/\", \"data\": {\"flags\": {}, \"segments\": {}}}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	cfg := Config{
+		StreamUri:                   ts.URL,
+		FeatureStore:                NewInMemoryFeatureStore(log.New(ioutil.Discard, "", 0)),
+		Logger:                      log.New(ioutil.Discard, "", 0),
+		StreamInitialReconnectDelay: time.Millisecond,
+		StreamReadTimeout:           time.Millisecond * 50,
+	}
+	sp := newStreamProcessor("sdkKey", cfg, nil)
+	defer sp.Close()
+
+	closeWhenReady := make(chan struct{})
+	sp.Start(closeWhenReady)
+	select {
+	case <-closeWhenReady:
+	case <-time.After(time.Second * 3):
+		assert.Fail(t, "timed out waiting for stream to connect")
+	}
+
+	assert.True(t, sp.Initialized())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+}
@@ -0,0 +1,122 @@
+package ldclient
+
+import (
+	"time"
+)
+
+// Failed requests for the item in an indirect/patch event are retried after a backoff delay, up to
+// indirectPatchMaxRetries times; after that, only reconciliation or a reconnect will repair the item.
+const (
+	indirectPatchRetryInitialDelay = time.Second
+	indirectPatchRetryMaxDelay     = 30 * time.Second
+	indirectPatchMaxRetries        = 5
+)
+
+// Requests the item for an indirect/patch event and stores it. Returns true if the request failed in
+// a way that may succeed if it is tried again.
+func (sp *streamProcessor) applyIndirectPatch(path parsedPath) bool {
+	item, requestErr := sp.requestor.requestResource(path.kind, path.key)
+	if requestErr != nil {
+		sp.logger.Error("Unexpected error requesting item", "kind", path.kind.GetNamespace(), "key", path.key, "error", requestErr)
+		sp.status.updateLastError(makeDataSourceErrorInfo(requestErr))
+		if hse, ok := requestErr.(HttpStatusError); ok && !isHTTPErrorRecoverable(hse.Code) {
+			return false
+		}
+		return true
+	}
+	if err := sp.store.Upsert(path.kind, item); err != nil {
+		sp.logger.Error("Unexpected error storing item", "kind", path.kind.GetNamespace(), "key", path.key, "error", err)
+		sp.status.updateLastError(newDataSourceErrorInfo(DataSourceErrorKindStoreError, err))
+	} else {
+		sp.status.recordUpdate()
+	}
+	return false
+}
+
+// Retries the request for an indirect/patch event in the background. If a retry is already pending
+// for the same item, it will get the latest version, so no other retry is started.
+func (sp *streamProcessor) retryIndirectPatch(path parsedPath) {
+	sp.patchRetryLock.Lock()
+	if sp.pendingPatchRetries[path] {
+		sp.patchRetryLock.Unlock()
+		return
+	}
+	sp.pendingPatchRetries[path] = true
+	sp.patchRetryLock.Unlock()
+
+	go func() {
+		defer func() {
+			sp.patchRetryLock.Lock()
+			delete(sp.pendingPatchRetries, path)
+			sp.patchRetryLock.Unlock()
+		}()
+		backoff := newBackoffWithJitter(sp.patchRetryInitialDelay, sp.patchRetryMaxDelay, streamBackoffResetInterval)
+		for i := 0; i < indirectPatchMaxRetries; i++ {
+			delay := backoff.nextDelay(time.Now())
+			sp.logger.Info("Will retry request for updated item", "kind", path.kind.GetNamespace(), "key", path.key, "delay", delay)
+			select {
+			case <-sp.halt:
+				return
+			case <-time.After(delay):
+			}
+			if !sp.applyIndirectPatch(path) {
+				return
+			}
+		}
+		sp.logger.Warn("Giving up on request for updated item; it will be updated on the next reconciliation or reconnect",
+			"kind", path.kind.GetNamespace(), "key", path.key)
+	}()
+}
+
+// Runs reconcile at Config.StreamReconciliationInterval until the processor is closed.
+func (sp *streamProcessor) runReconciliation(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sp.halt:
+			return
+		case <-ticker.C:
+			sp.reconcile()
+		}
+	}
+}
+
+// Requests all flag data and upserts each item, so that only items that are newer than the ones in
+// the store replace them. Unlike a put event, this does not remove items that are missing from the
+// data, since they may have been added by a stream event after the request was made.
+//
+// If the data has not changed since the last reconciliation, the response comes from the HTTP cache
+// and there is nothing to do, unless storing the data failed last time; then it is applied again.
+func (sp *streamProcessor) reconcile() {
+	data, cached, err := sp.requestor.requestAllData(sp.reconcileIncomplete)
+	if err != nil {
+		sp.logger.Warn("Error when requesting flag data for reconciliation", "error", err)
+		return
+	}
+	if !cached || sp.reconcileIncomplete {
+		sp.logger.Debug("Reconciling flag data with the stream", "flags", len(data.Flags), "segments", len(data.Segments))
+		sp.reconcileIncomplete = true
+		for _, flag := range data.Flags {
+			if !sp.upsertForReconciliation(Features, flag) {
+				return
+			}
+		}
+		for _, segment := range data.Segments {
+			if !sp.upsertForReconciliation(Segments, segment) {
+				return
+			}
+		}
+		sp.reconcileIncomplete = false
+	}
+	sp.status.recordUpdate()
+}
+
+func (sp *streamProcessor) upsertForReconciliation(kind VersionedDataKind, item VersionedData) bool {
+	if err := sp.store.Upsert(kind, item); err != nil {
+		sp.logger.Error("Unexpected error storing item", "kind", kind.GetNamespace(), "key", item.GetKey(), "error", err)
+		sp.status.updateLastError(newDataSourceErrorInfo(DataSourceErrorKindStoreError, err))
+		return false
+	}
+	return true
+}
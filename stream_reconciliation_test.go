@@ -0,0 +1,201 @@
+package ldclient
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Starts a stream server that sends the given events on each connection and then keeps it open.
+func startStreamServerWithEvents(events string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(200)
+		fmt.Fprint(w, events)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+}
+
+const reconciliationTestPutEvent = "event: put\ndata: {\"path\": \"/\", \"data\": {\"flags\": {" +
+	"\"my-flag\": {\"key\": \"my-flag\", \"version\": 1}, \"newer-flag\": {\"key\": \"newer-flag\", \"version\": 7}}, " +
+	"\"segments\": {}}}\n\n"
+
+func startReconciliationTestProcessor(t *testing.T, streamServer, sdkServer *httptest.Server,
+	configure func(*Config)) (*streamProcessor, FeatureStore) {
+	cfg := Config{
+		StreamUri:    streamServer.URL,
+		BaseUri:      sdkServer.URL,
+		FeatureStore: NewInMemoryFeatureStore(log.New(ioutil.Discard, "", 0)),
+		Logger:       log.New(ioutil.Discard, "", 0),
+	}
+	configure(&cfg)
+	sp := newStreamProcessor("sdkKey", cfg, newRequestor("sdkKey", cfg))
+	sp.patchRetryInitialDelay = time.Millisecond
+	sp.patchRetryMaxDelay = time.Millisecond
+	closeWhenReady := make(chan struct{})
+	sp.Start(closeWhenReady)
+	select {
+	case <-closeWhenReady:
+	case <-time.After(time.Second * 3):
+		require.Fail(t, "start timeout")
+	}
+	return sp, cfg.FeatureStore
+}
+
+func waitForFlagVersion(t *testing.T, store FeatureStore, key string, version int) {
+	deadline := time.Now().Add(time.Second * 3)
+	for {
+		item, err := store.Get(Features, key)
+		require.NoError(t, err)
+		if item != nil && item.GetVersion() == version {
+			return
+		}
+		if time.Now().After(deadline) {
+			require.Fail(t, "timed out waiting for flag version", "key: %s, version: %d", key, version)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStreamProcessorRetriesFailedIndirectPatch(t *testing.T) {
+	streamServer := startStreamServerWithEvents(reconciliationTestPutEvent + "event: indirect/patch\ndata: /flags/my-flag\n\n")
+	defer streamServer.Close()
+	var requests int32
+	sdkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/sdk/latest-flags/my-flag", r.URL.Path)
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			w.WriteHeader(503)
+			return
+		}
+		_, _ = w.Write([]byte(`{"key": "my-flag", "version": 5}`))
+	}))
+	defer sdkServer.Close()
+
+	sp, store := startReconciliationTestProcessor(t, streamServer, sdkServer, func(*Config) {})
+	defer sp.Close()
+
+	waitForFlagVersion(t, store, "my-flag", 5)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+}
+
+func TestStreamProcessorDoesNotRetryIndirectPatchAfterUnrecoverableError(t *testing.T) {
+	streamServer := startStreamServerWithEvents(reconciliationTestPutEvent + "event: indirect/patch\ndata: /flags/my-flag\n\n")
+	defer streamServer.Close()
+	var requests int32
+	sdkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(401)
+	}))
+	defer sdkServer.Close()
+
+	sp, store := startReconciliationTestProcessor(t, streamServer, sdkServer, func(*Config) {})
+	defer sp.Close()
+
+	deadline := time.Now().Add(time.Second * 3)
+	for atomic.LoadInt32(&requests) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	waitForFlagVersion(t, store, "my-flag", 1)
+}
+
+func TestStreamProcessorReconcilesDataWhileStreaming(t *testing.T) {
+	streamServer := startStreamServerWithEvents(reconciliationTestPutEvent)
+	defer streamServer.Close()
+	sdkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, LatestAllPath, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"flags": {"my-flag": {"key": "my-flag", "version": 3}, ` +
+			`"newer-flag": {"key": "newer-flag", "version": 6}}, ` +
+			`"segments": {"my-segment": {"key": "my-segment", "version": 2}}}`))
+	}))
+	defer sdkServer.Close()
+
+	sp, store := startReconciliationTestProcessor(t, streamServer, sdkServer, func(cfg *Config) {
+		cfg.StreamReconciliationInterval = time.Millisecond * 10
+	})
+	defer sp.Close()
+
+	waitForFlagVersion(t, store, "my-flag", 3)
+	segment, err := store.Get(Segments, "my-segment")
+	require.NoError(t, err)
+	require.NotNil(t, segment)
+	assert.Equal(t, 2, segment.GetVersion())
+	// An item that is newer in the store than in the reconciled data is not replaced
+	waitForFlagVersion(t, store, "newer-flag", 7)
+}
+
+// A FeatureStore whose Upsert fails while failUpserts is nonzero.
+type failingUpsertFeatureStore struct {
+	FeatureStore
+	failUpserts int32
+}
+
+func (s *failingUpsertFeatureStore) Upsert(kind VersionedDataKind, item VersionedData) error {
+	if atomic.LoadInt32(&s.failUpserts) != 0 {
+		return errors.New("sorry")
+	}
+	return s.FeatureStore.Upsert(kind, item)
+}
+
+func TestStreamProcessorReconcilesCachedDataAgainIfStoringItFailed(t *testing.T) {
+	streamServer := startStreamServerWithEvents(reconciliationTestPutEvent)
+	defer streamServer.Close()
+	var requests, cachedResponses int32
+	sdkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&cachedResponses, 1)
+			w.WriteHeader(304)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"flags": {"my-flag": {"key": "my-flag", "version": 3}}, "segments": {}}`))
+	}))
+	defer sdkServer.Close()
+	store := &failingUpsertFeatureStore{FeatureStore: NewInMemoryFeatureStore(log.New(ioutil.Discard, "", 0)), failUpserts: 1}
+
+	sp, _ := startReconciliationTestProcessor(t, streamServer, sdkServer, func(cfg *Config) {
+		cfg.FeatureStore = store
+		cfg.StreamReconciliationInterval = time.Millisecond * 10
+	})
+	defer sp.Close()
+
+	deadline := time.Now().Add(time.Second * 3)
+	for atomic.LoadInt32(&cachedResponses) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	require.NotEqual(t, int32(0), atomic.LoadInt32(&cachedResponses))
+	waitForFlagVersion(t, store, "my-flag", 1)
+
+	atomic.StoreInt32(&store.failUpserts, 0)
+	waitForFlagVersion(t, store, "my-flag", 3)
+}
+
+func TestStreamProcessorDoesNotReconcileByDefault(t *testing.T) {
+	streamServer := startStreamServerWithEvents(reconciliationTestPutEvent)
+	defer streamServer.Close()
+	var requests int32
+	sdkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer sdkServer.Close()
+
+	sp, _ := startReconciliationTestProcessor(t, streamServer, sdkServer, func(*Config) {})
+	defer sp.Close()
+
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&requests))
+}
@@ -2,6 +2,7 @@ package ldclient
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -17,18 +18,37 @@ const (
 	patchEvent         = "patch"
 	deleteEvent        = "delete"
 	indirectPatchEvent = "indirect/patch"
+
+	// If a stream connection stays up for this long, the next reconnection delay starts over.
+	streamBackoffResetInterval = time.Minute
 )
 
 type streamProcessor struct {
 	store              FeatureStore
 	requestor          *requestor
 	stream             *es.Stream
+	streamLock         sync.Mutex
+	httpClient         *http.Client
+	backoff            *backoffWithJitter
 	config             Config
 	sdkKey             string
 	setInitializedOnce sync.Once
 	isInitialized      bool
 	halt               chan struct{}
 	closeOnce          sync.Once
+	status             *dataSourceStatusManager
+	connectionHandler  streamConnectionHandler // optional; see streamingWithPollingFallback
+	metrics            Metrics
+	logger             LeveledLogger
+
+	// Used for retrying failed requests for indirect/patch events; see stream_reconciliation.go.
+	pendingPatchRetries    map[parsedPath]bool
+	patchRetryLock         sync.Mutex
+	patchRetryInitialDelay time.Duration
+	patchRetryMaxDelay     time.Duration
+
+	// Set if the last reconciliation did not store all of the data; only used by runReconciliation.
+	reconcileIncomplete bool
 }
 
 type putData struct {
@@ -56,9 +76,17 @@ func (sp *streamProcessor) Initialized() bool {
 	return sp.isInitialized
 }
 
+func (sp *streamProcessor) setDataSourceStatusManager(m *dataSourceStatusManager) {
+	sp.status = m
+}
+
 func (sp *streamProcessor) Start(closeWhenReady chan<- struct{}) {
-	sp.config.Logger.Printf("Starting LaunchDarkly streaming connection")
+	sp.logger.Info("Starting LaunchDarkly streaming connection")
 	go sp.subscribe(closeWhenReady)
+	if interval := sp.config.StreamReconciliationInterval; interval > 0 {
+		sp.logger.Info("Starting periodic reconciliation of flag data", "interval", interval)
+		go sp.runReconciliation(interval)
+	}
 }
 
 type parsedPath struct {
@@ -80,165 +108,261 @@ func parsePath(path string) (parsedPath, error) {
 	return parsedPath, nil
 }
 
-func (sp *streamProcessor) events(closeWhenReady chan<- struct{}) {
-	var readyOnce sync.Once
-	notifyReady := func() {
-		readyOnce.Do(func() {
-			close(closeWhenReady)
-		})
-	}
-	// Ensure we stop waiting for initialization if we exit, even if initialization fails
-	defer notifyReady()
-
-	// Consume remaining Events and Errors so we can garbage collect
+// Processes events from one stream connection until the connection fails or the processor is
+// closed. Returns true if the processor should reconnect.
+func (sp *streamProcessor) consumeStream(stream *es.Stream, notifyReady func()) bool {
+	// Close the stream and consume remaining Events and Errors so we can garbage collect
 	defer func() {
-		for range sp.stream.Events {
-		}
-		for range sp.stream.Errors {
+		stream.Close()
+		for events, errs := stream.Events, stream.Errors; events != nil || errs != nil; {
+			select {
+			case _, ok := <-events:
+				if !ok {
+					events = nil
+				}
+			case _, ok := <-errs:
+				if !ok {
+					errs = nil
+				}
+			}
 		}
 	}()
 
 	for {
 		select {
-		case event, ok := <-sp.stream.Events:
+		case event, ok := <-stream.Events:
 			if !ok {
-				sp.config.Logger.Printf("Event stream closed.")
-				return
+				sp.logger.Info("Event stream closed")
+				return true
 			}
 			switch event.Event() {
 			case putEvent:
 				var put putData
 				if err := json.Unmarshal([]byte(event.Data()), &put); err != nil {
-					sp.config.Logger.Printf("ERROR: Unexpected error unmarshalling PUT json: %+v", err)
+					sp.logger.Error("Unexpected error unmarshalling PUT json", "error", err)
+					sp.status.updateLastError(newDataSourceErrorInfo(DataSourceErrorKindInvalidData, err))
 					break
 				}
 				err := sp.store.Init(MakeAllVersionedDataMap(put.Data.Flags, put.Data.Segments))
 				if err != nil {
-					sp.config.Logger.Printf("Error initializing store: %s", err)
-					return
+					sp.logger.Error("Error initializing store", "error", err)
+					sp.status.updateStatus(DataSourceStateInterrupted, newDataSourceErrorInfo(DataSourceErrorKindStoreError, err))
+					return true // reconnecting will give us a new data set to retry with
+				}
+				sp.status.updateStatus(DataSourceStateValid, nil)
+				if sp.connectionHandler != nil {
+					sp.connectionHandler.streamConnected()
 				}
 				sp.setInitializedOnce.Do(func() {
-					sp.config.Logger.Printf("Started LaunchDarkly streaming client")
+					sp.logger.Info("Started LaunchDarkly streaming client")
 					sp.isInitialized = true
 					notifyReady()
 				})
 			case patchEvent:
 				var patch patchData
 				if err := json.Unmarshal([]byte(event.Data()), &patch); err != nil {
-					sp.config.Logger.Printf("ERROR: Unexpected error unmarshalling PATCH json: %+v", err)
+					sp.logger.Error("Unexpected error unmarshalling PATCH json", "error", err)
+					sp.status.updateLastError(newDataSourceErrorInfo(DataSourceErrorKindInvalidData, err))
 					break
 				}
 				path, err := parsePath(patch.Path)
 				if err != nil {
-					sp.config.Logger.Printf("ERROR: Unable to process event %s: %s", event.Event(), err)
+					sp.logger.Error("Unable to process stream event", "event", event.Event(), "error", err)
 					break
 				}
 				item := path.kind.GetDefaultItem().(VersionedData)
 				if err = json.Unmarshal(patch.Data, item); err != nil {
-					sp.config.Logger.Printf("ERROR: Unexpected error unmarshalling json for %s item: %+v", path.kind, err)
+					sp.logger.Error("Unexpected error unmarshalling json for item", "kind", path.kind.GetNamespace(), "error", err)
+					sp.status.updateLastError(newDataSourceErrorInfo(DataSourceErrorKindInvalidData, err))
 					break
 				}
 				if err = sp.store.Upsert(path.kind, item); err != nil {
-					sp.config.Logger.Printf("ERROR: Unexpected error storing segment json: %+v", err)
+					sp.logger.Error("Unexpected error storing item", "kind", path.kind.GetNamespace(), "key", item.GetKey(), "error", err)
+					sp.status.updateLastError(newDataSourceErrorInfo(DataSourceErrorKindStoreError, err))
+				} else {
+					sp.status.recordUpdate()
 				}
 			case deleteEvent:
 				var data deleteData
 				if err := json.Unmarshal([]byte(event.Data()), &data); err != nil {
-					sp.config.Logger.Printf("ERROR: Unexpected error unmarshalling DELETE json: %+v", err)
+					sp.logger.Error("Unexpected error unmarshalling DELETE json", "error", err)
+					sp.status.updateLastError(newDataSourceErrorInfo(DataSourceErrorKindInvalidData, err))
 					break
 				}
 				path, err := parsePath(data.Path)
 				if err != nil {
-					sp.config.Logger.Printf("ERROR: Unable to process event %s: %s", event.Event(), err)
+					sp.logger.Error("Unable to process stream event", "event", event.Event(), "error", err)
 					break
 				}
 				if err = sp.store.Delete(path.kind, path.key, data.Version); err != nil {
-					sp.config.Logger.Printf(`ERROR: Unexpected error deleting %s object "%s": %s`, path.kind, path.key, err)
+					sp.logger.Error("Unexpected error deleting item", "kind", path.kind.GetNamespace(), "key", path.key, "error", err)
+					sp.status.updateLastError(newDataSourceErrorInfo(DataSourceErrorKindStoreError, err))
+				} else {
+					sp.status.recordUpdate()
 				}
 			case indirectPatchEvent:
 				path, err := parsePath(event.Data())
 				if err != nil {
-					sp.config.Logger.Printf("ERROR: Unable to process event %s: %s", event.Event(), err)
-					break
-				}
-				item, requestErr := sp.requestor.requestResource(path.kind, path.key)
-				if requestErr != nil {
-					sp.config.Logger.Printf(`ERROR: Unexpected error requesting %s item "%s": %+v`, path.kind, path.key, err)
+					sp.logger.Error("Unable to process stream event", "event", event.Event(), "error", err)
 					break
 				}
-				if err = sp.store.Upsert(path.kind, item); err != nil {
-					sp.config.Logger.Printf(`ERROR: Unexpected error store %s item "%s": %+v`, path.kind, path.key, err)
+				if sp.applyIndirectPatch(path) {
+					sp.retryIndirectPatch(path)
 				}
 			default:
-				sp.config.Logger.Printf("Unexpected event found in stream: %s", event.Event())
+				sp.logger.Warn("Unexpected event found in stream", "event", event.Event())
 			}
-		case err, ok := <-sp.stream.Errors:
+		case err, ok := <-stream.Errors:
 			if !ok {
-				sp.config.Logger.Printf("Event error stream closed.")
-				return // Otherwise we will spin in this loop
+				sp.logger.Info("Event error stream closed")
+				return true
 			}
-			if err != io.EOF {
-				sp.config.Logger.Printf("ERROR: Error encountered processing stream: %+v", err)
+			// The eventsource library would reconnect by itself, but we close the stream and reconnect
+			// with our own backoff instead; until then, our data may be out of date.
+			if err == io.EOF {
+				sp.status.updateStatus(DataSourceStateInterrupted,
+					newDataSourceErrorInfo(DataSourceErrorKindNetworkError, errors.New("stream connection was closed")))
+			} else if err == errStreamReadTimeout {
+				sp.logger.Warn("No data received from stream within read timeout; will reconnect", "timeout", sp.readTimeout())
+				sp.status.updateStatus(DataSourceStateInterrupted, newDataSourceErrorInfo(DataSourceErrorKindNetworkError, err))
+			} else {
+				sp.logger.Error("Error encountered processing stream", "error", err)
 				if sp.checkIfPermanentFailure(err) {
-					sp.closeOnce.Do(func() {
-						sp.config.Logger.Printf("Closing event stream.")
-						sp.stream.Close()
-					})
-					return
+					sp.status.updateStatus(DataSourceStateOff, makeDataSourceErrorInfo(err))
+					sp.reportFailure(true)
+					return false
 				}
+				sp.status.updateStatus(DataSourceStateInterrupted, makeDataSourceErrorInfo(err))
 			}
+			return true
 		case <-sp.halt:
-			return
+			return false
 		}
 	}
 }
 
 func newStreamProcessor(sdkKey string, config Config, requestor *requestor) *streamProcessor {
+	initialDelay := config.StreamInitialReconnectDelay
+	if initialDelay <= 0 {
+		initialDelay = DefaultStreamInitialReconnectDelay
+	}
+	maxDelay := config.StreamMaxReconnectDelay
+	if maxDelay <= 0 {
+		maxDelay = DefaultStreamMaxReconnectDelay
+	}
 	sp := &streamProcessor{
-		store:     config.FeatureStore,
-		config:    config,
-		sdkKey:    sdkKey,
-		requestor: requestor,
-		halt:      make(chan struct{}),
+		store:                  config.FeatureStore,
+		config:                 config,
+		sdkKey:                 sdkKey,
+		requestor:              requestor,
+		backoff:                newBackoffWithJitter(initialDelay, maxDelay, streamBackoffResetInterval),
+		pendingPatchRetries:    make(map[parsedPath]bool),
+		patchRetryInitialDelay: indirectPatchRetryInitialDelay,
+		patchRetryMaxDelay:     indirectPatchRetryMaxDelay,
+		halt:                   make(chan struct{}),
+		metrics:                metricsOrDefault(config.Metrics),
+		logger:                 loggerForConfig(config),
+	}
+	sp.httpClient = &http.Client{
+		Transport: readTimeoutTransport{base: http.DefaultTransport, timeout: sp.readTimeout()},
 	}
 
 	return sp
 }
 
+func (sp *streamProcessor) readTimeout() time.Duration {
+	if sp.config.StreamReadTimeout <= 0 {
+		return DefaultStreamReadTimeout
+	}
+	return sp.config.StreamReadTimeout
+}
+
+// Connects to the stream, and reconnects after a backoff delay whenever the connection fails or is
+// lost, until the processor is closed or there is an error that we cannot recover from.
 func (sp *streamProcessor) subscribe(closeWhenReady chan<- struct{}) {
-	for {
-		req, _ := http.NewRequest("GET", sp.config.StreamUri+"/all", nil)
-		req.Header.Add("Authorization", sp.sdkKey)
-		req.Header.Add("User-Agent", sp.config.UserAgent)
-		sp.config.Logger.Printf("Connecting to LaunchDarkly stream using URL: %s", req.URL.String())
+	var readyOnce sync.Once
+	notifyReady := func() {
+		readyOnce.Do(func() {
+			close(closeWhenReady)
+		})
+	}
+	// Ensure we stop waiting for initialization if we exit, even if initialization fails
+	defer notifyReady()
 
-		if stream, err := es.SubscribeWithRequest("", req); err != nil {
+	for {
+		stream, err := sp.connect()
+		if err != nil {
 			if sp.checkIfPermanentFailure(err) {
-				close(closeWhenReady)
+				sp.status.updateStatus(DataSourceStateOff, makeDataSourceErrorInfo(err))
+				sp.reportFailure(true)
 				return
 			}
-
-			// Halt immediately if we've been closed already
-			select {
-			case <-sp.halt:
-				close(closeWhenReady)
+			sp.status.updateStatus(DataSourceStateInterrupted, makeDataSourceErrorInfo(err))
+			sp.reportFailure(false)
+		} else {
+			connectedTime := time.Now()
+			sp.backoff.setGoodSince(connectedTime)
+			reconnect := sp.consumeStream(stream, notifyReady)
+			sp.metrics.ObserveDuration(MetricStreamSessionDuration, time.Since(connectedTime))
+			if !reconnect {
 				return
-			default:
-				time.Sleep(2 * time.Second)
 			}
-		} else {
-			sp.stream = stream
-			sp.stream.Logger = sp.config.Logger
+			sp.reportFailure(false)
+		}
 
-			go sp.events(closeWhenReady)
+		// Halt immediately if we've been closed already
+		select {
+		case <-sp.halt:
+			return
+		default:
+		}
+		delay := sp.backoff.nextDelay(time.Now())
+		sp.metrics.ObserveDuration(MetricStreamReconnectDelay, delay)
+		sp.logger.Info("Will reconnect to LaunchDarkly stream", "delay", delay)
+		select {
+		case <-sp.halt:
 			return
+		case <-time.After(delay):
 		}
 	}
 }
 
+// Makes one attempt to open the stream.
+func (sp *streamProcessor) connect() (*es.Stream, error) {
+	req, _ := http.NewRequest("GET", sp.config.StreamUri+"/all", nil)
+	req.Header.Add("Authorization", sp.sdkKey)
+	req.Header.Add("User-Agent", sp.config.UserAgent)
+	sp.logger.Info("Connecting to LaunchDarkly stream", "url", req.URL.String())
+
+	startTime := time.Now()
+	stream, err := es.SubscribeWith("", sp.httpClient, req)
+	sp.metrics.ObserveDuration(MetricStreamConnectionDuration, time.Since(startTime), MetricLabelResult, resultLabel(err))
+	sp.metrics.AddCount(MetricStreamConnectionAttempts, 1, MetricLabelResult, resultLabel(err))
+	if err != nil {
+		return nil, err
+	}
+	stream.SetLogger(NewPrintfLoggerAdapter(sp.logger))
+
+	sp.streamLock.Lock()
+	defer sp.streamLock.Unlock()
+	sp.stream = stream
+	select {
+	case <-sp.halt:
+		stream.Close() // Close was called while we were connecting; consumeStream will see the halt
+	default:
+	}
+	return stream, nil
+}
+
+func (sp *streamProcessor) reportFailure(permanent bool) {
+	if sp.connectionHandler != nil {
+		sp.connectionHandler.streamFailed(permanent)
+	}
+}
+
 func (sp *streamProcessor) checkIfPermanentFailure(err error) bool {
 	if se, ok := err.(es.SubscriptionError); ok {
-		sp.config.Logger.Printf("ERROR: %s", httpErrorMessage(se.Code, "streaming connection", "will retry"))
+		sp.logger.Error(httpErrorMessage(se.Code, "streaming connection", "will retry"))
 		if !isHTTPErrorRecoverable(se.Code) {
 			return true
 		}
@@ -249,11 +373,13 @@ func (sp *streamProcessor) checkIfPermanentFailure(err error) bool {
 // Close instructs the processor to stop receiving updates
 func (sp *streamProcessor) Close() error {
 	sp.closeOnce.Do(func() {
-		sp.config.Logger.Printf("Closing event stream.")
+		sp.logger.Info("Closing event stream")
+		close(sp.halt)
+		sp.streamLock.Lock()
 		if sp.stream != nil {
 			sp.stream.Close()
 		}
-		close(sp.halt)
+		sp.streamLock.Unlock()
 	})
 	return nil
 }
@@ -0,0 +1,285 @@
+package ldclient
+
+import (
+	"sync"
+	"time"
+)
+
+// Implemented by streamingWithPollingFallback, to observe the connection of a streamProcessor.
+type streamConnectionHandler interface {
+	// Called when the stream has received a full data set.
+	streamConnected()
+	// Called when an attempt to connect the stream failed, or the connection was lost. If permanent is
+	// true, the error cannot be recovered from, and the streamProcessor has stopped.
+	streamFailed(permanent bool)
+}
+
+// An UpdateProcessor that uses a streamProcessor, but switches to a pollingProcessor if the stream
+// fails repeatedly or stays down for too long, as happens on networks that block server-sent events.
+// While polling, it periodically tries to connect the stream again, and switches back to streaming
+// if that succeeds. This is used instead of a streamProcessor if Config.StreamFallbackFailures or
+// Config.StreamFallbackTimeout is set.
+type streamingWithPollingFallback struct {
+	sdkKey        string
+	config        Config
+	requestor     *requestor
+	status        *dataSourceStatusManager
+	probeInterval time.Duration
+	logger        LeveledLogger
+
+	// Set by the streamProcessor through the streamConnectionHandler methods.
+	connected      bool
+	failures       int
+	unhealthySince time.Time
+	stopped        bool
+	checkCh        chan struct{}
+
+	polling       bool
+	isInitialized bool
+	lock          sync.Mutex
+	halt          chan struct{}
+	closeOnce     sync.Once
+}
+
+// Reports the result of a single attempt to connect the stream while polling.
+type streamProbe struct {
+	resultCh chan bool
+}
+
+func newStreamingWithPollingFallback(sdkKey string, config Config, requestor *requestor) *streamingWithPollingFallback {
+	probeInterval := config.StreamFallbackProbeInterval
+	if probeInterval <= 0 {
+		probeInterval = DefaultStreamFallbackProbeInterval
+	}
+	return &streamingWithPollingFallback{
+		sdkKey:        sdkKey,
+		config:        config,
+		requestor:     requestor,
+		probeInterval: probeInterval,
+		logger:        loggerForConfig(config),
+		checkCh:       make(chan struct{}, 1),
+		halt:          make(chan struct{}),
+	}
+}
+
+func (f *streamingWithPollingFallback) setDataSourceStatusManager(m *dataSourceStatusManager) {
+	f.status = m
+}
+
+func (f *streamingWithPollingFallback) Initialized() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.isInitialized
+}
+
+func (f *streamingWithPollingFallback) Start(closeWhenReady chan<- struct{}) {
+	go f.run(closeWhenReady)
+}
+
+// Close stops whichever processor is active.
+func (f *streamingWithPollingFallback) Close() error {
+	f.closeOnce.Do(func() {
+		close(f.halt)
+	})
+	return nil
+}
+
+// Returns true if the client is currently polling because the stream failed.
+func (f *streamingWithPollingFallback) isPolling() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.polling
+}
+
+func (f *streamingWithPollingFallback) run(closeWhenReady chan<- struct{}) {
+	var readyOnce sync.Once
+	notifyReady := func() {
+		readyOnce.Do(func() {
+			close(closeWhenReady)
+		})
+	}
+	// Ensure we stop waiting for initialization if we exit, even if initialization fails
+	defer notifyReady()
+
+	for {
+		if !f.runStreaming(notifyReady) {
+			return
+		}
+		if !f.runPolling(notifyReady) {
+			return
+		}
+	}
+}
+
+// Runs a streamProcessor until it should fall back to polling, in which case it returns true, or until
+// the stream stops permanently or we are closed, in which case it returns false.
+func (f *streamingWithPollingFallback) runStreaming(notifyReady func()) bool {
+	f.lock.Lock()
+	f.polling = false
+	f.connected = false
+	f.failures = 0
+	f.unhealthySince = time.Now()
+	f.stopped = false
+	f.lock.Unlock()
+
+	sp := newStreamProcessor(f.sdkKey, f.config, f.requestor)
+	sp.setDataSourceStatusManager(f.status)
+	sp.connectionHandler = f
+	defer sp.Close()
+	readyCh := make(chan struct{})
+	sp.Start(readyCh)
+
+	// The timeout is checked at a tenth of its interval, in addition to whenever the stream fails.
+	var timeoutCh <-chan time.Time
+	if f.config.StreamFallbackTimeout > 0 {
+		ticker := time.NewTicker(f.config.StreamFallbackTimeout/10 + 1)
+		defer ticker.Stop()
+		timeoutCh = ticker.C
+	}
+	for {
+		select {
+		case <-readyCh:
+			readyCh = nil
+			if !sp.Initialized() {
+				return false // the stream failed permanently, as with an invalid SDK key
+			}
+			f.setInitialized()
+			notifyReady()
+		case <-f.checkCh:
+		case <-timeoutCh:
+		case <-f.halt:
+			return false
+		}
+		fallBack, stopped := f.shouldFallBack(time.Now())
+		if stopped {
+			return false
+		}
+		if fallBack {
+			f.logger.Warn("Streaming connection is not working; falling back to polling",
+				"probeInterval", f.probeInterval)
+			return true
+		}
+	}
+}
+
+// Runs a pollingProcessor, and periodically tries to connect the stream. Returns true if the stream
+// connected, or false if polling stopped permanently or we are closed.
+func (f *streamingWithPollingFallback) runPolling(notifyReady func()) bool {
+	f.lock.Lock()
+	f.polling = true
+	f.lock.Unlock()
+
+	pp := newPollingProcessor(f.config, f.requestor)
+	pp.setDataSourceStatusManager(f.status)
+	defer pp.Close()
+	readyCh := make(chan struct{})
+	pp.Start(readyCh)
+
+	probeTicker := time.NewTicker(f.probeInterval)
+	defer probeTicker.Stop()
+	var probe *streamProbe
+	var probeResultCh <-chan bool
+	var closeProbe func()
+	defer func() {
+		if closeProbe != nil {
+			closeProbe()
+		}
+	}()
+	for {
+		select {
+		case <-readyCh:
+			readyCh = nil
+			if !pp.Initialized() {
+				return false // polling failed permanently, as with an invalid SDK key
+			}
+			f.setInitialized()
+			notifyReady()
+		case <-probeTicker.C:
+			if f.status != nil && f.status.getStatus().State == DataSourceStateOff {
+				return false // polling failed permanently after it was initialized
+			}
+			if probe == nil {
+				f.logger.Info("Checking whether the streaming connection is working again")
+				probe = &streamProbe{resultCh: make(chan bool, 1)}
+				probeResultCh = probe.resultCh
+				closeProbe = f.startProbe(probe)
+			}
+		case ok := <-probeResultCh:
+			closeProbe()
+			probe, probeResultCh, closeProbe = nil, nil, nil
+			if ok {
+				f.logger.Info("Streaming connection is working again; switching back from polling")
+				return true
+			}
+		case <-f.halt:
+			return false
+		}
+	}
+}
+
+// Starts a streamProcessor that reports whether it connects successfully, without updating the data
+// source status. It puts the data that it receives into a store of its own, so that it does not
+// overwrite the data from polling; if it succeeds, runStreaming connects a new stream. Returns a
+// function that stops it.
+func (f *streamingWithPollingFallback) startProbe(probe *streamProbe) func() {
+	config := f.config
+	config.FeatureStore = NewInMemoryFeatureStore(nil)
+	config.StreamReconciliationInterval = 0
+	sp := newStreamProcessor(f.sdkKey, config, f.requestor)
+	sp.connectionHandler = probe
+	sp.Start(make(chan struct{}))
+	return func() { _ = sp.Close() }
+}
+
+func (f *streamingWithPollingFallback) setInitialized() {
+	f.lock.Lock()
+	f.isInitialized = true
+	f.lock.Unlock()
+}
+
+// Returns whether we should switch to polling, and whether the stream has stopped permanently.
+func (f *streamingWithPollingFallback) shouldFallBack(now time.Time) (bool, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.stopped || f.connected {
+		return false, f.stopped
+	}
+	return (f.config.StreamFallbackFailures > 0 && f.failures >= f.config.StreamFallbackFailures) ||
+		(f.config.StreamFallbackTimeout > 0 && now.Sub(f.unhealthySince) >= f.config.StreamFallbackTimeout), false
+}
+
+func (f *streamingWithPollingFallback) streamConnected() {
+	f.lock.Lock()
+	f.connected = true
+	f.failures = 0
+	f.lock.Unlock()
+}
+
+func (f *streamingWithPollingFallback) streamFailed(permanent bool) {
+	f.lock.Lock()
+	f.stopped = permanent
+	if f.connected {
+		f.connected = false
+		f.unhealthySince = time.Now()
+	}
+	f.failures++
+	f.lock.Unlock()
+	select {
+	case f.checkCh <- struct{}{}:
+	default:
+	}
+}
+
+func (p *streamProbe) streamConnected() {
+	select {
+	case p.resultCh <- true:
+	default:
+	}
+}
+
+func (p *streamProbe) streamFailed(permanent bool) {
+	select {
+	case p.resultCh <- false:
+	default:
+	}
+}
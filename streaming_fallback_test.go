@@ -0,0 +1,224 @@
+package ldclient
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Starts a stream server that responds with the status returned by statusFn for each connection
+// attempt (numbered from 1); for a 200 status, it sends a put event and keeps the connection open.
+func startFallbackStreamServer(statusFn func(attempt int32) int, attempts *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempt := atomic.AddInt32(attempts, 1)
+		status := statusFn(attempt)
+		if status != 200 {
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(200)
+		fmt.Fprint(w, "event: put\ndata: {\"path\": \"/\", \"data\": {\"flags\": {\"stream-flag\": {\"key\": \"stream-flag\", \"version\": 1}}, \"segments\": {}}}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+}
+
+func startFallbackPollingServer(polls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(polls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"flags": {"polled-flag": {"key": "polled-flag", "version": 1}}, "segments": {}}`))
+	}))
+}
+
+func makeFallbackTestConfig(streamServer, pollServer *httptest.Server) Config {
+	return Config{
+		StreamUri:                   streamServer.URL,
+		BaseUri:                     pollServer.URL,
+		FeatureStore:                NewInMemoryFeatureStore(log.New(ioutil.Discard, "", 0)),
+		Logger:                      log.New(ioutil.Discard, "", 0),
+		PollInterval:                time.Millisecond * 10,
+		StreamInitialReconnectDelay: time.Millisecond,
+		StreamMaxReconnectDelay:     time.Millisecond,
+		StreamFallbackProbeInterval: time.Hour,
+	}
+}
+
+func startFallbackProcessor(t *testing.T, cfg Config) (*streamingWithPollingFallback, *dataSourceStatusManager) {
+	f := newStreamingWithPollingFallback("sdkKey", cfg, newRequestor("sdkKey", cfg))
+	status := newDataSourceStatusManager()
+	f.setDataSourceStatusManager(status)
+	closeWhenReady := make(chan struct{})
+	f.Start(closeWhenReady)
+	select {
+	case <-closeWhenReady:
+	case <-time.After(time.Second * 3):
+		require.Fail(t, "start timeout")
+	}
+	return f, status
+}
+
+func waitForPollingState(t *testing.T, f *streamingWithPollingFallback, polling bool) {
+	deadline := time.Now().Add(time.Second * 3)
+	for f.isPolling() != polling {
+		if time.Now().After(deadline) {
+			require.Fail(t, "timed out waiting for fallback state", "polling: %t", polling)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFallbackProcessorUsesStreamWhenItWorks(t *testing.T) {
+	var attempts, polls int32
+	streamServer := startFallbackStreamServer(func(int32) int { return 200 }, &attempts)
+	defer streamServer.Close()
+	pollServer := startFallbackPollingServer(&polls)
+	defer pollServer.Close()
+	cfg := makeFallbackTestConfig(streamServer, pollServer)
+	cfg.StreamFallbackFailures = 2
+
+	f, status := startFallbackProcessor(t, cfg)
+	defer f.Close()
+
+	assert.True(t, f.Initialized())
+	assert.False(t, f.isPolling())
+	assert.Equal(t, DataSourceStateValid, status.getStatus().State)
+	flag, _ := cfg.FeatureStore.Get(Features, "stream-flag")
+	assert.NotNil(t, flag)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&polls))
+}
+
+func TestFallbackProcessorSwitchesToPollingAfterConsecutiveFailures(t *testing.T) {
+	var attempts, polls int32
+	streamServer := startFallbackStreamServer(func(int32) int { return 503 }, &attempts)
+	defer streamServer.Close()
+	pollServer := startFallbackPollingServer(&polls)
+	defer pollServer.Close()
+	cfg := makeFallbackTestConfig(streamServer, pollServer)
+	cfg.StreamFallbackFailures = 3
+
+	f, status := startFallbackProcessor(t, cfg)
+	defer f.Close()
+
+	assert.True(t, f.Initialized())
+	assert.True(t, f.isPolling())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+	assert.Equal(t, DataSourceStateValid, status.getStatus().State)
+	flag, _ := cfg.FeatureStore.Get(Features, "polled-flag")
+	assert.NotNil(t, flag)
+}
+
+func TestFallbackProcessorSwitchesToPollingAfterTimeout(t *testing.T) {
+	var attempts, polls int32
+	// The stream server accepts the connection, but never sends any data.
+	streamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(200)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer streamServer.Close()
+	pollServer := startFallbackPollingServer(&polls)
+	defer pollServer.Close()
+	cfg := makeFallbackTestConfig(streamServer, pollServer)
+	cfg.StreamFallbackTimeout = time.Millisecond * 50
+
+	f, _ := startFallbackProcessor(t, cfg)
+	defer f.Close()
+
+	assert.True(t, f.Initialized())
+	assert.True(t, f.isPolling())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestFallbackProcessorSwitchesBackToStreamingWhenProbeSucceeds(t *testing.T) {
+	var attempts, polls int32
+	streamServer := startFallbackStreamServer(func(attempt int32) int {
+		if attempt <= 3 { // the initial failures, and the first probe
+			return 503
+		}
+		return 200
+	}, &attempts)
+	defer streamServer.Close()
+	pollServer := startFallbackPollingServer(&polls)
+	defer pollServer.Close()
+	cfg := makeFallbackTestConfig(streamServer, pollServer)
+	cfg.StreamFallbackFailures = 2
+	cfg.StreamFallbackProbeInterval = time.Millisecond * 20
+
+	f, status := startFallbackProcessor(t, cfg)
+	defer f.Close()
+	assert.True(t, f.isPolling())
+
+	waitForPollingState(t, f, false)
+	// After the successful probe, it makes a new stream connection
+	waitForFlagVersion(t, cfg.FeatureStore, "stream-flag", 1)
+	assert.Equal(t, int32(5), atomic.LoadInt32(&attempts))
+	assert.Equal(t, DataSourceStateValid, status.getStatus().State)
+	pollsAfterSwitch := atomic.LoadInt32(&polls)
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, pollsAfterSwitch, atomic.LoadInt32(&polls))
+}
+
+func TestFallbackProbeDoesNotUpdateStore(t *testing.T) {
+	var attempts, polls int32
+	streamServer := startFallbackStreamServer(func(int32) int { return 200 }, &attempts)
+	defer streamServer.Close()
+	pollServer := startFallbackPollingServer(&polls)
+	defer pollServer.Close()
+	cfg := makeFallbackTestConfig(streamServer, pollServer)
+	f := newStreamingWithPollingFallback("sdkKey", cfg, newRequestor("sdkKey", cfg))
+
+	probe := &streamProbe{resultCh: make(chan bool, 1)}
+	closeProbe := f.startProbe(probe)
+	defer closeProbe()
+	select {
+	case ok := <-probe.resultCh:
+		assert.True(t, ok)
+	case <-time.After(time.Second * 3):
+		require.Fail(t, "timed out waiting for probe")
+	}
+
+	flag, err := cfg.FeatureStore.Get(Features, "stream-flag")
+	assert.NoError(t, err)
+	assert.Nil(t, flag)
+	assert.False(t, cfg.FeatureStore.Initialized())
+}
+
+func TestFallbackProcessorDoesNotFallBackAfterUnrecoverableError(t *testing.T) {
+	var attempts, polls int32
+	streamServer := startFallbackStreamServer(func(int32) int { return 401 }, &attempts)
+	defer streamServer.Close()
+	pollServer := startFallbackPollingServer(&polls)
+	defer pollServer.Close()
+	cfg := makeFallbackTestConfig(streamServer, pollServer)
+	cfg.StreamFallbackFailures = 1
+
+	f, status := startFallbackProcessor(t, cfg)
+	defer f.Close()
+
+	assert.False(t, f.Initialized())
+	assert.Equal(t, DataSourceStateOff, status.getStatus().State)
+	time.Sleep(time.Millisecond * 20)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&polls))
+}
+
+func TestDefaultUpdateProcessorUsesFallbackIfConfigured(t *testing.T) {
+	p, err := createDefaultUpdateProcessor("sdkKey", Config{Stream: true, StreamFallbackTimeout: time.Minute})
+	require.NoError(t, err)
+	assert.IsType(t, &streamingWithPollingFallback{}, p)
+
+	p, err = createDefaultUpdateProcessor("sdkKey", Config{Stream: true})
+	require.NoError(t, err)
+	assert.IsType(t, &streamProcessor{}, p)
+}
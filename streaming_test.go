@@ -1,15 +1,18 @@
 package ldclient
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/launchdarkly/eventsource"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type testEvent struct {
@@ -215,6 +218,8 @@ func testStreamProcessorUnrecoverableError(t *testing.T, statusCode int) {
 
 	sp := newStreamProcessor("sdkKey", cfg, nil)
 	defer sp.Close()
+	status := newDataSourceStatusManager()
+	sp.setDataSourceStatusManager(status)
 
 	closeWhenReady := make(chan struct{})
 
@@ -223,6 +228,9 @@ func testStreamProcessorUnrecoverableError(t *testing.T, statusCode int) {
 	select {
 	case <-closeWhenReady:
 		assert.False(t, sp.Initialized())
+		assert.Equal(t, DataSourceStateOff, status.getStatus().State)
+		assert.Equal(t, DataSourceErrorKindErrorResponse, status.getStatus().LastError.Kind)
+		assert.Equal(t, statusCode, status.getStatus().LastError.StatusCode)
 	case <-time.After(time.Second * 3):
 		assert.Fail(t, "Initialization shouldn't block after this error")
 	}
@@ -259,6 +267,9 @@ func testStreamProcessorRecoverableError(t *testing.T, statusCode int) {
 
 	sp := newStreamProcessor("sdkKey", cfg, nil)
 	defer sp.Close()
+	status := newDataSourceStatusManager()
+	statusCh := status.addListener()
+	sp.setDataSourceStatusManager(status)
 
 	closeWhenReady := make(chan struct{})
 	sp.Start(closeWhenReady)
@@ -269,4 +280,94 @@ func testStreamProcessorRecoverableError(t *testing.T, statusCode int) {
 	case <-time.After(time.Second * 3):
 		assert.Fail(t, "Should have successfully retried before now")
 	}
+
+	failed := expectDataSourceStatus(t, statusCh)
+	assert.Equal(t, DataSourceStateInitializing, failed.State)
+	assert.Equal(t, statusCode, failed.LastError.StatusCode)
+	recovered := expectDataSourceStatus(t, statusCh)
+	assert.Equal(t, DataSourceStateValid, recovered.State)
+	assert.Equal(t, statusCode, recovered.LastError.StatusCode)
+}
+
+func TestStreamProcessorReconnectsAfterConnectionIsLost(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempt := atomic.AddInt32(&attempts, 1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(200)
+		fmt.Fprintf(w, "event: put\ndata: {\"path\": \"/\", \"data\": {\"flags\": {\"my-flag\": {\"key\": \"my-flag\", \"version\": %d}}, \"segments\": {}}}\n\n", attempt)
+		w.(http.Flusher).Flush()
+		if attempt > 1 {
+			<-r.Context().Done() // keep the second connection open; the first one is closed
+		}
+	}))
+	defer ts.Close()
+
+	store := NewInMemoryFeatureStore(log.New(ioutil.Discard, "", 0))
+	metrics := newRecordingMetrics()
+	cfg := Config{
+		StreamUri:                   ts.URL,
+		FeatureStore:                store,
+		Logger:                      log.New(ioutil.Discard, "", 0),
+		Metrics:                     metrics,
+		StreamInitialReconnectDelay: time.Millisecond,
+	}
+
+	sp := newStreamProcessor("sdkKey", cfg, nil)
+	defer sp.Close()
+	status := newDataSourceStatusManager()
+	statusCh := status.addListener()
+	sp.setDataSourceStatusManager(status)
+
+	closeWhenReady := make(chan struct{})
+	sp.Start(closeWhenReady)
+	<-closeWhenReady
+
+	assert.Equal(t, DataSourceStateValid, waitForDataSourceState(t, statusCh, DataSourceStateValid).State)
+	interrupted := waitForDataSourceState(t, statusCh, DataSourceStateInterrupted)
+	assert.Equal(t, DataSourceErrorKindNetworkError, interrupted.LastError.Kind)
+	waitForDataSourceState(t, statusCh, DataSourceStateValid)
+	flag, err := store.Get(Features, "my-flag")
+	require.NoError(t, err)
+	assert.Equal(t, 2, flag.GetVersion())
+
+	assert.Equal(t, int64(2), metrics.getCount(MetricStreamConnectionAttempts, MetricLabelResult, MetricResultSuccess))
+	assert.Equal(t, 1, metrics.getDurationCount(MetricStreamSessionDuration))
+	assert.Equal(t, 1, metrics.getDurationCount(MetricStreamReconnectDelay))
+}
+
+func TestStreamProcessorStopsReconnectingWhenClosed(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(503)
+	}))
+	defer ts.Close()
+
+	cfg := Config{
+		StreamUri:                   ts.URL,
+		FeatureStore:                NewInMemoryFeatureStore(log.New(ioutil.Discard, "", 0)),
+		Logger:                      log.New(ioutil.Discard, "", 0),
+		StreamInitialReconnectDelay: time.Millisecond,
+		StreamMaxReconnectDelay:     time.Millisecond,
+	}
+
+	sp := newStreamProcessor("sdkKey", cfg, nil)
+	closeWhenReady := make(chan struct{})
+	sp.Start(closeWhenReady)
+	deadline := time.Now().Add(time.Second * 3)
+	for atomic.LoadInt32(&attempts) < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, atomic.LoadInt32(&attempts) >= 3)
+
+	sp.Close()
+	select {
+	case <-closeWhenReady:
+	case <-time.After(time.Second):
+		assert.Fail(t, "processor did not stop after being closed")
+	}
+	attemptsAfterClose := atomic.LoadInt32(&attempts)
+	time.Sleep(time.Millisecond * 50)
+	assert.True(t, atomic.LoadInt32(&attempts) <= attemptsAfterClose+1)
 }
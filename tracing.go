@@ -0,0 +1,121 @@
+package ldclient
+
+import "context"
+
+// Tracer is an interface for reporting the work done by the client as tracing spans, so that it can
+// appear in the application's traces. It is set with Config.Tracer. Its methods are modeled on those
+// of OpenTelemetry, so an adapter for that, or for a similar tracing library, only needs to delegate
+// to it: for instance, StartSpan can call trace.Tracer.Start, and the returned Span can wrap the
+// OpenTelemetry span.
+//
+// Spans are reported for flag evaluations by the variation methods and AllFlagsState; for feature
+// store operations, if the store is based on utils.FeatureStoreWrapper (as are the Redis, Consul, and
+// DynamoDB stores); for requests made to LaunchDarkly for flag data, other than the stream itself; and
+// for deliveries of analytics events. The span names and attribute keys are the SpanName... and
+// SpanAttr... constants.
+type Tracer interface {
+	// StartSpan starts a span, which is a child of the span in ctx if there is one, and returns a
+	// context containing the new span.
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is an operation being traced; see Tracer. Its methods are only called from one goroutine.
+type Span interface {
+	// SetAttribute adds information to the span. The value is a string, bool, int, or int64.
+	SetAttribute(key string, value interface{})
+	// RecordError reports that the operation failed.
+	RecordError(err error)
+	// End is called when the operation is finished.
+	End()
+}
+
+// TracerReceiver is an optional interface for a component that can report tracing spans. If
+// Config.FeatureStore implements it, and Config.Tracer is set, the client passes the Tracer to
+// SetTracer before using the store.
+type TracerReceiver interface {
+	SetTracer(tracer Tracer)
+}
+
+// Names of the spans reported to a Tracer.
+const (
+	// SpanNameEvaluation is the span for a call to one of the variation methods.
+	SpanNameEvaluation = "launchdarkly.evaluation"
+	// SpanNameAllFlags is the span for a call to AllFlagsState.
+	SpanNameAllFlags = "launchdarkly.all_flags"
+	// SpanNameStoreGet is the span for FeatureStore.Get.
+	SpanNameStoreGet = "launchdarkly.store.get"
+	// SpanNameStoreAll is the span for FeatureStore.All.
+	SpanNameStoreAll = "launchdarkly.store.all"
+	// SpanNameStoreInit is the span for FeatureStore.Init.
+	SpanNameStoreInit = "launchdarkly.store.init"
+	// SpanNameStoreUpsert is the span for FeatureStore.Upsert or Delete.
+	SpanNameStoreUpsert = "launchdarkly.store.upsert"
+	// SpanNameRequest is the span for a request to LaunchDarkly for flag data.
+	SpanNameRequest = "launchdarkly.request"
+	// SpanNamePostEvents is the span for delivering a batch of analytics events, including any retry.
+	SpanNamePostEvents = "launchdarkly.post_events"
+)
+
+// Keys of the attributes that are set on spans.
+const (
+	// SpanAttrFlagKey is the key of the flag being evaluated.
+	SpanAttrFlagKey = "feature_flag.key"
+	// SpanAttrVariation is the variation index that an evaluation produced, if any.
+	SpanAttrVariation = "feature_flag.variation_index"
+	// SpanAttrReasonKind is the kind of the EvaluationReason for an evaluation, such as "FALLTHROUGH".
+	SpanAttrReasonKind = "feature_flag.reason_kind"
+	// SpanAttrFlagCount is the number of flags in the result of AllFlagsState.
+	SpanAttrFlagCount = "feature_flag.count"
+	// SpanAttrStoreKind is the namespace of the kind of data in a feature store operation.
+	SpanAttrStoreKind = "launchdarkly.store.kind"
+	// SpanAttrStoreKey is the key of the item in a feature store operation.
+	SpanAttrStoreKey = "launchdarkly.store.key"
+	// SpanAttrStoreCached is true if a feature store query was answered from the cache.
+	SpanAttrStoreCached = "launchdarkly.store.cached"
+	// SpanAttrHTTPMethod is the method of an HTTP request.
+	SpanAttrHTTPMethod = "http.method"
+	// SpanAttrHTTPURL is the URL of an HTTP request.
+	SpanAttrHTTPURL = "http.url"
+	// SpanAttrHTTPStatusCode is the status code of the response to an HTTP request.
+	SpanAttrHTTPStatusCode = "http.status_code"
+	// SpanAttrHTTPCached is true if the response to a request for flag data came from the HTTP cache.
+	SpanAttrHTTPCached = "launchdarkly.http.cached"
+	// SpanAttrEventCount is the number of analytics events being delivered.
+	SpanAttrEventCount = "launchdarkly.events.count"
+	// SpanAttrAttempts is the number of times that delivering analytics events was attempted.
+	SpanAttrAttempts = "launchdarkly.events.attempts"
+)
+
+// NullTracer is a Tracer that does nothing. It is used if Config.Tracer is nil.
+type NullTracer struct{}
+
+// StartSpan returns ctx and a Span that does nothing.
+func (t NullTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, nullSpan{}
+}
+
+type nullSpan struct{}
+
+func (s nullSpan) SetAttribute(key string, value interface{}) {}
+func (s nullSpan) RecordError(err error)                      {}
+func (s nullSpan) End()                                       {}
+
+func tracerOrDefault(tracer Tracer) Tracer {
+	if tracer == nil {
+		return NullTracer{}
+	}
+	return tracer
+}
+
+// Adds the attributes that describe the result of an evaluation to a span.
+func setEvaluationSpanAttributes(span Span, detail EvaluationDetail, err error) {
+	if detail.VariationIndex != nil {
+		span.SetAttribute(SpanAttrVariation, *detail.VariationIndex)
+	}
+	if detail.Reason != nil {
+		span.SetAttribute(SpanAttrReasonKind, string(detail.Reason.GetKind()))
+	}
+	if err != nil {
+		span.RecordError(err)
+	}
+}
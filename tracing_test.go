@@ -0,0 +1,241 @@
+package ldclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordedSpan struct {
+	name   string
+	parent *recordedSpan
+	attrs  map[string]interface{}
+	errors []error
+	ended  bool
+}
+
+// Records all of the spans that are started, so that tests can examine them once they have ended.
+type recordingTracer struct {
+	spans []*recordedSpan
+	lock  sync.Mutex
+}
+
+type recordingSpan struct {
+	span   *recordedSpan
+	tracer *recordingTracer
+}
+
+type recordedSpanContextKey struct{}
+
+func (t *recordingTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(recordedSpanContextKey{}).(*recordedSpan)
+	span := &recordedSpan{name: name, parent: parent, attrs: make(map[string]interface{})}
+	t.lock.Lock()
+	t.spans = append(t.spans, span)
+	t.lock.Unlock()
+	return context.WithValue(ctx, recordedSpanContextKey{}, span), recordingSpan{span: span, tracer: t}
+}
+
+// Returns the spans with the specified name.
+func (t *recordingTracer) getSpans(name string) []recordedSpan {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var ret []recordedSpan
+	for _, s := range t.spans {
+		if s.name == name {
+			ret = append(ret, *s)
+		}
+	}
+	return ret
+}
+
+func (s recordingSpan) SetAttribute(key string, value interface{}) {
+	s.tracer.lock.Lock()
+	s.span.attrs[key] = value
+	s.tracer.lock.Unlock()
+}
+
+func (s recordingSpan) RecordError(err error) {
+	s.tracer.lock.Lock()
+	s.span.errors = append(s.span.errors, err)
+	s.tracer.lock.Unlock()
+}
+
+func (s recordingSpan) End() {
+	s.tracer.lock.Lock()
+	s.span.ended = true
+	s.tracer.lock.Unlock()
+}
+
+// A feature store that reports spans for its queries, to verify that the client passes it the Tracer
+// and the context of the evaluation. Like queryRecordingFeatureStore, it embeds the FeatureStore
+// interface so that the client does not use the in-memory store's snapshot instead.
+type tracingFeatureStore struct {
+	FeatureStore
+	tracer Tracer
+}
+
+func (s *tracingFeatureStore) SetTracer(tracer Tracer) {
+	s.tracer = tracer
+}
+
+func (s *tracingFeatureStore) GetWithContext(ctx context.Context, kind VersionedDataKind, key string) (VersionedData, error) {
+	_, span := s.tracer.StartSpan(ctx, SpanNameStoreGet)
+	defer span.End()
+	return s.FeatureStore.Get(kind, key)
+}
+
+func (s *tracingFeatureStore) AllWithContext(ctx context.Context, kind VersionedDataKind) (map[string]VersionedData, error) {
+	_, span := s.tracer.StartSpan(ctx, SpanNameStoreAll)
+	defer span.End()
+	return s.FeatureStore.All(kind)
+}
+
+func TestEvaluationSpanHasResultAttributes(t *testing.T) {
+	tracer := &recordingTracer{}
+	client := makeTestClientWithConfig(func(c *Config) { c.Tracer = tracer })
+	defer client.Close()
+	client.store.Upsert(Features, makeTestFlag("flag", 1, "a", "b"))
+
+	_, _ = client.StringVariation("flag", evalTestUser, "default")
+	_, _ = client.StringVariation("unknown", evalTestUser, "default")
+
+	spans := tracer.getSpans(SpanNameEvaluation)
+	require.Len(t, spans, 2)
+	assert.Equal(t, map[string]interface{}{SpanAttrFlagKey: "flag", SpanAttrVariation: 1, SpanAttrReasonKind: "FALLTHROUGH"},
+		spans[0].attrs)
+	assert.Nil(t, spans[0].errors)
+	assert.True(t, spans[0].ended)
+	assert.Equal(t, map[string]interface{}{SpanAttrFlagKey: "unknown", SpanAttrReasonKind: "ERROR"}, spans[1].attrs)
+	assert.Len(t, spans[1].errors, 1)
+}
+
+func TestStoreSpanIsChildOfEvaluationSpan(t *testing.T) {
+	tracer := &recordingTracer{}
+	store := &tracingFeatureStore{FeatureStore: NewInMemoryFeatureStore(nil)}
+	client := makeTestClientWithConfig(func(c *Config) {
+		c.FeatureStore = store
+		c.Tracer = tracer
+	})
+	defer client.Close()
+	client.store.Upsert(Features, makeTestFlag("flag", 1, "a", "b"))
+	parentCtx, parentSpan := tracer.StartSpan(context.Background(), "request")
+
+	_, _ = client.StringVariationCtx(parentCtx, "flag", evalTestUser, "default")
+	parentSpan.End()
+
+	evalSpans := tracer.getSpans(SpanNameEvaluation)
+	require.Len(t, evalSpans, 1)
+	assert.Equal(t, "request", evalSpans[0].parent.name)
+	storeSpans := tracer.getSpans(SpanNameStoreGet)
+	require.Len(t, storeSpans, 1)
+	assert.Equal(t, SpanNameEvaluation, storeSpans[0].parent.name)
+}
+
+func TestAllFlagsStateSpan(t *testing.T) {
+	tracer := &recordingTracer{}
+	store := &tracingFeatureStore{FeatureStore: NewInMemoryFeatureStore(nil)}
+	client := makeTestClientWithConfig(func(c *Config) {
+		c.FeatureStore = store
+		c.Tracer = tracer
+	})
+	defer client.Close()
+	client.store.Upsert(Features, makeTestFlag("flag1", 1, "a", "b"))
+	client.store.Upsert(Features, makeTestFlag("flag2", 1, "a", "b"))
+
+	client.AllFlagsState(evalTestUser)
+
+	spans := tracer.getSpans(SpanNameAllFlags)
+	require.Len(t, spans, 1)
+	assert.Equal(t, map[string]interface{}{SpanAttrFlagCount: 2}, spans[0].attrs)
+	assert.True(t, spans[0].ended)
+	storeSpans := tracer.getSpans(SpanNameStoreAll)
+	require.Len(t, storeSpans, 1)
+	assert.Equal(t, SpanNameAllFlags, storeSpans[0].parent.name)
+}
+
+func TestRequestorSpan(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"flags":{},"segments":{}}`))
+	}))
+	defer ts.Close()
+	tracer := &recordingTracer{}
+	r := newRequestor("sdkKey", Config{BaseUri: ts.URL, Tracer: tracer})
+
+	_, _, err := r.requestAll()
+
+	require.NoError(t, err)
+	spans := tracer.getSpans(SpanNameRequest)
+	require.Len(t, spans, 1)
+	assert.Equal(t, map[string]interface{}{
+		SpanAttrHTTPMethod:     "GET",
+		SpanAttrHTTPURL:        ts.URL + LatestAllPath,
+		SpanAttrHTTPStatusCode: 200,
+		SpanAttrHTTPCached:     false,
+	}, spans[0].attrs)
+	assert.True(t, spans[0].ended)
+}
+
+func TestRequestorSpanRecordsError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(401)
+	}))
+	defer ts.Close()
+	tracer := &recordingTracer{}
+	r := newRequestor("sdkKey", Config{BaseUri: ts.URL, Tracer: tracer})
+
+	_, _, err := r.requestAll()
+
+	require.Error(t, err)
+	spans := tracer.getSpans(SpanNameRequest)
+	require.Len(t, spans, 1)
+	assert.Equal(t, 401, spans[0].attrs[SpanAttrHTTPStatusCode])
+	assert.Equal(t, []error{err}, spans[0].errors)
+}
+
+func TestPostEventsSpan(t *testing.T) {
+	tracer := &recordingTracer{}
+	config := epDefaultConfig
+	config.Tracer = tracer
+	config.EventsUri = "http://fake"
+	ep, st := createEventProcessor(config)
+	defer ep.Close()
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	flushAndGetEvents(ep, st)
+
+	spans := tracer.getSpans(SpanNamePostEvents)
+	require.Len(t, spans, 1)
+	assert.Equal(t, map[string]interface{}{
+		SpanAttrEventCount:     1,
+		SpanAttrHTTPURL:        "http://fake/bulk",
+		SpanAttrHTTPStatusCode: 200,
+		SpanAttrAttempts:       1,
+	}, spans[0].attrs)
+	assert.True(t, spans[0].ended)
+}
+
+func TestPostEventsSpanRecordsError(t *testing.T) {
+	tracer := &recordingTracer{}
+	config := epDefaultConfig
+	config.Tracer = tracer
+	ep, st := createEventProcessor(config)
+	defer ep.Close()
+	st.error = errors.New("sorry")
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.Flush()
+	ep.waitUntilInactive()
+
+	spans := tracer.getSpans(SpanNamePostEvents)
+	require.Len(t, spans, 1)
+	assert.Equal(t, 2, spans[0].attrs[SpanAttrAttempts])
+	require.Len(t, spans[0].errors, 1)
+}
@@ -0,0 +1,279 @@
+package ldclient
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// valueConverter converts a flag value to the type that a typed variation method returns, or returns
+// an error describing why the value does not have the expected type.
+type valueConverter func(value interface{}) (interface{}, error)
+
+// flagValueConverter is like valueConverter, but also receives the flag and the index of the variation
+// that was selected, for converters that cache their results along with the flag data. The flag may be
+// nil, and the value may not be the flag's variation if an evaluation hook substituted another value.
+type flagValueConverter func(flag *FeatureFlag, index int, value interface{}) (interface{}, error)
+
+// withFlag adapts a valueConverter to the form that convertedVariation takes.
+func (convert valueConverter) withFlag() flagValueConverter {
+	return func(_ *FeatureFlag, _ int, value interface{}) (interface{}, error) {
+		return convert(value)
+	}
+}
+
+const (
+	maxInt = int(^uint(0) >> 1)
+	minInt = -maxInt - 1
+)
+
+// Int64Variation returns the value of a feature flag (whose variations are integers) for the given
+// user. Returns defaultVal if there is an error, if the flag doesn't exist, or the feature is turned
+// off and has no off variation.
+//
+// A value with a fractional part is truncated, unless Config.StrictNumericVariations is set. A value
+// that is out of range for an int64 always causes defaultVal to be returned, with an EvalErrorWrongType
+// reason. Integers with a magnitude above 2^53, which a float64 cannot represent exactly, are
+// returned exactly if the flag data was parsed from JSON.
+func (client *LDClient) Int64Variation(key string, user User, defaultVal int64) (int64, error) {
+	return client.Int64VariationCtx(context.Background(), key, user, defaultVal)
+}
+
+// Int64VariationDetail is the same as Int64Variation, but also returns further information about how
+// the value was calculated. The "reason" data will also be included in analytics events.
+func (client *LDClient) Int64VariationDetail(key string, user User, defaultVal int64) (int64, EvaluationDetail, error) {
+	return client.Int64VariationDetailCtx(context.Background(), key, user, defaultVal)
+}
+
+// Int64VariationCtx is the same as Int64Variation, but uses a context for feature store queries.
+func (client *LDClient) Int64VariationCtx(ctx context.Context, key string, user User, defaultVal int64) (int64, error) {
+	result, _, err := client.int64Variation(ctx, key, user, defaultVal, false)
+	return result, err
+}
+
+// Int64VariationDetailCtx is the same as Int64VariationDetail, but uses a context for feature store
+// queries.
+func (client *LDClient) Int64VariationDetailCtx(ctx context.Context, key string, user User, defaultVal int64) (int64, EvaluationDetail, error) {
+	return client.int64Variation(ctx, key, user, defaultVal, true)
+}
+
+// DurationVariation returns the value of a feature flag whose variations are durations, for the given
+// user. A duration can be either a string in the format accepted by time.ParseDuration, such as
+// "1m30s", or a number of milliseconds. Returns defaultVal if there is an error, if the flag doesn't
+// exist, or the feature is turned off and has no off variation; if the value is neither of those
+// types, the reason is EvalErrorWrongType.
+func (client *LDClient) DurationVariation(key string, user User, defaultVal time.Duration) (time.Duration, error) {
+	return client.DurationVariationCtx(context.Background(), key, user, defaultVal)
+}
+
+// DurationVariationDetail is the same as DurationVariation, but also returns further information about
+// how the value was calculated. The "reason" data will also be included in analytics events.
+func (client *LDClient) DurationVariationDetail(key string, user User, defaultVal time.Duration) (time.Duration, EvaluationDetail, error) {
+	return client.DurationVariationDetailCtx(context.Background(), key, user, defaultVal)
+}
+
+// DurationVariationCtx is the same as DurationVariation, but uses a context for feature store queries.
+func (client *LDClient) DurationVariationCtx(ctx context.Context, key string, user User, defaultVal time.Duration) (time.Duration, error) {
+	result, _, err := client.durationVariation(ctx, key, user, defaultVal, false)
+	return result, err
+}
+
+// DurationVariationDetailCtx is the same as DurationVariationDetail, but uses a context for feature
+// store queries.
+func (client *LDClient) DurationVariationDetailCtx(ctx context.Context, key string, user User, defaultVal time.Duration) (time.Duration, EvaluationDetail, error) {
+	return client.durationVariation(ctx, key, user, defaultVal, true)
+}
+
+// TimeVariation returns the value of a feature flag whose variations are timestamps, for the given
+// user. A timestamp can be in any format accepted by ParseTime: an RFC3339 string, or a number of
+// milliseconds since the Unix epoch. Returns defaultVal if there is an error, if the flag doesn't
+// exist, or the feature is turned off and has no off variation; if the value cannot be parsed, the
+// reason is EvalErrorWrongType.
+func (client *LDClient) TimeVariation(key string, user User, defaultVal time.Time) (time.Time, error) {
+	return client.TimeVariationCtx(context.Background(), key, user, defaultVal)
+}
+
+// TimeVariationDetail is the same as TimeVariation, but also returns further information about how
+// the value was calculated. The "reason" data will also be included in analytics events.
+func (client *LDClient) TimeVariationDetail(key string, user User, defaultVal time.Time) (time.Time, EvaluationDetail, error) {
+	return client.TimeVariationDetailCtx(context.Background(), key, user, defaultVal)
+}
+
+// TimeVariationCtx is the same as TimeVariation, but uses a context for feature store queries.
+func (client *LDClient) TimeVariationCtx(ctx context.Context, key string, user User, defaultVal time.Time) (time.Time, error) {
+	result, _, err := client.timeVariation(ctx, key, user, defaultVal, false)
+	return result, err
+}
+
+// TimeVariationDetailCtx is the same as TimeVariationDetail, but uses a context for feature store
+// queries.
+func (client *LDClient) TimeVariationDetailCtx(ctx context.Context, key string, user User, defaultVal time.Time) (time.Time, EvaluationDetail, error) {
+	return client.timeVariation(ctx, key, user, defaultVal, true)
+}
+
+// StringListVariation returns the value of a feature flag whose variations are arrays of strings, for
+// the given user. The returned slice is a new copy. Returns defaultVal if there is an error, if the
+// flag doesn't exist, or the feature is turned off and has no off variation; if the value is not an
+// array, or any of its elements is not a string, the reason is EvalErrorWrongType.
+func (client *LDClient) StringListVariation(key string, user User, defaultVal []string) ([]string, error) {
+	return client.StringListVariationCtx(context.Background(), key, user, defaultVal)
+}
+
+// StringListVariationDetail is the same as StringListVariation, but also returns further information
+// about how the value was calculated. The "reason" data will also be included in analytics events.
+func (client *LDClient) StringListVariationDetail(key string, user User, defaultVal []string) ([]string, EvaluationDetail, error) {
+	return client.StringListVariationDetailCtx(context.Background(), key, user, defaultVal)
+}
+
+// StringListVariationCtx is the same as StringListVariation, but uses a context for feature store
+// queries.
+func (client *LDClient) StringListVariationCtx(ctx context.Context, key string, user User, defaultVal []string) ([]string, error) {
+	result, _, err := client.stringListVariation(ctx, key, user, defaultVal, false)
+	return result, err
+}
+
+// StringListVariationDetailCtx is the same as StringListVariationDetail, but uses a context for
+// feature store queries.
+func (client *LDClient) StringListVariationDetailCtx(ctx context.Context, key string, user User, defaultVal []string) ([]string, EvaluationDetail, error) {
+	return client.stringListVariation(ctx, key, user, defaultVal, true)
+}
+
+func (client *LDClient) int64Variation(ctx context.Context, key string, user User, defaultVal int64, sendReasonsInEvents bool) (int64, EvaluationDetail, error) {
+	strict := client.config.StrictNumericVariations
+	convert := func(flag *FeatureFlag, index int, value interface{}) (interface{}, error) {
+		if flag != nil {
+			if n, ok := flag.largeIntVariation(index, value); ok {
+				return n, nil
+			}
+		}
+		n, err := wholeNumberValue(value, math.MinInt64, strict)
+		if err != nil {
+			return nil, err
+		}
+		return int64(n), nil
+	}
+	detail, _, err := client.convertedVariation(ctx, key, user, float64(defaultVal), convert, sendReasonsInEvents)
+	if err != nil || detail.VariationIndex == nil {
+		detail.Value = defaultVal
+	}
+	result, _ := detail.Value.(int64)
+	return result, detail, err
+}
+
+func (client *LDClient) durationVariation(ctx context.Context, key string, user User, defaultVal time.Duration, sendReasonsInEvents bool) (time.Duration, EvaluationDetail, error) {
+	detail, err := client.typedVariation(ctx, key, user, defaultVal, defaultVal.String(), convertToDuration, sendReasonsInEvents)
+	result, _ := detail.Value.(time.Duration)
+	return result, detail, err
+}
+
+func (client *LDClient) timeVariation(ctx context.Context, key string, user User, defaultVal time.Time, sendReasonsInEvents bool) (time.Time, EvaluationDetail, error) {
+	detail, err := client.typedVariation(ctx, key, user, defaultVal, defaultVal.UTC().Format(time.RFC3339Nano), convertToTime, sendReasonsInEvents)
+	result, _ := detail.Value.(time.Time)
+	return result, detail, err
+}
+
+func (client *LDClient) stringListVariation(ctx context.Context, key string, user User, defaultVal []string, sendReasonsInEvents bool) ([]string, EvaluationDetail, error) {
+	detail, err := client.typedVariation(ctx, key, user, defaultVal, defaultVal, convertToStringList, sendReasonsInEvents)
+	result, _ := detail.Value.([]string)
+	return result, detail, err
+}
+
+// Evaluates a flag for one of the typed variation methods that has to convert the flag value. The
+// EvaluationDetail's Value is either the converted value or defaultVal. The default value that is
+// reported in analytics events is eventDefault, which must be something that can be represented in
+// JSON.
+func (client *LDClient) typedVariation(ctx context.Context, key string, user User, defaultVal, eventDefault interface{},
+	convert valueConverter, sendReasonsInEvents bool) (EvaluationDetail, error) {
+	detail, _, err := client.convertedVariation(ctx, key, user, eventDefault, convert.withFlag(), sendReasonsInEvents)
+	if err != nil || detail.VariationIndex == nil {
+		detail.Value = defaultVal
+	}
+	return detail, err
+}
+
+// Returns a converter for IntVariation in strict mode. It leaves the value as a float64, so that the
+// result has the same form as in non-strict mode.
+func intConverter(value interface{}) (interface{}, error) {
+	return wholeNumberValue(value, float64(minInt), true)
+}
+
+// Returns the value as a whole number if it is a number within the range of an integer type whose
+// minimum value is minValue; since that is a negative power of two, -minValue is one more than the
+// maximum. If strict is false, a fractional part is truncated rather than being an error.
+func wholeNumberValue(value interface{}, minValue float64, strict bool) (float64, error) {
+	n, ok := value.(float64)
+	if !ok {
+		return 0, fmt.Errorf("expected a number, got %s", describeValue(value))
+	}
+	if strict && n != math.Trunc(n) {
+		return 0, fmt.Errorf("expected an integer, got %v", n)
+	}
+	if n < minValue || n >= -minValue {
+		return 0, fmt.Errorf("%v is out of range", n)
+	}
+	return math.Trunc(n), nil
+}
+
+func convertToDuration(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, err
+		}
+		return d, nil
+	case float64:
+		if math.Abs(v) >= float64(math.MaxInt64/int64(time.Millisecond)) {
+			return nil, fmt.Errorf("%v milliseconds is out of range", v)
+		}
+		return time.Duration(v * float64(time.Millisecond)), nil
+	}
+	return nil, fmt.Errorf("expected a duration string or number of milliseconds, got %s", describeValue(value))
+}
+
+func convertToTime(value interface{}) (interface{}, error) {
+	switch value.(type) {
+	case string, float64:
+		if t := ParseTime(value); t != nil {
+			return *t, nil
+		}
+		return nil, fmt.Errorf("%v is not a valid timestamp", value)
+	}
+	return nil, fmt.Errorf("expected a timestamp string or number of milliseconds, got %s", describeValue(value))
+}
+
+func convertToStringList(value interface{}) (interface{}, error) {
+	a, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an array of strings, got %s", describeValue(value))
+	}
+	ret := make([]string, len(a))
+	for i, item := range a {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected an array of strings, but element %d is %s", i, describeValue(item))
+		}
+		ret[i] = s
+	}
+	return ret, nil
+}
+
+// Describes the JSON type of a flag value for error messages.
+func describeValue(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a boolean"
+	case float64:
+		return "a number"
+	case string:
+		return "a string"
+	case []interface{}:
+		return "an array"
+	case map[string]interface{}:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", value)
+}
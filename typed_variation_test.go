@@ -0,0 +1,262 @@
+package ldclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeStrictNumericTestClient() *LDClient {
+	return makeTestClientWithConfig(func(c *Config) { c.StrictNumericVariations = true })
+}
+
+func assertWrongTypeEvent(t *testing.T, client *LDClient, flag *FeatureFlag, defaultVal interface{}) {
+	events := client.eventProcessor.(*testEventProcessor).events
+	require.Equal(t, 1, len(events))
+	e := events[0].(FeatureRequestEvent)
+	assert.Equal(t, flag.Key, e.Key)
+	assert.Nil(t, e.Variation)
+	assert.Equal(t, defaultVal, e.Value)
+	assert.Equal(t, defaultVal, e.Default)
+	assert.Equal(t, newEvalReasonError(EvalErrorWrongType), e.Reason.Reason)
+}
+
+func TestIntVariationTruncatesFractionByDefault(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	client.store.Upsert(Features, makeTestFlag("validFeatureKey", 1, float64(1), 2.7))
+
+	actual, err := client.IntVariation("validFeatureKey", evalTestUser, 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, actual)
+}
+
+func TestIntVariationRejectsFractionInStrictMode(t *testing.T) {
+	client := makeStrictNumericTestClient()
+	defer client.Close()
+	flag := makeTestFlag("validFeatureKey", 1, float64(1), 2.7)
+	client.store.Upsert(Features, flag)
+
+	actual, detail, err := client.IntVariationDetail("validFeatureKey", evalTestUser, 5)
+
+	assert.Error(t, err)
+	assert.Equal(t, 5, actual)
+	assert.Equal(t, float64(5), detail.Value)
+	assert.Nil(t, detail.VariationIndex)
+	assert.Equal(t, newEvalReasonError(EvalErrorWrongType), detail.Reason)
+	assertWrongTypeEvent(t, client, flag, float64(5))
+}
+
+func TestIntVariationAcceptsWholeNumberInStrictMode(t *testing.T) {
+	client := makeStrictNumericTestClient()
+	defer client.Close()
+	flag := makeTestFlag("validFeatureKey", 1, float64(1), float64(2))
+	client.store.Upsert(Features, flag)
+
+	actual, detail, err := client.IntVariationDetail("validFeatureKey", evalTestUser, 5)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, actual)
+	assert.Equal(t, float64(2), detail.Value)
+	assertEvalEvent(t, client, flag, evalTestUser, float64(2), 1, float64(5), detail.Reason)
+}
+
+func TestInt64Variation(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	flag := makeTestFlag("validFeatureKey", 1, float64(1), float64(1<<40))
+	client.store.Upsert(Features, flag)
+
+	actual, detail, err := client.Int64VariationDetail("validFeatureKey", evalTestUser, 5)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1<<40), actual)
+	assert.Equal(t, int64(1<<40), detail.Value)
+	assert.Equal(t, intPtr(1), detail.VariationIndex)
+	assertEvalEvent(t, client, flag, evalTestUser, float64(1<<40), 1, float64(5), detail.Reason)
+}
+
+func TestInt64VariationReturnsLargeIntegerExactly(t *testing.T) {
+	client := makeStrictNumericTestClient()
+	defer client.Close()
+	for _, n := range []int64{9007199254740993, -9007199254740993, math.MaxInt64, math.MinInt64} {
+		var flag FeatureFlag
+		flagJSON := fmt.Sprintf(`{"key": "flag%d", "version": 1, "on": true, "fallthrough": {"variation": 1}, "variations": [1, %d]}`, n, n)
+		require.NoError(t, json.Unmarshal([]byte(flagJSON), &flag))
+		client.store.Upsert(Features, &flag)
+
+		actual, err := client.Int64Variation(flag.Key, evalTestUser, 5)
+
+		assert.NoError(t, err)
+		assert.Equal(t, n, actual)
+	}
+}
+
+func TestInt64VariationTruncatesFractionByDefault(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	client.store.Upsert(Features, makeTestFlag("validFeatureKey", 1, float64(1), -2.7))
+
+	actual, err := client.Int64Variation("validFeatureKey", evalTestUser, 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-2), actual)
+}
+
+func TestInt64VariationRejectsFractionInStrictMode(t *testing.T) {
+	client := makeStrictNumericTestClient()
+	defer client.Close()
+	flag := makeTestFlag("validFeatureKey", 1, float64(1), 2.7)
+	client.store.Upsert(Features, flag)
+
+	actual, detail, err := client.Int64VariationDetail("validFeatureKey", evalTestUser, 5)
+
+	assert.Error(t, err)
+	assert.Equal(t, int64(5), actual)
+	assert.Equal(t, int64(5), detail.Value)
+	assert.Equal(t, newEvalReasonError(EvalErrorWrongType), detail.Reason)
+	assertWrongTypeEvent(t, client, flag, float64(5))
+}
+
+func TestInt64VariationRejectsOutOfRangeValue(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	client.store.Upsert(Features, makeTestFlag("validFeatureKey", 1, float64(1), 1e19))
+
+	actual, detail, err := client.Int64VariationDetail("validFeatureKey", evalTestUser, 5)
+
+	assert.Error(t, err)
+	assert.Equal(t, int64(5), actual)
+	assert.Equal(t, newEvalReasonError(EvalErrorWrongType), detail.Reason)
+}
+
+func TestInt64VariationRejectsNonNumber(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	client.store.Upsert(Features, makeTestFlag("validFeatureKey", 1, float64(1), "2"))
+
+	actual, detail, err := client.Int64VariationDetail("validFeatureKey", evalTestUser, 5)
+
+	assert.Error(t, err)
+	assert.Equal(t, int64(5), actual)
+	assert.Equal(t, newEvalReasonError(EvalErrorWrongType), detail.Reason)
+}
+
+func TestInt64VariationReturnsDefaultForUnknownFlag(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+
+	actual, detail, err := client.Int64VariationDetail("unknownFlag", evalTestUser, 5)
+
+	assert.Error(t, err)
+	assert.Equal(t, int64(5), actual)
+	assert.Equal(t, int64(5), detail.Value)
+	assert.Equal(t, newEvalReasonError(EvalErrorFlagNotFound), detail.Reason)
+}
+
+func TestDurationVariation(t *testing.T) {
+	for _, params := range []struct {
+		value    interface{}
+		expected time.Duration
+	}{
+		{"1m30s", 90 * time.Second},
+		{float64(1500), 1500 * time.Millisecond},
+	} {
+		client := makeTestClient()
+		flag := makeTestFlag("validFeatureKey", 1, "0s", params.value)
+		client.store.Upsert(Features, flag)
+
+		actual, detail, err := client.DurationVariationDetail("validFeatureKey", evalTestUser, time.Second)
+
+		assert.NoError(t, err)
+		assert.Equal(t, params.expected, actual)
+		assert.Equal(t, params.expected, detail.Value)
+		assertEvalEvent(t, client, flag, evalTestUser, params.value, 1, "1s", detail.Reason)
+		client.Close()
+	}
+}
+
+func TestDurationVariationRejectsInvalidValue(t *testing.T) {
+	for _, value := range []interface{}{"soon", true, 1e300} {
+		client := makeTestClient()
+		flag := makeTestFlag("validFeatureKey", 1, "0s", value)
+		client.store.Upsert(Features, flag)
+
+		actual, detail, err := client.DurationVariationDetail("validFeatureKey", evalTestUser, time.Second)
+
+		assert.Error(t, err)
+		assert.Equal(t, time.Second, actual)
+		assert.Equal(t, time.Second, detail.Value)
+		assertWrongTypeEvent(t, client, flag, "1s")
+		client.Close()
+	}
+}
+
+func TestTimeVariation(t *testing.T) {
+	expected := time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC)
+	for _, value := range []interface{}{"2019-03-04T05:06:07Z", float64(expected.Unix() * 1000)} {
+		client := makeTestClient()
+		flag := makeTestFlag("validFeatureKey", 1, "", value)
+		client.store.Upsert(Features, flag)
+
+		actual, detail, err := client.TimeVariationDetail("validFeatureKey", evalTestUser, time.Time{})
+
+		assert.NoError(t, err)
+		assert.True(t, expected.Equal(actual), "expected %s, got %s", expected, actual)
+		assert.Equal(t, actual, detail.Value)
+		assertEvalEvent(t, client, flag, evalTestUser, value, 1, "0001-01-01T00:00:00Z", detail.Reason)
+		client.Close()
+	}
+}
+
+func TestTimeVariationRejectsInvalidValue(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	flag := makeTestFlag("validFeatureKey", 1, "", "yesterday")
+	client.store.Upsert(Features, flag)
+	defaultVal := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	actual, detail, err := client.TimeVariationDetail("validFeatureKey", evalTestUser, defaultVal)
+
+	assert.Error(t, err)
+	assert.Equal(t, defaultVal, actual)
+	assert.Equal(t, newEvalReasonError(EvalErrorWrongType), detail.Reason)
+	assertWrongTypeEvent(t, client, flag, "2019-01-01T00:00:00Z")
+}
+
+func TestStringListVariation(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	flag := makeTestFlag("validFeatureKey", 1, []interface{}{}, []interface{}{"a", "b"})
+	client.store.Upsert(Features, flag)
+
+	actual, detail, err := client.StringListVariationDetail("validFeatureKey", evalTestUser, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, actual)
+	assert.Equal(t, []string{"a", "b"}, detail.Value)
+	assertEvalEvent(t, client, flag, evalTestUser, []interface{}{"a", "b"}, 1, []string(nil), detail.Reason)
+}
+
+func TestStringListVariationRejectsInvalidValue(t *testing.T) {
+	for _, value := range []interface{}{"a", []interface{}{"a", float64(1)}} {
+		client := makeTestClient()
+		flag := makeTestFlag("validFeatureKey", 1, []interface{}{}, value)
+		client.store.Upsert(Features, flag)
+		defaultVal := []string{"default"}
+
+		actual, detail, err := client.StringListVariationDetail("validFeatureKey", evalTestUser, defaultVal)
+
+		assert.Error(t, err)
+		assert.Equal(t, defaultVal, actual)
+		assert.Equal(t, newEvalReasonError(EvalErrorWrongType), detail.Reason)
+		assertWrongTypeEvent(t, client, flag, defaultVal)
+		client.Close()
+	}
+}
@@ -2,6 +2,8 @@ package ldclient
 
 import (
 	"time"
+
+	"gopkg.in/launchdarkly/go-client.v4/ldvalue"
 )
 
 // A User contains specific attributes of a user browsing your site. The only mandatory property property is the Key,
@@ -56,6 +58,19 @@ func NewAnonymousUser(key string) User {
 	return User{Key: &key, Anonymous: &anonymous}
 }
 
+// GetCustom returns a copy of a custom attribute as an immutable value. The second return value is
+// false if the user does not have that attribute.
+func (user User) GetCustom(attr string) (ldvalue.Value, bool) {
+	if user.Custom == nil {
+		return ldvalue.Null(), false
+	}
+	v, ok := (*user.Custom)[attr]
+	if !ok {
+		return ldvalue.Null(), false
+	}
+	return ldvalue.CopyArbitraryValue(v), true
+}
+
 func (user User) valueOf(attr string) (interface{}, bool) {
 	if attr == "key" {
 		if user.Key != nil {
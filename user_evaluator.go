@@ -0,0 +1,349 @@
+package ldclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/launchdarkly/go-client.v4/ldvalue"
+)
+
+// UserEvaluator evaluates feature flags for one user. It is meant for code that evaluates many flags
+// for the same user, such as an HTTP request handler: create one with LDClient.ForUser at the start
+// of the request, and use it instead of the client's own variation methods.
+//
+// The user is checked once, when the UserEvaluator is created, rather than on every call. The result
+// of evaluating each flag is remembered for the lifetime of the UserEvaluator, so evaluating the same
+// flag again does not repeat the feature store queries or the evaluation. The remembered results are
+// discarded whenever the client receives new flag data; however, in LDD mode they are never used,
+// since changes that another process makes to the feature store cannot be detected.
+//
+// Each call still sends an analytics event, just like the corresponding LDClient method, so the
+// analytics data is the same as if the client had been used directly. However, the event processor
+// only checks whether it has already seen the user for the first of these events, since they are all
+// for the same user.
+//
+// A UserEvaluator is thread-safe. It can have its own default values for some flags; see WithDefaults.
+type UserEvaluator struct {
+	client   *LDClient
+	user     User
+	ctx      context.Context
+	defaults map[string]interface{}
+}
+
+// ForUser returns a UserEvaluator for evaluating feature flags for the specified user.
+func (client *LDClient) ForUser(user User) *UserEvaluator {
+	return client.ForUserCtx(context.Background(), user)
+}
+
+// ForUserCtx is the same as ForUser, but the UserEvaluator uses the specified context for feature store
+// queries, in the same way as the ...Ctx variation methods. This is typically the context of a request.
+func (client *LDClient) ForUserCtx(ctx context.Context, user User) *UserEvaluator {
+	memo := newEvaluationMemo()
+	if user.Key == nil {
+		memo.userErr = fmt.Errorf("user.Key cannot be nil for user: %+v", user)
+	} else if *user.Key == "" {
+		client.logger.Warn("User.Key is blank in ForUser; flag evaluation will proceed, but the user will not be stored in LaunchDarkly")
+	}
+	return &UserEvaluator{
+		client: client,
+		user:   user,
+		ctx:    context.WithValue(ctx, evaluationMemoContextKey{}, memo),
+	}
+}
+
+// WithDefaults returns a UserEvaluator for the same user that uses different default values for some
+// flags. If the defaults map contains a value for a flag, it is used instead of the defaultVal
+// parameter of the variation method, as long as it has the same type as that parameter (for instance,
+// a bool for BoolVariation); otherwise it is ignored. This allows the defaults to be set for a whole
+// request in one place. The map is copied, so changing it afterward has no effect.
+//
+// The new UserEvaluator shares the remembered evaluation results of the original one.
+func (e *UserEvaluator) WithDefaults(defaults map[string]interface{}) *UserEvaluator {
+	e1 := *e
+	e1.defaults = make(map[string]interface{}, len(e.defaults)+len(defaults))
+	for key, value := range e.defaults {
+		e1.defaults[key] = value
+	}
+	for key, value := range defaults {
+		e1.defaults[key] = value
+	}
+	return &e1
+}
+
+// User returns the user that this UserEvaluator evaluates flags for.
+func (e *UserEvaluator) User() User {
+	return e.user
+}
+
+// BoolVariation is the same as LDClient.BoolVariation, for the UserEvaluator's user.
+func (e *UserEvaluator) BoolVariation(key string, defaultVal bool) (bool, error) {
+	if d, ok := e.defaults[key].(bool); ok {
+		defaultVal = d
+	}
+	detail, err := e.client.variationWithType(e.ctx, key, e.user, defaultVal, reflect.TypeOf(true), false)
+	result, _ := detail.Value.(bool)
+	return result, err
+}
+
+// BoolVariationDetail is the same as LDClient.BoolVariationDetail, for the UserEvaluator's user.
+func (e *UserEvaluator) BoolVariationDetail(key string, defaultVal bool) (bool, EvaluationDetail, error) {
+	if d, ok := e.defaults[key].(bool); ok {
+		defaultVal = d
+	}
+	detail, err := e.client.variationWithType(e.ctx, key, e.user, defaultVal, reflect.TypeOf(true), true)
+	result, _ := detail.Value.(bool)
+	return result, detail, err
+}
+
+// IntVariation is the same as LDClient.IntVariation, for the UserEvaluator's user.
+func (e *UserEvaluator) IntVariation(key string, defaultVal int) (int, error) {
+	if d, ok := e.defaults[key].(int); ok {
+		defaultVal = d
+	}
+	result, _, err := e.client.intVariation(e.ctx, key, e.user, defaultVal, false)
+	return result, err
+}
+
+// IntVariationDetail is the same as LDClient.IntVariationDetail, for the UserEvaluator's user.
+func (e *UserEvaluator) IntVariationDetail(key string, defaultVal int) (int, EvaluationDetail, error) {
+	if d, ok := e.defaults[key].(int); ok {
+		defaultVal = d
+	}
+	return e.client.intVariation(e.ctx, key, e.user, defaultVal, true)
+}
+
+// Int64Variation is the same as LDClient.Int64Variation, for the UserEvaluator's user.
+func (e *UserEvaluator) Int64Variation(key string, defaultVal int64) (int64, error) {
+	if d, ok := e.defaults[key].(int64); ok {
+		defaultVal = d
+	}
+	result, _, err := e.client.int64Variation(e.ctx, key, e.user, defaultVal, false)
+	return result, err
+}
+
+// Int64VariationDetail is the same as LDClient.Int64VariationDetail, for the UserEvaluator's user.
+func (e *UserEvaluator) Int64VariationDetail(key string, defaultVal int64) (int64, EvaluationDetail, error) {
+	if d, ok := e.defaults[key].(int64); ok {
+		defaultVal = d
+	}
+	return e.client.int64Variation(e.ctx, key, e.user, defaultVal, true)
+}
+
+// Float64Variation is the same as LDClient.Float64Variation, for the UserEvaluator's user.
+func (e *UserEvaluator) Float64Variation(key string, defaultVal float64) (float64, error) {
+	if d, ok := e.defaults[key].(float64); ok {
+		defaultVal = d
+	}
+	detail, err := e.client.variationWithType(e.ctx, key, e.user, defaultVal, reflect.TypeOf(float64(0)), false)
+	result, _ := detail.Value.(float64)
+	return result, err
+}
+
+// Float64VariationDetail is the same as LDClient.Float64VariationDetail, for the UserEvaluator's user.
+func (e *UserEvaluator) Float64VariationDetail(key string, defaultVal float64) (float64, EvaluationDetail, error) {
+	if d, ok := e.defaults[key].(float64); ok {
+		defaultVal = d
+	}
+	detail, err := e.client.variationWithType(e.ctx, key, e.user, defaultVal, reflect.TypeOf(float64(0)), true)
+	result, _ := detail.Value.(float64)
+	return result, detail, err
+}
+
+// StringVariation is the same as LDClient.StringVariation, for the UserEvaluator's user.
+func (e *UserEvaluator) StringVariation(key string, defaultVal string) (string, error) {
+	if d, ok := e.defaults[key].(string); ok {
+		defaultVal = d
+	}
+	detail, err := e.client.variationWithType(e.ctx, key, e.user, defaultVal, reflect.TypeOf(""), false)
+	result, _ := detail.Value.(string)
+	return result, err
+}
+
+// StringVariationDetail is the same as LDClient.StringVariationDetail, for the UserEvaluator's user.
+func (e *UserEvaluator) StringVariationDetail(key string, defaultVal string) (string, EvaluationDetail, error) {
+	if d, ok := e.defaults[key].(string); ok {
+		defaultVal = d
+	}
+	detail, err := e.client.variationWithType(e.ctx, key, e.user, defaultVal, reflect.TypeOf(""), true)
+	result, _ := detail.Value.(string)
+	return result, detail, err
+}
+
+// JsonVariation is the same as LDClient.JsonVariation, for the UserEvaluator's user.
+func (e *UserEvaluator) JsonVariation(key string, defaultVal json.RawMessage) (json.RawMessage, error) {
+	if d, ok := e.defaults[key].(json.RawMessage); ok {
+		defaultVal = d
+	}
+	result, _, err := e.client.jsonVariation(e.ctx, key, e.user, defaultVal, false)
+	return result, err
+}
+
+// JsonVariationDetail is the same as LDClient.JsonVariationDetail, for the UserEvaluator's user.
+func (e *UserEvaluator) JsonVariationDetail(key string, defaultVal json.RawMessage) (json.RawMessage, EvaluationDetail, error) {
+	if d, ok := e.defaults[key].(json.RawMessage); ok {
+		defaultVal = d
+	}
+	return e.client.jsonVariation(e.ctx, key, e.user, defaultVal, true)
+}
+
+// JsonVariationInto is the same as LDClient.JsonVariationInto, for the UserEvaluator's user. A default
+// set with WithDefaults is used if it can be assigned to the type that out points to.
+func (e *UserEvaluator) JsonVariationInto(key string, out interface{}, defaultVal interface{}) error {
+	_, err := e.client.jsonVariationInto(e.ctx, key, e.user, out, e.intoDefault(key, out, defaultVal), false)
+	return err
+}
+
+// JsonVariationIntoDetail is the same as LDClient.JsonVariationIntoDetail, for the UserEvaluator's user.
+func (e *UserEvaluator) JsonVariationIntoDetail(key string, out interface{}, defaultVal interface{}) (EvaluationDetail, error) {
+	return e.client.jsonVariationInto(e.ctx, key, e.user, out, e.intoDefault(key, out, defaultVal), true)
+}
+
+// ValueVariation is the same as LDClient.ValueVariation, for the UserEvaluator's user.
+func (e *UserEvaluator) ValueVariation(key string, defaultVal ldvalue.Value) (ldvalue.Value, error) {
+	if d, ok := e.defaults[key].(ldvalue.Value); ok {
+		defaultVal = d
+	}
+	result, _, err := e.client.valueVariation(e.ctx, key, e.user, defaultVal, false)
+	return result, err
+}
+
+// ValueVariationDetail is the same as LDClient.ValueVariationDetail, for the UserEvaluator's user.
+func (e *UserEvaluator) ValueVariationDetail(key string, defaultVal ldvalue.Value) (ldvalue.Value, EvaluationDetail, error) {
+	if d, ok := e.defaults[key].(ldvalue.Value); ok {
+		defaultVal = d
+	}
+	return e.client.valueVariation(e.ctx, key, e.user, defaultVal, true)
+}
+
+// DurationVariation is the same as LDClient.DurationVariation, for the UserEvaluator's user.
+func (e *UserEvaluator) DurationVariation(key string, defaultVal time.Duration) (time.Duration, error) {
+	if d, ok := e.defaults[key].(time.Duration); ok {
+		defaultVal = d
+	}
+	result, _, err := e.client.durationVariation(e.ctx, key, e.user, defaultVal, false)
+	return result, err
+}
+
+// DurationVariationDetail is the same as LDClient.DurationVariationDetail, for the UserEvaluator's user.
+func (e *UserEvaluator) DurationVariationDetail(key string, defaultVal time.Duration) (time.Duration, EvaluationDetail, error) {
+	if d, ok := e.defaults[key].(time.Duration); ok {
+		defaultVal = d
+	}
+	return e.client.durationVariation(e.ctx, key, e.user, defaultVal, true)
+}
+
+// TimeVariation is the same as LDClient.TimeVariation, for the UserEvaluator's user.
+func (e *UserEvaluator) TimeVariation(key string, defaultVal time.Time) (time.Time, error) {
+	if d, ok := e.defaults[key].(time.Time); ok {
+		defaultVal = d
+	}
+	result, _, err := e.client.timeVariation(e.ctx, key, e.user, defaultVal, false)
+	return result, err
+}
+
+// TimeVariationDetail is the same as LDClient.TimeVariationDetail, for the UserEvaluator's user.
+func (e *UserEvaluator) TimeVariationDetail(key string, defaultVal time.Time) (time.Time, EvaluationDetail, error) {
+	if d, ok := e.defaults[key].(time.Time); ok {
+		defaultVal = d
+	}
+	return e.client.timeVariation(e.ctx, key, e.user, defaultVal, true)
+}
+
+// StringListVariation is the same as LDClient.StringListVariation, for the UserEvaluator's user.
+func (e *UserEvaluator) StringListVariation(key string, defaultVal []string) ([]string, error) {
+	if d, ok := e.defaults[key].([]string); ok {
+		defaultVal = d
+	}
+	result, _, err := e.client.stringListVariation(e.ctx, key, e.user, defaultVal, false)
+	return result, err
+}
+
+// StringListVariationDetail is the same as LDClient.StringListVariationDetail, for the UserEvaluator's
+// user.
+func (e *UserEvaluator) StringListVariationDetail(key string, defaultVal []string) ([]string, EvaluationDetail, error) {
+	if d, ok := e.defaults[key].([]string); ok {
+		defaultVal = d
+	}
+	return e.client.stringListVariation(e.ctx, key, e.user, defaultVal, true)
+}
+
+// Returns the default set with WithDefaults for JsonVariationInto, if it can be assigned to *out.
+func (e *UserEvaluator) intoDefault(key string, out interface{}, defaultVal interface{}) interface{} {
+	d, ok := e.defaults[key]
+	if !ok || d == nil {
+		return defaultVal
+	}
+	outType := reflect.TypeOf(out)
+	if outType == nil || outType.Kind() != reflect.Ptr || !reflect.TypeOf(d).AssignableTo(outType.Elem()) {
+		return defaultVal
+	}
+	return d
+}
+
+// evaluationMemo holds the results of evaluating flags for the user of a UserEvaluator, and what was
+// found out when the user was checked. It is passed to LDClient.evaluateInternal in the context, so
+// that all of the variation methods can use it.
+type evaluationMemo struct {
+	generation  uint64 // see changeTrackingFeatureStore.currentGeneration
+	results     map[string]memoizedEvaluation
+	lock        sync.Mutex
+	userErr     error // non-nil if the user cannot be evaluated
+	userNoticed int32 // set to 1 once an analytics event has been sent for the user; accessed atomically
+}
+
+// memoizedEvaluation is the result of evaluating a flag. The detail's Value is the flag's own value,
+// which is nil if the flag evaluated to the default; like any evaluation result, it is shared with the
+// flag until one of the methods that returns it to the caller makes a copy.
+type memoizedEvaluation struct {
+	detail       EvaluationDetail
+	flag         *FeatureFlag
+	prereqEvents []FeatureRequestEvent
+}
+
+type evaluationMemoContextKey struct{}
+
+func newEvaluationMemo() *evaluationMemo {
+	return &evaluationMemo{results: make(map[string]memoizedEvaluation)}
+}
+
+func evaluationMemoFromContext(ctx context.Context) *evaluationMemo {
+	memo, _ := ctx.Value(evaluationMemoContextKey{}).(*evaluationMemo)
+	return memo
+}
+
+// Returns true if an analytics event has already been sent for the user, so that the event processor
+// does not need to check it again for the next one. A nil memo always returns false.
+func (m *evaluationMemo) userAlreadyNoticed() bool {
+	return m != nil && !atomic.CompareAndSwapInt32(&m.userNoticed, 0, 1)
+}
+
+// Returns the result for a flag, unless the store has changed since it was computed.
+func (m *evaluationMemo) get(key string, generation uint64) (memoizedEvaluation, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if generation != m.generation {
+		return memoizedEvaluation{}, false
+	}
+	result, ok := m.results[key]
+	return result, ok
+}
+
+// Saves the result for a flag that was computed from the specified version of the store. If that is
+// newer than the other results, they are discarded; if it is older, the result is not saved.
+func (m *evaluationMemo) put(key string, generation uint64, result memoizedEvaluation) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if generation < m.generation {
+		return
+	}
+	if generation > m.generation {
+		m.generation = generation
+		m.results = make(map[string]memoizedEvaluation)
+	}
+	m.results[key] = result
+}
@@ -0,0 +1,185 @@
+package ldclient
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeUserEvaluatorTestClient(flags ...*FeatureFlag) (*LDClient, *queryRecordingFeatureStore) {
+	return makeUserEvaluatorTestClientWithConfig(func(c *Config) {}, flags...)
+}
+
+func makeUserEvaluatorTestClientWithConfig(modify func(*Config), flags ...*FeatureFlag) (*LDClient, *queryRecordingFeatureStore) {
+	store := &queryRecordingFeatureStore{FeatureStore: NewInMemoryFeatureStore(nil)}
+	client := makeTestClientWithConfig(func(c *Config) {
+		c.FeatureStore = store
+		modify(c)
+	})
+	for _, flag := range flags {
+		_ = client.store.Upsert(Features, flag)
+	}
+	store.queries = nil
+	return client, store
+}
+
+func TestUserEvaluatorEvaluatesFlag(t *testing.T) {
+	flag := makeTestFlag("flag", 1, "a", "b")
+	client, _ := makeUserEvaluatorTestClient(flag)
+	defer client.Close()
+
+	value, detail, err := client.ForUser(evalTestUser).StringVariationDetail("flag", "default")
+
+	require.NoError(t, err)
+	assert.Equal(t, "b", value)
+	assert.Equal(t, EvaluationDetail{Value: "b", VariationIndex: intPtr(1), Reason: evalReasonFallthroughInstance}, detail)
+	assertEvalEvent(t, client, flag, evalTestUser, "b", 1, "default", detail.Reason)
+}
+
+func TestUserEvaluatorRemembersResultButSendsEventForEachCall(t *testing.T) {
+	client, store := makeUserEvaluatorTestClient(makeTestFlag("flag", 1, false, true))
+	defer client.Close()
+	evaluator := client.ForUser(evalTestUser)
+
+	for i := 0; i < 3; i++ {
+		value, err := evaluator.BoolVariation("flag", false)
+		require.NoError(t, err)
+		assert.True(t, value)
+	}
+
+	assert.Equal(t, []string{"features:flag"}, store.queries)
+	assert.Len(t, client.eventProcessor.(*testEventProcessor).events, 3)
+}
+
+func TestUserEvaluatorOnlyAsksEventProcessorToCheckUserForFirstEvent(t *testing.T) {
+	client, _ := makeUserEvaluatorTestClient(makeTestFlag("flag1", 1, false, true), makeTestFlag("flag2", 1, "a", "b"))
+	defer client.Close()
+	evaluator := client.ForUser(evalTestUser)
+
+	_, _ = evaluator.BoolVariation("flag1", false)
+	_, _ = evaluator.StringVariation("flag2", "default")
+	_, _ = evaluator.WithDefaults(nil).BoolVariation("flag1", false)
+	_, _ = client.BoolVariation("flag1", evalTestUser, false)
+
+	events := client.eventProcessor.(*testEventProcessor).events
+	require.Len(t, events, 4)
+	assert.False(t, events[0].(FeatureRequestEvent).userNoticed)
+	assert.True(t, events[1].(FeatureRequestEvent).userNoticed)
+	assert.True(t, events[2].(FeatureRequestEvent).userNoticed)
+	assert.False(t, events[3].(FeatureRequestEvent).userNoticed)
+}
+
+func TestUserEvaluatorDoesNotShareResultsWithOtherEvaluators(t *testing.T) {
+	client, store := makeUserEvaluatorTestClient(makeTestFlag("flag", 1, false, true))
+	defer client.Close()
+
+	_, _ = client.ForUser(evalTestUser).BoolVariation("flag", false)
+	_, _ = client.ForUser(evalTestUser).BoolVariation("flag", false)
+
+	assert.Equal(t, []string{"features:flag", "features:flag"}, store.queries)
+}
+
+func TestUserEvaluatorDiscardsResultsWhenStoreChanges(t *testing.T) {
+	flag := makeTestFlag("flag", 0, "a", "b")
+	client, _ := makeUserEvaluatorTestClient(flag)
+	defer client.Close()
+	evaluator := client.ForUser(evalTestUser)
+
+	value, _ := evaluator.StringVariation("flag", "default")
+	assert.Equal(t, "a", value)
+
+	flag1 := makeTestFlag("flag", 1, "a", "b")
+	flag1.Version = flag.Version + 1
+	require.NoError(t, client.store.Upsert(Features, flag1))
+
+	value, _ = evaluator.StringVariation("flag", "default")
+	assert.Equal(t, "b", value)
+}
+
+func TestUserEvaluatorDoesNotRememberResultsInLDDMode(t *testing.T) {
+	client, store := makeUserEvaluatorTestClientWithConfig(func(c *Config) { c.UseLdd = true },
+		makeTestFlag("flag", 1, false, true))
+	defer client.Close()
+	evaluator := client.ForUser(evalTestUser)
+
+	_, _ = evaluator.BoolVariation("flag", false)
+	_, _ = evaluator.BoolVariation("flag", false)
+
+	assert.Equal(t, []string{"features:flag", "features:flag"}, store.queries)
+}
+
+func TestUserEvaluatorSendsPrerequisiteEventsForRememberedResult(t *testing.T) {
+	prereq := makeTestFlag("prereq", 1, false, true)
+	flag := makeTestFlag("flag", 1, "a", "b")
+	flag.Prerequisites = []Prerequisite{{Key: "prereq", Variation: 1}}
+	client, _ := makeUserEvaluatorTestClient(prereq, flag)
+	defer client.Close()
+	evaluator := client.ForUser(evalTestUser)
+
+	_, _ = evaluator.StringVariation("flag", "default")
+	_, _, _ = evaluator.StringVariationDetail("flag", "default")
+
+	events := client.eventProcessor.(*testEventProcessor).events
+	require.Len(t, events, 4)
+	for i, e := range events {
+		fe := e.(FeatureRequestEvent)
+		if i%2 == 0 {
+			assert.Equal(t, "prereq", fe.Key)
+			assert.Equal(t, "flag", *fe.PrereqOf)
+		} else {
+			assert.Equal(t, "flag", fe.Key)
+		}
+		if i < 2 {
+			assert.Nil(t, fe.Reason.Reason)
+		} else {
+			assert.NotNil(t, fe.Reason.Reason)
+		}
+	}
+}
+
+func TestUserEvaluatorReturnsCopyOfRememberedValue(t *testing.T) {
+	client, _ := makeUserEvaluatorTestClient(makeTestFlag("flag", 1, []interface{}{}, []interface{}{"a"}))
+	defer client.Close()
+	evaluator := client.ForUser(evalTestUser)
+
+	list, err := evaluator.StringListVariation("flag", nil)
+	require.NoError(t, err)
+	list[0] = "x"
+	var value []interface{}
+	require.NoError(t, evaluator.JsonVariationInto("flag", &value, nil))
+	value[0] = "y"
+	_, detail, err := evaluator.StringListVariationDetail("flag", nil)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, detail.Value)
+}
+
+func TestUserEvaluatorUsesScopedDefaultsOfMatchingType(t *testing.T) {
+	client, _ := makeUserEvaluatorTestClient()
+	defer client.Close()
+	evaluator := client.ForUser(evalTestUser).WithDefaults(map[string]interface{}{"flag1": "scoped", "flag2": 3})
+	evaluator = evaluator.WithDefaults(map[string]interface{}{"flag3": []string{"x"}})
+
+	s, err := evaluator.StringVariation("flag1", "default")
+	assert.Error(t, err)
+	assert.Equal(t, "scoped", s)
+	s, _ = evaluator.StringVariation("flag2", "default")
+	assert.Equal(t, "default", s)
+	n, _ := evaluator.IntVariation("flag2", 0)
+	assert.Equal(t, 3, n)
+	var list []string
+	assert.Error(t, evaluator.JsonVariationInto("flag3", &list, nil))
+	assert.Equal(t, []string{"x"}, list)
+}
+
+func TestUserEvaluatorReturnsErrorForUserWithoutKey(t *testing.T) {
+	client, _ := makeUserEvaluatorTestClient(makeTestFlag("flag", 1, false, true))
+	defer client.Close()
+
+	value, detail, err := client.ForUser(User{}).BoolVariationDetail("flag", false)
+
+	assert.Error(t, err)
+	assert.False(t, value)
+	assert.Equal(t, newEvalReasonError(EvalErrorUserNotSpecified), detail.Reason)
+}
@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/launchdarkly/go-client.v4/ldvalue"
 )
 
 func TestNewUser(t *testing.T) {
@@ -21,3 +23,21 @@ func TestNewAnonymousUser(t *testing.T) {
 	anonymous, _ := user.valueOf("anonymous")
 	assert.Equal(t, true, anonymous)
 }
+
+func TestGetCustomReturnsCopyOfAttribute(t *testing.T) {
+	custom := map[string]interface{}{"groups": []interface{}{"a"}, "none": nil}
+	user := User{Key: strPtr("some-key"), Custom: &custom}
+
+	value, found := user.GetCustom("groups")
+	assert.True(t, found)
+	assert.True(t, ldvalue.ArrayOf(ldvalue.String("a")).Equal(value))
+
+	value, found = user.GetCustom("none")
+	assert.True(t, found)
+	assert.True(t, value.IsNull())
+
+	_, found = user.GetCustom("missing")
+	assert.False(t, found)
+	_, found = NewUser("some-key").GetCustom("groups")
+	assert.False(t, found)
+}
@@ -102,6 +102,41 @@ func ToJsonRawMessage(input interface{}) (json.RawMessage, error) {
 	}
 }
 
+// deepCopyValue returns a copy of a value that was parsed from JSON, such as a flag variation, so that
+// the caller can modify it without affecting the original. Only maps and slices need to be copied;
+// other values are returned as they are. Evaluation itself does not copy anything; this is only done
+// where a result is handed to application code, such as in Evaluate, JsonVariation, and ToValuesMap.
+func deepCopyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[k] = deepCopyValue(item)
+		}
+		return m
+	case []interface{}:
+		if v == nil {
+			return v
+		}
+		a := make([]interface{}, len(v))
+		for i, item := range v {
+			a[i] = deepCopyValue(item)
+		}
+		return a
+	}
+	return value
+}
+
+func deepCopyValues(values []interface{}) []interface{} {
+	if values == nil {
+		return nil
+	}
+	return deepCopyValue(values).([]interface{})
+}
+
 func checkForHttpError(statusCode int, url string) error {
 	if statusCode == http.StatusUnauthorized {
 		return HttpStatusError{
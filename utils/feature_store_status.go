@@ -0,0 +1,35 @@
+package utils
+
+import (
+	ld "gopkg.in/launchdarkly/go-client.v4"
+	"gopkg.in/launchdarkly/go-client.v4/internal/broadcast"
+)
+
+// Delivers FeatureStoreStatus updates to any number of listeners, in the same way as the client's own
+// listener channels.
+type storeStatusBroadcaster struct {
+	broadcaster *broadcast.Broadcaster
+}
+
+func newStoreStatusBroadcaster() *storeStatusBroadcaster {
+	return &storeStatusBroadcaster{broadcaster: broadcast.New()}
+}
+
+func (b *storeStatusBroadcaster) addListener() <-chan ld.FeatureStoreStatus {
+	ch := make(chan ld.FeatureStoreStatus, broadcast.ListenerBufferSize)
+	var receiveCh <-chan ld.FeatureStoreStatus = ch
+	b.broadcaster.Add(broadcast.NewSubscription(ch, nil, broadcast.KeepLatest))
+	return receiveCh
+}
+
+func (b *storeStatusBroadcaster) removeListener(ch <-chan ld.FeatureStoreStatus) {
+	b.broadcaster.Remove(ch)
+}
+
+func (b *storeStatusBroadcaster) broadcast(status ld.FeatureStoreStatus) {
+	b.broadcaster.Broadcast(status)
+}
+
+func (b *storeStatusBroadcaster) close() {
+	b.broadcaster.Close()
+}
@@ -3,8 +3,10 @@
 package utils
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -57,7 +59,9 @@ type FeatureStoreCoreBase interface {
 	InitializedInternal() bool
 	// GetCacheTTL returns the length of time that data should be retained in an in-memory
 	// cache. This cache is maintained by FeatureStoreWrapper. If GetCacheTTL returns zero,
-	// there will be no cache.
+	// there will be no cache. If it returns a negative value, the cache never expires; this
+	// allows FeatureStoreWrapper to keep serving the last known data during a database outage,
+	// and to write that data back to the database once it is available again.
 	GetCacheTTL() time.Duration
 }
 
@@ -101,6 +105,19 @@ type NonAtomicFeatureStoreCore interface {
 	InitCollectionsInternal(allData []StoreCollection) error
 }
 
+// FeatureStoreCoreWithContext is an optional interface that a FeatureStoreCore or
+// NonAtomicFeatureStoreCore can implement if its queries can be given a deadline or cancelled. If
+// the core implements it, the GetWithContext and AllWithContext methods of FeatureStoreWrapper use
+// it; otherwise they can only check whether the context is already done before querying the core.
+type FeatureStoreCoreWithContext interface {
+	// GetInternalWithContext is the same as GetInternal, but should give up and return an error if
+	// the context is cancelled or its deadline passes before the query completes.
+	GetInternalWithContext(ctx context.Context, kind ld.VersionedDataKind, key string) (ld.VersionedData, error)
+	// GetAllInternalWithContext is the same as GetAllInternal, but should give up and return an
+	// error if the context is cancelled or its deadline passes before the query completes.
+	GetAllInternalWithContext(ctx context.Context, kind ld.VersionedDataKind) (map[string]ld.VersionedData, error)
+}
+
 // StoreCollection is used by the NonAtomicFeatureStoreCore interface.
 type StoreCollection struct {
 	Kind  ld.VersionedDataKind
@@ -110,31 +127,126 @@ type StoreCollection struct {
 // FeatureStoreWrapper is a partial implementation of ldclient.FeatureStore that delegates basic
 // functionality to an instance of FeatureStoreCore. It provides optional caching, and will
 // automatically provide the proper data ordering when using  NonAtomicFeatureStoreCoreInitialization.
+//
+// FeatureStoreWrapper also implements ldclient.FeatureStoreWithContext, passing the context to the
+// core if it implements FeatureStoreCoreWithContext, and ldclient.FeatureStoreStatusProvider. Whenever the core returns
+// an error, the store is considered unavailable, and FeatureStoreWrapper polls the core until it is
+// available again. If the cache TTL is infinite, the last known data set is written back to the
+// database at that point; otherwise the status will have NeedsRefresh set, since any updates that
+// arrived during the outage are missing from the database. With an infinite cache, Init and Upsert
+// do not return an error for a database failure, since the update is applied to the cache and is
+// not lost; the failure is reported only through the store status.
 type FeatureStoreWrapper struct {
-	core          FeatureStoreCoreBase
-	coreAtomic    FeatureStoreCore
-	coreNonAtomic NonAtomicFeatureStoreCore
-	cache         *cache.Cache
-	inited        bool
-	initLock      sync.RWMutex
+	core               FeatureStoreCoreBase
+	coreAtomic         FeatureStoreCore
+	coreNonAtomic      NonAtomicFeatureStoreCore
+	coreWithContext    FeatureStoreCoreWithContext // nil if the core does not implement it
+	cache              *cache.Cache
+	cacheInfinite      bool
+	inited             bool
+	initLock           sync.RWMutex
+	status             ld.FeatureStoreStatus
+	hasFullData        bool // true if the cache is infinite and contains a complete data set from Init
+	pollingForRecovery bool
+	statusPollInterval time.Duration
+	statusLock         sync.Mutex
+	statusBroadcaster  *storeStatusBroadcaster
+	closeCh            chan struct{}
+	closeOnce          sync.Once
+	tracer             ld.Tracer
+	metrics            ld.Metrics
 }
 
 const initCheckedKey = "$initChecked"
 
+const (
+	// How often FeatureStoreWrapper checks whether the database is available again after an error.
+	defaultStatusPollInterval = 500 * time.Millisecond
+	// If the cache never expires, we still shouldn't remember indefinitely that the store was not
+	// initialized, since another process may initialize it.
+	infiniteCacheInitCheckTTL = 5 * time.Second
+)
+
 // NewFeatureStoreWrapper creates an instance of FeatureStoreWrapper that wraps an instance
 // of FeatureStoreCore.
 func NewFeatureStoreWrapper(core FeatureStoreCore) *FeatureStoreWrapper {
-	w := FeatureStoreWrapper{core: core, coreAtomic: core}
-	w.cache = initCache(core)
-	return &w
+	w := newFeatureStoreWrapper(core)
+	w.coreAtomic = core
+	return w
 }
 
 // NewNonAtomicFeatureStoreWrapper creates an instance of FeatureStoreWrapper that wraps an
 // instance of NonAtomicFeatureStoreCore.
 func NewNonAtomicFeatureStoreWrapper(core NonAtomicFeatureStoreCore) *FeatureStoreWrapper {
-	w := FeatureStoreWrapper{core: core, coreNonAtomic: core}
-	w.cache = initCache(core)
-	return &w
+	w := newFeatureStoreWrapper(core)
+	w.coreNonAtomic = core
+	return w
+}
+
+func newFeatureStoreWrapper(core FeatureStoreCoreBase) *FeatureStoreWrapper {
+	coreWithContext, _ := core.(FeatureStoreCoreWithContext)
+	return &FeatureStoreWrapper{
+		core:               core,
+		cache:              initCache(core),
+		cacheInfinite:      core.GetCacheTTL() < 0,
+		status:             ld.FeatureStoreStatus{Available: true},
+		coreWithContext:    coreWithContext,
+		statusPollInterval: defaultStatusPollInterval,
+		statusBroadcaster:  newStoreStatusBroadcaster(),
+		closeCh:            make(chan struct{}),
+		tracer:             ld.NullTracer{},
+		metrics:            ld.NullMetrics{},
+	}
+}
+
+// SetTracer specifies a Tracer that will receive a span for each operation on the store, with the
+// ldclient.SpanNameStore... names. This is part of the ldclient.TracerReceiver interface; the client
+// calls it if Config.Tracer is set. It must be called before the store is used.
+func (w *FeatureStoreWrapper) SetTracer(tracer ld.Tracer) {
+	if tracer == nil {
+		tracer = ld.NullTracer{}
+	}
+	w.tracer = tracer
+}
+
+// SetMetrics specifies a Metrics object that will count the hits and misses of the cache, as
+// ldclient.MetricStoreCacheRequests. This is part of the ldclient.MetricsReceiver interface; the client
+// calls it if Config.Metrics is set. It must be called before the store is used.
+func (w *FeatureStoreWrapper) SetMetrics(metrics ld.Metrics) {
+	if metrics == nil {
+		metrics = ld.NullMetrics{}
+	}
+	w.metrics = metrics
+}
+
+// Records whether a query was answered from the cache, in both the span and the metrics.
+func (w *FeatureStoreWrapper) recordCacheResult(span ld.Span, kind ld.VersionedDataKind, hit bool) {
+	span.SetAttribute(ld.SpanAttrStoreCached, hit)
+	result := ld.MetricResultMiss
+	if hit {
+		result = ld.MetricResultHit
+	}
+	w.metrics.AddCount(ld.MetricStoreCacheRequests, 1, ld.MetricLabelKind, kind.GetNamespace(), ld.MetricLabelResult, result)
+}
+
+// Starts a span for an operation on the store. The key is omitted if it is empty.
+func (w *FeatureStoreWrapper) startSpan(ctx context.Context, name string, kind ld.VersionedDataKind, key string) (context.Context, ld.Span) {
+	ctx, span := w.tracer.StartSpan(ctx, name)
+	if kind != nil {
+		span.SetAttribute(ld.SpanAttrStoreKind, kind.GetNamespace())
+	}
+	if key != "" {
+		span.SetAttribute(ld.SpanAttrStoreKey, key)
+	}
+	return ctx, span
+}
+
+// Ends a span for an operation on the store, reporting the error if any.
+func endSpan(span ld.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
 }
 
 func initCache(core FeatureStoreCoreBase) *cache.Cache {
@@ -142,6 +254,9 @@ func initCache(core FeatureStoreCoreBase) *cache.Cache {
 	if cacheTTL > 0 {
 		return cache.New(cacheTTL, 5*time.Minute)
 	}
+	if cacheTTL < 0 {
+		return cache.New(cache.NoExpiration, 5*time.Minute)
+	}
 	return nil
 }
 
@@ -154,30 +269,51 @@ func featureStoreAllItemsCacheKey(kind ld.VersionedDataKind) string {
 }
 
 // Init performs an update of the entire data store, with optional caching.
-func (w *FeatureStoreWrapper) Init(allData map[ld.VersionedDataKind]map[string]ld.VersionedData) error {
-	var err error
-	if w.coreNonAtomic != nil {
-		// If the store uses non-atomic initialization, we'll need to put the data in the proper update
-		// order and call InitCollectionsInternal.
-		colls := transformUnorderedDataToOrderedData(allData)
-		err = w.coreNonAtomic.InitCollectionsInternal(colls)
-	} else {
-		err = w.coreAtomic.InitInternal(allData)
-	}
+func (w *FeatureStoreWrapper) Init(allData map[ld.VersionedDataKind]map[string]ld.VersionedData) (err error) {
+	_, span := w.startSpan(context.Background(), ld.SpanNameStoreInit, nil, "")
+	defer func() { endSpan(span, err) }()
+	err = w.initCore(allData)
 	if w.cache != nil {
 		w.cache.Flush()
-		if err == nil {
+		// With an infinite cache, the cache is our authoritative copy of the data during an outage, so
+		// we update it even if the database could not be written.
+		if err == nil || w.cacheInfinite {
 			for kind, items := range allData {
 				w.filterAndCacheItems(kind, items)
 			}
 		}
 	}
+	if w.cacheInfinite {
+		w.statusLock.Lock()
+		w.hasFullData = true
+		w.statusLock.Unlock()
+	}
+	if err != nil {
+		w.markUnavailable(err)
+		if !w.cacheInfinite {
+			return err
+		}
+	}
+	// With an infinite cache, the data was accepted even if the database could not be written, since
+	// it will be written when the database comes back; so as far as the caller is concerned, the
+	// update succeeded.
+	w.initLock.Lock()
+	w.inited = true
+	w.initLock.Unlock()
 	if err == nil {
-		w.initLock.Lock()
-		defer w.initLock.Unlock()
-		w.inited = true
+		w.markAvailable(false, false)
 	}
-	return err
+	return nil
+}
+
+func (w *FeatureStoreWrapper) initCore(allData map[ld.VersionedDataKind]map[string]ld.VersionedData) error {
+	if w.coreNonAtomic != nil {
+		// If the store uses non-atomic initialization, we'll need to put the data in the proper update
+		// order and call InitCollectionsInternal.
+		colls := transformUnorderedDataToOrderedData(allData)
+		return w.coreNonAtomic.InitCollectionsInternal(colls)
+	}
+	return w.coreAtomic.InitInternal(allData)
 }
 
 func (w *FeatureStoreWrapper) filterAndCacheItems(kind ld.VersionedDataKind, items map[string]ld.VersionedData) map[string]ld.VersionedData {
@@ -201,27 +337,59 @@ func (w *FeatureStoreWrapper) filterAndCacheItems(kind ld.VersionedDataKind, ite
 
 // Get retrieves a single item by key, with optional caching.
 func (w *FeatureStoreWrapper) Get(kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
+	return w.GetWithContext(context.Background(), kind, key)
+}
+
+// GetWithContext is the same as Get, but if the item is not cached, the query gives up when the
+// context is done. This is part of the ldclient.FeatureStoreWithContext interface.
+func (w *FeatureStoreWrapper) GetWithContext(ctx context.Context, kind ld.VersionedDataKind, key string) (item ld.VersionedData, err error) {
+	ctx, span := w.startSpan(ctx, ld.SpanNameStoreGet, kind, key)
+	defer func() { endSpan(span, err) }()
 	if w.cache == nil {
-		item, err := w.core.GetInternal(kind, key)
+		item, err := w.getInternal(ctx, kind, key)
+		if err != nil {
+			w.markUnavailableUnlessCancelled(ctx, err)
+		}
 		return itemOnlyIfNotDeleted(item), err
 	}
 	cacheKey := featureStoreCacheKey(kind, key)
 	if data, present := w.cache.Get(cacheKey); present {
 		if data == nil { // If present is true but data is nil, we have cached the absence of an item
+			w.recordCacheResult(span, kind, true)
 			return nil, nil
 		}
 		if item, ok := data.(ld.VersionedData); ok {
+			w.recordCacheResult(span, kind, true)
 			return itemOnlyIfNotDeleted(item), nil
 		}
 	}
 	// Item was not cached or cached value was not valid
-	item, err := w.core.GetInternal(kind, key)
+	w.recordCacheResult(span, kind, false)
+	item, err = w.getInternal(ctx, kind, key)
 	if err == nil {
 		w.cache.Set(cacheKey, item, cache.DefaultExpiration)
+	} else {
+		w.markUnavailableUnlessCancelled(ctx, err)
 	}
 	return itemOnlyIfNotDeleted(item), err
 }
 
+// Items that are read from the core are new objects, so they are preprocessed for evaluation here,
+// before they are cached; see ld.PreprocessItem.
+func (w *FeatureStoreWrapper) getInternal(ctx context.Context, kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
+	var item ld.VersionedData
+	var err error
+	if w.coreWithContext != nil {
+		item, err = w.coreWithContext.GetInternalWithContext(ctx, kind, key)
+	} else if err = ctx.Err(); err == nil {
+		item, err = w.core.GetInternal(kind, key)
+	}
+	if item != nil {
+		ld.PreprocessItem(item)
+	}
+	return item, err
+}
+
 func itemOnlyIfNotDeleted(item ld.VersionedData) ld.VersionedData {
 	if item != nil && item.IsDeleted() {
 		return nil
@@ -231,38 +399,112 @@ func itemOnlyIfNotDeleted(item ld.VersionedData) ld.VersionedData {
 
 // All retrieves all items of the specified kind, with optional caching.
 func (w *FeatureStoreWrapper) All(kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
+	return w.AllWithContext(context.Background(), kind)
+}
+
+// AllWithContext is the same as All, but if the items are not cached, the query gives up when the
+// context is done. This is part of the ldclient.FeatureStoreWithContext interface.
+func (w *FeatureStoreWrapper) AllWithContext(ctx context.Context, kind ld.VersionedDataKind) (items map[string]ld.VersionedData, err error) {
+	ctx, span := w.startSpan(ctx, ld.SpanNameStoreAll, kind, "")
+	defer func() { endSpan(span, err) }()
 	if w.cache == nil {
-		return w.core.GetAllInternal(kind)
+		items, err := w.getAllInternal(ctx, kind)
+		if err != nil {
+			w.markUnavailableUnlessCancelled(ctx, err)
+		}
+		return items, err
 	}
 	// Check whether we have a cache item for the entire data set
 	cacheKey := featureStoreAllItemsCacheKey(kind)
 	if data, present := w.cache.Get(cacheKey); present {
 		if items, ok := data.(map[string]ld.VersionedData); ok {
+			w.recordCacheResult(span, kind, true)
 			return items, nil
 		}
 	}
 	// Data set was not cached or cached value was not valid
-	items, err := w.core.GetAllInternal(kind)
+	w.recordCacheResult(span, kind, false)
+	items, err = w.getAllInternal(ctx, kind)
 	if err != nil {
+		w.markUnavailableUnlessCancelled(ctx, err)
 		return nil, err
 	}
 	return w.filterAndCacheItems(kind, items), nil
 }
 
+func (w *FeatureStoreWrapper) getAllInternal(ctx context.Context, kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
+	var items map[string]ld.VersionedData
+	var err error
+	if w.coreWithContext != nil {
+		items, err = w.coreWithContext.GetAllInternalWithContext(ctx, kind)
+	} else if err = ctx.Err(); err == nil {
+		items, err = w.core.GetAllInternal(kind)
+	}
+	for _, item := range items {
+		ld.PreprocessItem(item)
+	}
+	return items, err
+}
+
 // Upsert updates or adds an item, with optional caching.
-func (w *FeatureStoreWrapper) Upsert(kind ld.VersionedDataKind, item ld.VersionedData) error {
+func (w *FeatureStoreWrapper) Upsert(kind ld.VersionedDataKind, item ld.VersionedData) (err error) {
+	_, span := w.startSpan(context.Background(), ld.SpanNameStoreUpsert, kind, item.GetKey())
+	defer func() { endSpan(span, err) }()
 	finalItem, err := w.core.UpsertInternal(kind, item)
+	if err != nil {
+		w.markUnavailable(err)
+		if !w.cacheInfinite {
+			return err
+		}
+		// With an infinite cache, we apply the update to the cache anyway, so that it is served during
+		// the outage and written to the database when it comes back; since the update is not lost, we
+		// report it as successful, so that callers such as change tracking treat it as applied.
+		err = nil
+		finalItem = item
+		if data, present := w.cache.Get(featureStoreCacheKey(kind, item.GetKey())); present {
+			if oldItem, ok := data.(ld.VersionedData); ok && oldItem.GetVersion() >= item.GetVersion() {
+				finalItem = oldItem
+			}
+		}
+	}
 	// Note that what we put into the cache is finalItem, which may not be the same as item (i.e. if
 	// another process has already updated the item to a higher version).
-	if err == nil && finalItem != nil {
-		if w.cache != nil {
-			w.cache.Set(featureStoreCacheKey(kind, item.GetKey()), finalItem, cache.DefaultExpiration)
+	if finalItem != nil && w.cache != nil {
+		w.cache.Set(featureStoreCacheKey(kind, item.GetKey()), finalItem, cache.DefaultExpiration)
+		if w.cacheInfinite {
+			w.updateAllItemsCache(kind, finalItem)
+		} else {
 			w.cache.Delete(featureStoreAllItemsCacheKey(kind))
 		}
 	}
 	return err
 }
 
+// With an infinite cache, we can't just invalidate the cached data set for All after an update, since
+// we might not be able to read it from the database again; so we update it instead.
+func (w *FeatureStoreWrapper) updateAllItemsCache(kind ld.VersionedDataKind, item ld.VersionedData) {
+	cacheKey := featureStoreAllItemsCacheKey(kind)
+	data, present := w.cache.Get(cacheKey)
+	if !present {
+		return
+	}
+	oldItems, ok := data.(map[string]ld.VersionedData)
+	if !ok {
+		w.cache.Delete(cacheKey)
+		return
+	}
+	newItems := make(map[string]ld.VersionedData, len(oldItems)+1)
+	for k, v := range oldItems {
+		newItems[k] = v
+	}
+	if item.IsDeleted() {
+		delete(newItems, item.GetKey())
+	} else {
+		newItems[item.GetKey()] = item
+	}
+	w.cache.Set(cacheKey, newItems, cache.DefaultExpiration)
+}
+
 // Delete deletes an item, with optional caching.
 func (w *FeatureStoreWrapper) Delete(kind ld.VersionedDataKind, key string, version int) error {
 	deletedItem := kind.MakeDeletedItem(key, version)
@@ -299,8 +541,150 @@ func (w *FeatureStoreWrapper) Initialized() bool {
 		}
 	} else {
 		if w.cache != nil {
-			w.cache.Set(initCheckedKey, "", cache.DefaultExpiration)
+			initCheckedTTL := cache.DefaultExpiration
+			if w.cacheInfinite {
+				initCheckedTTL = infiniteCacheInitCheckTTL
+			}
+			w.cache.Set(initCheckedKey, "", initCheckedTTL)
 		}
 	}
 	return newValue
 }
+
+// GetStoreStatus returns the current availability status of the store. This is part of the
+// ldclient.FeatureStoreStatusProvider interface.
+func (w *FeatureStoreWrapper) GetStoreStatus() ld.FeatureStoreStatus {
+	w.statusLock.Lock()
+	defer w.statusLock.Unlock()
+	return w.status
+}
+
+// AddStatusListener returns a channel that will receive a new status whenever the store becomes
+// unavailable or available again. This is part of the ldclient.FeatureStoreStatusProvider interface.
+func (w *FeatureStoreWrapper) AddStatusListener() <-chan ld.FeatureStoreStatus {
+	return w.statusBroadcaster.addListener()
+}
+
+// RemoveStatusListener unsubscribes and closes a channel that was returned by AddStatusListener.
+// This is part of the ldclient.FeatureStoreStatusProvider interface.
+func (w *FeatureStoreWrapper) RemoveStatusListener(ch <-chan ld.FeatureStoreStatus) {
+	w.statusBroadcaster.removeListener(ch)
+}
+
+// Close stops any polling for database recovery that is in progress, and closes all status
+// listener channels. It does not close the underlying database connection.
+func (w *FeatureStoreWrapper) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.closeCh)
+		w.statusBroadcaster.close()
+	})
+	return nil
+}
+
+func (w *FeatureStoreWrapper) markUnavailable(err error) {
+	w.statusLock.Lock()
+	wasAvailable := w.status.Available
+	w.status = ld.FeatureStoreStatus{Available: false, LastError: err}
+	newStatus := w.status
+	startPolling := !w.pollingForRecovery
+	w.pollingForRecovery = true
+	w.statusLock.Unlock()
+	if startPolling {
+		go w.pollForRecovery()
+	}
+	if wasAvailable {
+		w.statusBroadcaster.broadcast(newStatus)
+	}
+}
+
+// A query that failed because the caller's context was done does not tell us anything about whether
+// the database is available. The core may notice that the deadline was reached slightly before the
+// context does, so a context error is also accepted as meaning that.
+func (w *FeatureStoreWrapper) markUnavailableUnlessCancelled(ctx context.Context, err error) {
+	if ctx.Err() == nil && err != context.Canceled && err != context.DeadlineExceeded {
+		w.markUnavailable(err)
+	}
+}
+
+// Sets the status to available, if it was not already. If fromPoller is true, this also tells
+// markUnavailable that it will need to start a new polling goroutine next time.
+func (w *FeatureStoreWrapper) markAvailable(needsRefresh bool, fromPoller bool) {
+	w.statusLock.Lock()
+	if fromPoller {
+		w.pollingForRecovery = false
+	}
+	if w.status.Available {
+		w.statusLock.Unlock()
+		return
+	}
+	w.status.Available = true
+	w.status.NeedsRefresh = needsRefresh
+	newStatus := w.status
+	w.statusLock.Unlock()
+	w.statusBroadcaster.broadcast(newStatus)
+}
+
+func (w *FeatureStoreWrapper) pollForRecovery() {
+	ticker := time.NewTicker(w.statusPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.closeCh:
+			return
+		case <-ticker.C:
+		}
+		w.statusLock.Lock()
+		if w.status.Available { // we may have recovered due to a successful Init in the meantime
+			w.pollingForRecovery = false
+			w.statusLock.Unlock()
+			return
+		}
+		hasFullData := w.hasFullData
+		w.statusLock.Unlock()
+
+		if hasFullData {
+			// The cache is infinite and contains everything we know, so we can restore the database
+			// regardless of what state it is in now.
+			if err := w.initCore(w.getCachedData()); err != nil {
+				w.statusLock.Lock()
+				w.status.LastError = err
+				w.statusLock.Unlock()
+				continue
+			}
+			w.initLock.Lock()
+			w.inited = true
+			w.initLock.Unlock()
+			w.markAvailable(false, true)
+			return
+		}
+		if w.core.InitializedInternal() {
+			// We don't know what updates we missed while the database was unavailable, so any cached
+			// data may be out of date.
+			if w.cache != nil {
+				w.cache.Flush()
+			}
+			w.markAvailable(true, true)
+			return
+		}
+	}
+}
+
+// Reconstructs the full data set from the individually cached items. This is only meaningful if the
+// cache is infinite and hasFullData is true.
+func (w *FeatureStoreWrapper) getCachedData() map[ld.VersionedDataKind]map[string]ld.VersionedData {
+	cachedItems := w.cache.Items()
+	allData := make(map[ld.VersionedDataKind]map[string]ld.VersionedData, len(ld.VersionedDataKinds))
+	for _, kind := range ld.VersionedDataKinds {
+		prefix := featureStoreCacheKey(kind, "")
+		items := make(map[string]ld.VersionedData)
+		for cacheKey, cacheItem := range cachedItems {
+			if strings.HasPrefix(cacheKey, prefix) {
+				if item, ok := cacheItem.Object.(ld.VersionedData); ok && item != nil {
+					items[item.GetKey()] = item
+				}
+			}
+		}
+		allData[kind] = items
+	}
+	return allData
+}
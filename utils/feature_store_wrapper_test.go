@@ -1,7 +1,13 @@
 package utils
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -16,6 +22,8 @@ type mockCore struct {
 	data             map[ld.VersionedDataKind]map[string]ld.VersionedData
 	inited           bool
 	initQueriedCount int
+	fakeError        error
+	lock             sync.Mutex // used only by the methods that FeatureStoreWrapper may call from another goroutine
 }
 
 // Test implementation of NonAtomicFeatureStoreCore - we test this in somewhat less deteail
@@ -42,21 +50,53 @@ func (c *mockCore) GetCacheTTL() time.Duration {
 	return c.cacheTTL
 }
 
+func (c *mockCore) setFakeError(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.fakeError = err
+}
+
+func (c *mockCore) getData(kind ld.VersionedDataKind, key string) ld.VersionedData {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.data[kind][key]
+}
+
 func (c *mockCore) InitInternal(allData map[ld.VersionedDataKind]map[string]ld.VersionedData) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.fakeError != nil {
+		return c.fakeError
+	}
 	c.data = allData
 	c.inited = true
 	return nil
 }
 
 func (c *mockCore) GetInternal(kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.fakeError != nil {
+		return nil, c.fakeError
+	}
 	return c.data[kind][key], nil
 }
 
 func (c *mockCore) GetAllInternal(kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.fakeError != nil {
+		return nil, c.fakeError
+	}
 	return c.data[kind], nil
 }
 
 func (c *mockCore) UpsertInternal(kind ld.VersionedDataKind, item ld.VersionedData) (ld.VersionedData, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.fakeError != nil {
+		return nil, c.fakeError
+	}
 	oldItem := c.data[kind][item.GetKey()]
 	if oldItem != nil && oldItem.GetVersion() >= item.GetVersion() {
 		return oldItem, nil
@@ -66,8 +106,32 @@ func (c *mockCore) UpsertInternal(kind ld.VersionedDataKind, item ld.VersionedDa
 }
 
 func (c *mockCore) InitializedInternal() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	c.initQueriedCount++
-	return c.inited
+	return c.inited && c.fakeError == nil
+}
+
+// Test implementation of FeatureStoreCoreWithContext, which records the last context it was given
+type mockCoreWithContext struct {
+	*mockCore
+	lastContext context.Context
+}
+
+func (c *mockCoreWithContext) GetInternalWithContext(ctx context.Context, kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
+	c.lastContext = ctx
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.GetInternal(kind, key)
+}
+
+func (c *mockCoreWithContext) GetAllInternalWithContext(ctx context.Context, kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
+	c.lastContext = ctx
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.GetAllInternal(kind)
 }
 
 func (c *mockNonAtomicCore) GetCacheTTL() time.Duration {
@@ -135,6 +199,23 @@ func TestFeatureStoreWrapper(t *testing.T) {
 		}
 	})
 
+	runCachedAndUncachedTests(t, "items read from core are preprocessed", func(t *testing.T, isCached bool, core *mockCore) {
+		w := NewFeatureStoreWrapper(core)
+		flag := &ld.FeatureFlag{Key: "flag", Version: 1}
+		segment := &ld.Segment{Key: "segment", Version: 1}
+		core.forceSet(ld.Features, flag)
+		core.forceSet(ld.Segments, segment)
+
+		_, err := w.Get(ld.Features, flag.Key)
+		require.NoError(t, err)
+		_, err = w.All(ld.Segments)
+		require.NoError(t, err)
+
+		// The preprocessed data is unexported, but reflection can tell whether it has been set
+		assert.False(t, reflect.ValueOf(flag).Elem().FieldByName("preprocessed").IsNil())
+		assert.False(t, reflect.ValueOf(segment).Elem().FieldByName("preprocessed").IsNil())
+	})
+
 	runCachedAndUncachedTests(t, "Get with deleted item", func(t *testing.T, isCached bool, core *mockCore) {
 		w := NewFeatureStoreWrapper(core)
 		flagv1 := ld.FeatureFlag{Key: "flag", Version: 1, Deleted: true}
@@ -454,3 +535,388 @@ var dependencyOrderingTestData = map[ld.VersionedDataKind]map[string]ld.Versione
 		"1": &ld.Segment{Key: "1"},
 	},
 }
+
+func expectStoreStatus(t *testing.T, ch <-chan ld.FeatureStoreStatus) ld.FeatureStoreStatus {
+	select {
+	case status := <-ch:
+		return status
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out waiting for store status")
+	}
+	return ld.FeatureStoreStatus{}
+}
+
+func makeWrapperForStatusTest(core *mockCore) *FeatureStoreWrapper {
+	w := NewFeatureStoreWrapper(core)
+	w.statusPollInterval = 10 * time.Millisecond
+	return w
+}
+
+func TestFeatureStoreWrapperStatus(t *testing.T) {
+	fakeError := errors.New("sorry")
+	flag1 := ld.FeatureFlag{Key: "flag1", Version: 1}
+	flag2 := ld.FeatureFlag{Key: "flag2", Version: 1}
+	allData := map[ld.VersionedDataKind]map[string]ld.VersionedData{
+		ld.Features: {flag1.Key: &flag1},
+		ld.Segments: {},
+	}
+
+	t.Run("store is initially available", func(t *testing.T) {
+		w := makeWrapperForStatusTest(newCore(0))
+		defer w.Close()
+		assert.Equal(t, ld.FeatureStoreStatus{Available: true}, w.GetStoreStatus())
+	})
+
+	t.Run("error makes store unavailable", func(t *testing.T) {
+		core := newCore(0)
+		w := makeWrapperForStatusTest(core)
+		defer w.Close()
+		ch := w.AddStatusListener()
+
+		core.setFakeError(fakeError)
+		_, err := w.Get(ld.Features, "flag1")
+		assert.Equal(t, fakeError, err)
+
+		status := expectStoreStatus(t, ch)
+		assert.Equal(t, ld.FeatureStoreStatus{Available: false, LastError: fakeError}, status)
+		assert.Equal(t, status, w.GetStoreStatus())
+	})
+
+	t.Run("recovery is detected by polling InitializedInternal", func(t *testing.T) {
+		core := newCore(30 * time.Second)
+		w := makeWrapperForStatusTest(core)
+		defer w.Close()
+		require.NoError(t, w.Init(allData))
+		ch := w.AddStatusListener()
+
+		core.setFakeError(fakeError)
+		assert.Error(t, w.Upsert(ld.Features, &flag2))
+		assert.False(t, expectStoreStatus(t, ch).Available)
+
+		core.setFakeError(nil)
+		status := expectStoreStatus(t, ch)
+		assert.True(t, status.Available)
+		assert.True(t, status.NeedsRefresh) // the cache is not infinite, so we couldn't restore the data
+		assert.Nil(t, core.getData(ld.Features, flag2.Key))
+	})
+
+	t.Run("successful Init makes store available", func(t *testing.T) {
+		core := newCore(0)
+		w := makeWrapperForStatusTest(core)
+		w.statusPollInterval = time.Hour
+		defer w.Close()
+		ch := w.AddStatusListener()
+
+		core.setFakeError(fakeError)
+		assert.Error(t, w.Init(allData))
+		assert.False(t, expectStoreStatus(t, ch).Available)
+
+		core.setFakeError(nil)
+		require.NoError(t, w.Init(allData))
+		status := expectStoreStatus(t, ch)
+		assert.True(t, status.Available)
+		assert.False(t, status.NeedsRefresh)
+	})
+
+	t.Run("infinite cache serves and restores data during outage", func(t *testing.T) {
+		core := newCore(-1)
+		w := makeWrapperForStatusTest(core)
+		defer w.Close()
+		require.NoError(t, w.Init(allData))
+		ch := w.AddStatusListener()
+
+		core.setFakeError(fakeError)
+		assert.NoError(t, w.Upsert(ld.Features, &flag2))
+		assert.False(t, expectStoreStatus(t, ch).Available)
+
+		item, err := w.Get(ld.Features, flag2.Key)
+		require.NoError(t, err)
+		assert.Equal(t, &flag2, item)
+		items, err := w.All(ld.Features)
+		require.NoError(t, err)
+		assert.Equal(t, 2, len(items))
+
+		core.setFakeError(nil)
+		status := expectStoreStatus(t, ch)
+		assert.True(t, status.Available)
+		assert.False(t, status.NeedsRefresh)
+		assert.Equal(t, &flag1, core.getData(ld.Features, flag1.Key))
+		assert.Equal(t, &flag2, core.getData(ld.Features, flag2.Key))
+	})
+
+	t.Run("infinite cache restores data from Init that failed", func(t *testing.T) {
+		core := newCore(-1)
+		w := makeWrapperForStatusTest(core)
+		defer w.Close()
+		ch := w.AddStatusListener()
+
+		core.setFakeError(fakeError)
+		assert.NoError(t, w.Init(allData))
+		assert.False(t, expectStoreStatus(t, ch).Available)
+		assert.True(t, w.Initialized())
+
+		core.setFakeError(nil)
+		assert.True(t, expectStoreStatus(t, ch).Available)
+		assert.Equal(t, &flag1, core.getData(ld.Features, flag1.Key))
+		assert.True(t, w.Initialized())
+	})
+
+	t.Run("Close closes status listeners", func(t *testing.T) {
+		w := makeWrapperForStatusTest(newCore(0))
+		ch := w.AddStatusListener()
+		require.NoError(t, w.Close())
+		_, ok := <-ch
+		assert.False(t, ok)
+	})
+}
+
+// An UpdateProcessor that does nothing, but lets the test write to the store that the client gave it.
+type storeCapturingUpdateProcessor struct{}
+
+func (p storeCapturingUpdateProcessor) Initialized() bool { return true }
+func (p storeCapturingUpdateProcessor) Close() error      { return nil }
+func (p storeCapturingUpdateProcessor) Start(closeWhenReady chan<- struct{}) {
+	close(closeWhenReady)
+}
+
+func TestFeatureStoreWrapperWithInfiniteCacheReportsChangesDuringOutage(t *testing.T) {
+	core := newCore(-1)
+	w := makeWrapperForStatusTest(core)
+	var store ld.FeatureStore
+	config := ld.DefaultConfig
+	config.FeatureStore = w
+	config.SendEvents = false
+	config.Logger = log.New(ioutil.Discard, "", 0)
+	config.UpdateProcessorFactory = func(sdkKey string, config ld.Config) (ld.UpdateProcessor, error) {
+		store = config.FeatureStore
+		return storeCapturingUpdateProcessor{}, nil
+	}
+	client, err := ld.MakeCustomClient("sdk-key", config, time.Second)
+	require.NoError(t, err)
+	defer client.Close()
+	user := ld.NewUser("user")
+
+	offVariation := 0
+	flag1 := ld.FeatureFlag{Key: "flag1", Version: 1, OffVariation: &offVariation, Variations: []interface{}{"a", "b"}}
+	require.NoError(t, store.Init(map[ld.VersionedDataKind]map[string]ld.VersionedData{
+		ld.Features: {flag1.Key: &flag1},
+		ld.Segments: {},
+	}))
+	ch := client.AddFlagChangeListener()
+	defer client.RemoveFlagChangeListener(ch)
+
+	core.setFakeError(errors.New("sorry"))
+	offVariation1 := 1
+	flag1v2 := ld.FeatureFlag{Key: "flag1", Version: 2, OffVariation: &offVariation1, Variations: []interface{}{"a", "b"}}
+	require.NoError(t, store.Upsert(ld.Features, &flag1v2))
+
+	select {
+	case e := <-ch:
+		assert.Equal(t, "flag1", e.Key)
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out waiting for flag change event")
+	}
+	value, _ := client.StringVariation("flag1", user, "default")
+	assert.Equal(t, "b", value)
+
+	require.NoError(t, store.Delete(ld.Features, "flag1", 3))
+	select {
+	case e := <-ch:
+		assert.Equal(t, "flag1", e.Key)
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out waiting for flag change event")
+	}
+	value, _ = client.StringVariation("flag1", user, "default")
+	assert.Equal(t, "default", value)
+}
+
+type contextTestKey struct{}
+
+func TestFeatureStoreWrapperWithContext(t *testing.T) {
+	flag := ld.FeatureFlag{Key: "flag", Version: 1}
+
+	t.Run("context is passed to core", func(t *testing.T) {
+		core := &mockCoreWithContext{mockCore: newCore(0)}
+		core.forceSet(ld.Features, &flag)
+		w := NewFeatureStoreWrapper(core)
+		defer w.Close()
+		ctx := context.WithValue(context.Background(), contextTestKey{}, "x")
+
+		item, err := w.GetWithContext(ctx, ld.Features, flag.Key)
+		require.NoError(t, err)
+		assert.Equal(t, &flag, item)
+		assert.Equal(t, ctx, core.lastContext)
+
+		core.lastContext = nil
+		items, err := w.AllWithContext(ctx, ld.Features)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]ld.VersionedData{flag.Key: &flag}, items)
+		assert.Equal(t, ctx, core.lastContext)
+	})
+
+	t.Run("query that fails because context is done does not make store unavailable", func(t *testing.T) {
+		core := &mockCoreWithContext{mockCore: newCore(0)}
+		w := NewFeatureStoreWrapper(core)
+		defer w.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := w.GetWithContext(ctx, ld.Features, flag.Key)
+		assert.Equal(t, context.Canceled, err)
+		_, err = w.AllWithContext(ctx, ld.Features)
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, ld.FeatureStoreStatus{Available: true}, w.GetStoreStatus())
+	})
+
+	t.Run("deadline error from core before context notices does not make store unavailable", func(t *testing.T) {
+		core := &mockCoreWithContext{mockCore: newCore(0)}
+		core.setFakeError(context.DeadlineExceeded)
+		w := NewFeatureStoreWrapper(core)
+		defer w.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+		defer cancel()
+
+		_, err := w.GetWithContext(ctx, ld.Features, flag.Key)
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.Equal(t, ld.FeatureStoreStatus{Available: true}, w.GetStoreStatus())
+	})
+
+	t.Run("core without context support is not queried if context is done", func(t *testing.T) {
+		core := newCore(0)
+		core.setFakeError(errors.New("should not have queried the core"))
+		w := makeWrapperForStatusTest(core)
+		defer w.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := w.GetWithContext(ctx, ld.Features, flag.Key)
+		assert.Equal(t, context.Canceled, err)
+		_, err = w.AllWithContext(ctx, ld.Features)
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, ld.FeatureStoreStatus{Available: true}, w.GetStoreStatus())
+	})
+
+	t.Run("cached item is returned even if context is done", func(t *testing.T) {
+		core := &mockCoreWithContext{mockCore: newCore(30 * time.Second)}
+		core.forceSet(ld.Features, &flag)
+		w := NewFeatureStoreWrapper(core)
+		defer w.Close()
+		_, err := w.Get(ld.Features, flag.Key)
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		item, err := w.GetWithContext(ctx, ld.Features, flag.Key)
+		require.NoError(t, err)
+		assert.Equal(t, &flag, item)
+	})
+}
+
+type testSpan struct {
+	name   string
+	ctx    context.Context
+	attrs  map[string]interface{}
+	errors []error
+	ended  bool
+}
+
+// Records spans; the wrapper only uses it from the test's goroutine, so it does not need a lock.
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (t *testTracer) StartSpan(ctx context.Context, name string) (context.Context, ld.Span) {
+	span := &testSpan{name: name, ctx: ctx, attrs: make(map[string]interface{})}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) { s.attrs[key] = value }
+func (s *testSpan) RecordError(err error)                      { s.errors = append(s.errors, err) }
+func (s *testSpan) End()                                       { s.ended = true }
+
+func TestFeatureStoreWrapperTracing(t *testing.T) {
+	flag := ld.FeatureFlag{Key: "flag", Version: 1}
+
+	t.Run("operations report spans", func(t *testing.T) {
+		core := newCore(30 * time.Second)
+		w := NewFeatureStoreWrapper(core)
+		defer w.Close()
+		tracer := &testTracer{}
+		w.SetTracer(tracer)
+		ctx := context.WithValue(context.Background(), contextTestKey{}, "x")
+
+		require.NoError(t, w.Init(map[ld.VersionedDataKind]map[string]ld.VersionedData{ld.Features: {}}))
+		require.NoError(t, w.Upsert(ld.Features, &flag))
+		_, _ = w.GetWithContext(ctx, ld.Features, flag.Key)
+		_, _ = w.AllWithContext(ctx, ld.Segments)
+		_, _ = w.AllWithContext(ctx, ld.Segments)
+
+		require.Len(t, tracer.spans, 5)
+		assert.Equal(t, ld.SpanNameStoreInit, tracer.spans[0].name)
+		assert.Equal(t, ld.SpanNameStoreUpsert, tracer.spans[1].name)
+		assert.Equal(t, map[string]interface{}{ld.SpanAttrStoreKind: "features", ld.SpanAttrStoreKey: "flag"}, tracer.spans[1].attrs)
+		assert.Equal(t, ld.SpanNameStoreGet, tracer.spans[2].name)
+		assert.Equal(t, ctx, tracer.spans[2].ctx)
+		assert.Equal(t, map[string]interface{}{ld.SpanAttrStoreKind: "features", ld.SpanAttrStoreKey: "flag",
+			ld.SpanAttrStoreCached: true}, tracer.spans[2].attrs)
+		assert.Equal(t, ld.SpanNameStoreAll, tracer.spans[3].name)
+		assert.Equal(t, map[string]interface{}{ld.SpanAttrStoreKind: "segments", ld.SpanAttrStoreCached: false}, tracer.spans[3].attrs)
+		assert.Equal(t, true, tracer.spans[4].attrs[ld.SpanAttrStoreCached])
+		for _, s := range tracer.spans {
+			assert.True(t, s.ended)
+			assert.Nil(t, s.errors)
+		}
+	})
+
+	t.Run("span records error", func(t *testing.T) {
+		core := newCore(0)
+		w := makeWrapperForStatusTest(core)
+		defer w.Close()
+		tracer := &testTracer{}
+		w.SetTracer(tracer)
+		core.setFakeError(errors.New("sorry"))
+
+		_, err := w.Get(ld.Features, flag.Key)
+
+		require.Error(t, err)
+		require.Len(t, tracer.spans, 1)
+		assert.Equal(t, []error{err}, tracer.spans[0].errors)
+		assert.True(t, tracer.spans[0].ended)
+	})
+}
+
+// Counts the calls to AddCount; the wrapper does not use the other methods.
+type testMetrics struct {
+	counts map[string]int64
+	lock   sync.Mutex
+}
+
+func (m *testMetrics) AddCount(name string, delta int64, labels ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.counts[strings.Join(append([]string{name}, labels...), " ")] += delta
+}
+
+func (m *testMetrics) SetGauge(name string, value float64, labels ...string) {}
+
+func (m *testMetrics) ObserveDuration(name string, duration time.Duration, labels ...string) {}
+
+func TestFeatureStoreWrapperCacheMetrics(t *testing.T) {
+	core := newCore(30 * time.Second)
+	w := NewFeatureStoreWrapper(core)
+	defer w.Close()
+	metrics := &testMetrics{counts: make(map[string]int64)}
+	w.SetMetrics(metrics)
+	flag := ld.FeatureFlag{Key: "flag", Version: 1}
+	core.forceSet(ld.Features, &flag)
+
+	_, _ = w.Get(ld.Features, flag.Key)
+	_, _ = w.Get(ld.Features, flag.Key)
+	_, _ = w.All(ld.Features)
+
+	assert.Equal(t, map[string]int64{
+		"launchdarkly_store_cache_requests_total kind features result hit":  1,
+		"launchdarkly_store_cache_requests_total kind features result miss": 2,
+	}, metrics.counts)
+}
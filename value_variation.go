@@ -0,0 +1,49 @@
+package ldclient
+
+import (
+	"context"
+
+	"gopkg.in/launchdarkly/go-client.v4/ldvalue"
+)
+
+// ValueVariation evaluates a feature flag of any type, and returns its value as an immutable
+// ldvalue.Value. Unlike JsonVariation, it does not need to marshal or copy the value, since a Value
+// can be shared safely with the feature store. If the flag cannot be evaluated, it returns defaultVal.
+func (client *LDClient) ValueVariation(key string, user User, defaultVal ldvalue.Value) (ldvalue.Value, error) {
+	value, _, err := client.valueVariation(context.Background(), key, user, defaultVal, false)
+	return value, err
+}
+
+// ValueVariationDetail is the same as ValueVariation, but also returns further information about how
+// the value was calculated. The "reason" data will also be included in analytics events.
+func (client *LDClient) ValueVariationDetail(key string, user User, defaultVal ldvalue.Value) (ldvalue.Value, EvaluationDetail, error) {
+	return client.valueVariation(context.Background(), key, user, defaultVal, true)
+}
+
+// ValueVariationCtx is the same as ValueVariation, but uses a context for feature store queries.
+func (client *LDClient) ValueVariationCtx(ctx context.Context, key string, user User, defaultVal ldvalue.Value) (ldvalue.Value, error) {
+	value, _, err := client.valueVariation(ctx, key, user, defaultVal, false)
+	return value, err
+}
+
+// ValueVariationDetailCtx is the same as ValueVariationDetail, but uses a context for feature store
+// queries.
+func (client *LDClient) ValueVariationDetailCtx(ctx context.Context, key string, user User, defaultVal ldvalue.Value) (ldvalue.Value, EvaluationDetail, error) {
+	return client.valueVariation(ctx, key, user, defaultVal, true)
+}
+
+func (client *LDClient) valueVariation(ctx context.Context, key string, user User, defaultVal ldvalue.Value,
+	sendReasonsInEvents bool) (ldvalue.Value, EvaluationDetail, error) {
+	detail, flag, err := client.variation(ctx, key, user, defaultVal.AsArbitraryValue(), sendReasonsInEvents)
+	var value ldvalue.Value
+	switch {
+	case flag != nil && detail.VariationIndex != nil && client.isFlagVariationValue(flag, *detail.VariationIndex, detail.Value):
+		value = flag.VariationValue(*detail.VariationIndex) // converted in advance when the flag was preprocessed
+	case err != nil && len(client.config.EvaluationHooks) == 0:
+		value = defaultVal
+	default:
+		value = ldvalue.CopyArbitraryValue(detail.Value) // possibly a value that an evaluation hook substituted
+	}
+	detail.Value = value
+	return value, detail, err
+}
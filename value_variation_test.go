@@ -0,0 +1,156 @@
+package ldclient
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/launchdarkly/go-client.v4/ldvalue"
+)
+
+func makeObjectValueTestFlag() *FeatureFlag {
+	return makeTestFlag("objectFlag", 1,
+		map[string]interface{}{"name": "a"},
+		map[string]interface{}{"name": "b", "servers": []interface{}{"x", "y"}})
+}
+
+func TestValueVariationReturnsFlagValue(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	flag := makeObjectValueTestFlag()
+	client.store.Upsert(Features, flag)
+
+	value, err := client.ValueVariation("objectFlag", evalTestUser, ldvalue.Null())
+
+	require.NoError(t, err)
+	assert.Equal(t, "b", value.GetByKey("name").StringValue())
+	assert.True(t, ldvalue.ArrayOf(ldvalue.String("x"), ldvalue.String("y")).Equal(value.GetByKey("servers")))
+	assertEvalEvent(t, client, flag, evalTestUser, flag.Variations[1], 1, nil, nil)
+}
+
+func TestValueVariationDetailReturnsFlagValueAndReason(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	flag := makeObjectValueTestFlag()
+	client.store.Upsert(Features, flag)
+
+	value, detail, err := client.ValueVariationDetail("objectFlag", evalTestUser, ldvalue.Null())
+
+	require.NoError(t, err)
+	assert.Equal(t, value, detail.Value)
+	assert.Equal(t, intPtr(1), detail.VariationIndex)
+	assert.Equal(t, evalReasonFallthroughInstance, detail.Reason)
+	assertEvalEvent(t, client, flag, evalTestUser, flag.Variations[1], 1, nil, detail.Reason)
+}
+
+func TestValueVariationReturnsDefaultForUnknownFlag(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+
+	defaultVal := ldvalue.String("default")
+	value, detail, err := client.ValueVariationDetail("unknownFlag", evalTestUser, defaultVal)
+
+	assert.Error(t, err)
+	assert.Equal(t, defaultVal, value)
+	assert.Equal(t, defaultVal, detail.Value)
+	assert.Equal(t, newEvalReasonError(EvalErrorFlagNotFound), detail.Reason)
+}
+
+func TestValueVariationReturnsValueSubstitutedByHook(t *testing.T) {
+	hooks := newRecordingHooks("a")
+	hooks[0].override = func(detail EvaluationDetail) EvaluationDetail {
+		return EvaluationDetail{Value: map[string]interface{}{"name": "c"}, VariationIndex: detail.VariationIndex,
+			Reason: detail.Reason}
+	}
+	client := makeHookTestClient(func(c *Config) {}, hooks...)
+	defer client.Close()
+	client.store.Upsert(Features, makeObjectValueTestFlag())
+
+	value, detail, err := client.ValueVariationDetail("objectFlag", evalTestUser, ldvalue.Null())
+
+	require.NoError(t, err)
+	assert.Equal(t, "c", value.GetByKey("name").StringValue())
+	assert.Equal(t, value, detail.Value)
+	assert.Equal(t, intPtr(1), detail.VariationIndex)
+}
+
+func TestValueVariationReturnsValueSubstitutedByHookForUnknownFlag(t *testing.T) {
+	hooks := newRecordingHooks("a")
+	hooks[0].override = func(detail EvaluationDetail) EvaluationDetail {
+		return EvaluationDetail{Value: "substitute", Reason: detail.Reason}
+	}
+	client := makeHookTestClient(func(c *Config) {}, hooks...)
+	defer client.Close()
+
+	value, err := client.ValueVariation("unknownFlag", evalTestUser, ldvalue.String("default"))
+
+	assert.Error(t, err)
+	assert.Equal(t, ldvalue.String("substitute"), value)
+}
+
+func TestModifyingEvaluationResultDoesNotAffectFlag(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	client.store.Upsert(Features, makeObjectValueTestFlag())
+
+	value, _, err := client.Evaluate("objectFlag", evalTestUser, nil)
+	require.NoError(t, err)
+	value.(map[string]interface{})["name"] = "changed"
+	value.(map[string]interface{})["servers"].([]interface{})[0] = "changed"
+
+	_, detail, err := client.JsonVariationDetail("objectFlag", evalTestUser, nil)
+	require.NoError(t, err)
+	detail.Value.(map[string]interface{})["name"] = "changed again"
+
+	expected := map[string]interface{}{"name": "b", "servers": []interface{}{"x", "y"}}
+	value, _, _ = client.Evaluate("objectFlag", evalTestUser, nil)
+	assert.Equal(t, expected, value)
+	stored, _ := client.store.Get(Features, "objectFlag")
+	assert.Equal(t, expected, stored.(*FeatureFlag).Variations[1])
+}
+
+func TestModifyingOtherEvaluationResultsDoesNotAffectFlag(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	flag := makeObjectValueTestFlag()
+	client.store.Upsert(Features, flag)
+
+	detail, trace, err := client.TraceVariation("objectFlag", evalTestUser, nil)
+	require.NoError(t, err)
+	detail.Value.(map[string]interface{})["name"] = "changed"
+	trace.Detail.Value.(map[string]interface{})["name"] = "changed"
+	detail, _ = flag.EvaluateDetail(evalTestUser, client.store, false)
+	detail.Value.(map[string]interface{})["name"] = "changed"
+	detail, trace = flag.EvaluateTrace(evalTestUser, client.store)
+	detail.Value.(map[string]interface{})["name"] = "changed"
+	trace.Detail.Value.(map[string]interface{})["name"] = "changed"
+
+	expected := map[string]interface{}{"name": "b", "servers": []interface{}{"x", "y"}}
+	assert.Equal(t, expected, flag.Variations[1])
+}
+
+func TestEvaluationDoesNotCopyVariationValue(t *testing.T) {
+	flag := makeObjectValueTestFlag()
+	detail, _ := flag.evaluateDetail(evalTestUser, emptyFeatureStore, false, &evaluationState{})
+	assert.Equal(t, reflect.ValueOf(flag.Variations[1]).Pointer(), reflect.ValueOf(detail.Value).Pointer())
+}
+
+func TestModifyingFlagsStateValuesDoesNotAffectFlagOrState(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	client.store.Upsert(Features, makeObjectValueTestFlag())
+
+	state := client.AllFlagsState(evalTestUser)
+	values := state.ToValuesMap()
+	values["objectFlag"].(map[string]interface{})["name"] = "changed"
+	values["otherFlag"] = true
+	state.GetFlagValue("objectFlag").(map[string]interface{})["name"] = "changed"
+	client.AllFlags(evalTestUser)["objectFlag"].(map[string]interface{})["name"] = "changed"
+
+	expected := map[string]interface{}{"name": "b", "servers": []interface{}{"x", "y"}}
+	assert.Equal(t, map[string]interface{}{"objectFlag": expected}, state.ToValuesMap())
+	stored, _ := client.store.Get(Features, "objectFlag")
+	assert.Equal(t, expected, stored.(*FeatureFlag).Variations[1])
+}